@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	imageHandling "pixf/internal/toolset"
+	"time"
+)
+
+// Audit statuses
+const (
+	auditOK        = "ok"
+	auditUpToDate  = "up-to-date"
+	auditCached    = "cached"
+	auditError     = "error"
+	auditNoImages  = "no-images" // With --fail-on-empty
+	auditTruncated = "truncated" // Images left out over --max-total-output
+)
+
+// auditRecord is one line of the --audit-log file
+type auditRecord struct {
+	Started   time.Time             `json:"started"`
+	Finished  time.Time             `json:"finished"`
+	User      string                `json:"user"`
+	Host      string                `json:"host"`
+	Input     string                `json:"input"`
+	InputHash string                `json:"input_sha256"`
+	Verified  bool                  `json:"input_verified"` // Input re-hashed unchanged after processing
+	Mode      string                `json:"mode"`           // unlock, extract or unlock+extract
+	Options   imageHandling.Options `json:"options"`
+	Unlocked  string                `json:"unlocked,omitempty"`
+	ImageDir  string                `json:"image_dir,omitempty"`
+	Images    int                   `json:"images"`
+	Status    string                `json:"status"`
+	Error     string                `json:"error,omitempty"`
+	Usage     *resourceUsage        `json:"usage,omitempty"`
+}
+
+// auditOutcome is what a sandboxed child learned about its run, sent
+// back for the record its parent writes
+type auditOutcome struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Verified bool   `json:"input_verified"`
+	Unlocked string `json:"unlocked,omitempty"`
+	ImageDir string `json:"image_dir,omitempty"`
+}
+
+// The audit record of this run and the log it is appended to; nil
+// without --audit-log. A sandboxed child keeps the outcome of its record
+// in childAudit for its parent, which writes the log.
+var (
+	audit      *auditRecord
+	auditFile  *os.File
+	childAudit *auditOutcome
+)
+
+// startAudit opens the audit log before any work is done, so a run that
+// can't be recorded doesn't happen. A sandboxed child only fills in a
+// record; the log was opened by its parent.
+func startAudit(path, input, inputHash, mode string, opts imageHandling.Options) error {
+	var f *os.File
+	if !inSandbox() {
+		var err error
+		if f, err = os.OpenFile(imageHandling.LongPath(path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640); err != nil {
+			return err
+		}
+	}
+
+	host, _ := os.Hostname()
+
+	auditFile = f
+	audit = &auditRecord{
+		Started:   time.Now().UTC(),
+		User:      auditUser(),
+		Host:      host,
+		Input:     absPath(input),
+		InputHash: inputHash,
+		Mode:      mode,
+		Options:   opts.Recorded(),
+	}
+	return nil
+}
+
+// auditUser names the user running pixf
+func auditUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return fmt.Sprintf("uid %d", os.Getuid())
+}
+
+// auditOutputs records where results go
+func auditOutputs(unlocked, imgDir string) {
+	if audit != nil {
+		audit.Unlocked, audit.ImageDir = absPath(unlocked), absPath(imgDir)
+	}
+}
+
+// absPath makes p absolute for the log; "" stays empty
+func absPath(p string) string {
+	if p == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+// auditVerified records that the input was found unchanged after processing
+func auditVerified() {
+	if audit != nil {
+		audit.Verified = true
+	}
+}
+
+// auditChild takes the outcome of the run from the sandboxed child that
+// did the work (nil = none came back)
+func auditChild(o *auditOutcome) {
+	if audit != nil && o != nil {
+		audit.Status, audit.Error, audit.Verified = o.Status, o.Error, o.Verified
+		audit.Unlocked, audit.ImageDir = o.Unlocked, o.ImageDir
+	}
+}
+
+// auditStatus sets the outcome of the run
+func auditStatus(status, errMsg string) {
+	if audit != nil {
+		audit.Status, audit.Error = status, errMsg
+	}
+}
+
+// finishAudit appends the record of this run to the audit log, or in a
+// sandboxed child keeps it for the parent. The image count is taken from
+// the manifest of the output directory.
+func finishAudit(code int) {
+	if audit == nil {
+		return
+	}
+	rec := audit
+	audit = nil
+
+	rec.Finished = time.Now().UTC()
+	if rec.Status == "" {
+		rec.Status = auditOK
+		if code != 0 {
+			rec.Status = auditError
+		}
+	}
+	if inSandbox() {
+		childAudit = &auditOutcome{Status: rec.Status, Error: rec.Error, Verified: rec.Verified, Unlocked: rec.Unlocked, ImageDir: rec.ImageDir}
+		return
+	}
+	if rec.Status != auditError && rec.ImageDir != "" {
+		if m, err := imageHandling.ReadManifest(rec.ImageDir); err == nil {
+			rec.Images = len(m.Images)
+		}
+	}
+	if rec.Mode == "unlock" {
+		rec.ImageDir = ""
+	}
+
+	// One write per record keeps lines whole when runs share the log
+	line, err := json.Marshal(rec)
+	if err == nil {
+		_, err = auditFile.Write(append(line, '\n'))
+	}
+	if cerr := auditFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing audit log:", err)
+	}
+}
+
+// fail prints an error, records it in the audit log and exits
+func fail(msg, detail string) {
+	fmt.Fprintln(console, msg, detail)
+	auditStatus(auditError, detail)
+	exit(1)
+}
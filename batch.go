@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	imageHandling "pixf/internal/toolset"
+)
+
+// batchDoc is a PDF of a batch on its way through the pipeline
+type batchDoc struct {
+	input    string
+	doc      *imageHandling.Document // Decrypted in memory (nil if unlocking failed)
+	unlocked string                  // Unlocked copy ("" = not encrypted)
+	imgDir   string
+	hash     string
+	upToDate bool
+	err      error
+}
+
+// runBatch implements "pixf batch <dir-or-pdf>...": every PDF is unlocked
+// and its images extracted as in default mode. Decryption of the next PDF
+// overlaps extraction of the current one.
+func runBatch(args []string) {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	format := fs.String("format", "original", "Image output format (original, png, webp, heic)")
+	presetName := fs.String("preset", "", "Options bundle: archive, web, ocr or a preset of the config file")
+	outputDir := fs.String("output-dir", ".", "Directory for unlocked PDFs and images")
+	dirPolicy := fs.String("dir-policy", dirReuse, "If an image directory exists: reuse, timestamp or error")
+	dedupIndexFile := fs.String("dedup-index", "", "Index of images written by earlier runs, which are left out")
+	failOnEmpty := fs.Bool("fail-on-empty", false, "Exit with status 3 if a PDF holds no images and none failed")
+	maxTotalOutput := fs.String("max-total-output", "", "Most bytes of images written per PDF, e.g. 2G (default: no limit)")
+	engine := fs.String("engine", imageHandling.DefaultEngine, "Library rendering the image streams (pdfcpu, mupdf)")
+	encodeShare := fs.String("encode-share", "", "Share of the encoders a format may take, e.g. webp=0.5")
+	reportFormat := fs.String("batch-report", "", "Also write a report of every document and totals: json, csv or html")
+	minDPI := fs.Int("min-dpi", 0, "Warn about images drawn below this resolution (0 = off)")
+	colorManaged := fs.Bool("require-color-managed", false, "Fail if an image's ICC profile can't be kept")
+	rotate := fs.Int("rotate", 0, "Turn converted images clockwise by 90, 180 or 270 degrees")
+	flip := fs.String("flip", "", "Mirror converted images: h (left to right) or v (top to bottom)")
+	eventsFormat := fs.String("events", "", "Stream an event per pipeline step to stderr (jsonl)")
+	eventsFile := fs.String("events-file", "", "Append the --events stream to this file instead")
+	force := fs.Bool("force", false, "Re-extract even if output is up to date")
+	safeNames := fs.Bool("safe-names", false, "Transliterate output names to ASCII")
+	timeout := fs.Duration("timeout", 0, "Maximum time to decrypt, and to extract, each PDF (0 = no limit)")
+	tmpDir := fs.String("tmpdir", "", "Directory for temporary files")
+	ignorePerms := fs.Bool("ignore-permissions", false, "Extract even if a PDF's permissions forbid it")
+	passwordFile := fs.String("password-file", "", "File of candidate passwords, one per line")
+	keychain := fs.Bool("keychain", false, "Look up passwords in the OS keychain by file name pattern")
+	p12 := fs.String("p12", "", "PKCS#12 certificate and key for PDFs encrypted to a certificate")
+	p12PassFile := fs.String("p12-pass-file", "", "File holding the passphrase of the --p12 file")
+	convertCmd := fs.String("convert-cmd", "", "External converter of Office documents to PDF ({in}, {out})")
+	showUsage := fs.Bool("usage", false, "Print resource usage and stage durations when done")
+	profiling := addProfileFlags(fs)
+	summary := addSummaryFlags(fs)
+	perms := addPermFlags(fs)
+	fs.Parse(args)
+	if *presetName != "" {
+		if _, err := applyPreset(fs, *presetName, true); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+	routeOutput(summary)
+
+	if fs.NArg() < 1 {
+		fmt.Println("Error: No PDF file or directory specified")
+		fmt.Println("Usage: pixf batch [--format f] [--output-dir dir] [--force] <dir-or-pdf>...")
+		os.Exit(1)
+	}
+	if !slices.Contains(imageHandling.Formats(), *format) {
+		fmt.Printf("Error: Unsupported format '%s'\n", *format)
+		fmt.Println("Supported formats:", strings.Join(imageHandling.Formats(), ", "))
+		os.Exit(1)
+	}
+	if *timeout < 0 {
+		fmt.Println("Error: Timeout must not be negative")
+		os.Exit(1)
+	}
+	if *minDPI < 0 {
+		fmt.Println("Error: --min-dpi must not be negative")
+		os.Exit(1)
+	}
+	maxTotal, err := parseOutputBudget(*maxTotalOutput)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	encodeScale, err := parseEncodeShares(*encodeShare)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if !slices.Contains(imageHandling.Engines(), *engine) {
+		fmt.Printf("Error: Unknown engine '%s'\n", *engine)
+		fmt.Println("Available engines:", strings.Join(imageHandling.Engines(), ", "))
+		os.Exit(1)
+	}
+	transform := imageHandling.Transform{Rotate: *rotate, Flip: *flip}
+	if err := transform.Check(); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if transform != (imageHandling.Transform{}) && *format == "original" {
+		fmt.Println("Error: --rotate and --flip require png or webp output")
+		os.Exit(1)
+	}
+	var report *batchReport
+	if *reportFormat != "" {
+		if !slices.Contains(batchReportFormats, *reportFormat) {
+			fmt.Printf("Error: Unsupported batch report format '%s'\n", *reportFormat)
+			fmt.Println("Supported formats:", strings.Join(batchReportFormats, ", "))
+			os.Exit(1)
+		}
+		report = &batchReport{Started: time.Now()}
+	}
+	if err := checkDirPolicy(*dirPolicy); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if outputPerms, err = perms.parse(); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	dedupIndex, err := openDedupIndex(*dedupIndexFile)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	events, err := openEvents(*eventsFormat, *eventsFile)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	var passwords []string
+	if *passwordFile != "" {
+		var err error
+		if passwords, err = readPasswordFile(*passwordFile); err != nil {
+			fmt.Println("Error reading password file:", err)
+			os.Exit(1)
+		}
+	}
+
+	var identity *imageHandling.Identity
+	if *p12 != "" {
+		var err error
+		if identity, err = loadIdentity(*p12, *p12PassFile); err != nil {
+			fmt.Println("Error reading certificate:", err)
+			os.Exit(1)
+		}
+	} else if *p12PassFile != "" {
+		fmt.Println("Error: --p12-pass-file requires --p12")
+		os.Exit(1)
+	}
+
+	if err := createWorkDir(*tmpDir); err != nil {
+		fmt.Fprintln(console, "Error creating temp directory:", err)
+		os.Exit(1)
+	}
+	defer removeWorkDir()
+	handleSignals()
+	if *showUsage {
+		startUsage(true)
+		defer finishUsage()
+	}
+	if err := startProfiling(profiling); err != nil {
+		fmt.Fprintln(console, "Error:", err)
+		exit(1)
+	}
+	defer stopProfiling()
+	inputs, err := batchInputs(fs.Args())
+	if err != nil {
+		fmt.Fprintln(console, "Error finding PDFs:", err)
+		exit(1)
+	}
+	inputs, names, failed := mailAttachments(inputs, report)
+	seen := make(map[string]string)
+	imgDirs := make(map[string]string)
+	now := time.Now()
+	for _, input := range inputs {
+		_, imgDir := outputPaths(input, *outputDir, *safeNames)
+		if prev, ok := seen[imgDir]; ok {
+			fmt.Fprintln(console, "Error:", prev, "and", displayName(input, names), "would both be extracted to", imgDir)
+			exit(1)
+		}
+		seen[imgDir] = displayName(input, names)
+		if imgDirs[input], err = imageDirFor(imgDir, *dirPolicy, now); err != nil {
+			fmt.Fprintln(console, "Error:", err)
+			exit(1)
+		}
+	}
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fmt.Fprintln(console, "Error creating output directory:", err)
+		exit(1)
+	}
+
+	opts := imageHandling.Options{Format: *format, SafeNames: *safeNames, TempDir: workDir, IgnorePerms: *ignorePerms, MinDPI: *minDPI, ColorManaged: *colorManaged, Transform: transform, MaxTotalOutput: maxTotal, Engine: engineOption(*engine), Perms: outputPerms, DedupIndex: dedupIndex, Events: events, Notices: progress}
+	converter := imageHandling.NewPreConverter(*convertCmd)
+	docs := unlockAhead(inputs, imgDirs, *outputDir, opts, passwords, *keychain, identity, converter, *force, *timeout)
+
+	e := imageHandling.NewExtractor(imageHandling.Workers{EncodeScale: encodeScale})
+	defer e.Close()
+	done, empty, truncated := 0, 0, 0
+	start := time.Now()
+	total := &imageHandling.Result{}
+	for doc := range docs {
+		if runCtx.Err() != nil {
+			break
+		}
+		name := displayName(doc.input, names)
+		switch {
+		case doc.err != nil:
+			fmt.Fprintln(console, name+":", "Error decrypting PDF:", describeError(doc.err, *timeout))
+			report.add(name, doc.imgDir, nil, doc.err)
+			failed++
+			continue
+		case doc.upToDate:
+			fmt.Fprintln(progress, name+":", "images already up to date in", doc.imgDir)
+			if hasNoImages(doc.imgDir) {
+				fmt.Fprintln(progress, name+":", "no images found")
+				empty++
+			}
+			if n := truncatedImages(doc.imgDir); n > 0 {
+				fmt.Fprintln(progress, name+":", "output truncated,", n, "image(s) left out")
+				truncated++
+			}
+			report.add(name, doc.imgDir, nil, nil)
+			done++
+			continue
+		}
+
+		opts.Source = doc.input
+		usageOutputs(doc.imgDir)
+		var res *imageHandling.Result
+		err := withTimeout(*timeout, func(ctx context.Context) (err error) {
+			res, err = e.ExtractDocument(ctx, doc.doc, doc.imgDir, opts)
+			return err
+		})
+		doc.doc.Close()
+		if err == nil {
+			err = imageHandling.VerifyUnchanged(doc.input, doc.hash)
+		}
+		if err != nil {
+			fmt.Fprintln(console, name+":", "Error extracting images:", describeError(err, *timeout))
+			report.add(name, doc.imgDir, nil, err)
+			failed++
+			continue
+		}
+		if doc.unlocked == "" {
+			fmt.Fprintln(progress, name+":", "not encrypted; images extracted to", doc.imgDir)
+		} else {
+			fmt.Fprintln(progress, name+":", "unlocked to", doc.unlocked+", images extracted to", doc.imgDir)
+		}
+		printDuplicates(summary, doc.imgDir)
+		if hasNoImages(doc.imgDir) {
+			fmt.Fprintln(progress, name+":", "no images found")
+			empty++
+		}
+		if res.Truncated > 0 {
+			fmt.Fprintln(progress, name+":", "output truncated,", res.Truncated, "image(s) left out")
+			truncated++
+		}
+		report.add(name, doc.imgDir, res, nil)
+		total.Add(res)
+		total.Documents++
+		done++
+	}
+
+	if empty > 0 {
+		fmt.Fprintf(progress, "%d PDF(s) processed, %d failed, %d without images\n", done, failed, empty)
+	} else {
+		fmt.Fprintf(progress, "%d PDF(s) processed, %d failed\n", done, failed)
+	}
+	if truncated > 0 {
+		fmt.Fprintf(console, "%d PDF(s) truncated by --max-total-output\n", truncated)
+	}
+	if report != nil {
+		path, err := report.write(*outputDir, *reportFormat)
+		if err != nil {
+			fmt.Fprintln(console, "Error writing batch report:", err)
+			exit(1)
+		}
+		fmt.Fprintln(progress, "Batch report written to:", path)
+	}
+	// Decryption overlaps extraction, so only the wall time is meaningful
+	total.Failed, total.Seconds = failed, time.Since(start).Round(time.Millisecond).Seconds()
+	printSummary(summary, total)
+	if failed > 0 || runCtx.Err() != nil {
+		exit(1)
+	}
+	if truncated > 0 {
+		exit(exitTruncated)
+	}
+	if *failOnEmpty && empty > 0 {
+		exit(exitNoImages)
+	}
+}
+
+// unlockAhead decrypts the inputs in order on its own goroutine and
+// writes their unlocked copies. The channel holds one finished PDF, so
+// decryption runs at most one document ahead of extraction and decrypted
+// documents don't pile up in memory. PDFs that need a password get the
+// listed ones, after any keychain entries for their name; PDFs encrypted
+// to a certificate need identity. Office documents are converted to PDF
+// by converter first. imgDirs holds the image directory of each input.
+func unlockAhead(inputs []string, imgDirs map[string]string, outputDir string, opts imageHandling.Options, listed []string, keychain bool, identity *imageHandling.Identity, converter imageHandling.PreConverter, force bool, timeout time.Duration) <-chan batchDoc {
+	docs := make(chan batchDoc, 1)
+	go func() {
+		defer close(docs)
+		for n, input := range inputs {
+			doc := batchDoc{input: input}
+			unlocked, _ := outputPaths(input, outputDir, opts.SafeNames)
+			doc.imgDir = imgDirs[input]
+
+			opts.Source = input
+			if !force && imageHandling.IsUpToDate(input, doc.imgDir, opts) {
+				doc.upToDate = true
+				docs <- doc
+				continue
+			}
+			// Hash first so a change during the run is detected
+			var passwords []string
+			if doc.hash, doc.err = imageHandling.HashFile(imageHandling.LongPath(input)); doc.err == nil {
+				passwords, doc.err = candidatePasswords(input, listed, keychain)
+			}
+			// The document only goes on to extraction if unlocking
+			// completed; one from a run that failed is closed
+			var opened *imageHandling.Document
+			if doc.err == nil {
+				doc.err = withTimeout(timeout, func(ctx context.Context) error {
+					pdf := input
+					if imageHandling.NeedsConversion(input) {
+						var err error
+						if pdf, err = converter.Convert(ctx, input, filepath.Join(workDir, "convert", strconv.Itoa(n))); err != nil {
+							return fmt.Errorf("convert to PDF: %w", err)
+						}
+					}
+					var wrote bool
+					var err error
+					if opened, wrote, err = unlock(ctx, pdf, unlocked, opts.IgnorePerms, imageHandling.Credentials{Passwords: passwords, Identity: identity}); wrote {
+						doc.unlocked = unlocked
+					}
+					return err
+				})
+			}
+			switch {
+			case doc.err == nil:
+				doc.doc = opened
+			case opened != nil:
+				opened.Close()
+				doc.unlocked = ""
+			}
+			docs <- doc
+		}
+	}()
+	return docs
+}
+
+// withTimeout runs fn with a context ending after timeout (0 = no limit)
+// or when the run is interrupted
+func withTimeout(timeout time.Duration, fn func(ctx context.Context) error) error {
+	ctx := runCtx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return fn(ctx)
+}
+
+// batchInputs expands directories into the PDFs, Office documents and
+// emails below them, sorted by path; files are taken as given
+func batchInputs(args []string) ([]string, error) {
+	var inputs []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			inputs = append(inputs, arg)
+			continue
+		}
+		var found []string
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && (strings.EqualFold(filepath.Ext(path), ".pdf") || imageHandling.NeedsConversion(path) || imageHandling.IsMail(path)) {
+				found = append(found, path)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(found)
+		inputs = append(inputs, found...)
+	}
+	return inputs, nil
+}
+
+// mailAttachments replaces the emails among inputs by their PDF
+// attachments, saved to the work directory under the email's name joined
+// with the attachment's, so each gets its own output. names maps the
+// saved files to "<email>: <attachment>" for messages. Emails that can't
+// be read are reported, counted in failed and added to report.
+func mailAttachments(inputs []string, report *batchReport) (expanded []string, names map[string]string, failed int) {
+	names = make(map[string]string)
+	for n, input := range inputs {
+		if !imageHandling.IsMail(input) {
+			expanded = append(expanded, input)
+			continue
+		}
+		pdfs, err := imageHandling.MailPDFs(input)
+		if err == nil && len(pdfs) > 0 {
+			err = os.MkdirAll(filepath.Join(workDir, "mail", strconv.Itoa(n)), 0700)
+		}
+		if err != nil {
+			fmt.Fprintln(console, input+":", "Error reading email:", err)
+			report.add(input, "", nil, err)
+			failed++
+			continue
+		}
+		if len(pdfs) == 0 {
+			fmt.Fprintln(progress, input+":", "no PDF attachments")
+			continue
+		}
+		stem := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+		for _, pdf := range pdfs {
+			path := filepath.Join(workDir, "mail", strconv.Itoa(n), imageHandling.SanitizeName(stem+"_"+pdf.Name, false))
+			if err := os.WriteFile(path, pdf.Data, 0600); err != nil {
+				fmt.Fprintln(console, input+":", "Error saving attachment", pdf.Name+":", err)
+				report.add(input+": "+pdf.Name, "", nil, err)
+				failed++
+				continue
+			}
+			expanded = append(expanded, path)
+			names[path] = input + ": " + pdf.Name
+		}
+	}
+	return expanded, names, failed
+}
+
+// displayName is how messages refer to input
+func displayName(input string, names map[string]string) string {
+	if name, ok := names[input]; ok {
+		return name
+	}
+	return input
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	imageHandling "pixf/internal/toolset"
+)
+
+// Formats of the aggregate report of a batch (--batch-report)
+var batchReportFormats = []string{"json", "csv", "html"}
+
+// batchReportName is the file the report is written to in the output
+// directory, followed by the format as extension
+const batchReportName = "batch-report"
+
+// batchReport rolls up the result of every document of a batch, with
+// totals for the whole corpus
+type batchReport struct {
+	Started   time.Time       `json:"started"`
+	Documents []batchDocument `json:"documents"`
+	Totals    batchTotals     `json:"totals"`
+
+	dir string // Directory the report is written to
+}
+
+// batchDocument is a document of a batch: what was extracted, or why not
+type batchDocument struct {
+	Document string `json:"document"`
+	Status   string `json:"status"` // ok, truncated, up-to-date or error
+	Error    string `json:"error,omitempty"`
+	ImageDir string `json:"image_dir,omitempty"`
+	imageHandling.Result
+}
+
+// batchTotals adds up the documents of a batch; Seconds is the wall time
+type batchTotals struct {
+	imageHandling.Result
+	UpToDate int `json:"up_to_date"`
+}
+
+// add records the outcome of a document; res is nil unless it was extracted
+func (r *batchReport) add(name, imgDir string, res *imageHandling.Result, err error) {
+	if r == nil {
+		return
+	}
+	doc := batchDocument{Document: name, Status: auditOK, ImageDir: imgDir}
+	switch {
+	case err != nil:
+		doc.Status, doc.Error, doc.ImageDir = auditError, err.Error(), ""
+	case res == nil:
+		doc.Status = auditUpToDate
+	default:
+		doc.Result = *res
+		if res.Truncated > 0 {
+			doc.Status = auditTruncated
+		}
+	}
+	r.Documents = append(r.Documents, doc)
+}
+
+// finish fills in the totals of the documents recorded, with the wall
+// time since the batch started
+func (r *batchReport) finish() {
+	t := batchTotals{}
+	for _, doc := range r.Documents {
+		switch doc.Status {
+		case auditOK, auditTruncated:
+			t.Add(&doc.Result)
+			t.Documents++
+		case auditUpToDate:
+			t.UpToDate++
+		default:
+			t.Failed++
+		}
+	}
+	t.Seconds = time.Since(r.Started).Round(time.Millisecond).Seconds()
+	r.Totals = t
+}
+
+// write writes the report to dir as batch-report.<format> and returns its path
+func (r *batchReport) write(dir, format string) (string, error) {
+	r.finish()
+	r.dir = dir
+	path := filepath.Join(dir, batchReportName+"."+format)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	switch format {
+	case "json":
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(r)
+	case "csv":
+		err = r.writeCSV(f)
+	default:
+		err = batchReportTemplate.Execute(f, r)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("write %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// Link returns the path of imgDir relative to the report, for the HTML report
+func (r *batchReport) Link(imgDir string) string {
+	if rel, err := filepath.Rel(r.dir, imgDir); err == nil {
+		imgDir = rel
+	}
+	return filepath.ToSlash(imgDir) + "/"
+}
+
+// writeCSV writes a row per document and a last row "total"
+func (r *batchReport) writeCSV(out io.Writer) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"document", "status", "images", "duplicates", "errors", "low_dpi", "bytes_in", "bytes_out", "seconds", "image_dir", "error"})
+	row := func(name, status string, res imageHandling.Result, imgDir, errText string) {
+		w.Write([]string{
+			name, status,
+			strconv.Itoa(res.Images), strconv.Itoa(res.Duplicates), strconv.Itoa(res.Errors), strconv.Itoa(res.LowDPI),
+			strconv.FormatInt(res.BytesIn, 10), strconv.FormatInt(res.BytesOut, 10),
+			strconv.FormatFloat(res.Seconds, 'f', 3, 64),
+			imgDir, errText,
+		})
+	}
+	for _, doc := range r.Documents {
+		row(doc.Document, doc.Status, doc.Result, doc.ImageDir, doc.Error)
+	}
+	t := r.Totals
+	row("total", fmt.Sprintf("%d ok, %d up-to-date, %d error", t.Documents, t.UpToDate, t.Failed), t.Result, "", "")
+	w.Flush()
+	return w.Error()
+}
+
+var batchReportTemplate = template.Must(template.New("batch").Funcs(template.FuncMap{
+	"size": imageHandling.FormatSize,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Batch of {{.Started.Format "2006-01-02 15:04"}} - pixf</title>
+<style>
+body { font-family: sans-serif; margin: 2em; background: #fafafa; }
+table { border-collapse: collapse; background: #fff; }
+th, td { border: 1px solid #ddd; padding: .3em .6em; text-align: right; }
+th:first-child, td:first-child, td.status { text-align: left; }
+tfoot td { font-weight: bold; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Batch of {{.Started.Format "2006-01-02 15:04"}}</h1>
+<p>{{.Totals.Documents}} extracted &middot; {{.Totals.UpToDate}} up to date &middot; {{.Totals.Failed}} failed &middot; {{printf "%.1f" .Totals.Seconds}}s</p>
+<table>
+<thead><tr><th>Document</th><th>Status</th><th>Images</th><th>Duplicates</th><th>Errors</th><th>Low DPI</th><th>Read</th><th>Written</th><th>Seconds</th></tr></thead>
+<tbody>
+{{range .Documents}}<tr><td>{{if .ImageDir}}<a href="{{$.Link .ImageDir}}">{{.Document}}</a>{{else}}{{.Document}}{{end}}</td>{{if .Error}}<td class="status error" colspan="8">{{.Error}}</td>{{else}}<td class="status">{{.Status}}</td><td>{{.Images}}</td><td>{{.Duplicates}}</td><td>{{.Errors}}</td><td>{{.LowDPI}}</td><td>{{size .BytesIn}}</td><td>{{size .BytesOut}}</td><td>{{printf "%.3f" .Seconds}}</td>{{end}}</tr>
+{{end}}</tbody>
+<tfoot><tr><td>Total</td><td></td><td>{{.Totals.Images}}</td><td>{{.Totals.Duplicates}}</td><td>{{.Totals.Errors}}</td><td>{{.Totals.LowDPI}}</td><td>{{size .Totals.BytesIn}}</td><td>{{size .Totals.BytesOut}}</td><td>{{printf "%.3f" .Totals.Seconds}}</td></tr></tfoot>
+</table>
+</body>
+</html>
+`))
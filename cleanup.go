@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	imageHandling "pixf/internal/toolset"
+)
+
+// workDir holds all temporary files of this run; removed on every exit path
+var workDir string
+
+// createWorkDir creates the per-run temp directory below parent ("" = OS default)
+func createWorkDir(parent string) error {
+	dir, err := os.MkdirTemp(parent, "pixf")
+	if err != nil {
+		return err
+	}
+	workDir = dir
+	return nil
+}
+
+// removeWorkDir deletes the per-run temp directory
+func removeWorkDir() {
+	if workDir != "" {
+		os.RemoveAll(workDir)
+	}
+}
+
+// runCtx is cancelled with imageHandling.ErrInterrupted on SIGINT or
+// SIGTERM, so extraction stops and records what it completed
+var runCtx, interrupt = context.WithCancelCause(context.Background())
+
+// interruptGrace is how long an interrupted run may take to stop before
+// it is ended anyway
+const interruptGrace = 10 * time.Second
+
+// exit removes temporary files before terminating, since os.Exit skips
+// defers. An interrupted run always ends with 130.
+func exit(code int) {
+	if runCtx.Err() != nil {
+		code = 130
+	}
+	stopProfiling()
+	removeWorkDir()
+	finishUsage()
+	finishAudit(code)
+	reportSandboxResult(code)
+	os.Exit(code)
+}
+
+// handleSignals stops the run when interrupted: workers finish the image
+// at hand, the partial output is kept with its manifest and temporary
+// files are removed. A second signal, or a run that doesn't stop within
+// interruptGrace, exits at once.
+func handleSignals() {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		fmt.Fprintln(console, "\nInterrupted by", sig, "- stopping (again to quit now)")
+		interrupt(imageHandling.ErrInterrupted)
+		select {
+		case <-sigs:
+		case <-time.After(interruptGrace):
+		}
+		fmt.Fprintln(progress, "Cleaning up")
+		exit(130)
+	}()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// writeClipboardFile stores data in a temporary PNG for clipboard tools
+// that only read files
+func writeClipboardFile(data []byte) (path string, cleanup func(), err error) {
+	f, err := os.CreateTemp("", "pixf-*.png")
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), func() { os.Remove(f.Name()) }, nil
+}
+
+// commandError describes a failed clipboard tool with its output
+func commandError(cmd *exec.Cmd, err error, out []byte) error {
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		return fmt.Errorf("%s: %v: %s", cmd.Args[0], err, msg)
+	}
+	return fmt.Errorf("%s: %w", cmd.Args[0], err)
+}
@@ -0,0 +1,24 @@
+//go:build darwin
+
+package main
+
+import (
+	"os/exec"
+)
+
+// copyToClipboard puts PNG data on the clipboard with AppleScript, which
+// reads the image from a temporary file
+func copyToClipboard(data []byte) error {
+	path, cleanup, err := writeClipboardFile(data)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	script := `set the clipboard to (read (POSIX file "` + path + `") as «class PNGf»)`
+	cmd := exec.Command("osascript", "-e", script)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return commandError(cmd, err, out)
+	}
+	return nil
+}
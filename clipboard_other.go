@@ -0,0 +1,29 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// copyToClipboard puts PNG data on the clipboard with wl-copy under
+// Wayland or xclip under X11; both read the image from stdin
+func copyToClipboard(data []byte) error {
+	var cmd *exec.Cmd
+	switch {
+	case os.Getenv("WAYLAND_DISPLAY") != "":
+		cmd = exec.Command("wl-copy", "--type", "image/png")
+	case os.Getenv("DISPLAY") != "":
+		cmd = exec.Command("xclip", "-selection", "clipboard", "-t", "image/png", "-i")
+	default:
+		return errors.New("no graphical session (neither WAYLAND_DISPLAY nor DISPLAY is set)")
+	}
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return commandError(cmd, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// copyToClipboard puts PNG data on the clipboard through PowerShell and
+// Windows Forms, which reads the image from a temporary file
+func copyToClipboard(data []byte) error {
+	path, cleanup, err := writeClipboardFile(data)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	path = strings.ReplaceAll(path, "'", "''")
+	script := "Add-Type -AssemblyName System.Windows.Forms, System.Drawing; " +
+		"$img = [System.Drawing.Image]::FromFile('" + path + "'); " +
+		"[System.Windows.Forms.Clipboard]::SetImage($img); $img.Dispose()"
+	cmd := exec.Command("powershell", "-NoProfile", "-STA", "-Command", script)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return commandError(cmd, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	imageHandling "pixf/internal/toolset"
+)
+
+// runCluster implements "pixf cluster <dir-or-pdf>"
+func runCluster(args []string) {
+	fs := flag.NewFlagSet("cluster", flag.ExitOnError)
+	threshold := fs.Int("threshold", imageHandling.DefaultClusterThreshold, "Maximum perceptual hash distance (0-64)")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fmt.Println("Error: No PDF file or directory specified")
+		fmt.Println("Usage: pixf cluster [--threshold N] [--json] <dir-or-pdf>")
+		os.Exit(1)
+	}
+
+	clusters, err := imageHandling.ClusterImages(fs.Arg(0), *threshold, "")
+	if err != nil {
+		fmt.Println("Error clustering images:", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(clusters)
+		return
+	}
+
+	singles := 0
+	for i, c := range clusters {
+		if len(c.Members) == 1 {
+			singles++
+			continue
+		}
+		fmt.Printf("Cluster %d (%d images):\n", i+1, len(c.Members))
+		for _, m := range c.Members {
+			fmt.Println("  " + m.String())
+		}
+	}
+	fmt.Printf("%d cluster(s) with similar images, %d unique image(s)\n", len(clusters)-singles, singles)
+}
+
+// runMatch implements "pixf match <pdf-file> --library dir-or-index": it
+// reports the images of the PDF found in a reference library
+func runMatch(args []string) {
+	fs := flag.NewFlagSet("match", flag.ExitOnError)
+	library := fs.String("library", "", "Directory of reference images, or an index of them")
+	threshold := fs.Int("threshold", imageHandling.DefaultClusterThreshold, "Maximum perceptual hash distance (0-64)")
+	saveIndex := fs.String("save-index", "", "Write the library's hashes to this index file for later runs")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || *library == "" {
+		fmt.Println("Error: No PDF file or library specified")
+		fmt.Println("Usage: pixf match --library <dir-or-index> [--threshold N] [--save-index file] [--json] <pdf-file>")
+		os.Exit(1)
+	}
+	if *threshold < 0 || *threshold > 64 {
+		fmt.Println("Error: --threshold must be between 0 and 64")
+		os.Exit(1)
+	}
+
+	lib, err := imageHandling.LoadLibrary(*library)
+	if err != nil {
+		fmt.Println("Error reading library:", err)
+		os.Exit(1)
+	}
+	if *saveIndex != "" {
+		if err := imageHandling.SaveLibrary(*saveIndex, lib); err != nil {
+			fmt.Println("Error saving library index:", err)
+			os.Exit(1)
+		}
+	}
+	matches, checked, err := imageHandling.MatchImages(imageHandling.LongPath(fs.Arg(0)), lib, *threshold, "")
+	if err != nil {
+		fmt.Println("Error matching images:", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(matches)
+		return
+	}
+
+	for _, m := range matches {
+		if m.Exact {
+			fmt.Printf("%s: copy of %s\n", m.Image, m.Library)
+		} else {
+			fmt.Printf("%s: similar to %s (distance %d)\n", m.Image, m.Library, m.Distance)
+		}
+	}
+	fmt.Printf("%d of %d image(s) found in %d library image(s)\n", len(matches), checked, len(lib))
+}
+
+// runIndex implements "pixf index <dir>...": it indexes the images of
+// the output directories below the given directories for "pixf search"
+func runIndex(args []string) {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	out := fs.String("out", imageHandling.SearchIndexName, "Index file to write")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fmt.Println("Error: No directory specified")
+		fmt.Println("Usage: pixf index [--out file] <dir>...")
+		os.Exit(1)
+	}
+
+	dirs := make([]string, fs.NArg())
+	for i, dir := range fs.Args() {
+		dirs[i] = imageHandling.LongPath(dir)
+	}
+	entries, extractions, err := imageHandling.BuildSearchIndex(dirs)
+	if err != nil {
+		fmt.Println("Error indexing images:", err)
+		os.Exit(1)
+	}
+	if err := imageHandling.SaveSearchIndex(*out, entries); err != nil {
+		fmt.Println("Error saving index:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d image(s) of %d extraction(s) indexed in %s\n", len(entries), extractions, *out)
+}
+
+// runSearch implements "pixf search <words>" and "pixf search --image
+// file": it finds images in an index written by "pixf index"
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	index := fs.String("index", imageHandling.SearchIndexName, "Index file written by \"pixf index\"")
+	similarTo := fs.String("image", "", "Find images like this one instead of searching for words")
+	threshold := fs.Int("threshold", imageHandling.DefaultClusterThreshold, "Maximum perceptual hash distance for --image (0-64)")
+	asJSON := fs.Bool("json", false, "Print the results as JSON")
+	fs.Parse(args)
+
+	query := strings.Join(fs.Args(), " ")
+	if (query == "") == (*similarTo == "") {
+		fmt.Println("Error: Give either words to search for or --image")
+		fmt.Println("Usage: pixf search [--index file] [--json] <words>...")
+		fmt.Println("       pixf search [--index file] [--json] --image <file> [--threshold N]")
+		os.Exit(1)
+	}
+	if *threshold < 0 || *threshold > 64 {
+		fmt.Println("Error: --threshold must be between 0 and 64")
+		os.Exit(1)
+	}
+
+	entries, err := imageHandling.LoadSearchIndex(*index)
+	if err != nil {
+		fmt.Println("Error reading index:", err)
+		os.Exit(1)
+	}
+
+	var results any
+	var lines []string
+	if query != "" {
+		found := imageHandling.SearchText(entries, query)
+		for _, e := range found {
+			lines = append(lines, e.String())
+		}
+		results = found
+	} else {
+		hits, err := imageHandling.SearchImage(entries, *similarTo, *threshold)
+		if err != nil {
+			fmt.Println("Error searching images:", err)
+			os.Exit(1)
+		}
+		for _, h := range hits {
+			if h.Exact {
+				lines = append(lines, h.Entry.String()+" (copy)")
+			} else {
+				lines = append(lines, fmt.Sprintf("%s (distance %d)", h.Entry, h.Distance))
+			}
+		}
+		results = hits
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	fmt.Printf("%d of %d image(s) found\n", len(lines), len(entries))
+}
+
+// runList implements "pixf list <pdf-file>"
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the list as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fmt.Println("Error: No PDF file specified")
+		fmt.Println("Usage: pixf list [--json] <pdf-file>")
+		os.Exit(1)
+	}
+
+	images, err := imageHandling.ListImages(context.Background(), imageHandling.LongPath(fs.Arg(0)))
+	if err != nil {
+		fmt.Println("Error listing images:", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(images)
+		return
+	}
+
+	fmt.Printf("%-16s %6s %11s  %s\n", "ID", "Object", "Size", "Filters")
+	for _, img := range images {
+		size := fmt.Sprintf("%dx%d", img.Width, img.Height)
+		fmt.Printf("%-16s %6d %11s  %s\n", img.ID, img.ObjNr, size, strings.Join(img.Filters, ","))
+	}
+	fmt.Printf("%d image(s)\n", len(images))
+}
+
+// runGrab implements "pixf grab <pdf-file> --page N --index N": it writes
+// one image as PNG or copies it to the system clipboard
+func runGrab(args []string) {
+	fs := flag.NewFlagSet("grab", flag.ExitOnError)
+	page := fs.Int("page", 1, "Page the image is on")
+	index := fs.Int("index", 1, "Image on the page, counted from 1 as in \"pixf list\"")
+	clipboard := fs.Bool("clipboard", false, "Copy the image to the system clipboard")
+	out := fs.String("out", "", "PNG file to write (default: page<N>_image<N>.png)")
+	ignorePerms := fs.Bool("ignore-permissions", false, "Extract even if the PDF's permissions forbid it")
+	fs.Parse(reorderArgs(fs, args))
+
+	if fs.NArg() < 1 {
+		fmt.Println("Error: No PDF file specified")
+		fmt.Println("Usage: pixf grab <pdf-file> [--page N] [--index N] [--clipboard | --out file.png]")
+		os.Exit(1)
+	}
+	if *clipboard && *out != "" {
+		fmt.Println("Error: Use either --clipboard or --out, not both")
+		os.Exit(1)
+	}
+
+	img, err := imageHandling.GrabImage(context.Background(), imageHandling.LongPath(fs.Arg(0)), *page, *index, "", *ignorePerms)
+	if err != nil {
+		fmt.Println("Error grabbing image:", describeError(err, 0))
+		os.Exit(1)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		fmt.Println("Error encoding image:", err)
+		os.Exit(1)
+	}
+	if *clipboard {
+		if err := copyToClipboard(buf.Bytes()); err != nil {
+			fmt.Println("Error copying to clipboard:", err)
+			os.Exit(1)
+		}
+		b := img.Bounds()
+		fmt.Printf("Copied %dx%d image to the clipboard\n", b.Dx(), b.Dy())
+		return
+	}
+
+	path := *out
+	if path == "" {
+		path = fmt.Sprintf("page%d_image%d.png", *page, *index)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		fmt.Println("Error writing image:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Image written to", path)
+}
+
+// runForms implements "pixf forms <pdf-file>": it exports the names and
+// values of the PDF's form fields as JSON or FDF
+func runForms(args []string) {
+	fs := flag.NewFlagSet("forms", flag.ExitOnError)
+	format := fs.String("format", "json", "Output format (json, fdf)")
+	out := fs.String("out", "", "File to write (default: standard output)")
+	ignorePerms := fs.Bool("ignore-permissions", false, "Export even if the PDF's permissions forbid it")
+	fs.Parse(reorderArgs(fs, args))
+
+	if fs.NArg() < 1 {
+		fmt.Println("Error: No PDF file specified")
+		fmt.Println("Usage: pixf forms <pdf-file> [--format json|fdf] [--out file]")
+		os.Exit(1)
+	}
+	if *format != "json" && *format != "fdf" {
+		fmt.Printf("Error: Unsupported format '%s'\n", *format)
+		fmt.Println("Supported formats: json, fdf")
+		os.Exit(1)
+	}
+
+	fields, err := imageHandling.FormFields(context.Background(), imageHandling.LongPath(fs.Arg(0)), *ignorePerms)
+	if err != nil {
+		fmt.Println("Error reading form fields:", describeError(err, 0))
+		os.Exit(1)
+	}
+
+	var buf bytes.Buffer
+	if *format == "fdf" {
+		imageHandling.WriteFDF(&buf, fields, filepath.Base(fs.Arg(0)))
+	} else {
+		if fields == nil {
+			fields = []imageHandling.FormField{}
+		}
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		enc.Encode(fields)
+	}
+
+	if *out == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	if err := os.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		fmt.Println("Error writing form data:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d form field(s) written to %s\n", len(fields), *out)
+}
+
+// runAnnots implements "pixf annots <pdf-file>": it lists comments,
+// highlights and other annotations for review workflows
+func runAnnots(args []string) {
+	fs := flag.NewFlagSet("annots", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the list as JSON")
+	ignorePerms := fs.Bool("ignore-permissions", false, "List even if the PDF's permissions forbid extraction")
+	fs.Parse(reorderArgs(fs, args))
+
+	if fs.NArg() < 1 {
+		fmt.Println("Error: No PDF file specified")
+		fmt.Println("Usage: pixf annots [--json] <pdf-file>")
+		os.Exit(1)
+	}
+
+	annots, err := imageHandling.Annotations(context.Background(), imageHandling.LongPath(fs.Arg(0)), *ignorePerms)
+	if err != nil {
+		fmt.Println("Error reading annotations:", describeError(err, 0))
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		if annots == nil {
+			annots = []imageHandling.Annotation{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(annots)
+		return
+	}
+
+	fmt.Printf("%4s  %-12s %-20s %s\n", "Page", "Type", "Author", "Contents")
+	for _, a := range annots {
+		fmt.Printf("%4d  %-12s %-20s %s\n", a.Page, a.Type, clip(a.Author, 20), clip(a.Contents, 60))
+	}
+	fmt.Printf("%d annotation(s)\n", len(annots))
+}
+
+// runPack implements "pixf pack <dir> <out>": it packs extracted page
+// images into a CBZ comic book or a fixed-layout EPUB
+func runPack(args []string) {
+	fs := flag.NewFlagSet("pack", flag.ExitOnError)
+	title := fs.String("title", "", "Book title (default: the PDF or directory name)")
+	author := fs.String("author", "", "Author")
+	lang := fs.String("lang", "", "Language, e.g. en or ja")
+	rtl := fs.Bool("rtl", false, "Pages are read right to left (manga)")
+	fs.Parse(reorderArgs(fs, args))
+
+	if fs.NArg() < 2 {
+		fmt.Println("Error: No image directory or output file specified")
+		fmt.Println("Usage: pixf pack <dir> <out.cbz|out.epub> [--title t] [--author a] [--lang code] [--rtl]")
+		os.Exit(1)
+	}
+	out := fs.Arg(1)
+	if ext := strings.ToLower(filepath.Ext(out)); ext != ".cbz" && ext != ".epub" {
+		fmt.Printf("Error: Unsupported output '%s'\n", out)
+		fmt.Println("Supported formats: .cbz, .epub")
+		os.Exit(1)
+	}
+
+	n, err := imageHandling.Pack(fs.Arg(0), out, imageHandling.PackInfo{
+		Title:    *title,
+		Author:   *author,
+		Language: *lang,
+		RTL:      *rtl,
+	})
+	if err != nil {
+		fmt.Println("Error packing images:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d page(s) packed into %s\n", n, out)
+}
+
+// runAssemble implements "pixf assemble --from-manifest <dir> <out.pdf>":
+// it rebuilds a PDF from an output directory, the way back of extraction
+func runAssemble(args []string) {
+	fs := flag.NewFlagSet("assemble", flag.ExitOnError)
+	from := fs.String("from-manifest", "", "Output directory (or its manifest.json) to rebuild the PDF from")
+	fs.Parse(reorderArgs(fs, args))
+
+	if *from == "" || fs.NArg() < 1 {
+		fmt.Println("Error: No output directory or PDF specified")
+		fmt.Println("Usage: pixf assemble --from-manifest <dir> <out.pdf>")
+		os.Exit(1)
+	}
+	dir := *from
+	if filepath.Base(dir) == imageHandling.ManifestName {
+		dir = filepath.Dir(dir)
+	}
+	out := fs.Arg(0)
+	if !strings.EqualFold(filepath.Ext(out), ".pdf") {
+		fmt.Printf("Error: Unsupported output '%s'\n", out)
+		fmt.Println("Supported formats: .pdf")
+		os.Exit(1)
+	}
+
+	n, err := imageHandling.Assemble(dir, out)
+	if err != nil {
+		fmt.Println("Error assembling PDF:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d page(s) assembled into %s\n", n, out)
+}
+
+// clip shortens s to its first line and at most n characters
+func clip(s string, n int) string {
+	s, _, cut := strings.Cut(strings.ReplaceAll(s, "\r", "\n"), "\n")
+	if r := []rune(s); len(r) > n {
+		s, cut = string(r[:n-1]), true
+	}
+	if cut {
+		s += "…"
+	}
+	return s
+}
+
+// sigsReport is the JSON output of "pixf sigs"
+type sigsReport struct {
+	Signatures []imageHandling.SignatureInfo `json:"signatures"`
+	Validator  *validatorResult              `json:"validator,omitempty"`
+}
+
+// validatorResult is the verdict of the --validator command
+type validatorResult struct {
+	Command string `json:"command"`
+	Valid   bool   `json:"valid"`
+	Output  string `json:"output,omitempty"`
+}
+
+// runSigs implements "pixf sigs <pdf-file>": it lists the digital
+// signatures of a PDF with their signers and validity, so signed
+// documents can be recognized before their images are extracted
+func runSigs(args []string) {
+	fs := flag.NewFlagSet("sigs", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	trust := fs.String("trust", "", "PEM file of additional trusted root certificates")
+	validator := fs.String("validator", "", "External validator command, e.g. \"pdfsig {in}\"")
+	fs.Parse(reorderArgs(fs, args))
+
+	if fs.NArg() < 1 {
+		fmt.Println("Error: No PDF file specified")
+		fmt.Println("Usage: pixf sigs [--json] [--trust roots.pem] [--validator cmd] <pdf-file>")
+		os.Exit(1)
+	}
+
+	var roots *x509.CertPool
+	if *trust != "" {
+		var err error
+		if roots, err = trustedRoots(*trust); err != nil {
+			fmt.Println("Error reading trusted certificates:", err)
+			os.Exit(1)
+		}
+	}
+
+	filename := imageHandling.LongPath(fs.Arg(0))
+	sigs, err := imageHandling.Signatures(context.Background(), filename, roots)
+	if err != nil {
+		fmt.Println("Error reading signatures:", err)
+		os.Exit(1)
+	}
+	report := sigsReport{Signatures: sigs}
+	if *validator != "" {
+		valid, output, err := imageHandling.ValidateCommand(context.Background(), *validator, filename)
+		if err != nil {
+			fmt.Println("Error running validator:", err)
+			os.Exit(1)
+		}
+		report.Validator = &validatorResult{Command: *validator, Valid: valid, Output: output}
+	}
+
+	if *asJSON {
+		if report.Signatures == nil {
+			report.Signatures = []imageHandling.SignatureInfo{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+
+	for i, sig := range sigs {
+		kind := sig.Type + " signature"
+		if sig.Type == "timestamp" {
+			kind = "document timestamp"
+		}
+		if sig.Certified {
+			kind += ", certified"
+		}
+		if sig.Page > 0 {
+			kind += fmt.Sprintf(", visible on page %d", sig.Page)
+		}
+		fmt.Printf("%d: %s\n", i+1, kind)
+		if sig.Field != "" {
+			fmt.Println("   Field: ", sig.Field)
+		}
+		fmt.Println("   Signer:", sig.Signer)
+		if !sig.SigningTime.IsZero() {
+			fmt.Println("   Signed:", sig.SigningTime.Format(time.RFC3339))
+		}
+		fmt.Printf("   Status: %s (%s)\n", sig.Status, sig.Reason)
+		for _, p := range sig.Problems {
+			fmt.Println("          ", p)
+		}
+	}
+	fmt.Printf("%d signature(s)\n", len(sigs))
+
+	if v := report.Validator; v != nil {
+		verdict := "rejected"
+		if v.Valid {
+			verdict = "accepted"
+		}
+		fmt.Println("Validator", verdict, "the signatures")
+		for _, line := range strings.Split(v.Output, "\n") {
+			if line != "" {
+				fmt.Println("  " + line)
+			}
+		}
+	}
+}
+
+// trustedRoots returns the system roots plus the certificates in the PEM
+// file at path
+func trustedRoots(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", path)
+	}
+	return roots, nil
+}
+
+// reorderArgs moves flags after the first positional argument to the
+// front, so "pixf grab file.pdf --page 3" parses like the usage suggests
+func reorderArgs(fs *flag.FlagSet, args []string) []string {
+	var flags, rest []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			rest = append(rest, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(a, "-") || a == "-" {
+			rest = append(rest, a)
+			continue
+		}
+		flags = append(flags, a)
+		name := strings.SplitN(strings.TrimLeft(a, "-"), "=", 2)[0]
+		if f := fs.Lookup(name); f != nil && !strings.Contains(a, "=") && i+1 < len(args) {
+			if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !bf.IsBoolFlag() {
+				flags = append(flags, args[i+1])
+				i++
+			}
+		}
+	}
+	return append(flags, rest...)
+}
@@ -2,18 +2,21 @@ module pixf
 
 go 1.25.6
 
-require github.com/pdfcpu/pdfcpu v0.11.1
+require (
+	github.com/chai2010/webp v1.4.0
+	github.com/hhrutter/pkcs7 v0.2.0
+	github.com/pdfcpu/pdfcpu v0.11.1
+	golang.org/x/image v0.36.0
+	golang.org/x/text v0.34.0
+	software.sslmate.com/src/go-pkcs12 v0.5.0
+)
 
 require (
-	github.com/chai2010/webp v1.4.0 // indirect
 	github.com/clipperhouse/uax29/v2 v2.6.0 // indirect
 	github.com/hhrutter/lzw v1.0.0 // indirect
-	github.com/hhrutter/pkcs7 v0.2.0 // indirect
 	github.com/hhrutter/tiff v1.0.2 // indirect
 	github.com/mattn/go-runewidth v0.0.19 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/crypto v0.48.0 // indirect
-	golang.org/x/image v0.36.0 // indirect
-	golang.org/x/text v0.34.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
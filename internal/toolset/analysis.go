@@ -0,0 +1,111 @@
+package imageHandling
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// Number of dominant colors and luminance buckets recorded per image
+const (
+	dominantColorCount = 5
+	luminanceBuckets   = 16
+	maxAnalysisSamples = 1 << 20
+)
+
+// ImageAnalysis holds color statistics of one image
+type ImageAnalysis struct {
+	DominantColors []DominantColor `json:"dominant_colors"`
+	Luminance      []float64       `json:"luminance_histogram"` // Share of pixels per bucket, dark to bright
+}
+
+// DominantColor is an average color and the share of pixels it covers
+type DominantColor struct {
+	Hex   string  `json:"hex"`
+	Share float64 `json:"share"`
+}
+
+// colorBin accumulates pixels falling into one quantized color cell
+type colorBin struct {
+	count    int
+	r, g, b  int
+	binIndex int
+}
+
+// analyzeImage computes dominant colors and a luminance histogram.
+// Colors are quantized to 4 bits per channel; fully transparent pixels
+// are ignored and very large images are sampled.
+func analyzeImage(img *image.RGBA) *ImageAnalysis {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	step := 1
+	if w*h > maxAnalysisSamples {
+		step = int(math.Ceil(math.Sqrt(float64(w*h) / maxAnalysisSamples)))
+	}
+
+	bins := make([]colorBin, 4096)
+	hist := make([]int, luminanceBuckets)
+	total := 0
+
+	for y := 0; y < h; y += step {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x += step {
+			p := row[x*4 : x*4+4]
+			if p[3] == 0 {
+				continue
+			}
+			r, g, bl := int(p[0]), int(p[1]), int(p[2])
+
+			bin := &bins[(r>>4)<<8|(g>>4)<<4|bl>>4]
+			bin.count++
+			bin.r += r
+			bin.g += g
+			bin.b += bl
+
+			// Rec. 709 luma
+			lum := (2126*r + 7152*g + 722*bl) / 10000
+			hist[lum*luminanceBuckets/256]++
+			total++
+		}
+	}
+
+	result := &ImageAnalysis{
+		DominantColors: []DominantColor{},
+		Luminance:      make([]float64, luminanceBuckets),
+	}
+	if total == 0 {
+		return result
+	}
+
+	for i := range bins {
+		bins[i].binIndex = i
+	}
+	sort.Slice(bins, func(i, j int) bool {
+		if bins[i].count != bins[j].count {
+			return bins[i].count > bins[j].count
+		}
+		return bins[i].binIndex < bins[j].binIndex
+	})
+
+	for _, bin := range bins[:dominantColorCount] {
+		if bin.count == 0 {
+			break
+		}
+		result.DominantColors = append(result.DominantColors, DominantColor{
+			Hex:   fmt.Sprintf("#%02X%02X%02X", bin.r/bin.count, bin.g/bin.count, bin.b/bin.count),
+			Share: roundShare(bin.count, total),
+		})
+	}
+
+	for i, n := range hist {
+		result.Luminance[i] = roundShare(n, total)
+	}
+	return result
+}
+
+// roundShare returns n/total rounded to four decimals
+func roundShare(n, total int) float64 {
+	return math.Round(float64(n)/float64(total)*10000) / 10000
+}
@@ -0,0 +1,102 @@
+package imageHandling
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Annotation is a comment, highlight or other annotation on a page
+type Annotation struct {
+	Type     string     `json:"type"`               // Subtype, e.g. Text, Highlight, FreeText, Ink
+	Page     int        `json:"page"`               // Page the annotation is on
+	Rect     [4]float64 `json:"rect"`               // x0, y0, x1, y1 in PDF points from the bottom left
+	Author   string     `json:"author,omitempty"`   // Title entry, by convention the author
+	Subject  string     `json:"subject,omitempty"`  // Short description of the topic
+	Contents string     `json:"contents,omitempty"` // Text of the comment
+	Modified time.Time  `json:"modified,omitzero"`  // Last modification as recorded by the viewer
+}
+
+// Annotations lists the annotations of filename in page order. Form field
+// widgets are left to FormFields, and popups, which only show the text of
+// their parent annotation, are skipped.
+func Annotations(ctx context.Context, filename string, ignorePerms bool) ([]Annotation, error) {
+	pdf, closePDF, err := openPDF(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer closePDF()
+	if !ignorePerms && !allowsExtraction(pdf) {
+		return nil, ErrExtractionForbidden
+	}
+
+	var annots []Annotation
+	for page := 1; page <= pdf.PageCount; page++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		d, _, _, err := pdf.PageDict(page, false)
+		if err != nil || d == nil {
+			continue
+		}
+		arr, err := pdf.DereferenceArray(d["Annots"])
+		if err != nil {
+			continue
+		}
+		for _, o := range arr {
+			ad, err := pdf.DereferenceDict(o)
+			if err != nil || ad == nil {
+				continue
+			}
+			subtype := ad.NameEntry("Subtype")
+			if subtype == nil || *subtype == "Widget" || *subtype == "Popup" {
+				continue
+			}
+			a := Annotation{
+				Type:     *subtype,
+				Page:     page,
+				Rect:     annotRect(pdf, ad["Rect"]),
+				Author:   annotText(pdf, ad["T"]),
+				Subject:  annotText(pdf, ad["Subj"]),
+				Contents: annotText(pdf, ad["Contents"]),
+			}
+			if m := annotText(pdf, ad["M"]); m != "" {
+				if t, ok := types.DateTime(m, true); ok {
+					a.Modified = t
+				}
+			}
+			annots = append(annots, a)
+		}
+	}
+	return annots, nil
+}
+
+// annotText decodes a text string entry, giving "" if it is missing or
+// malformed
+func annotText(pdf *model.Context, o types.Object) string {
+	if o == nil {
+		return ""
+	}
+	s, err := pdf.DereferenceStringOrHexLiteral(o, model.V10, nil)
+	if err != nil {
+		return ""
+	}
+	return s
+}
+
+// annotRect reads a rectangle, normalized so x0 <= x1 and y0 <= y1
+func annotRect(pdf *model.Context, o types.Object) [4]float64 {
+	arr, err := pdf.DereferenceArray(o)
+	if err != nil || len(arr) != 4 {
+		return [4]float64{}
+	}
+	var v [4]float64
+	for i, e := range arr {
+		e, _ = pdf.Dereference(e)
+		v[i] = pdfNumber(e)
+	}
+	return [4]float64{math.Min(v[0], v[2]), math.Min(v[1], v[3]), math.Max(v[0], v[2]), math.Max(v[1], v[3])}
+}
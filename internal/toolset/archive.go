@@ -0,0 +1,294 @@
+package imageHandling
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IsArchive reports whether name is a ZIP archive of PDFs pixf reads
+func IsArchive(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".zip")
+}
+
+// ArchiveError is a PDF of an archive that couldn't be extracted
+type ArchiveError struct {
+	Name string // Path within the archive
+	Err  error
+}
+
+func (e *ArchiveError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+func (e *ArchiveError) Unwrap() error {
+	return e.Err
+}
+
+// ExtractArchive extracts the images of every PDF in the ZIP archive into
+// a folder of imgDir named after the PDF, with one manifest for the whole
+// archive whose images record the PDF they come from. The archive isn't
+// unpacked: each PDF is read into memory while it is processed. PDFs
+// encrypted within the archive, with ZipCrypto or AES, are decrypted with
+// opts.ZipPassword as they are read, and refused by engines that would
+// write a decrypted copy. creds gives the credentials to open the PDF with
+// the given path within the archive. PDFs that fail are returned and
+// listed in the manifest, which then never counts as up to date; err is
+// only set if the archive can't be read or the output written. The result
+// adds up the PDFs extracted.
+// Stitched strips and multi-page TIFFs combine the images of one PDF and
+// are not supported.
+func (e *Extractor) ExtractArchive(ctx context.Context, archive string, imgDir string, opts Options, creds func(name string) Credentials) (res *Result, failed []*ArchiveError, err error) {
+	if opts.Stitch != "" || opts.MultiTIFF {
+		return nil, nil, errors.New("stitched strips and multi-page TIFFs are not supported for archives")
+	}
+	archive, imgDir = LongPath(archive), LongPath(imgDir)
+	opts.target = imgDir
+	source := archive
+	if opts.Source != "" {
+		source = LongPath(opts.Source)
+	}
+	ctx = withEvents(ctx, opts.Events, filepath.Base(source))
+	archiveHash, err := HashFile(source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("hash input: %w", err)
+	}
+
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zr.Close()
+	var pdfs []*zip.File
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() && strings.EqualFold(path.Ext(f.Name), ".pdf") {
+			pdfs = append(pdfs, f)
+		}
+	}
+	sort.SliceStable(pdfs, func(i, j int) bool { return pdfs[i].Name < pdfs[j].Name })
+
+	staging, err := beginStaging(imgDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest := &Manifest{
+		Input:     filepath.Base(source),
+		InputHash: archiveHash,
+		Options:   opts.Recorded(),
+		StampHash: stampHash(opts.Stamp),
+		CreatedAt: time.Now().UTC(),
+		Images:    []ManifestImage{},
+		source:    source,
+	}
+	members := make([]archiveMember, len(pdfs))
+	for i, f := range pdfs {
+		open := func() (io.ReadCloser, error) { return openZipFile(f, opts.ZipPassword) }
+		members[i] = archiveMember{name: f.Name, open: open, encrypted: f.Flags&zipEncrypted != 0}
+	}
+	res, failed, err = e.extractMembers(ctx, staging, manifest, members, make(map[string]bool), opts, creds)
+	if err != nil {
+		if interrupted(ctx) {
+			if err := writeInterrupted(staging, manifest); err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, fmt.Errorf("partial results kept in %s: %w", staging, ErrInterrupted)
+		}
+		os.RemoveAll(staging)
+		return nil, nil, err
+	}
+
+	if err := manifest.verifyInput(); err != nil {
+		os.RemoveAll(staging)
+		return nil, nil, err
+	}
+	if err := writeManifest(staging, manifest); err != nil {
+		os.RemoveAll(staging)
+		return nil, nil, err
+	}
+	if opts.Tags {
+		if err := tagImages(staging, opts.Notices); err != nil {
+			os.RemoveAll(staging)
+			return nil, nil, err
+		}
+	}
+	if err := opts.Perms.Apply(staging); err != nil {
+		os.RemoveAll(staging)
+		return nil, nil, fmt.Errorf("set permissions: %w", err)
+	}
+	if err := commitStaging(staging, imgDir); err != nil {
+		return nil, nil, err
+	}
+	if err := opts.DedupIndex.record(imgDir); err != nil {
+		return nil, nil, fmt.Errorf("update dedup index: %w", err)
+	}
+	res.Failed = len(failed)
+	if info, err := os.Stat(source); err == nil {
+		res.BytesIn = info.Size()
+	}
+	res.Seconds = roundMillis(time.Since(manifest.CreatedAt).Seconds())
+	return res, failed, nil
+}
+
+// errEmbeddingCycle is returned for an embedded PDF identical to a PDF it
+// is embedded in
+var errEmbeddingCycle = errors.New("identical to a PDF it is embedded in")
+
+// archiveMember is a PDF within an archive or portfolio
+type archiveMember struct {
+	name      string // Path within the archive
+	open      func() (io.ReadCloser, error)
+	encrypted bool // Encrypted within the archive
+}
+
+// memoryInput is a PDF extracted from memory rather than a file, whose
+// hash and size were taken as it was read
+type memoryInput struct {
+	hash      string
+	size      int64
+	encrypted bool // Decrypted from a ZIP archive, so never to be written to disk
+}
+
+// extractMembers extracts the images of every member into a folder of dir
+// named after it, not taking the names in folders, and adds them to
+// manifest. Members that fail are returned and listed in the manifest; err
+// is only set if ctx is done, and leaves the manifest with the members
+// done so far.
+func (e *Extractor) extractMembers(ctx context.Context, dir string, manifest *Manifest, members []archiveMember, folders map[string]bool, opts Options, creds func(name string) Credentials) (res *Result, failed []*ArchiveError, err error) {
+	res = &Result{}
+	for _, m := range members {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		folder := archiveFolder(m.name, opts.SafeNames, folders)
+		member, memberRes, nested, err := e.extractMember(ctx, m, filepath.Join(dir, folder), opts, creds(m.name))
+		if err != nil {
+			os.RemoveAll(filepath.Join(dir, folder))
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			if errors.Is(err, errEmbeddingCycle) {
+				noticef(opts.Notices, "skipped %s: %v", m.name, err)
+				continue
+			}
+			failed = append(failed, &ArchiveError{Name: m.name, Err: err})
+			manifest.Failed = append(manifest.Failed, m.name)
+			continue
+		}
+		// PDFs embedded in a portfolio member are named by their path
+		// through it
+		for _, f := range nested {
+			f.Name = m.name + "/" + f.Name
+			failed = append(failed, f)
+			manifest.Failed = append(manifest.Failed, f.Name)
+		}
+		for _, img := range member.Images {
+			img.File = path.Join(folder, img.File)
+			img.Document = memberDocument(m.name, img.Document)
+			manifest.Images = append(manifest.Images, img)
+		}
+		for _, sk := range member.Skipped {
+			sk.File = path.Join(folder, sk.File)
+			sk.Document = memberDocument(m.name, sk.Document)
+			manifest.Skipped = append(manifest.Skipped, sk)
+		}
+		for _, t := range member.Truncated {
+			t.Document = memberDocument(m.name, t.Document)
+			manifest.Truncated = append(manifest.Truncated, t)
+		}
+		manifest.Stages = AddStages(manifest.Stages, member.Stages)
+		manifest.Indexed += member.Indexed
+		res.Add(memberRes)
+		res.Documents++
+	}
+	return res, failed, nil
+}
+
+// memberDocument is the document recorded for an image of member name
+// that came from doc within it ("" for the member itself)
+func memberDocument(name, doc string) string {
+	if doc == "" {
+		return name
+	}
+	return name + "/" + doc
+}
+
+// extractMember extracts the images of the PDF m into dir and returns its
+// manifest, which is removed from dir, and its summary. If m is a
+// portfolio, the PDFs embedded in it that failed are returned as well.
+func (e *Extractor) extractMember(ctx context.Context, m archiveMember, dir string, opts Options, creds Credentials) (*Manifest, *Result, []*ArchiveError, error) {
+	ctx = withNestedEvents(ctx, m.name)
+	r, err := m.open()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("read from archive: %w", err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("read from archive: %w", err)
+	}
+	input := &memoryInput{
+		hash:      fmt.Sprintf("%x", sha256.Sum256(data)),
+		size:      int64(len(data)),
+		encrypted: m.encrypted || opts.memory != nil && opts.memory.encrypted,
+	}
+	if slices.Contains(opts.enclosing, input.hash) {
+		return nil, nil, nil, errEmbeddingCycle
+	}
+
+	doc, err := openDocumentData(ctx, m.name, data, creds)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer doc.Close()
+	if doc.protected {
+		eventsOf(ctx).emit(Event{Type: EventDecrypted})
+	}
+	if !opts.IgnorePerms && !doc.AllowsExtraction() {
+		return nil, nil, nil, ErrExtractionForbidden
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, nil, err
+	}
+	opts.Source, opts.memory = "", input
+	var res *Result
+	var nested []*ArchiveError
+	if embedsPDFs(doc, opts) {
+		res, nested, err = e.extractEmbedded(ctx, doc, doc.filename, dir, opts)
+	} else {
+		res, err = e.extractToDir(ctx, doc.pdf, doc.filename, dir, opts)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := os.Remove(filepath.Join(dir, ManifestName)); err != nil {
+		return nil, nil, nil, err
+	}
+	return manifest, res, nested, nil
+}
+
+// archiveFolder names the output folder of the archived PDF name after
+// its file name, numbered on if an earlier PDF took it
+func archiveFolder(name string, ascii bool, taken map[string]bool) string {
+	base := path.Base(strings.ReplaceAll(name, `\`, "/"))
+	stem := SanitizeName(strings.TrimSuffix(base, path.Ext(base)), ascii)
+	folder := stem
+	for n := 2; taken[strings.ToLower(folder)]; n++ {
+		folder = fmt.Sprintf("%s_%d", stem, n)
+	}
+	taken[strings.ToLower(folder)] = true
+	return folder
+}
@@ -0,0 +1,341 @@
+package imageHandling
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Assemble rebuilds a PDF at out from the images of the output directory
+// dir and the layout its manifest records: every page at its original size
+// and rotation, with the images drawn where and in the order they were.
+// JPEG and JPEG 2000 files are embedded as they are; other images are
+// stored losslessly. Text and vector graphics are not extracted, so the
+// pages hold the images only. Returns the number of pages.
+func Assemble(dir, out string) (int, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return 0, err
+	}
+	if len(m.Pages) == 0 {
+		return 0, errors.New("the manifest records no page layout; extract again with --force")
+	}
+	for _, img := range m.Images {
+		if img.Document != "" {
+			return 0, errors.New("the manifest covers the PDFs of an archive; assemble works on one PDF")
+		}
+	}
+
+	// Written next to out and renamed, so out is never half a PDF
+	f, err := os.CreateTemp(filepath.Dir(out), ".assemble*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	err = writeAssembled(f, dir, m)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("write %s: %w", out, err)
+	}
+	if err := os.Rename(f.Name(), out); err != nil {
+		return 0, err
+	}
+	return len(m.Pages), nil
+}
+
+// pagePlacement is an image drawn on a page being assembled
+type pagePlacement struct {
+	ImagePlacement
+	image int // Index into the manifest images
+}
+
+// writeAssembled writes the PDF for manifest m of dir to w
+func writeAssembled(w io.Writer, dir string, m *Manifest) error {
+	pw := newPDFWriter(w)
+	catalog, pages := pw.alloc(), pw.alloc()
+
+	byPage := make(map[int][]pagePlacement)
+	for i, img := range m.Images {
+		for _, p := range img.Placements {
+			byPage[p.Page] = append(byPage[p.Page], pagePlacement{p, i})
+		}
+	}
+
+	// Images are written once, however often they are drawn
+	xobjects := make(map[int]int)
+	var kids []string
+	for _, box := range m.Pages {
+		placed := byPage[box.Page]
+		sort.SliceStable(placed, func(a, b int) bool { return placed[a].Z < placed[b].Z })
+
+		var content, resources bytes.Buffer
+		used := make(map[int]bool)
+		for _, p := range placed {
+			img := m.Images[p.image]
+			if _, ok := xobjects[p.image]; !ok {
+				nr, err := pw.image(filepath.Join(dir, filepath.FromSlash(img.File)))
+				if err != nil {
+					return fmt.Errorf("%s: %w", img.File, err)
+				}
+				xobjects[p.image] = nr
+			}
+			t := matrix(p.Transform)
+			// A half of a split spread fills its half of the original
+			switch img.Part {
+			case PartLeft:
+				t = matrix{0.5, 0, 0, 1, 0, 0}.mul(t)
+			case PartRight:
+				t = matrix{0.5, 0, 0, 1, 0.5, 0}.mul(t)
+			}
+			// A tile fills its part of the whole image, whose top is at y = 1
+			if tr := img.Tile; tr != nil {
+				sx, sy := float64(img.Width)/float64(tr.ImageWidth), float64(img.Height)/float64(tr.ImageHeight)
+				t = matrix{sx, 0, 0, sy, float64(tr.X) / float64(tr.ImageWidth), 1 - sy - float64(tr.Y)/float64(tr.ImageHeight)}.mul(t)
+			}
+			fmt.Fprintf(&content, "q %s cm /Im%d Do Q\n", pdfNumbers(t[:]), p.image)
+			if !used[p.image] {
+				used[p.image] = true
+				fmt.Fprintf(&resources, "/Im%d %d 0 R ", p.image, xobjects[p.image])
+			}
+		}
+
+		contentNr, err := pw.stream("", content.Bytes())
+		if err != nil {
+			return err
+		}
+		page := pw.alloc()
+		dict := fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [%s] /Resources << /XObject << %s>> >> /Contents %d 0 R",
+			pages, pdfNumbers(box.Box[:]), resources.String(), contentNr)
+		if box.Rotate != 0 {
+			dict += fmt.Sprintf(" /Rotate %d", box.Rotate)
+		}
+		if err := pw.object(page, dict+" >>"); err != nil {
+			return err
+		}
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+	}
+
+	if err := pw.object(pages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))); err != nil {
+		return err
+	}
+	if err := pw.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pages)); err != nil {
+		return err
+	}
+	return pw.finish(catalog)
+}
+
+// pdfWriter writes a PDF object by object and the cross-reference table
+// at the end
+type pdfWriter struct {
+	w       *bufio.Writer
+	n       int64
+	offsets []int64 // By object number - 1
+	err     error
+}
+
+func newPDFWriter(w io.Writer) *pdfWriter {
+	pw := &pdfWriter{w: bufio.NewWriter(w)}
+	// The binary comment marks the file as binary for transfer tools
+	pw.write([]byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"))
+	return pw
+}
+
+// write appends b, keeping the first error
+func (pw *pdfWriter) write(b []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(b)
+	pw.n += int64(n)
+	pw.err = err
+}
+
+// alloc reserves an object number, so objects can refer to objects
+// written later
+func (pw *pdfWriter) alloc() int {
+	pw.offsets = append(pw.offsets, 0)
+	return len(pw.offsets)
+}
+
+// object writes object nr
+func (pw *pdfWriter) object(nr int, body string) error {
+	pw.offsets[nr-1] = pw.n
+	pw.write(fmt.Appendf(nil, "%d 0 obj\n%s\nendobj\n", nr, body))
+	return pw.err
+}
+
+// stream writes a new stream object with the entries dict, which must
+// not hold /Length, and returns its number
+func (pw *pdfWriter) stream(dict string, data []byte) (int, error) {
+	nr := pw.alloc()
+	pw.offsets[nr-1] = pw.n
+	pw.write(fmt.Appendf(nil, "%d 0 obj\n<< %s/Length %d >>\nstream\n", nr, dict, len(data)))
+	pw.write(data)
+	pw.write([]byte("\nendstream\nendobj\n"))
+	return nr, pw.err
+}
+
+// finish writes the cross-reference table and trailer
+func (pw *pdfWriter) finish(root int) error {
+	xref := pw.n
+	pw.write(fmt.Appendf(nil, "xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets)+1))
+	for _, off := range pw.offsets {
+		pw.write(fmt.Appendf(nil, "%010d 00000 n \n", off))
+	}
+	pw.write(fmt.Appendf(nil, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pw.offsets)+1, root, xref))
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.Flush()
+}
+
+// image writes the image file at path as an image XObject and returns
+// its number. JPEG and JPEG 2000 data is embedded unchanged, as PDFs
+// store it; other formats are decoded and compressed losslessly, with
+// transparency in a soft mask.
+func (pw *pdfWriter) image(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		cfg, err := jpegConfig(data)
+		if err != nil {
+			return 0, err
+		}
+		cs := "/DeviceRGB"
+		switch cfg.ColorModel {
+		case color.GrayModel:
+			cs = "/DeviceGray"
+		case color.CMYKModel:
+			cs = "/DeviceCMYK"
+		}
+		return pw.stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode ",
+			cfg.Width, cfg.Height, cs), data)
+	case ".jp2", ".jpx", ".j2k":
+		w, h, err := jp2Size(data)
+		if err != nil {
+			return 0, err
+		}
+		return pw.stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /Filter /JPXDecode ", w, h), data)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	b := img.Bounds()
+	gray := isGrayImage(img)
+	channels := 3
+	if gray {
+		channels = 1
+	}
+	pix := make([]byte, 0, b.Dx()*b.Dy()*channels)
+	alpha := make([]byte, 0, b.Dx()*b.Dy())
+	opaque := true
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if gray {
+				pix = append(pix, c.R)
+			} else {
+				pix = append(pix, c.R, c.G, c.B)
+			}
+			alpha = append(alpha, c.A)
+			opaque = opaque && c.A == 0xff
+		}
+	}
+
+	smask := ""
+	if !opaque {
+		z, err := deflate(alpha)
+		if err != nil {
+			return 0, err
+		}
+		nr, err := pw.stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode ",
+			b.Dx(), b.Dy()), z)
+		if err != nil {
+			return 0, err
+		}
+		smask = fmt.Sprintf("/SMask %d 0 R ", nr)
+	}
+	cs := "/DeviceRGB"
+	if gray {
+		cs = "/DeviceGray"
+	}
+	z, err := deflate(pix)
+	if err != nil {
+		return 0, err
+	}
+	return pw.stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /FlateDecode %s",
+		b.Dx(), b.Dy(), cs, smask), z)
+}
+
+// jpegConfig reads the dimensions and color model of JPEG data
+func jpegConfig(data []byte) (image.Config, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil && format != "jpeg" {
+		err = fmt.Errorf("not a JPEG but %s", format)
+	}
+	return cfg, err
+}
+
+// jp2Size reads the dimensions of a JPEG 2000 file or codestream from
+// its image header box or SIZ marker
+func jp2Size(data []byte) (int, int, error) {
+	be32 := func(b []byte) int { return int(b[0])<<24 | int(b[1])<<16 | int(b[2])<<8 | int(b[3]) }
+	if i := bytes.Index(data, []byte("ihdr")); i >= 0 && i+12 <= len(data) {
+		return be32(data[i+8:]), be32(data[i+4:]), nil
+	}
+	// SIZ: marker, length, capabilities, then Xsiz, Ysiz, XOsiz, YOsiz
+	if i := bytes.Index(data, []byte{0xff, 0x51}); i >= 0 && i+22 <= len(data) {
+		return be32(data[i+6:]) - be32(data[i+14:]), be32(data[i+10:]) - be32(data[i+18:]), nil
+	}
+	return 0, 0, errors.New("no JPEG 2000 image header")
+}
+
+// isGrayImage reports whether img is stored as gray levels
+func isGrayImage(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.GrayModel, color.Gray16Model:
+		return true
+	}
+	return false
+}
+
+// deflate compresses data for a FlateDecode stream
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pdfNumbers formats numbers for PDF syntax, which has no exponents
+func pdfNumbers(nums []float64) string {
+	s := make([]string, len(nums))
+	for i, v := range nums {
+		s[i] = strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.4f", v), "0"), ".")
+		if s[i] == "-0" {
+			s[i] = "0"
+		}
+	}
+	return strings.Join(s, " ")
+}
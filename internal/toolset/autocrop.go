@@ -0,0 +1,97 @@
+package imageHandling
+
+import (
+	"context"
+	"fmt"
+	"image"
+)
+
+// DefaultCropTolerance is how far a channel may stray from pure black or
+// white and still count as scanner border
+const DefaultCropTolerance = 24
+
+// autocropImages trims uniform black or white borders off every image on
+// the encode pool
+func (e *Extractor) autocropImages(ctx context.Context, images []LoadedImage, tolerance int) error {
+	r := newRun(ctx)
+	for i := range images {
+		img := &images[i]
+		ok := r.submit(e.encode, func() error {
+			rect := cropRect(img.Img, uint8(tolerance))
+			if rect == img.Img.Rect {
+				return nil
+			}
+			cropped := cropCopy(img.Img, rect)
+			putRGBA(img.Img)
+			img.Img = cropped
+			img.Width, img.Height = rect.Dx(), rect.Dy()
+			return nil
+		})
+		if !ok {
+			break
+		}
+	}
+	if err := r.wait(); err != nil {
+		return fmt.Errorf("autocrop: %w", err)
+	}
+	return nil
+}
+
+// cropRect returns the bounds of img without its borders. A border is a
+// run of rows or columns that are all black or all white within tolerance.
+// Sides are trimmed repeatedly, so a black edge on one side doesn't hide
+// a white edge on the next. An image that is all border is kept whole.
+func cropRect(img *image.RGBA, tolerance uint8) image.Rectangle {
+	r := img.Rect
+	for changed := true; changed; {
+		changed = false
+		for r.Dy() > 1 && uniformLine(img, r.Min.X, r.Min.Y, 1, 0, r.Dx(), tolerance) {
+			r.Min.Y++
+			changed = true
+		}
+		for r.Dy() > 1 && uniformLine(img, r.Min.X, r.Max.Y-1, 1, 0, r.Dx(), tolerance) {
+			r.Max.Y--
+			changed = true
+		}
+		for r.Dx() > 1 && uniformLine(img, r.Min.X, r.Min.Y, 0, 1, r.Dy(), tolerance) {
+			r.Min.X++
+			changed = true
+		}
+		for r.Dx() > 1 && uniformLine(img, r.Max.X-1, r.Min.Y, 0, 1, r.Dy(), tolerance) {
+			r.Max.X--
+			changed = true
+		}
+	}
+	// Nothing but border: a blank page, not something to crop
+	if r.Dx() <= 1 || r.Dy() <= 1 {
+		return img.Rect
+	}
+	return r
+}
+
+// uniformLine reports whether n pixels from (x, y) in steps of (dx, dy) are
+// all near black or all near white
+func uniformLine(img *image.RGBA, x, y, dx, dy, n int, tolerance uint8) bool {
+	black, white := true, true
+	for i := 0; i < n && (black || white); i++ {
+		p := img.Pix[img.PixOffset(x+i*dx, y+i*dy):]
+		for _, c := range p[:3] {
+			if c > tolerance {
+				black = false
+			}
+			if c < 255-tolerance {
+				white = false
+			}
+		}
+	}
+	return black || white
+}
+
+// cropCopy copies rect of img into a new image with its origin at (0, 0)
+func cropCopy(img *image.RGBA, rect image.Rectangle) *image.RGBA {
+	dst := getRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	for y := 0; y < rect.Dy(); y++ {
+		copy(dst.Pix[y*dst.Stride:(y+1)*dst.Stride], img.Pix[img.PixOffset(rect.Min.X, rect.Min.Y+y):])
+	}
+	return dst
+}
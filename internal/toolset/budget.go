@@ -0,0 +1,41 @@
+package imageHandling
+
+// Truncation records the images of a document left out because their
+// output would have exceeded Options.MaxTotalOutput
+type Truncation struct {
+	Document string `json:"document,omitempty"` // PDF within the archive, or embedded PDF, that was cut short
+	Budget   int64  `json:"budget"`             // Options.MaxTotalOutput
+	Omitted  int    `json:"omitted"`            // Unique images not written
+	Page     int    `json:"page"`               // Page of the first image not written
+}
+
+// applyBudget keeps images, in order, while their estimated output fits
+// within opts.MaxTotalOutput and leaves out the rest with their
+// duplicates, returning what was left out (nil = all fit). Original files
+// are counted at their size, converted ones as estimated for the disk
+// space check, which errs on the large side.
+func applyBudget(images []LoadedImage, dups []duplicate, opts Options) ([]LoadedImage, []duplicate, *Truncation) {
+	if opts.MaxTotalOutput <= 0 {
+		return images, dups, nil
+	}
+	var total float64
+	for i, img := range images {
+		if total += estimateImage(img, opts.Format); total <= float64(opts.MaxTotalOutput) {
+			continue
+		}
+		var kept []duplicate
+		for _, d := range dups {
+			if d.Of < i {
+				kept = append(kept, d)
+			} else {
+				putRGBA(d.Image.Img)
+			}
+		}
+		for _, left := range images[i:] {
+			putRGBA(left.Img)
+		}
+		noticef(opts.Notices, "left out %d image(s) from page %d on: output over %s", len(images)-i, img.Page, FormatSize(opts.MaxTotalOutput))
+		return images[:i], kept, &Truncation{Budget: opts.MaxTotalOutput, Omitted: len(images) - i, Page: img.Page}
+	}
+	return images, dups, nil
+}
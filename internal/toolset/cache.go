@@ -0,0 +1,234 @@
+package imageHandling
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultCacheBytes is the cache size cap when Cache.MaxBytes is unset
+const DefaultCacheBytes = 1 << 30
+
+// Names within a cache entry
+const (
+	cacheImages   = "images"
+	cacheUnlocked = "unlocked.pdf"
+)
+
+// Cache keeps the results of earlier runs on disk, keyed by the hash of
+// the input and the options, so processing the same document again is a
+// copy. Once the entries take more than MaxBytes, the least recently used
+// are evicted. Entries are written under a temporary name and renamed, so
+// concurrent runs sharing a cache never see half an entry.
+type Cache struct {
+	Dir      string
+	MaxBytes int64 // Size cap (0 = DefaultCacheBytes)
+}
+
+// DefaultCacheDir is the cache location in the user's cache directory,
+// e.g. ~/.cache/pixf on Linux
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pixf"), nil
+}
+
+// CacheKey identifies the result of processing an input with the given
+// hash in mode (e.g. "extract" or "unlock+extract") with opts. The stamp
+// image is keyed by content; external commands (Options.UpscaleCmd,
+// Options.OptimizeCmd) only by the command line, so results stay cached
+// when the tool behind a command changes.
+func CacheKey(inputHash, mode string, opts Options) (string, error) {
+	o, err := json.Marshal(opts)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", inputHash, mode, stampHash(opts.Stamp))
+	h.Write(o)
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Restore copies the entry for key to imgDir, replacing what is there.
+// If the entry holds an unlocked copy and unlocked isn't "", that is
+// copied to unlocked. The manifest is updated to name source as the
+// input. hit is false when there is no entry for key.
+func (c *Cache) Restore(key, imgDir, unlocked, source string) (hit, restoredUnlocked bool, err error) {
+	entry := filepath.Join(c.Dir, key)
+	if _, err := os.Stat(filepath.Join(entry, cacheImages, ManifestName)); err != nil {
+		return false, false, nil
+	}
+	// Mark the entry as used before copying, so eviction by a concurrent
+	// run picks older ones
+	now := time.Now()
+	os.Chtimes(entry, now, now)
+
+	staging, err := beginStaging(imgDir)
+	if err != nil {
+		return false, false, err
+	}
+	if err := copyTree(staging, filepath.Join(entry, cacheImages)); err != nil {
+		os.RemoveAll(staging)
+		return false, false, fmt.Errorf("restore from cache: %w", err)
+	}
+	if m, err := ReadManifest(staging); err == nil {
+		m.Input = source
+		if err := writeManifest(staging, m); err != nil {
+			os.RemoveAll(staging)
+			return false, false, err
+		}
+	}
+	if _, err := os.Stat(filepath.Join(entry, cacheUnlocked)); err == nil && unlocked != "" {
+		if err := copyFile(unlocked, filepath.Join(entry, cacheUnlocked)); err != nil {
+			os.RemoveAll(staging)
+			return false, false, fmt.Errorf("restore from cache: %w", err)
+		}
+		restoredUnlocked = true
+	}
+	if err := commitStaging(staging, imgDir); err != nil {
+		return false, false, err
+	}
+	return true, restoredUnlocked, nil
+}
+
+// Store adds imgDir, and the unlocked copy unless unlocked is "", to the
+// cache as the entry for key and evicts entries beyond the size cap.
+// Results larger than the cap on their own are not cached.
+func (c *Cache) Store(key, imgDir, unlocked string) error {
+	size, err := treeSize(imgDir)
+	if err != nil {
+		return err
+	}
+	if unlocked != "" {
+		info, err := os.Stat(unlocked)
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+	}
+	if size > c.maxBytes() {
+		return nil
+	}
+
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(c.Dir, ".store")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyTree(filepath.Join(tmp, cacheImages), imgDir); err != nil {
+		return fmt.Errorf("store in cache: %w", err)
+	}
+	if unlocked != "" {
+		if err := copyFile(filepath.Join(tmp, cacheUnlocked), unlocked); err != nil {
+			return fmt.Errorf("store in cache: %w", err)
+		}
+	}
+
+	entry := filepath.Join(c.Dir, key)
+	os.RemoveAll(entry)
+	if err := os.Rename(tmp, entry); err != nil {
+		return fmt.Errorf("store in cache: %w", err)
+	}
+	return c.evict(key)
+}
+
+// maxBytes is the effective size cap
+func (c *Cache) maxBytes() int64 {
+	if c.MaxBytes > 0 {
+		return c.MaxBytes
+	}
+	return DefaultCacheBytes
+}
+
+// evict removes the least recently used entries until the cache fits its
+// cap; keep, the entry just stored, stays
+func (c *Cache) evict(keep string) error {
+	dirents, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return err
+	}
+	type cacheEntry struct {
+		path string
+		used time.Time
+		size int64
+	}
+	var entries []cacheEntry
+	var total int64
+	for _, d := range dirents {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(c.Dir, d.Name())
+		size, err := treeSize(path)
+		if err != nil {
+			continue
+		}
+		total += size
+		if d.Name() != keep {
+			entries = append(entries, cacheEntry{path, info.ModTime(), size})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+	for _, e := range entries {
+		if total <= c.maxBytes() {
+			break
+		}
+		if err := os.RemoveAll(e.path); err != nil {
+			return fmt.Errorf("evict %s: %w", e.path, err)
+		}
+		total -= e.size
+	}
+	return nil
+}
+
+// treeSize adds up the sizes of the files below dir
+func treeSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// copyTree copies the directory src to dst, which is created if needed
+// and should be empty
+func copyTree(dst, src string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(target, path)
+	})
+}
@@ -0,0 +1,538 @@
+package imageHandling
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Caption search is a heuristic: text is only read from fonts with a
+// ToUnicode map or a simple 8-bit encoding, and glyph widths are estimated.
+const (
+	captionGap      = 48.0 // Largest distance in points between image and caption
+	captionMaxRunes = 80   // Longer captions are cut at a word boundary
+	maxFormDepth    = 8    // Nesting limit for form XObjects
+	avgGlyphWidth   = 0.5  // Estimated glyph advance, in text space units per size
+)
+
+// captionPattern matches the usual figure labels, which win over other text
+var captionPattern = regexp.MustCompile(`(?i)^(fig(ure|\.)?|abb(ildung|\.)?|table|tab\.|plate|image|photo|chart|diagram|illustration|exhibit|map)\s*[0-9ivxlc]`)
+
+// rect is an axis-aligned box in page space
+type rect struct{ x0, y0, x1, y1 float64 }
+
+// textRun is a string shown at one position
+type textRun struct {
+	x, y, size float64
+	text       string
+}
+
+// textLine is a run of text on one baseline
+type textLine struct {
+	rect
+	text string
+}
+
+// pageScan collects image placements and text of one page
+type pageScan struct {
+	pdf    *model.Context
+	text   bool // Collect text runs too
+	fonts  map[int]*fontInfo
+	places map[int]rect // First placement per image object number
+	draws  []imageDraw  // Every image drawn, in drawing order
+	runs   []textRun
+}
+
+// imageDraw is an image object drawn with a CTM
+type imageDraw struct {
+	objNr int
+	ctm   matrix
+}
+
+// findCaptions maps images to the caption next to their first placement
+// on the page they were found on, keyed by page and object number. Pages
+// whose content can't be read are skipped.
+func findCaptions(pdf *model.Context, images []LoadedImage) map[[2]int]string {
+	pages := make(map[int]bool)
+	for _, img := range images {
+		pages[img.Page] = true
+	}
+	captions := make(map[[2]int]string)
+	for page := range pages {
+		scan, err := scanPage(pdf, page, true)
+		if err != nil {
+			continue
+		}
+		lines := scan.lines()
+		for objNr, r := range scan.places {
+			if c := captionFor(r, lines); c != "" {
+				captions[[2]int{page, objNr}] = c
+			}
+		}
+	}
+	return captions
+}
+
+// scanPage interprets the content stream of a page; text is only read
+// with text set
+func scanPage(pdf *model.Context, page int, text bool) (*pageScan, error) {
+	d, _, inh, err := pdf.PageDict(page, true)
+	if err != nil || d == nil {
+		return nil, fmt.Errorf("page %d: %v", page, err)
+	}
+	content, err := pdf.PageContent(d, page)
+	if err != nil {
+		return nil, fmt.Errorf("page %d content: %w", page, err)
+	}
+	var res types.Dict
+	if inh != nil {
+		res = inh.Resources
+	}
+	s := &pageScan{pdf: pdf, text: text, fonts: make(map[int]*fontInfo), places: make(map[int]rect)}
+	s.run(content, res, identity, 0)
+	return s, nil
+}
+
+// run interprets one content stream with the given resources and CTM
+func (s *pageScan) run(content []byte, res types.Dict, ctm matrix, depth int) {
+	type state struct {
+		ctm     matrix
+		font    *fontInfo
+		size    float64
+		leading float64
+	}
+	gs := state{ctm: ctm}
+	var stack []state
+	var tm, tlm matrix
+	space := false // Kerning wide enough to separate words came before
+	lex := &contentLexer{data: content}
+
+	newLine := func(tx, ty float64) {
+		tlm = matrix{1, 0, 0, 1, tx, ty}.mul(tlm)
+		tm = tlm
+	}
+	show := func(str []byte) {
+		if gs.font == nil {
+			return
+		}
+		text := gs.font.decode(str)
+		if space {
+			text, space = " "+text, false
+		}
+		trm := tm.mul(gs.ctm)
+		x, y := trm.apply(0, 0)
+		if size := gs.size * math.Hypot(trm[2], trm[3]); strings.TrimSpace(text) != "" {
+			s.runs = append(s.runs, textRun{x: x, y: y, size: size, text: text})
+		}
+		advance := float64(utf8.RuneCountInString(text)) * avgGlyphWidth * gs.size
+		tm = matrix{1, 0, 0, 1, advance, 0}.mul(tm)
+	}
+
+	for {
+		ops, op := lex.next()
+		if op == "" {
+			return
+		}
+		switch op {
+		case "q":
+			stack = append(stack, gs)
+		case "Q":
+			if n := len(stack); n > 0 {
+				gs, stack = stack[n-1], stack[:n-1]
+			}
+		case "cm":
+			if m, ok := numbers(ops, 6); ok {
+				gs.ctm = matrix(m).mul(gs.ctm)
+			}
+		case "BT":
+			tm, tlm = identity, identity
+			space = false
+		case "Tf":
+			if s.text && len(ops) == 2 {
+				name, _ := ops[0].(pdfName)
+				gs.font = s.font(res, string(name))
+				gs.size, _ = ops[1].(float64)
+			}
+		case "TL":
+			if n, ok := numbers(ops, 1); ok {
+				gs.leading = n[0]
+			}
+		case "Td":
+			if n, ok := numbers(ops, 2); ok {
+				newLine(n[0], n[1])
+			}
+		case "TD":
+			if n, ok := numbers(ops, 2); ok {
+				gs.leading = -n[1]
+				newLine(n[0], n[1])
+			}
+		case "Tm":
+			if m, ok := numbers(ops, 6); ok {
+				tlm = matrix(m)
+				tm = tlm
+			}
+		case "T*":
+			newLine(0, -gs.leading)
+		case "Tj", "'", "\"":
+			if op != "Tj" {
+				newLine(0, -gs.leading)
+			}
+			if len(ops) > 0 {
+				if str, ok := ops[len(ops)-1].([]byte); ok {
+					show(str)
+				}
+			}
+		case "TJ":
+			if len(ops) == 0 {
+				continue
+			}
+			items, _ := ops[0].([]any)
+			for _, item := range items {
+				switch v := item.(type) {
+				case []byte:
+					show(v)
+				case float64:
+					// Large negative kerning separates words
+					space = space || v < -200
+					tm = matrix{1, 0, 0, 1, -v / 1000 * gs.size, 0}.mul(tm)
+				}
+			}
+		case "Do":
+			if len(ops) == 1 {
+				name, _ := ops[0].(pdfName)
+				s.xobject(res, string(name), gs.ctm, depth)
+			}
+		}
+	}
+}
+
+// xobject records an image placement or runs a form
+func (s *pageScan) xobject(res types.Dict, name string, ctm matrix, depth int) {
+	xobjs, err := s.pdf.DereferenceDict(res["XObject"])
+	if err != nil || xobjs == nil {
+		return
+	}
+	ir, ok := xobjs[name].(types.IndirectRef)
+	if !ok {
+		return
+	}
+	sd, _, err := s.pdf.DereferenceStreamDict(ir)
+	if err != nil || sd == nil || sd.Subtype() == nil {
+		return
+	}
+	switch *sd.Subtype() {
+	case "Image":
+		objNr := ir.ObjectNumber.Value()
+		if _, ok := s.places[objNr]; !ok {
+			s.places[objNr] = unitSquare(ctm)
+		}
+		s.draws = append(s.draws, imageDraw{objNr, ctm})
+	case "Form":
+		if depth >= maxFormDepth || sd.Decode() != nil {
+			return
+		}
+		m := identity
+		if arr, err := s.pdf.DereferenceArray(sd.Dict["Matrix"]); err == nil && len(arr) == 6 {
+			for i, o := range arr {
+				m[i] = pdfNumber(o)
+			}
+		}
+		formRes := res
+		if d, err := s.pdf.DereferenceDict(sd.Dict["Resources"]); err == nil && d != nil {
+			formRes = d
+		}
+		s.run(sd.Content, formRes, m.mul(ctm), depth+1)
+	}
+}
+
+// unitSquare returns the bounds of the unit square an image is drawn into
+func unitSquare(ctm matrix) rect {
+	r := rect{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range [4][2]float64{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		x, y := ctm.apply(p[0], p[1])
+		r.x0, r.y0 = min(r.x0, x), min(r.y0, y)
+		r.x1, r.y1 = max(r.x1, x), max(r.y1, y)
+	}
+	return r
+}
+
+// lines joins runs sharing a baseline into lines, split at wide gaps
+// so the columns of a page stay apart
+func (s *pageScan) lines() []textLine {
+	runs := s.runs
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].y > runs[j].y })
+
+	var lines []textLine
+	for start := 0; start < len(runs); {
+		// Runs within a third of the font size share the baseline
+		end := start + 1
+		for end < len(runs) && runs[start].y-runs[end].y <= 0.3*runs[start].size {
+			end++
+		}
+		base := runs[start:end]
+		sort.SliceStable(base, func(i, j int) bool { return base[i].x < base[j].x })
+
+		var cur *textLine
+		for _, r := range base {
+			width := float64(utf8.RuneCountInString(r.text)) * avgGlyphWidth * r.size
+			if cur == nil || r.x-cur.x1 > 2*r.size {
+				lines = append(lines, textLine{rect: rect{r.x, r.y, r.x, r.y + r.size}})
+				cur = &lines[len(lines)-1]
+			} else if r.x-cur.x1 > 0.25*r.size {
+				cur.text += " "
+			}
+			cur.text += r.text
+			cur.x1 = max(cur.x1, r.x+width)
+			cur.y1 = max(cur.y1, r.y+r.size)
+		}
+		start = end
+	}
+	return lines
+}
+
+// captionFor picks the line labelling an image placed at r. Lines that
+// look like figure labels win; otherwise the nearest line below is taken.
+func captionFor(r rect, lines []textLine) string {
+	best, bestScore := "", math.Inf(1)
+	for _, l := range lines {
+		if l.x1 < r.x0 || l.x0 > r.x1 {
+			continue // No horizontal overlap
+		}
+		var dist float64
+		switch {
+		case l.y1 <= r.y0+1:
+			dist = r.y0 - l.y1 // Below the image
+		case l.y0 >= r.y1-1:
+			dist = l.y0 - r.y1 + captionGap/4 // Above; captions below are usual
+		default:
+			continue
+		}
+		if dist > captionGap {
+			continue
+		}
+		text := strings.Join(strings.Fields(l.text), " ")
+		if captionPattern.MatchString(text) {
+			dist -= 2 * captionGap
+		}
+		if dist < bestScore {
+			best, bestScore = text, dist
+		}
+	}
+	return truncateWords(best, captionMaxRunes)
+}
+
+// truncateWords cuts s to at most n runes, at a space where possible
+func truncateWords(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	cut := string(r[:n])
+	if i := strings.LastIndexByte(cut, ' '); i > n/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimSpace(cut)
+}
+
+// assignCaptions labels images with their captions and gives labelled
+// images a unique file name stem derived from the caption. Halves of a
+// split spread get _left or _right; later images with the same caption
+// in a folder get _2, _3, ...
+func assignCaptions(images []LoadedImage, captions map[[2]int]string, ascii bool) {
+	used := make(map[string]int)
+	for i := range images {
+		label := captions[[2]int{images[i].Page, images[i].ObjNr}]
+		if label == "" {
+			continue
+		}
+		images[i].Label = label
+		stem := SanitizeName(label, ascii)
+		if images[i].Part != "" {
+			stem += "_" + images[i].Part
+		}
+		key := strings.ToLower(images[i].Dir + "/" + stem)
+		used[key]++
+		if n := used[key]; n > 1 {
+			stem = fmt.Sprintf("%s_%d", stem, n)
+		}
+		images[i].Stem = stem
+	}
+}
+
+// fontInfo decodes the strings shown with one font
+type fontInfo struct {
+	toUnicode map[string]string // Code bytes to text
+	codeLens  []int             // Code lengths in bytes, longest first
+	simple    bool              // 8-bit font; codes without a mapping are read as WinAnsi
+}
+
+// font loads the font resource name, caching by object number
+func (s *pageScan) font(res types.Dict, name string) *fontInfo {
+	fonts, err := s.pdf.DereferenceDict(res["Font"])
+	if err != nil || fonts == nil {
+		return nil
+	}
+	objNr := -1
+	if ir, ok := fonts[name].(types.IndirectRef); ok {
+		objNr = ir.ObjectNumber.Value()
+		if f, ok := s.fonts[objNr]; ok {
+			return f
+		}
+	}
+	d, err := s.pdf.DereferenceDict(fonts[name])
+	if err != nil || d == nil {
+		return nil
+	}
+
+	f := &fontInfo{codeLens: []int{1}, simple: true}
+	if st := d.NameEntry("Subtype"); st != nil && *st == "Type0" {
+		f.codeLens, f.simple = []int{2}, false
+	}
+	if sd, _, err := s.pdf.DereferenceStreamDict(d["ToUnicode"]); err == nil && sd != nil && sd.Decode() == nil {
+		f.parseCMap(sd.Content)
+	}
+	if objNr >= 0 {
+		s.fonts[objNr] = f
+	}
+	return f
+}
+
+// parseCMap reads the codespace ranges and bfchar/bfrange mappings of a
+// ToUnicode CMap
+func (f *fontInfo) parseCMap(data []byte) {
+	f.toUnicode = make(map[string]string)
+	lens := make(map[int]bool)
+	lex := &contentLexer{data: data}
+	for {
+		ops, op := lex.next()
+		switch op {
+		case "":
+			if len(lens) > 0 {
+				f.codeLens = f.codeLens[:0]
+				for n := range lens {
+					f.codeLens = append(f.codeLens, n)
+				}
+				sort.Sort(sort.Reverse(sort.IntSlice(f.codeLens)))
+			}
+			return
+		case "endcodespacerange":
+			for i := 0; i+1 < len(ops); i += 2 {
+				if lo, ok := ops[i].([]byte); ok && len(lo) > 0 && len(lo) <= 4 {
+					lens[len(lo)] = true
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(ops); i += 2 {
+				src, ok1 := ops[i].([]byte)
+				dst, ok2 := ops[i+1].([]byte)
+				if ok1 && ok2 {
+					f.toUnicode[string(src)] = utf16BE(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(ops); i += 3 {
+				lo, ok1 := ops[i].([]byte)
+				hi, ok2 := ops[i+1].([]byte)
+				if !ok1 || !ok2 || len(lo) != len(hi) || len(lo) == 0 || len(lo) > 4 {
+					continue
+				}
+				from, to := codeValue(lo), codeValue(hi)
+				if to < from || to-from > 0xffff {
+					continue
+				}
+				for c := from; c <= to; c++ {
+					key := string(codeBytes(c, len(lo)))
+					switch dst := ops[i+2].(type) {
+					case []byte:
+						// Consecutive codes map to consecutive text
+						d := append([]byte(nil), dst...)
+						if len(d) > 0 {
+							d[len(d)-1] += byte(c - from)
+						}
+						f.toUnicode[key] = utf16BE(d)
+					case []any:
+						if n := int(c - from); n < len(dst) {
+							if b, ok := dst[n].([]byte); ok {
+								f.toUnicode[key] = utf16BE(b)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// decode turns a shown string into text
+func (f *fontInfo) decode(str []byte) string {
+	var b strings.Builder
+	for i := 0; i < len(str); {
+		matched := false
+		for _, n := range f.codeLens {
+			if i+n > len(str) {
+				continue
+			}
+			if t, ok := f.toUnicode[string(str[i:i+n])]; ok {
+				b.WriteString(t)
+				i += n
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		if f.simple {
+			b.WriteRune(charmap.Windows1252.DecodeByte(str[i]))
+			i++
+		} else {
+			i += f.codeLens[len(f.codeLens)-1] // Unknown glyph
+		}
+	}
+	return b.String()
+}
+
+// utf16BE decodes the big-endian UTF-16 text of a CMap destination
+func utf16BE(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(u))
+}
+
+func codeValue(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+func codeBytes(v uint32, n int) []byte {
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return b
+}
+
+// pdfNumber returns an integer or real object as a float
+func pdfNumber(o types.Object) float64 {
+	switch v := o.(type) {
+	case types.Integer:
+		return float64(v)
+	case types.Float:
+		return float64(v)
+	}
+	return 0
+}
@@ -0,0 +1,253 @@
+package imageHandling
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Compound File Binary format ([MS-CFB]), the container of Outlook .msg
+// files: a FAT file system in a file, with a tree of storages (folders)
+// and streams (files). Only reading is supported.
+
+var cfbSignature = []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}
+
+// Special sector numbers
+const (
+	cfbEndOfChain = 0xfffffffe
+	cfbNoStream   = 0xffffffff
+)
+
+// Directory entry types
+const (
+	cfbStorage = 1
+	cfbStream  = 2
+	cfbRoot    = 5
+)
+
+// errCFB reports a malformed compound file
+var errCFB = errors.New("malformed compound file")
+
+// cfbFile is a compound file read into memory
+type cfbFile struct {
+	data       []byte
+	sectorSize int
+	miniSize   int
+	miniCutoff uint64
+	fat        []uint32
+	miniFAT    []uint32
+	miniStream []byte
+	entries    []cfbEntry
+}
+
+// cfbEntry is a directory entry: a storage or a stream
+type cfbEntry struct {
+	name  string
+	typ   byte
+	left  uint32
+	right uint32
+	child uint32
+	start uint32
+	size  uint64
+}
+
+// isCFB reports whether data starts like a compound file
+func isCFB(data []byte) bool {
+	return bytes.HasPrefix(data, cfbSignature)
+}
+
+// readCFB parses the header, allocation tables and directory of data
+func readCFB(data []byte) (*cfbFile, error) {
+	if len(data) < 512 || !isCFB(data) {
+		return nil, errCFB
+	}
+	le := binary.LittleEndian
+	shift, miniShift := le.Uint16(data[0x1e:]), le.Uint16(data[0x20:])
+	if shift != 9 && shift != 12 || miniShift != 6 {
+		return nil, fmt.Errorf("%w: sector size 2^%d", errCFB, shift)
+	}
+	f := &cfbFile{
+		data:       data,
+		sectorSize: 1 << shift,
+		miniSize:   1 << miniShift,
+		miniCutoff: uint64(le.Uint32(data[0x38:])),
+	}
+
+	// The FAT sectors are listed in the header and the DIFAT chain
+	var fatSectors []uint32
+	for i := 0; i < 109; i++ {
+		fatSectors = append(fatSectors, le.Uint32(data[0x4c+4*i:]))
+	}
+	difat := le.Uint32(data[0x44:])
+	for n := 0; difat != cfbEndOfChain && difat != cfbNoStream; n++ {
+		s, err := f.sector(difat)
+		if err != nil || n > len(data)/f.sectorSize {
+			return nil, fmt.Errorf("%w: DIFAT", errCFB)
+		}
+		last := len(s)/4 - 1
+		for i := 0; i < last; i++ {
+			fatSectors = append(fatSectors, le.Uint32(s[4*i:]))
+		}
+		difat = le.Uint32(s[4*last:])
+	}
+	numFAT := int(le.Uint32(data[0x2c:]))
+	if numFAT > len(fatSectors) {
+		return nil, fmt.Errorf("%w: FAT", errCFB)
+	}
+	for _, sec := range fatSectors[:numFAT] {
+		s, err := f.sector(sec)
+		if err != nil {
+			return nil, fmt.Errorf("%w: FAT", errCFB)
+		}
+		for i := 0; i < len(s); i += 4 {
+			f.fat = append(f.fat, le.Uint32(s[i:]))
+		}
+	}
+
+	dir, err := f.chain(le.Uint32(data[0x30:]), 0)
+	if err != nil {
+		return nil, fmt.Errorf("directory: %w", err)
+	}
+	for i := 0; i+128 <= len(dir); i += 128 {
+		e := parseCFBEntry(dir[i : i+128])
+		if shift == 9 {
+			// Version 3 files only use the low half; some writers leave
+			// garbage in the high one
+			e.size &= 0xffffffff
+		}
+		f.entries = append(f.entries, e)
+	}
+	if len(f.entries) == 0 || f.entries[0].typ != cfbRoot {
+		return nil, fmt.Errorf("%w: no root entry", errCFB)
+	}
+
+	if miniFAT := le.Uint32(data[0x3c:]); miniFAT != cfbEndOfChain && miniFAT != cfbNoStream {
+		m, err := f.chain(miniFAT, 0)
+		if err != nil {
+			return nil, fmt.Errorf("mini FAT: %w", err)
+		}
+		for i := 0; i+4 <= len(m); i += 4 {
+			f.miniFAT = append(f.miniFAT, le.Uint32(m[i:]))
+		}
+	}
+	root := f.entries[0]
+	if root.start != cfbEndOfChain && root.start != cfbNoStream {
+		if f.miniStream, err = f.chain(root.start, root.size); err != nil {
+			return nil, fmt.Errorf("mini stream: %w", err)
+		}
+	}
+	return f, nil
+}
+
+// parseCFBEntry decodes a 128-byte directory entry
+func parseCFBEntry(b []byte) cfbEntry {
+	le := binary.LittleEndian
+	nameLen := int(le.Uint16(b[0x40:]))
+	if nameLen > 64 {
+		nameLen = 64
+	}
+	units := make([]uint16, 0, nameLen/2)
+	for i := 0; i+1 < nameLen; i += 2 {
+		if u := le.Uint16(b[i:]); u != 0 {
+			units = append(units, u)
+		}
+	}
+	return cfbEntry{
+		name:  string(utf16.Decode(units)),
+		typ:   b[0x42],
+		left:  le.Uint32(b[0x44:]),
+		right: le.Uint32(b[0x48:]),
+		child: le.Uint32(b[0x4c:]),
+		start: le.Uint32(b[0x74:]),
+		size:  le.Uint64(b[0x78:]),
+	}
+}
+
+// sector returns sector n
+func (f *cfbFile) sector(n uint32) ([]byte, error) {
+	off := (int64(n) + 1) * int64(f.sectorSize)
+	if n >= cfbEndOfChain || off+int64(f.sectorSize) > int64(len(f.data)) {
+		return nil, errCFB
+	}
+	return f.data[off : off+int64(f.sectorSize)], nil
+}
+
+// chain reads the sectors of the FAT chain starting at start, cut to
+// size bytes unless size is 0
+func (f *cfbFile) chain(start uint32, size uint64) ([]byte, error) {
+	var buf []byte
+	for n, sec := 0, start; sec != cfbEndOfChain; n++ {
+		if n > len(f.fat) || int(sec) >= len(f.fat) {
+			return nil, errCFB
+		}
+		s, err := f.sector(sec)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, s...)
+		sec = f.fat[sec]
+	}
+	return cut(buf, size)
+}
+
+// miniChain reads a chain of the mini stream
+func (f *cfbFile) miniChain(start uint32, size uint64) ([]byte, error) {
+	var buf []byte
+	for n, sec := 0, start; sec != cfbEndOfChain; n++ {
+		off := int(sec) * f.miniSize
+		if n > len(f.miniFAT) || int(sec) >= len(f.miniFAT) || off+f.miniSize > len(f.miniStream) {
+			return nil, errCFB
+		}
+		buf = append(buf, f.miniStream[off:off+f.miniSize]...)
+		sec = f.miniFAT[sec]
+	}
+	return cut(buf, size)
+}
+
+// cut shortens a chain to the stream size
+func cut(buf []byte, size uint64) ([]byte, error) {
+	if size == 0 {
+		return buf, nil
+	}
+	if size > uint64(len(buf)) {
+		return nil, errCFB
+	}
+	return buf[:size], nil
+}
+
+// children lists the entries of the storage with index dir by name
+func (f *cfbFile) children(dir uint32) map[string]uint32 {
+	kids := make(map[string]uint32)
+	seen := make(map[uint32]bool)
+	var walk func(i uint32)
+	walk = func(i uint32) {
+		if i == cfbNoStream || int(i) >= len(f.entries) || seen[i] {
+			return
+		}
+		seen[i] = true
+		kids[f.entries[i].name] = i
+		walk(f.entries[i].left)
+		walk(f.entries[i].right)
+	}
+	if int(dir) < len(f.entries) {
+		walk(f.entries[dir].child)
+	}
+	return kids
+}
+
+// stream reads the stream with index i
+func (f *cfbFile) stream(i uint32) ([]byte, error) {
+	e := f.entries[i]
+	if e.typ != cfbStream {
+		return nil, fmt.Errorf("%w: %s is not a stream", errCFB, e.name)
+	}
+	if e.size == 0 {
+		return nil, nil
+	}
+	if e.size < f.miniCutoff {
+		return f.miniChain(e.start, e.size)
+	}
+	return f.chain(e.start, e.size)
+}
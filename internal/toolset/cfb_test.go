@@ -0,0 +1,238 @@
+package imageHandling
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"unicode/utf16"
+)
+
+// cfbNode is a storage (with kids) or stream (with data) to write
+type cfbNode struct {
+	name    string
+	storage bool
+	kids    []*cfbNode
+	data    []byte
+}
+
+// writeCFB returns a version 3 compound file whose root storage holds
+// kids. Streams under the 4096-byte cutoff go to the mini stream.
+func writeCFB(kids ...*cfbNode) []byte {
+	const sectorSize, miniSize, cutoff = 512, 64, 4096
+	le := binary.LittleEndian
+	var (
+		sectors    []byte
+		fat        []uint32
+		miniStream []byte
+		miniFAT    []uint32
+	)
+	// alloc appends data to the sectors as a chain and returns its start
+	alloc := func(data []byte) uint32 {
+		if len(data) == 0 {
+			return cfbEndOfChain
+		}
+		start := uint32(len(fat))
+		for off := 0; off < len(data); off += sectorSize {
+			sector := make([]byte, sectorSize)
+			copy(sector, data[off:])
+			sectors = append(sectors, sector...)
+			fat = append(fat, uint32(len(fat)+1))
+		}
+		fat[len(fat)-1] = cfbEndOfChain
+		return start
+	}
+	allocMini := func(data []byte) uint32 {
+		start := uint32(len(miniFAT))
+		for off := 0; off < len(data); off += miniSize {
+			unit := make([]byte, miniSize)
+			copy(unit, data[off:])
+			miniStream = append(miniStream, unit...)
+			miniFAT = append(miniFAT, uint32(len(miniFAT)+1))
+		}
+		miniFAT[len(miniFAT)-1] = cfbEndOfChain
+		return start
+	}
+
+	// Directory entries in depth-first order; the kids of a storage are
+	// linked through their right siblings
+	var dir [][]byte
+	var add func(n *cfbNode, typ byte) int
+	add = func(n *cfbNode, typ byte) int {
+		i := len(dir)
+		e := make([]byte, 128)
+		dir = append(dir, e)
+		name := utf16.Encode([]rune(n.name))
+		for j, u := range name {
+			le.PutUint16(e[2*j:], u)
+		}
+		le.PutUint16(e[0x40:], uint16(2*len(name)+2))
+		e[0x42], e[0x43] = typ, 1
+		le.PutUint32(e[0x44:], cfbNoStream)
+		le.PutUint32(e[0x48:], cfbNoStream)
+		le.PutUint32(e[0x4c:], cfbNoStream)
+		if !n.storage {
+			start := uint32(cfbEndOfChain)
+			switch {
+			case len(n.data) >= cutoff:
+				start = alloc(n.data)
+			case len(n.data) > 0:
+				start = allocMini(n.data)
+			}
+			le.PutUint32(e[0x74:], start)
+			le.PutUint64(e[0x78:], uint64(len(n.data)))
+			return i
+		}
+		prev := -1
+		for _, k := range n.kids {
+			typ := byte(cfbStream)
+			if k.storage {
+				typ = cfbStorage
+			}
+			j := add(k, typ)
+			if prev < 0 {
+				le.PutUint32(e[0x4c:], uint32(j))
+			} else {
+				le.PutUint32(dir[prev][0x48:], uint32(j))
+			}
+			prev = j
+		}
+		return i
+	}
+	add(&cfbNode{name: "Root Entry", storage: true, kids: kids}, cfbRoot)
+
+	root := dir[0]
+	le.PutUint32(root[0x74:], alloc(miniStream))
+	le.PutUint64(root[0x78:], uint64(len(miniStream)))
+	var miniFATData []byte
+	for _, n := range miniFAT {
+		miniFATData = le.AppendUint32(miniFATData, n)
+	}
+	miniFATStart := alloc(miniFATData)
+	dirStart := alloc(bytes.Join(dir, nil))
+
+	// The FAT covers the sectors it is stored in
+	numFAT := 0
+	for numFAT*sectorSize/4 < len(fat)+numFAT {
+		numFAT++
+	}
+	fatStart := len(fat)
+	for range numFAT {
+		fat = append(fat, 0xfffffffd)
+	}
+	for len(fat)%(sectorSize/4) != 0 {
+		fat = append(fat, cfbNoStream)
+	}
+	for _, n := range fat {
+		sectors = le.AppendUint32(sectors, n)
+	}
+
+	header := make([]byte, sectorSize)
+	copy(header, cfbSignature)
+	le.PutUint16(header[0x18:], 0x3e)
+	le.PutUint16(header[0x1a:], 3)
+	le.PutUint16(header[0x1c:], 0xfffe)
+	le.PutUint16(header[0x1e:], 9)
+	le.PutUint16(header[0x20:], 6)
+	le.PutUint32(header[0x2c:], uint32(numFAT))
+	le.PutUint32(header[0x30:], dirStart)
+	le.PutUint32(header[0x38:], cutoff)
+	le.PutUint32(header[0x3c:], miniFATStart)
+	le.PutUint32(header[0x40:], uint32((len(miniFATData)+sectorSize-1)/sectorSize))
+	le.PutUint32(header[0x44:], cfbEndOfChain)
+	for i := range 109 {
+		sec := uint32(cfbNoStream)
+		if i < numFAT {
+			sec = uint32(fatStart + i)
+		}
+		le.PutUint32(header[0x4c+4*i:], sec)
+	}
+	return append(header, sectors...)
+}
+
+func TestReadCFB(t *testing.T) {
+	small := []byte("a stream in the mini stream, over one 64-byte unit of it, in fact")
+	large := bytes.Repeat([]byte("0123456789abcdef"), 600) // Over the cutoff
+	file := writeCFB(
+		&cfbNode{name: "small", data: small},
+		&cfbNode{name: "folder", storage: true, kids: []*cfbNode{
+			{name: "large", data: large},
+			{name: "empty"},
+		}},
+	)
+
+	f, err := readCFB(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kids := f.children(0)
+	folder := f.children(kids["folder"])
+	if len(kids) != 2 || len(folder) != 2 {
+		t.Fatalf("root has %v, folder %v", kids, folder)
+	}
+	tests := []struct {
+		name  string
+		index uint32
+		want  []byte
+	}{
+		{"small", kids["small"], small},
+		{"large", folder["large"], large},
+		{"empty", folder["empty"], nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := f.stream(tt.index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("read %d bytes, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+	if _, err := f.stream(kids["folder"]); !errors.Is(err, errCFB) {
+		t.Errorf("reading a storage: error %v, want %v", err, errCFB)
+	}
+}
+
+func TestReadCFBMalformed(t *testing.T) {
+	file := writeCFB(&cfbNode{name: "large", data: bytes.Repeat([]byte{1}, 5000)})
+	le := binary.LittleEndian
+	tests := []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{"short", func(b []byte) []byte { return b[:511] }},
+		{"signature", func(b []byte) []byte { b[0] = 0; return b }},
+		{"sector size", func(b []byte) []byte { le.PutUint16(b[0x1e:], 10); return b }},
+		{"FAT count", func(b []byte) []byte { le.PutUint32(b[0x2c:], 110); return b }},
+		{"FAT sector", func(b []byte) []byte { le.PutUint32(b[0x4c:], 1000); return b }},
+		{"directory", func(b []byte) []byte { le.PutUint32(b[0x30:], 1000); return b }},
+		{"no root", func(b []byte) []byte {
+			dir := (int(le.Uint32(b[0x30:])) + 1) * 512
+			b[dir+0x42] = cfbStorage
+			return b
+		}},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-512] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readCFB(tt.modify(bytes.Clone(file))); !errors.Is(err, errCFB) {
+				t.Errorf("error %v, want %v", err, errCFB)
+			}
+		})
+	}
+}
+
+func TestCFBStreamCycle(t *testing.T) {
+	f, err := readCFB(writeCFB(&cfbNode{name: "large", data: bytes.Repeat([]byte{1}, 5000)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := f.children(0)["large"]
+	start := f.entries[i].start
+	f.fat[start+1] = start // The chain loops back
+	if _, err := f.stream(i); !errors.Is(err, errCFB) {
+		t.Errorf("error %v, want %v", err, errCFB)
+	}
+}
@@ -0,0 +1,178 @@
+package imageHandling
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultClusterThreshold is the maximum dHash distance of similar images
+const DefaultClusterThreshold = 10
+
+// ClusterMember is one image of a similarity cluster
+type ClusterMember struct {
+	Name   string `json:"name"`           // File path, or image name inside a PDF
+	Page   int    `json:"page,omitempty"` // Page for images taken from a PDF
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Hash   string `json:"phash"`
+
+	phash    uint64
+	fileHash string // SHA-256 of the encoded image
+}
+
+// Cluster groups perceptually similar images
+type Cluster struct {
+	Members []ClusterMember `json:"members"`
+}
+
+// ClusterImages groups the images of a PDF or a directory tree into
+// clusters whose members are within threshold dHash distance of another
+// member. Clusters are sorted by size, largest first.
+func ClusterImages(input string, threshold int, tempDir string) ([]Cluster, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []ClusterMember
+	if info.IsDir() {
+		members, err = hashDirImages(input)
+	} else {
+		members, err = hashPDFImages(input, tempDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return groupMembers(members, threshold), nil
+}
+
+// hashDirImages hashes every decodable image below dir
+func hashDirImages(dir string) ([]ClusterMember, error) {
+	var members []ClusterMember
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isImageFile(path) {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		if m, ok := hashMember(path, 0, data); ok {
+			members = append(members, m)
+		}
+		return nil
+	})
+	return members, err
+}
+
+// hashPDFImages hashes every decodable image of a PDF
+func hashPDFImages(filename string, tempDir string) ([]ClusterMember, error) {
+	dir, err := os.MkdirTemp(tempDir, "pdfimg")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pdf, closePDF, err := openPDF(context.Background(), filename)
+	if err != nil {
+		return nil, fmt.Errorf("extract images: %w", err)
+	}
+	defer closePDF()
+	files, err := extractRaw(context.Background(), pdf, dir, nil, Limits{}.withDefaults(), pdfcpuEngine{})
+	if err != nil {
+		return nil, fmt.Errorf("extract images: %w", err)
+	}
+
+	var members []ClusterMember
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join(dir, f.Name))
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", f.Name, err)
+		}
+		if m, ok := hashMember(f.Name, f.Page, data); ok {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+// hashMember decodes data and computes its perceptual hash
+func hashMember(name string, page int, data []byte) (ClusterMember, bool) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return ClusterMember{}, false
+	}
+	h := PerceptualHash(img)
+	b := img.Bounds()
+	return ClusterMember{
+		Name:     name,
+		Page:     page,
+		Width:    b.Dx(),
+		Height:   b.Dy(),
+		Hash:     fmt.Sprintf("%016x", h),
+		phash:    h,
+		fileHash: fmt.Sprintf("%x", sha256.Sum256(data)),
+	}, true
+}
+
+// groupMembers links members within threshold using union-find
+func groupMembers(members []ClusterMember, threshold int) []Cluster {
+	parent := make([]int, len(members))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range members {
+		for j := i + 1; j < len(members); j++ {
+			if HammingDistance(members[i].phash, members[j].phash) <= threshold {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := make(map[int][]ClusterMember)
+	var roots []int
+	for i, m := range members {
+		r := find(i)
+		if _, ok := groups[r]; !ok {
+			roots = append(roots, r)
+		}
+		groups[r] = append(groups[r], m)
+	}
+
+	clusters := make([]Cluster, 0, len(roots))
+	for _, r := range roots {
+		clusters = append(clusters, Cluster{Members: groups[r]})
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return len(clusters[i].Members) > len(clusters[j].Members)
+	})
+	return clusters
+}
+
+// String formats a member for text reports
+func (m ClusterMember) String() string {
+	var b strings.Builder
+	b.WriteString(m.Name)
+	if m.Page > 0 {
+		fmt.Fprintf(&b, " (page %d)", m.Page)
+	}
+	fmt.Fprintf(&b, " %dx%d %s", m.Width, m.Height, m.Hash)
+	return b.String()
+}
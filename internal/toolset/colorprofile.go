@@ -0,0 +1,330 @@
+package imageHandling
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// What became of the ICC profile of an image (ColorProfile.Handling)
+const (
+	ProfileHonored   = "honored"   // Embedded in the written file, so its colors are exact
+	ProfileConverted = "converted" // An sRGB profile, which files without one are read as
+	ProfileDropped   = "dropped"   // Lost, so colors may shift
+)
+
+// Where the ICC profile of an image was found (ColorProfile.Source)
+const (
+	profileFromPDF  = "pdf"  // ICCBased color space of the image stream
+	profileFromFile = "file" // APP2 segments of the extracted JPEG
+)
+
+// ErrNotColorManaged is returned with Options.ColorManaged if the
+// ICC profile of an image can't be kept
+var ErrNotColorManaged = errors.New("colors can't be kept accurate")
+
+// ColorProfile describes the ICC profile of an image's source and what
+// became of it in the written file
+type ColorProfile struct {
+	Name     string `json:"name,omitempty"` // Description in the profile
+	Space    string `json:"space"`          // Color space: GRAY, RGB, CMYK, Lab, ...
+	Source   string `json:"source"`         // pdf (color space of the image) or file (within the image)
+	Handling string `json:"handling"`       // honored, converted or dropped
+}
+
+// iccProfile is an ICC profile found for an image
+type iccProfile struct {
+	data   []byte
+	space  string
+	name   string
+	source string
+}
+
+// parseICC reads the color space and description of an ICC profile,
+// nil if data is not one
+func parseICC(data []byte, source string) *iccProfile {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil
+	}
+	p := &iccProfile{data: data, space: strings.TrimSpace(string(data[16:20])), source: source}
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	for i := 0; i < count && 132+12*(i+1) <= len(data); i++ {
+		tag := data[132+12*i:]
+		off, size := int(binary.BigEndian.Uint32(tag[4:])), int(binary.BigEndian.Uint32(tag[8:]))
+		if string(tag[:4]) == "desc" && off >= 0 && size >= 12 && off+size <= len(data) {
+			p.name = iccText(data[off : off+size])
+			break
+		}
+	}
+	return p
+}
+
+// iccText decodes a textDescriptionType (ICC v2) or the first record of a
+// multiLocalizedUnicodeType (v4)
+func iccText(b []byte) string {
+	switch string(b[:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(b[8:]))
+		if n <= 0 || 12+n > len(b) {
+			return ""
+		}
+		return strings.TrimRight(string(b[12:12+n]), "\x00")
+	case "mluc":
+		if len(b) < 28 || binary.BigEndian.Uint32(b[8:]) == 0 {
+			return ""
+		}
+		n, off := int(binary.BigEndian.Uint32(b[20:])), int(binary.BigEndian.Uint32(b[24:]))
+		if off+n > len(b) {
+			return ""
+		}
+		units := make([]uint16, n/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(b[off+2*i:])
+		}
+		return string(utf16.Decode(units))
+	}
+	return ""
+}
+
+// sRGB reports whether p is an sRGB profile, by its description
+func (p *iccProfile) sRGB() bool {
+	return p.space == "RGB" && strings.Contains(p.name, "sRGB")
+}
+
+// handling tells what became of p, embedded in the written file or not
+func (p *iccProfile) handling(embedded bool) string {
+	switch {
+	case p == nil:
+		return ""
+	case embedded:
+		return ProfileHonored
+	case p.sRGB():
+		return ProfileConverted
+	}
+	return ProfileDropped
+}
+
+// record describes p for the manifest, nil if there is none
+func (p *iccProfile) record(handling string) *ColorProfile {
+	if p == nil {
+		return nil
+	}
+	return &ColorProfile{Name: p.name, Space: p.space, Source: p.source, Handling: handling}
+}
+
+// attachProfiles finds the ICC profile of each image: that of its color
+// space in the PDF, which governs, or else the one in an extracted JPEG
+func attachProfiles(pdf *model.Context, images []LoadedImage) {
+	found := make(map[int]*iccProfile)
+	for i := range images {
+		img := &images[i]
+		p, ok := found[img.ObjNr]
+		if !ok {
+			p = streamProfile(pdf, img.ObjNr)
+			if p == nil {
+				p = fileProfile(img.Path)
+			}
+			found[img.ObjNr] = p
+		}
+		img.profile = p
+	}
+}
+
+// streamProfile returns the profile of the ICCBased color space of the
+// image stream objNr, or of the base of its Indexed color space
+func streamProfile(pdf *model.Context, objNr int) *iccProfile {
+	sd, _, err := pdf.DereferenceStreamDict(*types.NewIndirectRef(objNr, 0))
+	if err != nil || sd == nil {
+		return nil
+	}
+	cs, _ := pdf.Dereference(sd.Dict["ColorSpace"])
+	arr, ok := cs.(types.Array)
+	if ok && len(arr) == 4 {
+		if name, _ := arr[0].(types.Name); name == "Indexed" {
+			cs, _ = pdf.Dereference(arr[1])
+			arr, ok = cs.(types.Array)
+		}
+	}
+	if !ok || len(arr) != 2 {
+		return nil
+	}
+	if name, _ := arr[0].(types.Name); name != "ICCBased" {
+		return nil
+	}
+	profile, _, err := pdf.DereferenceStreamDict(arr[1])
+	if err != nil || profile == nil || profile.Decode() != nil {
+		return nil
+	}
+	return parseICC(profile.Content, profileFromPDF)
+}
+
+// fileProfile returns the profile in the APP2 segments of a JPEG file
+func fileProfile(path string) *iccProfile {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+	default:
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var icc []byte
+	for _, seg := range jpegSegments(data) {
+		if seg.marker == 0xE2 && bytes.HasPrefix(seg.data, jpegICCHeader) && len(seg.data) > len(jpegICCHeader)+2 {
+			icc = append(icc, seg.data[len(jpegICCHeader)+2:]...)
+		}
+	}
+	return parseICC(icc, profileFromFile)
+}
+
+// embedProfile returns data, a JPEG or PNG file named with ext, with p
+// embedded, and whether it could be: the profile must match the color
+// space of the file. Other formats are returned unchanged.
+func embedProfile(data []byte, ext string, p *iccProfile) ([]byte, bool) {
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+		return jpegWithProfile(data, p)
+	case ".png":
+		return pngWithProfile(data, p)
+	}
+	return data, false
+}
+
+// jpegICCHeader starts the APP2 segments holding an ICC profile, followed
+// by the segment's number and the number of segments
+var jpegICCHeader = []byte("ICC_PROFILE\x00")
+
+// jpegICCChunk is the most profile data an APP2 segment holds
+const jpegICCChunk = 0xFFFF - 2 - 14
+
+// jpegSegment is a marker segment before the scan of a JPEG file
+type jpegSegment struct {
+	marker byte
+	start  int
+	end    int
+	data   []byte // Payload after the length
+}
+
+// jpegSegments lists the segments of a JPEG file up to its first scan or
+// a malformed segment
+func jpegSegments(data []byte) []jpegSegment {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	var segs []jpegSegment
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		marker := data[pos+1]
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xDA || size < 2 || pos+2+size > len(data) {
+			return segs
+		}
+		segs = append(segs, jpegSegment{marker: marker, start: pos, end: pos + 2 + size, data: data[pos+4 : pos+2+size]})
+		pos += 2 + size
+	}
+	return segs
+}
+
+// jpegWithProfile replaces the profile of a JPEG file with p, written
+// after the JFIF segment
+func jpegWithProfile(data []byte, p *iccProfile) ([]byte, bool) {
+	segs := jpegSegments(data)
+	components := 0
+	for _, seg := range segs {
+		// Start of frame markers, other than DHT, JPG and DAC
+		if seg.marker >= 0xC0 && seg.marker <= 0xCF && seg.marker != 0xC4 && seg.marker != 0xC8 && seg.marker != 0xCC && len(seg.data) >= 6 {
+			components = int(seg.data[5])
+			break
+		}
+	}
+	if components == 0 || map[int]string{1: "GRAY", 3: "RGB", 4: "CMYK"}[components] != p.space {
+		return data, false
+	}
+	chunks := (len(p.data) + jpegICCChunk - 1) / jpegICCChunk
+	if chunks > 255 {
+		return data, false
+	}
+
+	out := make([]byte, 0, len(data)+len(p.data)+18*chunks)
+	out = append(out, 0xFF, 0xD8)
+	pos := 2
+	if len(segs) > 0 && segs[0].marker == 0xE0 {
+		out = append(out, data[segs[0].start:segs[0].end]...)
+		pos = segs[0].end
+	}
+	for i := 0; i < chunks; i++ {
+		chunk := p.data[i*jpegICCChunk : min((i+1)*jpegICCChunk, len(p.data))]
+		out = append(out, 0xFF, 0xE2)
+		out = binary.BigEndian.AppendUint16(out, uint16(2+len(jpegICCHeader)+2+len(chunk)))
+		out = append(out, jpegICCHeader...)
+		out = append(out, byte(i+1), byte(chunks))
+		out = append(out, chunk...)
+	}
+	// Leave out the profile the file had
+	for _, seg := range segs {
+		if seg.start < pos {
+			continue
+		}
+		if seg.marker == 0xE2 && bytes.HasPrefix(seg.data, jpegICCHeader) {
+			out = append(out, data[pos:seg.start]...)
+			pos = seg.end
+		}
+	}
+	return append(out, data[pos:]...), true
+}
+
+// pngWithProfile adds p to a PNG file as an iCCP chunk after the header
+func pngWithProfile(data []byte, p *iccProfile) ([]byte, bool) {
+	// Signature, then the IHDR chunk: length, type, 13 bytes and CRC
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd || !bytes.HasPrefix(data, pngSignature) || string(data[12:16]) != "IHDR" {
+		return data, false
+	}
+	space := "RGB"
+	if colorType := data[25]; colorType == 0 || colorType == 4 {
+		space = "GRAY"
+	}
+	if space != p.space {
+		return data, false
+	}
+
+	var chunk bytes.Buffer
+	chunk.WriteString("iCCP")
+	chunk.WriteString("ICC profile\x00\x00") // Name, then the compression method
+	zw := zlib.NewWriter(&chunk)
+	zw.Write(p.data)
+	zw.Close()
+	body := chunk.Bytes()
+
+	out := make([]byte, 0, len(data)+len(body)+8)
+	out = append(out, data[:ihdrEnd]...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(body)-4))
+	out = append(out, body...)
+	out = binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(body))
+	return append(out, data[ihdrEnd:]...), true
+}
+
+// checkColorManaged fails with ErrNotColorManaged for the first image
+// whose profile was dropped
+func checkColorManaged(manifest *Manifest) error {
+	for _, img := range manifest.Images {
+		if cp := img.ColorProfile; cp != nil && cp.Handling == ProfileDropped {
+			name := cp.Name
+			if name == "" {
+				name = cp.Space
+			}
+			return fmt.Errorf("%w: the %q profile of %s (page %d) was dropped", ErrNotColorManaged, name, img.File, img.Page)
+		}
+	}
+	return nil
+}
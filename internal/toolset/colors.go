@@ -0,0 +1,81 @@
+package imageHandling
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// DefaultKeyTolerance absorbs compression noise around a keyed color
+const DefaultKeyTolerance = 16
+
+// colorKey makes pixels of one color transparent, e.g. the white
+// background of a logo
+type colorKey struct {
+	color     color.RGBA
+	tolerance int // Largest per-channel distance still keyed
+}
+
+// newColorKey parses Options.KeyColor; nil when no color is keyed
+func newColorKey(opts Options) (*colorKey, error) {
+	if opts.KeyColor == "" {
+		return nil, nil
+	}
+	c, err := ParseHexColor(opts.KeyColor)
+	if err != nil {
+		return nil, err
+	}
+	return &colorKey{color: c, tolerance: opts.KeyTolerance}, nil
+}
+
+// ParseHexColor parses a color written as #RRGGBB or #RGB
+func ParseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color '%s' (use #RRGGBB)", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
+
+// apply clears every opaque pixel of img within the tolerance of the key
+// color. Partly transparent pixels are left alone.
+func (k *colorKey) apply(img *image.RGBA) {
+	key := k.color
+	near := func(a, b uint8) bool {
+		d := int(a) - int(b)
+		return d <= k.tolerance && -d <= k.tolerance
+	}
+
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	forRows(w, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+			for i := 0; i < len(row); i += 4 {
+				if row[i+3] == 0xff && near(row[i], key.R) && near(row[i+1], key.G) && near(row[i+2], key.B) {
+					row[i], row[i+1], row[i+2], row[i+3] = 0, 0, 0, 0
+				}
+			}
+		}
+	})
+}
+
+// invertImage turns img into its negative, keeping alpha. RGBA is
+// premultiplied, so the inverse of a channel is alpha minus its value.
+func invertImage(img *image.RGBA) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	forRows(w, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+			for i := 0; i < len(row); i += 4 {
+				a := row[i+3]
+				row[i], row[i+1], row[i+2] = a-row[i], a-row[i+1], a-row[i+2]
+			}
+		}
+	})
+}
@@ -0,0 +1,329 @@
+package imageHandling
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// Content streams and CMaps share PostScript-like syntax: operands
+// followed by an operator. contentLexer reads that syntax without building
+// pdfcpu objects, which is all that locating images and text needs.
+
+// pdfName is a name operand such as /Im0, without the slash
+type pdfName string
+
+// contentLexer reads operands and operators from a content stream
+type contentLexer struct {
+	data []byte
+	pos  int
+}
+
+// next returns the operands before the next operator and the operator;
+// op is "" at the end of data
+func (l *contentLexer) next() (operands []any, op string) {
+	for {
+		v, isOp, ok := l.token()
+		if !ok {
+			return operands, ""
+		}
+		if isOp {
+			op = v.(string)
+			if op == "ID" {
+				l.skipInlineImage()
+			}
+			return operands, op
+		}
+		operands = append(operands, v)
+	}
+}
+
+// token reads one operand or operator; ok is false at the end of data
+func (l *contentLexer) token() (v any, isOp bool, ok bool) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, false, false
+	}
+	c := l.data[l.pos]
+	switch {
+	case c == '(':
+		return l.literalString(), false, true
+	case c == '<' && l.peek(1) == '<':
+		l.pos += 2
+		return l.dict(), false, true
+	case c == '<':
+		return l.hexString(), false, true
+	case c == '[':
+		l.pos++
+		return l.array(), false, true
+	case c == '/':
+		l.pos++
+		return pdfName(l.regular()), false, true
+	case c == ']' || c == '>' || c == '{' || c == '}' || c == ')':
+		// Stray delimiters; return them as operators so callers skip them
+		l.pos++
+		return string(c), true, true
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		word := l.regular()
+		if f, err := strconv.ParseFloat(word, 64); err == nil {
+			return f, false, true
+		}
+		return word, true, true
+	}
+	word := l.regular()
+	switch word {
+	case "true", "false", "null":
+		return word, false, true
+	}
+	return word, true, true
+}
+
+// array reads operands up to the closing bracket
+func (l *contentLexer) array() []any {
+	var items []any
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.data) {
+			return items
+		}
+		if l.data[l.pos] == ']' {
+			l.pos++
+			return items
+		}
+		v, _, ok := l.token()
+		if !ok {
+			return items
+		}
+		items = append(items, v)
+	}
+}
+
+// dict skips a dictionary; its entries are never needed
+func (l *contentLexer) dict() any {
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.data) {
+			return nil
+		}
+		if l.data[l.pos] == '>' && l.peek(1) == '>' {
+			l.pos += 2
+			return nil
+		}
+		if _, _, ok := l.token(); !ok {
+			return nil
+		}
+	}
+}
+
+// literalString reads a (string) with nested parentheses and escapes
+func (l *contentLexer) literalString() []byte {
+	l.pos++
+	var b []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return b
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return b
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// Line continuation
+				if e == '\r' && l.peek(0) == '\n' {
+					l.pos++
+				}
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					n := int(e - '0')
+					for i := 0; i < 2 && l.peek(0) >= '0' && l.peek(0) <= '7'; i++ {
+						n = n*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(n)
+				} else {
+					c = e
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// hexString reads a <hex> string; an odd last digit is padded with 0
+func (l *contentLexer) hexString() []byte {
+	l.pos++
+	var b []byte
+	var hi byte
+	half := false
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		if c == '>' {
+			break
+		}
+		v, ok := hexDigit(c)
+		if !ok {
+			continue
+		}
+		if half {
+			b = append(b, hi<<4|v)
+		} else {
+			hi = v
+		}
+		half = !half
+	}
+	if half {
+		b = append(b, hi<<4)
+	}
+	return b
+}
+
+// regular reads a run of regular characters, decoding #xx escapes
+func (l *contentLexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isDelimiter(l.data[l.pos]) && !isSpace(l.data[l.pos]) {
+		l.pos++
+	}
+	word := l.data[start:l.pos]
+	if bytes.IndexByte(word, '#') < 0 {
+		return string(word)
+	}
+	var b []byte
+	for i := 0; i < len(word); i++ {
+		if word[i] == '#' && i+2 < len(word) {
+			hi, ok1 := hexDigit(word[i+1])
+			lo, ok2 := hexDigit(word[i+2])
+			if ok1 && ok2 {
+				b = append(b, hi<<4|lo)
+				i += 2
+				continue
+			}
+		}
+		b = append(b, word[i])
+	}
+	return string(b)
+}
+
+// skipInlineImage skips the binary data of an inline image after ID
+func (l *contentLexer) skipInlineImage() {
+	if l.pos < len(l.data) {
+		l.pos++ // Single whitespace after ID
+	}
+	for l.pos+2 <= len(l.data) {
+		i := bytes.Index(l.data[l.pos:], []byte("EI"))
+		if i < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		at := l.pos + i
+		l.pos = at + 2
+		if at > 0 && isSpace(l.data[at-1]) && (l.pos == len(l.data) || isSpace(l.data[l.pos])) {
+			return
+		}
+	}
+}
+
+// skipSpace skips whitespace and comments
+func (l *contentLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if !isSpace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// peek returns the byte n positions ahead, or 0 past the end
+func (l *contentLexer) peek(n int) byte {
+	if l.pos+n < len(l.data) {
+		return l.data[l.pos+n]
+	}
+	return 0
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isDelimiter(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+func hexDigit(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// matrix is a PDF transformation matrix [a b c d e f]
+type matrix [6]float64
+
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+// mul returns m applied first, then n
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// apply transforms the point (x, y)
+func (m matrix) apply(x, y float64) (float64, float64) {
+	return m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]
+}
+
+// numbers returns operands as a matrix-sized list of numbers
+func numbers(operands []any, n int) ([]float64, bool) {
+	if len(operands) < n {
+		return nil, false
+	}
+	out := make([]float64, n)
+	for i, o := range operands[len(operands)-n:] {
+		f, ok := o.(float64)
+		if !ok {
+			return nil, false
+		}
+		out[i] = f
+	}
+	return out, true
+}
@@ -0,0 +1,149 @@
+package imageHandling
+
+import (
+	"image"
+	"runtime"
+	"sync"
+)
+
+// Direct conversions for the images decoders return most often. They
+// produce exactly the pixels draw.Draw would, but work on re-sliced rows
+// the compiler can drop bounds checks for, and split large images into
+// bands converted in parallel. dst must have the same bounds as src.
+
+// parallelPixels is the image size above which rows are split into bands
+const parallelPixels = 1 << 20
+
+// forRows calls fn for bands of rows [y0, y1) covering 0..h, in parallel
+// for large images
+func forRows(w, h int, fn func(y0, y1 int)) {
+	bands := runtime.GOMAXPROCS(0)
+	if w*h < parallelPixels || bands < 2 || h < bands {
+		fn(0, h)
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(bands)
+	for i := 0; i < bands; i++ {
+		go func() {
+			defer wg.Done()
+			fn(h*i/bands, h*(i+1)/bands)
+		}()
+	}
+	wg.Wait()
+}
+
+// grayToRGBA expands 8-bit gray to opaque RGBA
+func grayToRGBA(dst *image.RGBA, src *image.Gray) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	forRows(w, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[y*src.Stride : y*src.Stride+w]
+			d := dst.Pix[y*dst.Stride : y*dst.Stride+w*4]
+			for x, di := 0, 0; x < len(s); x, di = x+1, di+4 {
+				v := s[x]
+				p := d[di : di+4 : di+4]
+				p[0], p[1], p[2], p[3] = v, v, v, 0xff
+			}
+		}
+	})
+}
+
+// nrgbaToRGBA premultiplies alpha, rounding like draw.Draw
+func nrgbaToRGBA(dst *image.RGBA, src *image.NRGBA) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	forRows(w, h, func(y0, y1 int) {
+		nrgbaRows(dst, src, w, y0, y1)
+	})
+}
+
+// nrgbaRows converts rows [y0, y1) of src
+func nrgbaRows(dst *image.RGBA, src *image.NRGBA, w, y0, y1 int) {
+	for y := y0; y < y1; y++ {
+		s := src.Pix[y*src.Stride : y*src.Stride+w*4]
+		d := dst.Pix[y*dst.Stride : y*dst.Stride+w*4]
+		for i := 0; i+4 <= len(s); i += 4 {
+			sp := s[i : i+4 : i+4]
+			dp := d[i : i+4 : i+4]
+			// No special case for alpha 0 and 0xff, which come out right
+			// anyway: branching on mixed alpha costs more than it saves
+			sa := uint32(sp[3]) * 0x101
+			dp[0] = uint8(uint32(sp[0]) * sa / 0xff >> 8)
+			dp[1] = uint8(uint32(sp[1]) * sa / 0xff >> 8)
+			dp[2] = uint8(uint32(sp[2]) * sa / 0xff >> 8)
+			dp[3] = sp[3]
+		}
+	}
+}
+
+// ycbcrToRGBA converts JPEG-style YCbCr for the common subsample ratios.
+// It reports false for ratios it doesn't handle.
+func ycbcrToRGBA(dst *image.RGBA, src *image.YCbCr) bool {
+	hd, vd := 1, 1 // chroma subsampling divisors
+	switch src.SubsampleRatio {
+	case image.YCbCrSubsampleRatio444:
+	case image.YCbCrSubsampleRatio422:
+		hd = 2
+	case image.YCbCrSubsampleRatio420:
+		hd, vd = 2, 2
+	case image.YCbCrSubsampleRatio440:
+		vd = 2
+	default:
+		return false
+	}
+
+	r := src.Rect
+	if r.Min.X < 0 || r.Min.Y < 0 {
+		return false
+	}
+	w, h := r.Dx(), r.Dy()
+	if w == 0 || h == 0 {
+		return true
+	}
+	cw := (r.Max.X-1)/hd - r.Min.X/hd + 1 // chroma samples per row
+	forRows(w, h, func(y0, y1 int) {
+		ycbcrRows(dst, src, hd, vd, cw, y0, y1)
+	})
+	return true
+}
+
+// ycbcrRows converts rows [y0, y1) of src; hd and vd are the chroma
+// subsampling divisors and cw the chroma samples per row
+func ycbcrRows(dst *image.RGBA, src *image.YCbCr, hd, vd, cw, y0, y1 int) {
+	r := src.Rect
+	w := r.Dx()
+	for y := y0; y < y1; y++ {
+		yRow := src.Y[y*src.YStride : y*src.YStride+w]
+		ci := ((r.Min.Y+y)/vd - r.Min.Y/vd) * src.CStride
+		cb := src.Cb[ci : ci+cw]
+		cr := src.Cr[ci : ci+cw]
+		d := dst.Pix[y*dst.Stride : y*dst.Stride+w*4]
+
+		// Odd Min.X starts halfway through a chroma sample
+		odd := r.Min.X & 1
+		for x, di := 0, 0; x < len(yRow); x, di = x+1, di+4 {
+			c := x
+			if hd == 2 {
+				c = (x + odd) >> 1
+			}
+			// Same fixed-point math as color.YCbCrToRGB
+			yy := int32(yRow[x]) * 0x10101
+			cb1 := int32(cb[c]) - 128
+			cr1 := int32(cr[c]) - 128
+			p := d[di : di+4 : di+4]
+			p[0] = clampYCbCr(yy + 91881*cr1)
+			p[1] = clampYCbCr(yy - 22554*cb1 - 46802*cr1)
+			p[2] = clampYCbCr(yy + 116130*cb1)
+			p[3] = 0xff
+		}
+	}
+}
+
+// clampYCbCr converts a 16.16 fixed-point channel to 8 bits, clamping to
+// 0..255 like color.YCbCrToRGB
+func clampYCbCr(v int32) uint8 {
+	if uint32(v)&0xff000000 == 0 {
+		return uint8(v >> 16)
+	}
+	return uint8(^(v >> 31))
+}
@@ -0,0 +1,150 @@
+package imageHandling
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+// Bounds the conversions are checked on: odd sizes, non-zero and odd
+// minimums, and one large enough to be split into bands
+var convertBounds = []image.Rectangle{
+	image.Rect(0, 0, 1, 1),
+	image.Rect(0, 0, 7, 5),
+	image.Rect(3, 5, 36, 22),
+	image.Rect(1, 1, 2, 2),
+	image.Rect(-3, -2, 10, 9),
+	image.Rect(0, 0, 1031, 1029),
+}
+
+var ycbcrRatios = []image.YCbCrSubsampleRatio{
+	image.YCbCrSubsampleRatio444,
+	image.YCbCrSubsampleRatio422,
+	image.YCbCrSubsampleRatio420,
+	image.YCbCrSubsampleRatio440,
+}
+
+// randomGray returns a gray image of r with random pixels
+func randomGray(rng *rand.Rand, r image.Rectangle) *image.Gray {
+	img := image.NewGray(r)
+	fill(rng, img.Pix)
+	return img
+}
+
+// randomNRGBA returns an NRGBA image of r with random pixels, a share of
+// them fully transparent or opaque
+func randomNRGBA(rng *rand.Rand, r image.Rectangle) *image.NRGBA {
+	img := image.NewNRGBA(r)
+	fill(rng, img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		switch rng.IntN(4) {
+		case 0:
+			img.Pix[i] = 0
+		case 1:
+			img.Pix[i] = 0xff
+		}
+	}
+	return img
+}
+
+// randomYCbCr returns a YCbCr image of r with random samples
+func randomYCbCr(rng *rand.Rand, r image.Rectangle, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	img := image.NewYCbCr(r, ratio)
+	fill(rng, img.Y)
+	fill(rng, img.Cb)
+	fill(rng, img.Cr)
+	return img
+}
+
+func fill(rng *rand.Rand, b []byte) {
+	for i := range b {
+		b[i] = byte(rng.Uint32())
+	}
+}
+
+// drawRGBA is the draw.Draw conversion the direct ones replace
+func drawRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, img, b.Min, draw.Src)
+	return rgba
+}
+
+// convertSources returns test images of every type with a direct
+// conversion, by name, for r. Sub-images of a larger image are included
+// so the source rows don't start at the beginning of its buffers.
+func convertSources(rng *rand.Rand, r image.Rectangle) map[string]image.Image {
+	outer := image.Rect(r.Min.X-1, r.Min.Y-3, r.Max.X+2, r.Max.Y+1)
+	sources := map[string]image.Image{
+		"gray":      randomGray(rng, r),
+		"gray/sub":  randomGray(rng, outer).SubImage(r),
+		"nrgba":     randomNRGBA(rng, r),
+		"nrgba/sub": randomNRGBA(rng, outer).SubImage(r),
+	}
+	for _, ratio := range ycbcrRatios {
+		name := "ycbcr" + strings.TrimPrefix(ratio.String(), "YCbCrSubsampleRatio")
+		sources[name] = randomYCbCr(rng, r, ratio)
+		sources[name+"/sub"] = randomYCbCr(rng, outer, ratio).SubImage(r)
+	}
+	return sources
+}
+
+func TestToRGBAMatchesDraw(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, r := range convertBounds {
+		for name, src := range convertSources(rng, r) {
+			t.Run(fmt.Sprintf("%s/%v", name, r), func(t *testing.T) {
+				want := drawRGBA(src)
+				got := toRGBA(src)
+				if got.Rect != want.Rect {
+					t.Fatalf("bounds %v, want %v", got.Rect, want.Rect)
+				}
+				for y := r.Min.Y; y < r.Max.Y; y++ {
+					g := got.Pix[got.PixOffset(r.Min.X, y):][:r.Dx()*4]
+					w := want.Pix[want.PixOffset(r.Min.X, y):][:r.Dx()*4]
+					for i := range w {
+						if g[i] != w[i] {
+							x := r.Min.X + i/4
+							t.Fatalf("pixel (%d, %d) is %v, want %v", x, y, g[i/4*4:i/4*4+4], w[i/4*4:i/4*4+4])
+						}
+					}
+				}
+				putRGBA(got)
+			})
+		}
+	}
+}
+
+func BenchmarkToRGBA(b *testing.B) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	r := image.Rect(0, 0, 2480, 3508) // A4 at 300 dpi
+	sources := []struct {
+		name string
+		img  image.Image
+	}{
+		{"gray", randomGray(rng, r)},
+		{"nrgba", randomNRGBA(rng, r)},
+		{"ycbcr444", randomYCbCr(rng, r, image.YCbCrSubsampleRatio444)},
+		{"ycbcr422", randomYCbCr(rng, r, image.YCbCrSubsampleRatio422)},
+		{"ycbcr420", randomYCbCr(rng, r, image.YCbCrSubsampleRatio420)},
+	}
+	for _, s := range sources {
+		b.Run(s.name+"/direct", func(b *testing.B) {
+			b.SetBytes(int64(r.Dx() * r.Dy() * 4))
+			for b.Loop() {
+				putRGBA(toRGBA(s.img))
+			}
+		})
+		b.Run(s.name+"/draw", func(b *testing.B) {
+			b.SetBytes(int64(r.Dx() * r.Dy() * 4))
+			for b.Loop() {
+				rgba := getRGBA(r)
+				draw.Draw(rgba, r, s.img, r.Min, draw.Src)
+				putRGBA(rgba)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package imageHandling
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Report formats accepted by Options.Report
+var reportFormats = map[string]rune{
+	"csv": ',',
+	"tsv": '\t',
+}
+
+// csvHeader lists the columns of the statistics report
+var csvHeader = []string{"file", "page", "width", "height", "format", "bytes", "sha256", "dup_of"}
+
+// writeCSVReport writes one row per image, including skipped duplicates,
+// to report.csv or report.tsv in imgDir
+func writeCSVReport(imgDir string, format string, m *Manifest, dups []duplicate) error {
+	sep, ok := reportFormats[format]
+	if !ok {
+		return fmt.Errorf("unsupported report format: %s", format)
+	}
+
+	path := filepath.Join(imgDir, "report."+format)
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Comma = sep
+	w.Write(csvHeader)
+
+	for _, img := range m.Images {
+		w.Write(csvRow(img.File, img.Page, img.Width, img.Height, img.Bytes, img.SHA256, ""))
+	}
+
+	// Duplicates were not written; point them at the image that was kept
+	for _, d := range dups {
+		kept := m.Images[d.Of]
+		w.Write(csvRow("", d.Image.Page, d.Image.Width, d.Image.Height, d.Image.Size, d.Image.FileHash, kept.File))
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// csvRow formats a single report row
+func csvRow(file string, page, width, height int, size int64, hash, dupOf string) []string {
+	format := strings.TrimPrefix(filepath.Ext(file), ".")
+	if file == "" {
+		format = ""
+	}
+	return []string{
+		file,
+		strconv.Itoa(page),
+		strconv.Itoa(width),
+		strconv.Itoa(height),
+		format,
+		strconv.FormatInt(size, 10),
+		hash,
+		dupOf,
+	}
+}
@@ -0,0 +1,146 @@
+package imageHandling
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strings"
+)
+
+// needsPixels reports whether any stage needs decoded pixels; otherwise
+// only image headers are parsed
+func needsPixels(opts Options) bool {
+	format := strings.ToLower(opts.Format)
+	return (format != "" && format != "original") ||
+		opts.Analyze || opts.EmbedPreviews > 0 || opts.HTMLReport || opts.SimilarDist > 0 || opts.MultiTIFF || editsPixels(opts)
+}
+
+// editsPixels reports whether opts change image content, which only
+// converted output can carry
+func editsPixels(opts Options) bool {
+	return opts.Despeckle || opts.AutoCrop || opts.SplitSpread || opts.UpscaleFactor > 1 ||
+		opts.KeyColor != "" || opts.Invert || opts.Tone.enabled() || opts.Stamp.enabled() ||
+		opts.Stitch != "" || opts.Tile != "" || opts.transformsPixels()
+}
+
+// decodeImages decodes the unique images in parallel, or only their headers
+// when pixels aren't needed. Undecodable images are skipped into quarantine
+// and dropped together with their duplicates.
+func (e *Extractor) decodeImages(ctx context.Context, images []LoadedImage, dups []duplicate, skips *skipLog, pixels bool, limits Limits) ([]LoadedImage, []duplicate, error) {
+	errs, err := e.decodeAll(ctx, images, pixels, limits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kept := make([]LoadedImage, 0, len(images))
+	remap := make([]int, len(images))
+	quarantined := 0
+
+	for i, img := range images {
+		if err := errs[i]; err != nil {
+			if err := skips.skip(img.OrigName, img.Path, img.Page, img.ObjNr, "decode", err); err != nil {
+				return nil, nil, err
+			}
+			remap[i] = -1
+			quarantined++
+			continue
+		}
+		remap[i] = len(kept)
+		kept = append(kept, img)
+	}
+
+	dups = remapDuplicates(dups, remap)
+
+	// Exact duplicates share the dimensions of the image they duplicate
+	for i := range dups {
+		dups[i].Image.Width = kept[dups[i].Of].Width
+		dups[i].Image.Height = kept[dups[i].Of].Height
+	}
+
+	if quarantined > 0 {
+		noticef(skips.notices, "quarantined %d undecodable image(s) in %s", quarantined, QuarantineDirName)
+	}
+	return kept, dups, nil
+}
+
+// exifHeadSize bounds how much of a file is searched for EXIF data; the
+// APP1 segment holding it is at most 64 KiB and sits near the start
+const exifHeadSize = 128 << 10
+
+// decodeImage fills in dimensions and, with pixels set, the RGBA image.
+// EXIF orientation is applied so converted output matches viewers.
+// The file is streamed; only its head is buffered to find the orientation.
+// Headers are checked against the pixel limit before pixels are decoded.
+func decodeImage(img *LoadedImage, pixels bool, limits Limits) error {
+	f, err := os.Open(img.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, exifHeadSize)
+	head, err := br.Peek(exifHeadSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	orientation := exifOrientation(head)
+
+	cfg, _, err := image.DecodeConfig(br)
+	if err != nil {
+		return decodeError(err)
+	}
+	if err := limits.checkPixels(cfg.Width, cfg.Height); err != nil {
+		return err
+	}
+	if !pixels {
+		img.Width, img.Height = cfg.Width, cfg.Height
+		if orientation >= orientTranspose {
+			img.Width, img.Height = cfg.Height, cfg.Width
+		}
+		return nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	br.Reset(f)
+	decoded, _, err := image.Decode(br)
+	if err != nil {
+		return decodeError(err)
+	}
+	rgba := toRGBA(decoded)
+	img.Img = applyOrientation(rgba, orientation)
+	if img.Img != rgba {
+		putRGBA(rgba)
+	}
+	b := img.Img.Bounds()
+	img.Width, img.Height = b.Dx(), b.Dy()
+	return nil
+}
+
+// decodeError classifies an error decoding an image file: a format no
+// decoder is registered for is unsupported, anything else corrupt
+func decodeError(err error) error {
+	if errors.Is(err, image.ErrFormat) {
+		return fmt.Errorf("%w: %w", ErrUnsupportedFilter, err)
+	}
+	return fmt.Errorf("%w: %w", ErrCorruptImage, err)
+}
+
+// remapDuplicates updates duplicate targets after the unique list changed;
+// remap[old] is the new index or -1 when the target was dropped
+func remapDuplicates(dups []duplicate, remap []int) []duplicate {
+	out := dups[:0]
+	for _, d := range dups {
+		if remap[d.Of] < 0 {
+			continue
+		}
+		d.Of = remap[d.Of]
+		out = append(out, d)
+	}
+	return out
+}
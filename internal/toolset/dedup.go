@@ -0,0 +1,164 @@
+package imageHandling
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Deduplication scopes accepted by Options.DedupScope
+const (
+	DedupDocument = "document" // One copy per document
+	DedupPage     = "page"     // One copy per page
+	DedupOff      = "off"      // Keep every copy
+)
+
+// Selection policies accepted by Options.DedupKeep
+const (
+	KeepFirst         = "first"          // First image in page order
+	KeepLargestPixels = "largest-pixels" // Highest resolution
+	KeepLargestBytes  = "largest-bytes"  // Largest encoded size
+)
+
+// duplicate is an image skipped because an identical or similar one was kept
+type duplicate struct {
+	Image   LoadedImage
+	Of      int  // Index of the kept image in the unique list
+	Similar bool // Merged by perceptual hash, not identical
+}
+
+// scopeKeyFunc returns the key partitioning images into dedup scopes,
+// or nil when deduplication is off
+func scopeKeyFunc(scope string) (func(LoadedImage) string, error) {
+	switch strings.ToLower(scope) {
+	case "", DedupDocument:
+		return func(LoadedImage) string { return "" }, nil
+	case DedupPage:
+		return func(img LoadedImage) string { return fmt.Sprint(img.Page) }, nil
+	case DedupOff:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported dedup scope: %s", scope)
+}
+
+// deduplicate removes images with equal hashes within opts.DedupScope.
+// It runs on raw bytes, before anything is decoded.
+func deduplicate(images []LoadedImage, opts Options) ([]LoadedImage, []duplicate, error) {
+	scopeKey, err := scopeKeyFunc(opts.DedupScope)
+	if err != nil || scopeKey == nil {
+		return images, nil, err
+	}
+	if _, err := keepPolicy(opts.DedupKeep); err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[string]int)
+	var unique []LoadedImage
+	var dups []duplicate
+
+	for _, img := range images {
+		k := scopeKey(img) + ":" + img.FileHash
+		if idx, ok := seen[k]; ok {
+			dups = append(dups, duplicate{Image: img, Of: idx})
+			continue
+		}
+		seen[k] = len(unique)
+		unique = append(unique, img)
+	}
+	return unique, dups, nil
+}
+
+// mergeSimilar merges decoded images within opts.SimilarDist perceptual
+// hash distance. From every group the image chosen by opts.DedupKeep is
+// kept, at the position of the group's first member.
+func mergeSimilar(images []LoadedImage, dups []duplicate, opts Options) ([]LoadedImage, []duplicate, error) {
+	scopeKey, err := scopeKeyFunc(opts.DedupScope)
+	if err != nil || scopeKey == nil || opts.SimilarDist <= 0 {
+		return images, dups, err
+	}
+	better, err := keepPolicy(opts.DedupKeep)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]uint64, len(images))
+	for i := range images {
+		hashes[i] = PerceptualHash(images[i].Img)
+	}
+
+	// Union-find over images whose hashes are close within the same scope
+	parent := make([]int, len(images))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for a := range images {
+		for b := a + 1; b < len(images); b++ {
+			if scopeKey(images[a]) != scopeKey(images[b]) {
+				continue
+			}
+			if HammingDistance(hashes[a], hashes[b]) <= opts.SimilarDist {
+				ra, rb := find(a), find(b)
+				if ra < rb {
+					parent[rb] = ra
+				} else {
+					parent[ra] = rb
+				}
+			}
+		}
+	}
+
+	// Pick the surviving image of every group; roots are first members
+	kept := make(map[int]int)
+	for i := range images {
+		root := find(i)
+		k, ok := kept[root]
+		if !ok || better(images[i], images[k]) {
+			kept[root] = i
+		}
+	}
+
+	index := make(map[int]int)
+	var unique []LoadedImage
+	for i := range images {
+		if find(i) == i {
+			index[i] = len(unique)
+			unique = append(unique, images[kept[i]])
+		}
+	}
+
+	var merged []duplicate
+	for _, d := range dups {
+		// Identical to an image that another one replaced
+		if kept[find(d.Of)] != d.Of {
+			d.Similar = true
+		}
+		d.Of = index[find(d.Of)]
+		merged = append(merged, d)
+	}
+	for i, img := range images {
+		if kept[find(i)] != i {
+			merged = append(merged, duplicate{Image: img, Of: index[find(i)], Similar: true})
+		}
+	}
+	return unique, merged, nil
+}
+
+// keepPolicy returns a function reporting whether a should replace b as
+// the kept image of a duplicate group
+func keepPolicy(policy string) (func(a, b LoadedImage) bool, error) {
+	switch strings.ToLower(policy) {
+	case "", KeepFirst:
+		return func(a, b LoadedImage) bool { return false }, nil
+	case KeepLargestPixels:
+		return func(a, b LoadedImage) bool { return a.Width*a.Height > b.Width*b.Height }, nil
+	case KeepLargestBytes:
+		return func(a, b LoadedImage) bool { return a.Size > b.Size }, nil
+	}
+	return nil, fmt.Errorf("unsupported dedup keep policy: %s", policy)
+}
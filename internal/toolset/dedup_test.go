@@ -0,0 +1,143 @@
+package imageHandling
+
+import (
+	"fmt"
+	"image"
+	"strings"
+	"testing"
+)
+
+// dedupResult describes kept images by name and duplicates as
+// name>index of the kept image, with ~ for similar ones
+func dedupResult(unique []LoadedImage, dups []duplicate) string {
+	var kept, skipped []string
+	for _, img := range unique {
+		kept = append(kept, img.OrigName)
+	}
+	for _, d := range dups {
+		s := fmt.Sprintf("%s>%d", d.Image.OrigName, d.Of)
+		if d.Similar {
+			s += "~"
+		}
+		skipped = append(skipped, s)
+	}
+	return strings.Join(kept, ",") + " | " + strings.Join(skipped, ",")
+}
+
+func TestDeduplicate(t *testing.T) {
+	images := []LoadedImage{
+		{OrigName: "a", Page: 1, FileHash: "x"},
+		{OrigName: "b", Page: 1, FileHash: "y"},
+		{OrigName: "c", Page: 2, FileHash: "x"},
+		{OrigName: "d", Page: 1, FileHash: "x"},
+	}
+	tests := []struct {
+		scope, keep string
+		want        string // "" = error
+	}{
+		{"", "", "a,b | c>0,d>0"},
+		{DedupDocument, KeepLargestBytes, "a,b | c>0,d>0"},
+		{"PAGE", "", "a,b,c | d>0"},
+		{DedupOff, "", "a,b,c,d | "},
+		{DedupOff, "bogus", "a,b,c,d | "},
+		{"bogus", "", ""},
+		{DedupDocument, "bogus", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope+"/"+tt.keep, func(t *testing.T) {
+			unique, dups, err := deduplicate(images, Options{DedupScope: tt.scope, DedupKeep: tt.keep})
+			if tt.want == "" {
+				if err == nil {
+					t.Fatal("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dedupResult(unique, dups); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// gradient returns a size×size image getting brighter to the right, or
+// darker if falling
+func gradient(size int, falling bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := range size {
+		for x := range size {
+			v := uint8(x * 255 / (size - 1))
+			if falling {
+				v = 255 - v
+			}
+			i := y*img.Stride + 4*x
+			copy(img.Pix[i:i+4], []byte{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestMergeSimilar(t *testing.T) {
+	images := []LoadedImage{
+		{OrigName: "small", Page: 1, Width: 32, Height: 32, Size: 900, Img: gradient(32, false)},
+		{OrigName: "other", Page: 1, Width: 32, Height: 32, Size: 900, Img: gradient(32, true)},
+		{OrigName: "large", Page: 2, Width: 64, Height: 64, Size: 500, Img: gradient(64, false)},
+	}
+	// An identical copy of small found before decoding
+	copyOfSmall := []duplicate{{Image: LoadedImage{OrigName: "copy", Page: 1}, Of: 0}}
+
+	tests := []struct {
+		name string
+		opts Options
+		dups []duplicate
+		want string
+	}{
+		{"off", Options{SimilarDist: 0}, nil, "small,other,large | "},
+		{"first", Options{SimilarDist: 4}, nil, "small,other | large>0~"},
+		{"largest pixels", Options{SimilarDist: 4, DedupKeep: KeepLargestPixels}, nil, "large,other | small>0~"},
+		{"largest bytes", Options{SimilarDist: 4, DedupKeep: KeepLargestBytes}, nil, "small,other | large>0~"},
+		{"per page", Options{SimilarDist: 4, DedupScope: DedupPage}, nil, "small,other,large | "},
+		{"scope off", Options{SimilarDist: 4, DedupScope: DedupOff}, nil, "small,other,large | "},
+		{"identical kept", Options{SimilarDist: 4}, copyOfSmall, "small,other | copy>0,large>0~"},
+		{"identical replaced", Options{SimilarDist: 4, DedupKeep: KeepLargestPixels}, copyOfSmall, "large,other | copy>0~,small>0~"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unique, dups, err := mergeSimilar(images, tt.dups, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dedupResult(unique, dups); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeepPolicy(t *testing.T) {
+	small := LoadedImage{Width: 10, Height: 10, Size: 500}
+	large := LoadedImage{Width: 20, Height: 20, Size: 100}
+	tests := []struct {
+		policy        string
+		largeReplaces bool // large replaces small
+		smallReplaces bool
+	}{
+		{KeepFirst, false, false},
+		{KeepLargestPixels, true, false},
+		{"Largest-Bytes", false, true},
+	}
+	for _, tt := range tests {
+		better, err := keepPolicy(tt.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if better(large, small) != tt.largeReplaces || better(small, large) != tt.smallReplaces {
+			t.Errorf("%s: large replaces small %t, small replaces large %t", tt.policy, better(large, small), better(small, large))
+		}
+	}
+	if _, err := keepPolicy("smallest"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
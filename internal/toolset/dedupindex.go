@@ -0,0 +1,142 @@
+package imageHandling
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DedupIndex remembers the images written by earlier runs, so documents
+// processed again and again, such as a nightly feed, never emit an image
+// the output already holds. Images are keyed by the SHA-256 of their raw
+// stream, as in deduplication within a document. The index is a file of
+// JSON lines, one per image, appended once an extraction is committed.
+type DedupIndex struct {
+	path string
+
+	mu   sync.Mutex
+	held map[string]string // Hash to the image directory holding it
+}
+
+// dedupEntry is a line of a dedup index file
+type dedupEntry struct {
+	SHA256 string `json:"sha256"`
+	Dir    string `json:"dir"`  // Image directory
+	File   string `json:"file"` // Image within Dir
+}
+
+// OpenDedupIndex reads the index at path; a missing file is an empty index
+func OpenDedupIndex(path string) (*DedupIndex, error) {
+	x := &DedupIndex{path: path, held: make(map[string]string)}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return x, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e dedupEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.SHA256 == "" {
+			return nil, fmt.Errorf("%s:%d: not a dedup index entry", path, n)
+		}
+		if _, ok := x.held[e.SHA256]; !ok {
+			x.held[e.SHA256] = e.Dir
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// filter removes the images the index holds from images. Images held in
+// imgDir itself are kept: that output is about to be replaced.
+func (x *DedupIndex) filter(images []LoadedImage, imgDir string) (kept []LoadedImage, known int) {
+	if x == nil {
+		return images, 0
+	}
+	dir := indexDir(imgDir)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, img := range images {
+		if held, ok := x.held[img.FileHash]; ok && held != dir {
+			known++
+			continue
+		}
+		kept = append(kept, img)
+	}
+	return kept, known
+}
+
+// record adds the images in the manifest of imgDir to the index
+func (x *DedupIndex) record(imgDir string) error {
+	if x == nil {
+		return nil
+	}
+	m, err := ReadManifest(imgDir)
+	if err != nil {
+		return err
+	}
+	dir := indexDir(imgDir)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var lines []byte
+	added := make(map[string]bool)
+	for _, img := range m.Images {
+		if _, ok := x.held[img.SHA256]; ok || added[img.SHA256] {
+			continue
+		}
+		line, err := json.Marshal(dedupEntry{SHA256: img.SHA256, Dir: dir, File: img.File})
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+		added[img.SHA256] = true
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	// One append per extraction, so concurrent runs don't interleave lines
+	if err := os.MkdirAll(filepath.Dir(x.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(x.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(lines)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", x.path, err)
+	}
+	for hash := range added {
+		x.held[hash] = dir
+	}
+	return nil
+}
+
+// indexDir is how the index names imgDir: absolute, so runs from
+// different working directories agree
+func indexDir(imgDir string) string {
+	if abs, err := filepath.Abs(imgDir); err == nil {
+		return abs
+	}
+	return filepath.Clean(imgDir)
+}
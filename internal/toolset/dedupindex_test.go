@@ -0,0 +1,123 @@
+package imageHandling
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenDedupIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string // "" = no file
+		held    map[string]string
+		err     string // Part of the error message
+	}{
+		{name: "missing", held: map[string]string{}},
+		{name: "entries", content: `{"sha256":"a","dir":"/out/1","file":"x.png"}` + "\n\n" + `{"sha256":"b","dir":"/out/2","file":"y.png"}` + "\n",
+			held: map[string]string{"a": "/out/1", "b": "/out/2"}},
+		{name: "first kept", content: `{"sha256":"a","dir":"/out/1"}` + "\n" + `{"sha256":"a","dir":"/out/2"}` + "\n",
+			held: map[string]string{"a": "/out/1"}},
+		{name: "not json", content: `{"sha256":"a","dir":"/out/1"}` + "\nnot json\n", err: ":2: not a dedup index entry"},
+		{name: "no hash", content: `{"dir":"/out/1"}` + "\n", err: ":1: not a dedup index entry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "index.jsonl")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			x, err := OpenDedupIndex(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(x.held) != len(tt.held) {
+				t.Fatalf("held %v, want %v", x.held, tt.held)
+			}
+			for hash, dir := range tt.held {
+				if x.held[hash] != dir {
+					t.Errorf("%s held in %q, want %q", hash, x.held[hash], dir)
+				}
+			}
+		})
+	}
+}
+
+func TestDedupIndexFilter(t *testing.T) {
+	root := t.TempDir()
+	imgDir, otherDir := filepath.Join(root, "imgs"), filepath.Join(root, "other")
+	x := &DedupIndex{held: map[string]string{"a": otherDir, "b": imgDir}}
+	images := []LoadedImage{{OrigName: "1", FileHash: "a"}, {OrigName: "2", FileHash: "b"}, {OrigName: "3", FileHash: "c"}}
+
+	tests := []struct {
+		name  string
+		x     *DedupIndex
+		kept  string
+		known int
+	}{
+		{"no index", nil, "1,2,3", 0},
+		{"held elsewhere", x, "2,3", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, known := tt.x.filter(images, imgDir)
+			var names []string
+			for _, img := range kept {
+				names = append(names, img.OrigName)
+			}
+			if strings.Join(names, ",") != tt.kept || known != tt.known {
+				t.Errorf("kept %v, %d known; want %s, %d", names, known, tt.kept, tt.known)
+			}
+		})
+	}
+}
+
+func TestDedupIndexRecord(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "state", "index.jsonl")
+	x, err := OpenDedupIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgDir := filepath.Join(root, "imgs")
+	if err := os.Mkdir(imgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	m := &Manifest{Images: []ManifestImage{{File: "1.png", SHA256: "a"}, {File: "2.png", SHA256: "b"}, {File: "3.png", SHA256: "a"}}}
+	if err := writeManifest(imgDir, m); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recording again adds nothing
+	for range 2 {
+		if err := x.record(imgDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("%d lines written, want 2:\n%s", lines, data)
+	}
+
+	reopened, err := OpenDedupIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, idx := range []*DedupIndex{x, reopened} {
+		if idx.held["a"] != indexDir(imgDir) || idx.held["b"] != indexDir(imgDir) {
+			t.Errorf("held %v, want a and b in %s", idx.held, imgDir)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package imageHandling
+
+import (
+	"context"
+	"fmt"
+	"image"
+)
+
+// despeckleImages removes speckle noise from grayscale and bilevel scans
+// with a 3x3 median filter on the encode pool. Color images are left
+// untouched, since the filter would soften fine detail in photos.
+func (e *Extractor) despeckleImages(ctx context.Context, images []LoadedImage) error {
+	r := newRun(ctx)
+	for i := range images {
+		img := images[i].Img
+		ok := r.submit(e.encode, func() error {
+			if isGray(img) {
+				medianFilter(img)
+			}
+			return nil
+		})
+		if !ok {
+			break
+		}
+	}
+	if err := r.wait(); err != nil {
+		return fmt.Errorf("despeckle: %w", err)
+	}
+	return nil
+}
+
+// isGray reports whether every pixel of img has equal red, green and blue
+func isGray(img *image.RGBA) bool {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+		for i := 0; i < len(row); i += 4 {
+			if row[i] != row[i+1] || row[i] != row[i+2] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// medianFilter replaces each gray level of img by the median of its 3x3
+// neighbourhood; edges repeat the border pixels. Alpha is kept.
+func medianFilter(img *image.RGBA) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w < 3 || h < 3 {
+		return
+	}
+
+	// Work on a copy of the gray plane so results don't feed back
+	gray := make([]byte, w*h)
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			gray[y*w+x] = row[x*4]
+		}
+	}
+
+	forRows(w, h, func(y0, y1 int) {
+		var win [9]byte
+		for y := y0; y < y1; y++ {
+			up, down := max(y-1, 0), min(y+1, h-1)
+			rows := [3][]byte{gray[up*w : up*w+w], gray[y*w : y*w+w], gray[down*w : down*w+w]}
+			d := img.Pix[y*img.Stride : y*img.Stride+w*4]
+			for x, di := 0, 0; x < w; x, di = x+1, di+4 {
+				left, right := max(x-1, 0), min(x+1, w-1)
+				for k, row := range rows {
+					win[k*3], win[k*3+1], win[k*3+2] = row[left], row[x], row[right]
+				}
+				v := median9(&win)
+				d[di], d[di+1], d[di+2] = v, v, v
+			}
+		}
+	})
+}
+
+// median9 returns the median of win, reordering it
+func median9(win *[9]byte) byte {
+	for i := 1; i < len(win); i++ {
+		for j := i; j > 0 && win[j] < win[j-1]; j-- {
+			win[j], win[j-1] = win[j-1], win[j]
+		}
+	}
+	return win[4]
+}
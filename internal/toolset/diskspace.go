@@ -0,0 +1,99 @@
+package imageHandling
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInsufficientSpace is returned when the output would not fit on the
+// destination file system
+var ErrInsufficientSpace = errors.New("not enough disk space")
+
+// spaceMargin is left free beyond an estimate, which is rough, for the
+// manifest, reports and whatever else writes to the file system meanwhile
+const spaceMargin = 16 << 20
+
+// Output size of encoded images relative to their RGBA pixels. PNG is
+// written uncompressed; lossless WebP and Deflate usually halve scans and
+// do far better on graphics, and lossy HEIC takes a fraction of that, so
+// these err on the large side.
+const (
+	ratioPNG     = 1.0
+	ratioWebP    = 0.5
+	ratioDeflate = 0.5
+	ratioHEIC    = 0.1
+)
+
+// estimateOutput estimates the bytes images take once written with opts:
+// the extracted files for the original format, the encoded pixels
+// otherwise, plus the stitched strip and multi-page TIFF if requested
+func estimateOutput(images []LoadedImage, opts Options) int64 {
+	var size, pixels float64
+	for _, img := range images {
+		size += estimateImage(img, opts.Format)
+		pixels += float64(img.Width) * float64(img.Height) * 4
+	}
+	if opts.UpscaleFactor > 1 {
+		scale := float64(opts.UpscaleFactor * opts.UpscaleFactor)
+		size, pixels = size*scale, pixels*scale
+	}
+	if opts.Stitch != "" {
+		size *= 2
+	}
+	if opts.MultiTIFF {
+		size += pixels * ratioDeflate
+	}
+	return int64(size)
+}
+
+// estimateImage estimates the bytes img takes once written in format:
+// the extracted file and any profile added to it for the original format,
+// its encoded pixels otherwise
+func estimateImage(img LoadedImage, format string) float64 {
+	pixels := float64(img.Width) * float64(img.Height) * 4
+	switch strings.ToLower(format) {
+	case "png":
+		return pixels * ratioPNG
+	case "webp":
+		return pixels * ratioWebP
+	case "heic":
+		return pixels * ratioHEIC
+	}
+	if p := img.profile; p != nil && p.source == profileFromPDF {
+		return float64(img.Size + int64(len(p.data)))
+	}
+	return float64(img.Size)
+}
+
+// checkSpace fails with ErrInsufficientSpace if the file system holding
+// dir has less than need bytes and the margin free. Where the free space can't be
+// determined, it succeeds.
+func checkSpace(dir string, need int64) error {
+	need += spaceMargin
+	free, ok := freeSpace(dir)
+	if !ok || need <= free {
+		return nil
+	}
+	return fmt.Errorf("%w on the file system of %s: about %s needed, %s free", ErrInsufficientSpace, dir, FormatSize(need), FormatSize(free))
+}
+
+// checkFileSpace is checkSpace for a file of about need bytes at path
+func checkFileSpace(path string, need int64) error {
+	return checkSpace(filepath.Dir(path), need)
+}
+
+// FormatSize renders a byte count for messages, e.g. "1.5 GiB"
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
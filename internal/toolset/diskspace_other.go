@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package imageHandling
+
+// freeSpace can't tell the free space on this platform
+func freeSpace(dir string) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package imageHandling
+
+import (
+	"math"
+	"syscall"
+)
+
+// freeSpace returns the bytes available to unprivileged users on the file
+// system holding dir
+func freeSpace(dir string) (int64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	free := uint64(st.Bavail) * uint64(st.Bsize)
+	return int64(min(free, math.MaxInt64)), true
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package imageHandling
+
+import (
+	"math"
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// freeSpace returns the bytes available to the current user on the volume
+// holding dir, which honours disk quotas
+func freeSpace(dir string) (int64, bool) {
+	p, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, false
+	}
+	var avail uint64
+	if ok, _, _ := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&avail)), 0, 0); ok == 0 {
+		return 0, false
+	}
+	return int64(min(avail, math.MaxInt64)), true
+}
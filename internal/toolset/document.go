@@ -0,0 +1,172 @@
+package imageHandling
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// ErrNotEncrypted is returned when an unlocked copy of a PDF without
+// encryption is requested
+var ErrNotEncrypted = errors.New("PDF is not encrypted")
+
+// ErrEncrypted is returned for an encrypted PDF that none of the given
+// passwords opens, or that is encrypted to a certificate and no identity
+// is given (ErrCertificateRequired)
+var ErrEncrypted = errors.New("PDF is encrypted")
+
+// ErrExtractionForbidden is returned when an encrypted PDF's permissions
+// don't allow copying or extracting its content
+var ErrExtractionForbidden = errors.New("PDF permissions forbid extracting content")
+
+// permExtract is the permission bit for copying or extracting text and
+// graphics (bit 5 of /P)
+const permExtract = 0x10
+
+// Document is a PDF read and decrypted in memory. Writing an unlocked copy
+// and extracting images share it, so the file is parsed and decrypted once
+// and extraction never reads decrypted data back from disk.
+// A Document is safe for concurrent use: extractions and copies of it take
+// turns, as pdfcpu's parsed file can't be read by two at once.
+type Document struct {
+	filename  string
+	pdf       *model.Context
+	close     func() error
+	pdfAccess            // How it was opened
+	mu        sync.Mutex // Held while pdf is read beyond its header or changed
+}
+
+// Credentials open encrypted PDFs: passwords are tried in order, and the
+// identity opens PDFs encrypted to certificates
+type Credentials struct {
+	Passwords []string
+	Identity  *Identity
+}
+
+// OpenDocument reads and decrypts filename, using creds if it can't be
+// opened without them. The file stays open until Close, since streams are
+// read from it on demand.
+func OpenDocument(ctx context.Context, filename string, creds Credentials) (*Document, error) {
+	filename = LongPath(filename)
+	pdf, closePDF, access, err := openPDFWith(ctx, filename, creds)
+	if err != nil {
+		return nil, err
+	}
+	return &Document{filename: filename, pdf: pdf, close: closePDF, pdfAccess: access}, nil
+}
+
+// openDocumentData is OpenDocument for a PDF held in memory, such as a
+// member of an archive, so its content is never written to disk; name is
+// what it is called in the manifest and messages
+func openDocumentData(ctx context.Context, name string, data []byte, creds Credentials) (*Document, error) {
+	pdf, access, err := readPDFWith(ctx, bytes.NewReader(data), creds)
+	if err != nil {
+		return nil, err
+	}
+	return &Document{filename: name, pdf: pdf, close: func() error { return nil }, pdfAccess: access}, nil
+}
+
+// Encrypted reports whether the file on disk is encrypted
+func (d *Document) Encrypted() bool {
+	return d.pdf.Encrypt != nil
+}
+
+// Protected reports whether the document could only be opened with a
+// password or certificate, as opposed to no or an owner-only lock
+func (d *Document) Protected() bool {
+	return d.protected
+}
+
+// AllowsExtraction reports whether the document's permissions allow
+// extracting its content. Unencrypted documents always do, as do those
+// opened with the owner password.
+func (d *Document) AllowsExtraction() bool {
+	return d.owner || allowsExtraction(d.pdf)
+}
+
+// allowsExtraction checks the permissions of a PDF opened without the
+// owner password, which grants full access; only the caller's policy
+// overrides them.
+func allowsExtraction(pdf *model.Context) bool {
+	return pdf.E == nil || pdf.E.P&permExtract != 0
+}
+
+// WriteUnlocked writes the decrypted document to path
+func (d *Document) WriteUnlocked(ctx context.Context, path string) error {
+	if !d.Encrypted() {
+		return ErrNotEncrypted
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.writeCopy(ctx, path)
+}
+
+// writeCopy writes the document to path, decrypted; d.mu must be held
+func (d *Document) writeCopy(ctx context.Context, path string) error {
+	return writeDecrypted(ctx, d.pdf, path)
+}
+
+// writeDecrypted writes pdf to path without encryption. The copy is
+// written under a temporary name next to path and renamed into place once
+// complete, so a failed write never leaves a partial file at path. Unlike
+// parsing, the write isn't abandoned when ctx is done, since pdfcpu
+// changes the document while writing: it is waited for, and covers only
+// objects parsing already got through.
+func writeDecrypted(ctx context.Context, pdf *model.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The DECRYPT command makes pdfcpu drop encryption while writing. It
+	// also clears the key, which streams read later still need.
+	cmd, key := pdf.Cmd, pdf.EncKey
+	if pdf.Encrypt != nil {
+		pdf.Cmd = model.DECRYPT
+	}
+	defer func() { pdf.Cmd, pdf.EncKey = cmd, key }()
+
+	// A copy is about as large as the file it was read from
+	if err := checkFileSpace(path, pdf.Read.FileSize); err != nil {
+		return err
+	}
+
+	// pdfcpu skips objects it has already written, so every copy starts
+	// with fresh write state
+	pdf.Write = model.NewWriteContext(pdf.Write.Eol)
+
+	path = LongPath(path)
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	// The mode os.Create would give it, not CreateTemp's private one
+	if err = f.Chmod(0644); err == nil {
+		err = callSafe(func() error { return api.WriteContext(pdf, f) })
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// Close releases the file
+func (d *Document) Close() error {
+	return d.close()
+}
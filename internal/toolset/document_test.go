@@ -0,0 +1,162 @@
+package imageHandling
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// minimalPDF returns a valid one-page PDF without content
+func minimalPDF() []byte {
+	return buildPDF(nil, "")
+}
+
+// buildPDF returns a one-page PDF without content with the extra
+// objects, numbered from 4, and entries in its trailer
+func buildPDF(extra []string, trailer string) []byte {
+	objects := append([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 100 100] /Resources << >> >>",
+	}, extra...)
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R %s>>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailer, xref)
+	return b.Bytes()
+}
+
+// encryptedPDF returns minimalPDF encrypted with the passwords and
+// permissions, with AES-256 or else 128-bit RC4
+func encryptedPDF(t testing.TB, user, owner string, perms model.PermissionFlags, aes bool) []byte {
+	t.Helper()
+	conf := model.NewRC4Configuration(user, owner, 128)
+	if aes {
+		conf = model.NewAESConfiguration(user, owner, 256)
+	}
+	conf.Permissions = perms
+	var out bytes.Buffer
+	if err := api.Encrypt(bytes.NewReader(minimalPDF()), &out, conf); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestOpenDocumentPasswords(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      string // "" = owner password only
+		perms     model.PermissionFlags
+		passwords []string
+		err       error // nil = opens
+		protected bool
+		allows    bool
+	}{
+		{name: "owner-only/none", perms: model.PermissionsNone, allows: false},
+		{name: "owner-only/wrong", perms: model.PermissionsNone, passwords: []string{"nope"}, allows: false},
+		{name: "owner-only/owner", perms: model.PermissionsNone, passwords: []string{"nope", "own"}, allows: true},
+		{name: "owner-only/permitted", perms: model.PermissionsAll, allows: true},
+		{name: "user/none", user: "usr", perms: model.PermissionsNone, err: ErrEncrypted},
+		{name: "user/wrong", user: "usr", perms: model.PermissionsNone, passwords: []string{"nope"}, err: ErrEncrypted},
+		{name: "user/user", user: "usr", perms: model.PermissionsNone, passwords: []string{"usr"}, protected: true, allows: false},
+		{name: "user/owner", user: "usr", perms: model.PermissionsNone, passwords: []string{"own"}, protected: true, allows: true},
+		{name: "user/user-then-owner", user: "usr", perms: model.PermissionsNone, passwords: []string{"usr", "own"}, protected: true, allows: true},
+		{name: "user/permitted", user: "usr", perms: model.PermissionsAll, passwords: []string{"usr"}, protected: true, allows: true},
+	}
+	for _, aes := range []bool{true, false} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/aes=%t", tt.name, aes), func(t *testing.T) {
+				data := encryptedPDF(t, tt.user, "own", tt.perms, aes)
+				doc, err := openDocumentData(context.Background(), "test.pdf", data, Credentials{Passwords: tt.passwords})
+				if tt.err != nil {
+					if !errors.Is(err, tt.err) {
+						t.Fatalf("error %v, want %v", err, tt.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer doc.Close()
+				if doc.Protected() != tt.protected {
+					t.Errorf("protected %t, want %t", doc.Protected(), tt.protected)
+				}
+				if doc.AllowsExtraction() != tt.allows {
+					t.Errorf("allows extraction %t, want %t", doc.AllowsExtraction(), tt.allows)
+				}
+			})
+		}
+	}
+}
+
+func TestOpenDocumentUnencrypted(t *testing.T) {
+	doc, err := openDocumentData(context.Background(), "test.pdf", minimalPDF(), Credentials{Passwords: []string{"own"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	if doc.Encrypted() || doc.Protected() || !doc.AllowsExtraction() {
+		t.Errorf("encrypted %t, protected %t, allows extraction %t", doc.Encrypted(), doc.Protected(), doc.AllowsExtraction())
+	}
+}
+
+func TestWriteUnlocked(t *testing.T) {
+	data := encryptedPDF(t, "usr", "own", model.PermissionsAll, true)
+	doc, err := openDocumentData(context.Background(), "test.pdf", data, Credentials{Passwords: []string{"usr"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "unlocked.pdf")
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := doc.WriteUnlocked(cancelled, path); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled write: error %v, want %v", err, context.Canceled)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("cancelled write left %d file(s)", len(entries))
+	}
+
+	for range 2 {
+		if err := doc.WriteUnlocked(context.Background(), path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d files written, want only the copy", len(entries))
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := openDocumentData(context.Background(), "unlocked.pdf", out, Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	if copied.Encrypted() {
+		t.Error("copy is encrypted")
+	}
+	// The document stays readable after writing copies
+	if !doc.Encrypted() || doc.pdf.EncKey == nil {
+		t.Error("writing a copy changed the document")
+	}
+}
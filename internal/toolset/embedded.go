@@ -0,0 +1,129 @@
+package imageHandling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// IsPortfolio reports whether the document is a PDF portfolio (a
+// collection), whose cover sheet presents the PDFs embedded in it
+func (d *Document) IsPortfolio() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	root, err := d.pdf.Catalog()
+	return err == nil && root["Collection"] != nil
+}
+
+// embedsPDFs reports whether the PDFs embedded in doc are extracted along
+// with it: always for portfolios, and for attachments up to
+// opts.Attachments levels down
+func embedsPDFs(doc *Document, opts Options) bool {
+	return doc.IsPortfolio() || len(opts.enclosing) < opts.Attachments
+}
+
+// extractEmbedded extracts the images of doc like extractToDir: its own
+// into dir, and those of every PDF embedded in it into a folder of dir
+// named after it, recursing into embedded PDFs that embed PDFs in turn.
+// The manifest covers them all, recording the embedded PDF each image
+// comes from. A PDF identical to one it is embedded in is skipped, so a
+// cycle ends there. Embedded PDFs that fail are returned and listed in the
+// manifest; opening them takes no credentials.
+func (e *Extractor) extractEmbedded(ctx context.Context, doc *Document, source string, dir string, opts Options) (*Result, []*ArchiveError, error) {
+	members, err := embeddedMembers(doc.pdf)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := e.extractToDir(ctx, doc.pdf, source, dir, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest.source, manifest.memory = source, opts.memory
+	opts.enclosing = append(slices.Clip(opts.enclosing), manifest.InputHash)
+
+	// Folders don't take the names of doc's own output
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	folders := map[string]bool{strings.ToLower(QuarantineDirName): true}
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name())
+		folders[name] = true
+		folders[strings.TrimSuffix(name, path.Ext(name))] = true
+	}
+
+	noCreds := func(string) Credentials { return Credentials{} }
+	embedded, failed, err := e.extractMembers(ctx, dir, manifest, members, folders, opts, noCreds)
+	if err != nil {
+		if interrupted(ctx) {
+			if err := writeInterrupted(dir, manifest); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, nil, err
+	}
+	if err := manifest.verifyInput(); err != nil {
+		return nil, nil, err
+	}
+	if err := writeManifest(dir, manifest); err != nil {
+		return nil, nil, err
+	}
+	res.Add(embedded)
+	res.Failed = len(failed)
+	return res, failed, nil
+}
+
+// embeddedMembers lists the PDFs embedded in pdf, the documents of a
+// portfolio or attachments, in name order. Other embedded files are left
+// out; each PDF is decoded when opened.
+func embeddedMembers(pdf *model.Context) ([]archiveMember, error) {
+	attachments, err := pdf.ListAttachments()
+	if err != nil {
+		return nil, err
+	}
+	var members []archiveMember
+	for _, a := range attachments {
+		name := a.FileName
+		if name == "" {
+			name = a.ID
+		}
+		if !strings.EqualFold(path.Ext(name), ".pdf") {
+			continue
+		}
+		id := a.ID
+		members = append(members, archiveMember{name: name, open: func() (io.ReadCloser, error) {
+			found, err := pdf.ExtractAttachments([]string{id})
+			if err != nil {
+				return nil, err
+			}
+			if len(found) == 0 || found[0].Reader == nil {
+				return nil, errors.New("embedded file not found")
+			}
+			return io.NopCloser(found[0].Reader), nil
+		}})
+	}
+	sort.SliceStable(members, func(i, j int) bool { return members[i].name < members[j].name })
+	return members, nil
+}
+
+// embeddedError joins the errors of the embedded PDFs that failed
+func embeddedError(failed []*ArchiveError) error {
+	errs := make([]error, len(failed))
+	for i, f := range failed {
+		errs[i] = f
+	}
+	return fmt.Errorf("%d embedded PDF(s) failed: %w", len(failed), errors.Join(errs...))
+}
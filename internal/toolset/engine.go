@@ -0,0 +1,132 @@
+package imageHandling
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// DefaultEngine renders image streams unless Options.Engine names another
+const DefaultEngine = "pdfcpu"
+
+// engine turns the image streams of a PDF into image files. pdfcpu works
+// on the document as parsed; the others run another PDF library on a
+// decrypted copy, as some malformed PDFs only render in one of them.
+// Pages and image references always come from pdfcpu, so a PDF pdfcpu
+// can't parse at all fails with every engine.
+type engine interface {
+	// start prepares rendering refs of pdf, working in dir, and returns
+	// the renderer of one of them
+	start(ctx context.Context, pdf *model.Context, dir string, refs []imageRef) (renderFunc, error)
+}
+
+// renderFunc renders the stream of ref, checked against limits
+type renderFunc func(ref imageRef, limits Limits) (*model.Image, error)
+
+// engines are the engines by name (Options.Engine)
+var engines = map[string]engine{
+	DefaultEngine: pdfcpuEngine{},
+	"mupdf":       mupdfEngine{},
+}
+
+// Engines returns the names of the extraction engines, the default first
+func Engines() []string {
+	names := []string{DefaultEngine}
+	for name := range engines {
+		if name != DefaultEngine {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// engineFor returns the engine called name ("" = DefaultEngine)
+func engineFor(name string) (engine, error) {
+	if name == "" {
+		name = DefaultEngine
+	}
+	if e, ok := engines[strings.ToLower(name)]; ok {
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown engine %q (available: %s)", name, strings.Join(Engines(), ", "))
+}
+
+// pdfcpuEngine renders streams with pdfcpu, from the parsed document
+type pdfcpuEngine struct{}
+
+func (pdfcpuEngine) start(ctx context.Context, pdf *model.Context, dir string, refs []imageRef) (renderFunc, error) {
+	return func(ref imageRef, limits Limits) (*model.Image, error) {
+		return renderImage(pdf, ref, limits)
+	}, nil
+}
+
+// mupdfEngine renders streams with MuPDF's mutool, which repairs broken
+// cross-reference tables and decodes some streams pdfcpu gives up on
+type mupdfEngine struct{}
+
+func (mupdfEngine) start(ctx context.Context, pdf *model.Context, dir string, refs []imageRef) (renderFunc, error) {
+	bin, err := exec.LookPath("mutool")
+	if err != nil {
+		return nil, fmt.Errorf("the mupdf engine needs mutool: %w", err)
+	}
+	work, err := os.MkdirTemp(dir, "mupdf")
+	if err != nil {
+		return nil, err
+	}
+	// mutool can't take the document from memory or decrypt it with the
+	// credentials pdfcpu used, so it gets a decrypted copy
+	src := filepath.Join(work, "input.pdf")
+	if err := writeDecrypted(ctx, pdf, src); err != nil {
+		os.RemoveAll(work)
+		return nil, err
+	}
+
+	// Images are written as image-<object number>.<type>, converted to RGB
+	args := []string{"extract", "-r", src}
+	seen := make(map[int]bool)
+	for _, ref := range refs {
+		if !seen[ref.objNr] {
+			seen[ref.objNr] = true
+			args = append(args, strconv.Itoa(ref.objNr))
+		}
+	}
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Dir = work
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(work)
+		return nil, commandError("mutool", err, out)
+	}
+	os.Remove(src)
+
+	return func(ref imageRef, limits Limits) (*model.Image, error) {
+		if err := checkStreamLimits(ref.sd, limits); err != nil {
+			return nil, err
+		}
+		matches, _ := filepath.Glob(filepath.Join(work, fmt.Sprintf("image-%04d.*", ref.objNr)))
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%w: mutool wrote no image", ErrCorruptImage)
+		}
+		info, err := os.Stat(matches[0])
+		if err != nil {
+			return nil, err
+		}
+		if limit := maxDecodedBytes(declaredPixels(ref.sd, limits)); info.Size() > limit {
+			return nil, fmt.Errorf("rendered image is %w of %d bytes", errOverLimit, limit)
+		}
+		data, err := os.ReadFile(matches[0])
+		if err != nil {
+			return nil, err
+		}
+		ext := strings.TrimPrefix(filepath.Ext(matches[0]), ".")
+		return &model.Image{Reader: bytes.NewReader(data), Name: ref.name, FileType: ext, PageNr: ref.page, ObjNr: ref.objNr, Thumb: ref.thumb}, nil
+	}, nil
+}
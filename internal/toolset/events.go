@@ -0,0 +1,118 @@
+package imageHandling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event types reported to an EventSink
+const (
+	EventDecrypted = "decrypted" // Input opened with a password or certificate
+	EventExtracted = "extracted" // Image stream read from the PDF
+	EventDecoded   = "decoded"   // Image decoded
+	EventDeduped   = "deduped"   // Image left out as a duplicate of another
+	EventEncoded   = "encoded"   // Image converted to the output format
+	EventWritten   = "written"   // Image file written
+	EventError     = "error"     // Image quarantined, or the extraction failed
+)
+
+// Event is a step of an extraction, reported as it happens
+type Event struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"event"`
+	Input       string    `json:"input"` // Input file name, followed by /<name> for PDFs within it
+	Page        int       `json:"page,omitempty"`
+	ObjNr       int       `json:"obj_nr,omitempty"`
+	DuplicateOf int       `json:"duplicate_of,omitempty"` // Object number of the image kept instead
+	File        string    `json:"file,omitempty"`         // Written file, relative to the image directory
+	Bytes       int64     `json:"bytes,omitempty"`
+	Stage       string    `json:"stage,omitempty"` // Where an image failed
+	Error       string    `json:"error,omitempty"`
+}
+
+// EventSink receives the events of extractions. Images are processed
+// concurrently, so Event must be safe for concurrent use.
+type EventSink interface {
+	Event(Event)
+}
+
+// JSONLinesSink writes events as one line of JSON each
+type JSONLinesSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONLinesSink returns a sink writing to w. Write errors are ignored,
+// so a monitor going away doesn't stop the extraction.
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{enc: json.NewEncoder(w)}
+}
+
+func (s *JSONLinesSink) Event(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enc.Encode(ev)
+}
+
+// noticef writes a progress notice, such as images quarantined, to w as one
+// line; nil writes nothing. Each notice is a single Write, so notices of
+// parallel extractions sharing w don't interleave.
+func noticef(w io.Writer, format string, args ...any) {
+	if w == nil {
+		return
+	}
+	io.WriteString(w, fmt.Sprintf(format, args...)+"\n")
+}
+
+// eventLog reports the events of one input; nil reports nothing
+type eventLog struct {
+	sink  EventSink
+	input string
+}
+
+// emit stamps ev with the time and input and reports it
+func (l *eventLog) emit(ev Event) {
+	if l == nil {
+		return
+	}
+	ev.Time, ev.Input = time.Now().UTC(), l.input
+	l.sink.Event(ev)
+}
+
+type eventLogKey struct{}
+
+// withEvents returns ctx carrying the event log of input, so the pipeline
+// stages report to sink without it being passed along (nil = no events)
+func withEvents(ctx context.Context, sink EventSink, input string) context.Context {
+	if sink == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, eventLogKey{}, &eventLog{sink: sink, input: input})
+}
+
+// withNestedEvents returns ctx reporting the events of the PDF name within
+// the input of ctx
+func withNestedEvents(ctx context.Context, name string) context.Context {
+	l := eventsOf(ctx)
+	if l == nil {
+		return ctx
+	}
+	return withEvents(ctx, l.sink, l.input+"/"+name)
+}
+
+// eventsOf returns the event log carried by ctx, nil if none
+func eventsOf(ctx context.Context) *eventLog {
+	l, _ := ctx.Value(eventLogKey{}).(*eventLog)
+	return l
+}
+
+// emitDuplicates reports dups, duplicates of images
+func emitDuplicates(l *eventLog, images []LoadedImage, dups []duplicate) {
+	for _, d := range dups {
+		l.emit(Event{Type: EventDeduped, Page: d.Image.Page, ObjNr: d.Image.ObjNr, DuplicateOf: images[d.Of].ObjNr})
+	}
+}
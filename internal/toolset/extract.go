@@ -0,0 +1,437 @@
+package imageHandling
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/filter"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// ErrUnsupportedFilter is returned for an image stream stored with a
+// filter, or a combination of filters and color space, pixf can't decode
+var ErrUnsupportedFilter = errors.New("unsupported image filter")
+
+// ErrCorruptImage is returned for image data that fails to decode
+var ErrCorruptImage = errors.New("corrupt image")
+
+// extractedFile is an image stream dumped into the temp directory
+type extractedFile struct {
+	Name     string // File name within the temp directory
+	Page     int    // Page the image was found on
+	ObjNr    int    // PDF object number of the image XObject
+	Resource string // Resource path on the page (e.g. Im0 or Fm1.Im0)
+	Hash     string // SHA-256 of the file, computed while it is written
+	Size     int64  // File size in bytes
+	Err      error  // Set when pdfcpu could not render the stream; Name then holds the raw bytes
+}
+
+// Several extractedFiles share a Name when the same image object is used
+// on several pages; the file is written and decoded only once.
+
+// imageRef is an image XObject reachable from a page
+type imageRef struct {
+	page  int
+	objNr int
+	name  string
+	sd    *types.StreamDict
+	thumb bool
+}
+
+// openPDF reads and validates filename for image extraction. Streams are
+// read from the file later, so it stays open until close is called.
+func openPDF(ctx context.Context, filename string) (pdf *model.Context, close func() error, err error) {
+	pdf, close, _, err = openPDFWith(ctx, filename, Credentials{})
+	return pdf, close, err
+}
+
+// openPDFWith is openPDF for encrypted files: they are opened with the
+// first of creds.Passwords that is the owner password, else without a
+// password if possible, else with the first that fits as the user
+// password. Files encrypted to certificates need creds.Identity. access
+// tells which was needed.
+func openPDFWith(ctx context.Context, filename string, creds Credentials) (pdf *model.Context, close func() error, access pdfAccess, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, pdfAccess{}, err
+	}
+	if pdf, access, err = readPDFWith(ctx, f, creds); err != nil {
+		f.Close()
+		return nil, nil, pdfAccess{}, err
+	}
+	return pdf, f.Close, access, nil
+}
+
+// pdfAccess tells how an encrypted PDF was opened
+type pdfAccess struct {
+	protected bool // Opening took a password or certificate
+	owner     bool // The owner password opened it, which lifts its permissions
+}
+
+// noUserPassword stands in for the user password while a candidate is
+// tried as the owner password: pdfcpu falls back to the user password
+// when the owner password doesn't fit, and this one never does. AES-256
+// passwords must be printable, so it can't be made of control codes.
+const noUserPassword = "pixf/owner-password-only/5d1e8b2c9f4a7063"
+
+// readPDFWith reads and validates the PDF in src like openPDFWith. Streams
+// are read from src later, so it must stay readable while pdf is used.
+func readPDFWith(ctx context.Context, src io.ReadSeeker, creds Credentials) (pdf *model.Context, access pdfAccess, err error) {
+	rs := src
+	if creds.Identity != nil {
+		data, pubSec, err := pubSecData(src, creds.Identity)
+		if err != nil {
+			return nil, pdfAccess{}, err
+		}
+		if pubSec {
+			rs, access.protected = bytes.NewReader(data), true
+		}
+	}
+
+	// read parses rs with pw as the owner password if owner is set, else
+	// as the user password ("" = none)
+	read := func(pw string, owner bool) (pdf *model.Context, err error) {
+		if _, err = rs.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		conf := model.NewDefaultConfiguration()
+		conf.Cmd = model.EXTRACTIMAGES
+		if pw != "" {
+			// Given a password, pdfcpu enforces the permissions itself.
+			// OPTIMIZE is read the same way but isn't restricted, so
+			// callers keep applying their own policy.
+			conf.Cmd = model.OPTIMIZE
+			conf.UserPW, conf.OwnerPW = pw, pw
+			if owner {
+				conf.UserPW = noUserPassword
+			}
+		}
+		// Parsing is where malformed PDFs hang; don't wait past the deadline
+		err = RunContext(ctx, func() (err error) {
+			pdf, err = api.ReadValidateAndOptimize(rs, conf)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		pdf.Cmd = model.EXTRACTIMAGES
+		return pdf, nil
+	}
+	// owners tries the passwords as the owner password, which only
+	// encrypted files check
+	owners := func() *model.Context {
+		for _, pw := range creds.Passwords {
+			if pdf, err := read(pw, true); err == nil {
+				return pdf
+			} else if !errors.Is(err, pdfcpu.ErrWrongPassword) {
+				break
+			}
+		}
+		return nil
+	}
+
+	pdf, err = read("", false)
+	if err == nil {
+		if pdf.E == nil || allowsExtraction(pdf) || len(creds.Passwords) == 0 {
+			return pdf, access, nil
+		}
+		// Locked by an owner password alone: its permissions apply unless
+		// one of the passwords is the owner's
+		if owned := owners(); owned != nil {
+			return owned, pdfAccess{protected: access.protected, owner: true}, nil
+		}
+		return pdf, access, nil
+	}
+	if errors.Is(err, pdfcpu.ErrWrongPassword) && len(creds.Passwords) > 0 {
+		if pdf = owners(); pdf != nil {
+			return pdf, pdfAccess{protected: true, owner: true}, nil
+		}
+		for _, pw := range creds.Passwords {
+			if pdf, err = read(pw, false); err == nil {
+				return pdf, pdfAccess{protected: true}, nil
+			}
+			if !errors.Is(err, pdfcpu.ErrWrongPassword) {
+				break
+			}
+		}
+	}
+	switch {
+	case errors.Is(err, pdfcpu.ErrWrongPassword) && len(creds.Passwords) > 0:
+		err = fmt.Errorf("%w: none of %d password(s) fits: %w", ErrEncrypted, len(creds.Passwords), err)
+	case creds.Identity == nil && ctx.Err() == nil:
+		// pdfcpu only reports an unsupported security handler
+		if _, pubSec, _ := pubSecData(src, nil); pubSec {
+			err = ErrCertificateRequired
+		}
+	}
+	if errors.Is(err, pdfcpu.ErrWrongPassword) && !errors.Is(err, ErrEncrypted) {
+		err = fmt.Errorf("%w: %w", ErrEncrypted, err)
+	}
+	return nil, pdfAccess{}, err
+}
+
+// extractRaw writes the image streams of pdf selected by sel into dir, in
+// page order, rendered by eng. Streams over limits or that fail to render
+// are kept raw for quarantine.
+func extractRaw(ctx context.Context, pdf *model.Context, dir string, sel *objectSet, limits Limits, eng engine) ([]extractedFile, error) {
+	all, err := collectImageRefs(pdf)
+	if err != nil {
+		return nil, err
+	}
+	var refs []imageRef
+	for _, ref := range all {
+		if sel.matches(ref) {
+			refs = append(refs, ref)
+		}
+	}
+	render, err := eng.start(ctx, pdf, dir, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Images shared by several pages (letterheads, logos) are the same PDF
+	// object; extract them once and let later references reuse the file
+	first := make(map[int]extractedFile)
+
+	var files []extractedFile
+	for _, ref := range refs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if prev, ok := first[ref.objNr]; ok {
+			prev.Page, prev.Resource = ref.page, ref.name
+			files = append(files, prev)
+			continue
+		}
+
+		file := extractedFile{Page: ref.page, ObjNr: ref.objNr, Resource: ref.name}
+
+		img, err := render(ref, limits)
+		if err == nil {
+			file.Name = fmt.Sprintf("page%d_%s.%s", ref.page, ref.name, img.FileType)
+			file.Hash, file.Size, err = writeHashed(filepath.Join(dir, file.Name), img, maxDecodedBytes(declaredPixels(ref.sd, limits)))
+			if err != nil && !errors.Is(err, errOverLimit) {
+				return nil, fmt.Errorf("write %s: %w", file.Name, err)
+			}
+		}
+		if err != nil {
+			// Keep the undecoded stream so it can be quarantined
+			file.Name = fmt.Sprintf("page%d_%s.raw", ref.page, ref.name)
+			file.Hash, file.Size = "", 0
+			file.Err = err
+			if werr := os.WriteFile(filepath.Join(dir, file.Name), ref.sd.Raw, 0644); werr != nil {
+				return nil, fmt.Errorf("write %s: %w", file.Name, werr)
+			}
+		}
+		first[ref.objNr] = file
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// renderImage checks the stream of ref against limits and renders it with
+// pdfcpu. A stream that makes pdfcpu panic costs that image, not the run.
+func renderImage(pdf *model.Context, ref imageRef, limits Limits) (img *model.Image, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			img, err = nil, fmt.Errorf("%w: panic: %v", ErrCorruptImage, p)
+		}
+	}()
+	// Rendering inflates the stream, so check the declared size first
+	if err := checkStreamLimits(ref.sd, limits); err != nil {
+		return nil, err
+	}
+	if err := checkInflated(ref.sd, maxDecodedBytes(declaredPixels(ref.sd, limits))); err != nil {
+		return nil, err
+	}
+	img, err = pdfcpu.ExtractImage(pdf, ref.sd, ref.thumb, ref.name, ref.objNr, false)
+	if errors.Is(err, filter.ErrUnsupportedFilter) {
+		return nil, fmt.Errorf("%w %v: %w", ErrUnsupportedFilter, ref.sd.FilterPipeline, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorruptImage, err)
+	}
+	if img == nil || img.Reader == nil {
+		// pdfcpu skips what it can't decode without saying why
+		return nil, fmt.Errorf("%w %v", ErrUnsupportedFilter, ref.sd.FilterPipeline)
+	}
+	return img, nil
+}
+
+// checkStreamLimits compares an image stream's compressed length and
+// declared dimensions with limits
+func checkStreamLimits(sd *types.StreamDict, limits Limits) error {
+	if err := limits.checkBytes(int64(len(sd.Raw))); err != nil {
+		return err
+	}
+	w, h := sd.IntEntry("Width"), sd.IntEntry("Height")
+	if w != nil && h != nil {
+		return limits.checkPixels(*w, *h)
+	}
+	return nil
+}
+
+// declaredPixels is the pixel count a stream declares, or the pixel limit
+// if it declares none
+func declaredPixels(sd *types.StreamDict, limits Limits) int64 {
+	w, h := sd.IntEntry("Width"), sd.IntEntry("Height")
+	if w == nil || h == nil || *w <= 0 || *h <= 0 {
+		return limits.MaxPixels
+	}
+	return min(int64(*w)*int64(*h), limits.MaxPixels)
+}
+
+// checkInflated fails when a Flate stream inflates to more than limit
+// bytes. A stream can declare a small image and still inflate to
+// gigabytes, and pdfcpu inflates without bounds, so the stream is
+// inflated into nothing first.
+func checkInflated(sd *types.StreamDict, limit int64) error {
+	if len(sd.FilterPipeline) == 0 || sd.FilterPipeline[0].Name != filter.Flate {
+		return nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(sd.Raw))
+	if err != nil {
+		return nil // pdfcpu reports the broken stream
+	}
+	defer zr.Close()
+	if n, _ := io.CopyN(io.Discard, zr, limit+1); n > limit {
+		return fmt.Errorf("image stream inflates to more than %d bytes", limit)
+	}
+	return nil
+}
+
+// errOverLimit is returned by writeHashed for output over its limit
+var errOverLimit = errors.New("over the size limit")
+
+// writeHashed streams r into a new file at path and returns the SHA-256
+// and size of what was written, so images are never held in memory whole.
+// More than limit bytes fail with errOverLimit and leave no file.
+func writeHashed(path string, r io.Reader, limit int64) (string, int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return "", 0, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(f, io.TeeReader(io.LimitReader(r, limit+1), h))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > limit {
+		err = fmt.Errorf("rendered image is %w of %d bytes", errOverLimit, limit)
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", 0, err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), n, nil
+}
+
+// collectImageRefs walks the resources of every page, descending into
+// Form XObjects, and returns each image once per page in discovery order.
+// Tracking object numbers per page avoids extracting an image twice when
+// it is reachable through several (nested or reused) forms. Malformed
+// resources that make pdfcpu panic fail the document with an error.
+func collectImageRefs(ctx *model.Context) (refs []imageRef, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			refs, err = nil, fmt.Errorf("read page resources: panic: %v", p)
+		}
+	}()
+	for page := 1; page <= ctx.PageCount; page++ {
+		_, _, inh, err := ctx.PageDict(page, true)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", page, err)
+		}
+
+		seen := make(map[int]bool)
+		if inh != nil {
+			if err := walkXObjects(ctx, inh.Resources, page, "", seen, &refs); err != nil {
+				return nil, fmt.Errorf("page %d: %w", page, err)
+			}
+		}
+
+		// Page thumbnails are images too
+		if indRef, ok := ctx.PageThumbs[page]; ok {
+			sd, _, err := ctx.DereferenceStreamDict(indRef)
+			if err != nil {
+				return nil, fmt.Errorf("page %d thumbnail: %w", page, err)
+			}
+			if sd != nil {
+				refs = append(refs, imageRef{page: page, objNr: indRef.ObjectNumber.Value(), name: "thumb", sd: sd, thumb: true})
+			}
+		}
+	}
+	return refs, nil
+}
+
+// walkXObjects collects images of a resource dict and recurses into forms.
+// Nested resource names are joined with dots (Fm0.Im1).
+func walkXObjects(ctx *model.Context, res types.Dict, page int, prefix string, seen map[int]bool, refs *[]imageRef) error {
+	if res == nil {
+		return nil
+	}
+	o, found := res.Find("XObject")
+	if !found {
+		return nil
+	}
+	xobjs, err := ctx.DereferenceDict(o)
+	if err != nil || xobjs == nil {
+		return err
+	}
+
+	names := make([]string, 0, len(xobjs))
+	for name := range xobjs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		// XObjects are always indirect; the object number identifies them
+		ir, ok := xobjs[name].(types.IndirectRef)
+		if !ok {
+			continue
+		}
+		objNr := ir.ObjectNumber.Value()
+		if seen[objNr] {
+			continue
+		}
+		seen[objNr] = true
+
+		sd, _, err := ctx.DereferenceStreamDict(ir)
+		if err != nil {
+			return fmt.Errorf("xobject %s: %w", name, err)
+		}
+		if sd == nil {
+			continue
+		}
+
+		subtype := sd.Subtype()
+		if subtype == nil {
+			continue
+		}
+		switch *subtype {
+		case "Image":
+			*refs = append(*refs, imageRef{page: page, objNr: objNr, name: prefix + name, sd: sd})
+		case "Form":
+			formRes, err := ctx.DereferenceDict(sd.Dict["Resources"])
+			if err != nil {
+				return fmt.Errorf("form %s resources: %w", name, err)
+			}
+			if err := walkXObjects(ctx, formRes, page, prefix+name+".", seen, refs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package imageHandling
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+)
+
+// Extractor keeps decode, encode and write worker pools alive between
+// extractions, so server and queue modes don't start goroutines and grow
+// fresh buffers for every request. Encode buffers come from a process-wide
+// pool that stays warm across calls. An Extractor is safe for concurrent
+// use; jobs of parallel extractions share its workers.
+type Extractor struct {
+	decode *workerPool
+	encode *workerPool
+	write  *workerPool
+
+	// Encoders each output format may occupy, by extension without the
+	// dot; formats without an entry may use all
+	encodeSlots map[string]limiter
+
+	closeOnce sync.Once
+}
+
+// NewExtractor starts the worker pools; call Close to stop them
+func NewExtractor(workers Workers) *Extractor {
+	workers = workers.withDefaults()
+	e := &Extractor{
+		decode:      newWorkerPool(workers.Decode),
+		encode:      newWorkerPool(workers.Encode),
+		write:       newWorkerPool(workers.Write),
+		encodeSlots: make(map[string]limiter),
+	}
+	for format, scale := range workers.EncodeScale {
+		if scale > 0 && scale < 1 {
+			n := max(1, int(math.Round(float64(workers.Encode)*scale)))
+			e.encodeSlots[strings.ToLower(format)] = make(limiter, n)
+		}
+	}
+	return e
+}
+
+// Close stops the workers after running extractions have finished
+// submitting work. The Extractor must not be used afterwards.
+func (e *Extractor) Close() {
+	e.closeOnce.Do(func() {
+		e.decode.close()
+		e.encode.close()
+		e.write.close()
+	})
+}
+
+// workerPool is a fixed set of goroutines running submitted jobs
+type workerPool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+}
+
+// newWorkerPool starts n workers
+func newWorkerPool(n int) *workerPool {
+	p := &workerPool{jobs: make(chan func())}
+	p.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// submit hands job to the next idle worker. It blocks while all workers
+// are busy, which is what gives the pipeline its backpressure.
+func (p *workerPool) submit(ctx context.Context, job func()) error {
+	select {
+	case p.jobs <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limiter caps how many jobs of one kind run at once on a shared pool;
+// a nil limiter doesn't limit
+type limiter chan struct{}
+
+// acquire takes a slot, waiting for one until ctx is done
+func (l limiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a slot taken by acquire
+func (l limiter) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// close stops the workers once their current jobs return
+func (p *workerPool) close() {
+	close(p.jobs)
+	p.wg.Wait()
+}
@@ -0,0 +1,305 @@
+package imageHandling
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// maxFieldDepth bounds the AcroForm field tree, which malformed PDFs can
+// make cyclic
+const maxFieldDepth = 32
+
+// FormField is a terminal AcroForm field and its value
+type FormField struct {
+	Name   string   `json:"name"`             // Fully qualified name, e.g. "applicant.address.city"
+	Type   string   `json:"type"`             // text, checkbox, radio, button, choice or signature
+	Value  string   `json:"value"`            // Text, chosen option, or button state ("Off" = unchecked)
+	Values []string `json:"values,omitempty"` // All chosen options of a multiple-choice list
+	Page   int      `json:"page,omitempty"`   // Page of the field's first widget (0 = unknown)
+
+	parts []string // Partial names from the root field down
+	name  bool     // Value is a PDF name (button state) rather than a string
+}
+
+// FormFields reads the AcroForm fields of filename with their values, in
+// document order. PDFs without a form give an empty list.
+func FormFields(ctx context.Context, filename string, ignorePerms bool) ([]FormField, error) {
+	pdf, closePDF, err := openPDF(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer closePDF()
+	if !ignorePerms && !allowsExtraction(pdf) {
+		return nil, ErrExtractionForbidden
+	}
+
+	root, err := pdf.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	form, err := pdf.DereferenceDict(root["AcroForm"])
+	if err != nil || form == nil {
+		return nil, err
+	}
+	kids, err := pdf.DereferenceArray(form["Fields"])
+	if err != nil {
+		return nil, fmt.Errorf("read form fields: %w", err)
+	}
+
+	w := fieldWalker{pdf: pdf, pages: widgetPages(pdf), seen: make(map[int]bool)}
+	for _, kid := range kids {
+		if err := w.walk(kid, nil, fieldAttrs{}, 0); err != nil {
+			return nil, fmt.Errorf("read form fields: %w", err)
+		}
+	}
+	return w.fields, nil
+}
+
+// fieldAttrs are the inheritable field attributes
+type fieldAttrs struct {
+	ft    string
+	flags int
+	v     types.Object
+}
+
+// fieldWalker collects the terminal fields of an AcroForm field tree
+type fieldWalker struct {
+	pdf    *model.Context
+	pages  map[int]int // Widget object number -> page
+	seen   map[int]bool
+	fields []FormField
+}
+
+// walk visits the field o below the partial names parts
+func (w *fieldWalker) walk(o types.Object, parts []string, inh fieldAttrs, depth int) error {
+	if depth > maxFieldDepth {
+		return fmt.Errorf("field tree nested deeper than %d levels", maxFieldDepth)
+	}
+	objNr := 0
+	if ref, ok := o.(types.IndirectRef); ok {
+		objNr = ref.ObjectNumber.Value()
+		if w.seen[objNr] {
+			return nil
+		}
+		w.seen[objNr] = true
+	}
+	d, err := w.pdf.DereferenceDict(o)
+	if err != nil || d == nil {
+		return err
+	}
+
+	if t, ok := d["T"]; ok {
+		name, err := w.pdf.DereferenceStringOrHexLiteral(t, model.V10, nil)
+		if err != nil {
+			return err
+		}
+		parts = append(parts[:len(parts):len(parts)], name)
+	}
+	if ft := d.NameEntry("FT"); ft != nil {
+		inh.ft = *ft
+	}
+	if ff := d.IntEntry("Ff"); ff != nil {
+		inh.flags = *ff
+	}
+	if v, ok := d["V"]; ok {
+		inh.v = v
+	}
+
+	// Kids with a T entry are fields; kids without are the widgets of a
+	// terminal field
+	kids, err := w.pdf.DereferenceArray(d["Kids"])
+	if err != nil {
+		return err
+	}
+	var widgets []int
+	if objNr > 0 {
+		widgets = append(widgets, objNr)
+	}
+	terminal := true
+	for _, kid := range kids {
+		kd, err := w.pdf.DereferenceDict(kid)
+		if err != nil || kd == nil {
+			continue
+		}
+		if _, ok := kd["T"]; !ok {
+			if ref, ok := kid.(types.IndirectRef); ok {
+				widgets = append(widgets, ref.ObjectNumber.Value())
+			}
+			continue
+		}
+		terminal = false
+		if err := w.walk(kid, parts, inh, depth+1); err != nil {
+			return err
+		}
+	}
+	if !terminal || len(parts) == 0 || inh.ft == "" {
+		return nil
+	}
+
+	f := FormField{Name: strings.Join(parts, "."), Type: fieldType(inh.ft, inh.flags), parts: parts}
+	for _, nr := range widgets {
+		if p, ok := w.pages[nr]; ok {
+			f.Page = p
+			break
+		}
+	}
+	if err := w.setValue(&f, inh.v); err != nil {
+		return fmt.Errorf("%s: %w", f.Name, err)
+	}
+	w.fields = append(w.fields, f)
+	return nil
+}
+
+// setValue decodes the value of a field
+func (w *fieldWalker) setValue(f *FormField, v types.Object) error {
+	o, err := w.pdf.Dereference(v)
+	if err != nil {
+		return err
+	}
+	switch o := o.(type) {
+	case nil:
+		if f.Type == "checkbox" || f.Type == "radio" {
+			f.Value, f.name = "Off", true
+		}
+	case types.Name:
+		f.Value, f.name = o.Value(), true
+	case types.StringLiteral, types.HexLiteral:
+		f.Value, err = w.pdf.DereferenceStringOrHexLiteral(o, model.V10, nil)
+	case types.Array:
+		for _, e := range o {
+			s, err := w.pdf.DereferenceStringOrHexLiteral(e, model.V10, nil)
+			if err != nil {
+				return err
+			}
+			f.Values = append(f.Values, s)
+		}
+		if len(f.Values) > 0 {
+			f.Value = f.Values[0]
+		}
+		if len(f.Values) < 2 {
+			f.Values = nil
+		}
+	}
+	return err
+}
+
+// fieldType names a field type given its FT entry and flags
+func fieldType(ft string, flags int) string {
+	const (
+		flagRadio      = 1 << 15
+		flagPushButton = 1 << 16
+	)
+	switch ft {
+	case "Tx":
+		return "text"
+	case "Ch":
+		return "choice"
+	case "Sig":
+		return "signature"
+	case "Btn":
+		switch {
+		case flags&flagPushButton != 0:
+			return "button"
+		case flags&flagRadio != 0:
+			return "radio"
+		}
+		return "checkbox"
+	}
+	return strings.ToLower(ft)
+}
+
+// widgetPages maps the object numbers of the annotations on each page to
+// the page
+func widgetPages(pdf *model.Context) map[int]int {
+	pages := make(map[int]int)
+	for page := 1; page <= pdf.PageCount; page++ {
+		d, _, _, err := pdf.PageDict(page, false)
+		if err != nil || d == nil {
+			continue
+		}
+		annots, err := pdf.DereferenceArray(d["Annots"])
+		if err != nil {
+			continue
+		}
+		for _, a := range annots {
+			if ref, ok := a.(types.IndirectRef); ok {
+				if _, ok := pages[ref.ObjectNumber.Value()]; !ok {
+					pages[ref.ObjectNumber.Value()] = page
+				}
+			}
+		}
+	}
+	return pages
+}
+
+// WriteFDF writes fields as an FDF file that PDF viewers can import into
+// the form of source. The field hierarchy of the PDF is kept.
+func WriteFDF(w io.Writer, fields []FormField, source string) error {
+	bw := bufio.NewWriter(w)
+	// The binary comment marks the file as binary for transfer tools
+	bw.WriteString("%FDF-1.2\n%\xe2\xe3\xcf\xd3\n1 0 obj\n<< /FDF << /Fields [")
+	writeFDFFields(bw, fields, 0)
+	bw.WriteString("\n]")
+	if source != "" {
+		fmt.Fprintf(bw, " /F %s", fdfString(source))
+	}
+	bw.WriteString(" >> >>\nendobj\ntrailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return bw.Flush()
+}
+
+// writeFDFFields writes the fields sharing the first depth partial names,
+// grouping those with a common next partial name under one parent
+func writeFDFFields(w *bufio.Writer, fields []FormField, depth int) {
+	indent := strings.Repeat("  ", depth+1)
+	for i := 0; i < len(fields); {
+		f := fields[i]
+		part := f.parts[depth]
+		if len(f.parts) == depth+1 {
+			fmt.Fprintf(w, "\n%s<< /T %s /V %s >>", indent, fdfString(part), fdfValue(f))
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(fields) && len(fields[j].parts) > depth+1 && fields[j].parts[depth] == part {
+			j++
+		}
+		fmt.Fprintf(w, "\n%s<< /T %s /Kids [", indent, fdfString(part))
+		writeFDFFields(w, fields[i:j], depth+1)
+		fmt.Fprintf(w, "\n%s] >>", indent)
+		i = j
+	}
+}
+
+// fdfValue encodes the value of f for the V entry
+func fdfValue(f FormField) string {
+	switch {
+	case f.name:
+		return "/" + types.EncodeName(f.Value)
+	case f.Values != nil:
+		vals := make([]string, len(f.Values))
+		for i, v := range f.Values {
+			vals[i] = fdfString(v)
+		}
+		return "[" + strings.Join(vals, " ") + "]"
+	}
+	return fdfString(f.Value)
+}
+
+// fdfString encodes s as a PDF string literal, in UTF-16 unless it is
+// plain ASCII
+func fdfString(s string) string {
+	for _, r := range s {
+		if r > 0x7e {
+			s = types.EncodeUTF16String(s)
+			break
+		}
+	}
+	esc, _ := types.Escape(s)
+	return "(" + *esc + ")"
+}
@@ -0,0 +1,126 @@
+//go:build heif
+
+package imageHandling
+
+/*
+#cgo pkg-config: libheif
+#include <stdlib.h>
+#include <string.h>
+#include <libheif/heif.h>
+
+// pixf_buffer collects the encoded file written by libheif
+typedef struct {
+	char*  data;
+	size_t len;
+	size_t cap;
+} pixf_buffer;
+
+static struct heif_error pixf_write(struct heif_context* ctx, const void* data, size_t size, void* userdata) {
+	pixf_buffer* b = userdata;
+	struct heif_error err = { heif_error_Ok, heif_suberror_Unspecified, "" };
+	if (b->len + size > b->cap) {
+		size_t cap = b->cap ? b->cap : 1 << 16;
+		while (cap < b->len + size) {
+			cap *= 2;
+		}
+		char* grown = realloc(b->data, cap);
+		if (grown == NULL) {
+			err.code = heif_error_Memory_allocation_error;
+			err.message = "out of memory";
+			return err;
+		}
+		b->data = grown;
+		b->cap = cap;
+	}
+	memcpy(b->data + b->len, data, size);
+	b->len += size;
+	return err;
+}
+
+static struct heif_error pixf_write_context(struct heif_context* ctx, pixf_buffer* b) {
+	struct heif_writer w;
+	w.writer_api_version = 1;
+	w.write = pixf_write;
+	return heif_context_write(ctx, &w, b);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"io"
+	"unsafe"
+)
+
+// heicQuality is the lossy quality of HEIC images, about what phones use
+const heicQuality = 90
+
+// HEIFEncoder writes HEIC (HEVC in HEIF) with libheif. It is only built
+// with -tags heif, as it needs libheif and an HEVC encoder such as x265.
+type HEIFEncoder struct{}
+
+func init() {
+	RegisterEncoder("heic", HEIFEncoder{})
+}
+
+func (HEIFEncoder) Encode(w io.Writer, img *image.RGBA) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	ctx := C.heif_context_alloc()
+	defer C.heif_context_free(ctx)
+
+	var enc *C.struct_heif_encoder
+	if err := heifError(C.heif_context_get_encoder_for_format(ctx, C.heif_compression_HEVC, &enc)); err != nil {
+		return err
+	}
+	defer C.heif_encoder_release(enc)
+	if err := heifError(C.heif_encoder_set_lossy_quality(enc, heicQuality)); err != nil {
+		return err
+	}
+
+	var himg *C.struct_heif_image
+	if err := heifError(C.heif_image_create(C.int(width), C.int(height), C.heif_colorspace_RGB, C.heif_chroma_interleaved_RGBA, &himg)); err != nil {
+		return err
+	}
+	defer C.heif_image_release(himg)
+	if err := heifError(C.heif_image_add_plane(himg, C.heif_channel_interleaved, C.int(width), C.int(height), 8)); err != nil {
+		return err
+	}
+	var stride C.int
+	plane := C.heif_image_get_plane(himg, C.heif_channel_interleaved, &stride)
+	dst := unsafe.Slice((*byte)(unsafe.Pointer(plane)), int(stride)*height)
+	for y := 0; y < height; y++ {
+		// image.RGBA is premultiplied, libheif expects straight alpha
+		src := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):][:4*width]
+		row := dst[y*int(stride):][:4*width]
+		for x := 0; x < len(row); x += 4 {
+			px := unpremultiply(src[x : x+4])
+			copy(row[x:x+4], px[:])
+		}
+	}
+
+	var handle *C.struct_heif_image_handle
+	if err := heifError(C.heif_context_encode_image(ctx, himg, enc, nil, &handle)); err != nil {
+		return err
+	}
+	C.heif_image_handle_release(handle)
+
+	var out C.pixf_buffer
+	defer C.free(unsafe.Pointer(out.data))
+	if err := heifError(C.pixf_write_context(ctx, &out)); err != nil {
+		return err
+	}
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(out.data), C.int(out.len)))
+	return err
+}
+func (HEIFEncoder) Extension() string { return ".heic" }
+
+// heifError returns err as a Go error, nil if it reports success
+func heifError(err C.struct_heif_error) error {
+	if err.code == C.heif_error_Ok {
+		return nil
+	}
+	return errors.New("heif: " + C.GoString(err.message))
+}
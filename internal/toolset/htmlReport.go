@@ -0,0 +1,90 @@
+package imageHandling
+
+import (
+	"fmt"
+	"html/template"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+// HTMLReportName is the gallery page written with Options.HTMLReport
+const HTMLReportName = "index.html"
+
+// thumbDirName holds the gallery thumbnails
+const thumbDirName = "thumbs"
+
+// thumbSize is the longer side of gallery thumbnails in pixels
+const thumbSize = 240
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Input}} - pixf</title>
+<style>
+body { font-family: sans-serif; margin: 2em; background: #fafafa; }
+.grid { display: flex; flex-wrap: wrap; gap: 1em; }
+.card { background: #fff; border: 1px solid #ddd; padding: .5em; width: 260px; }
+.card img { display: block; margin: 0 auto; max-width: 240px; max-height: 240px; }
+.card p { margin: .3em 0 0; font-size: .85em; color: #444; }
+</style>
+</head>
+<body>
+<h1>{{.Input}}</h1>
+<p>{{len .Images}} image(s) &middot; SHA-256 {{.InputHash}}</p>
+<div class="grid">
+{{range .Images}}<div class="card">
+<a href="{{.File}}"><img src="thumbs/{{.File}}.png" alt="{{.File}}" loading="lazy"></a>
+<p><a href="{{.File}}">{{.File}}</a></p>
+<p>Page {{.Page}} &middot; {{.Width}}&times;{{.Height}} &middot; {{.Bytes}} bytes</p>
+</div>
+{{end}}</div>
+</body>
+</html>
+`))
+
+// writeHTMLReport renders an index.html gallery with thumbnails into imgDir
+func writeHTMLReport(imgDir string, m *Manifest, images []LoadedImage) error {
+	thumbDir := filepath.Join(imgDir, thumbDirName)
+	if err := os.MkdirAll(thumbDir, 0755); err != nil {
+		return fmt.Errorf("create thumb dir: %w", err)
+	}
+
+	for i, entry := range m.Images {
+		thumb := filepath.Join(thumbDir, filepath.FromSlash(entry.File)+".png")
+		// Images grouped into folders get their thumbnails grouped alike
+		if err := os.MkdirAll(filepath.Dir(thumb), 0755); err != nil {
+			return fmt.Errorf("create thumb dir: %w", err)
+		}
+		if err := writeThumbnail(thumb, images[i]); err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(imgDir, HTMLReportName)
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if err := htmlReportTemplate.Execute(f, m); err != nil {
+		return fmt.Errorf("render %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// writeThumbnail saves a small PNG preview of img
+func writeThumbnail(path string, img LoadedImage) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if err := png.Encode(f, makeThumbnail(img.Img, thumbSize)); err != nil {
+		return fmt.Errorf("encode thumbnail: %w", err)
+	}
+	return f.Close()
+}
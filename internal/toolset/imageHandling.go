@@ -2,20 +2,25 @@ package imageHandling
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/draw"
 	"image/png"
 	"io"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
-	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/chai2010/webp"
-	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
 )
 
 // Buffer pool for encoding to reduce allocations
@@ -39,7 +44,7 @@ type ImageEncoder interface {
 type PNGEncoder struct{}
 
 func (PNGEncoder) Encode(w io.Writer, img *image.RGBA) error {
-	return png.Encoder{CompressionLevel: png.NoCompression}.Encode(w, img)
+	return (&png.Encoder{CompressionLevel: png.NoCompression}).Encode(w, img)
 }
 func (PNGEncoder) Extension() string { return ".png" }
 
@@ -50,28 +55,69 @@ func (WebPEncoder) Encode(w io.Writer, img *image.RGBA) error {
 }
 func (WebPEncoder) Extension() string { return ".webp" }
 
-// Encoder registry
-var encoderRegistry = map[string]ImageEncoder{
-	"png":  PNGEncoder{},
-	"webp": WebPEncoder{},
+// Encoder registry, guarded by encodersMu as RegisterEncoder may run
+// alongside extractions
+var (
+	encodersMu      sync.RWMutex
+	encoderRegistry = map[string]ImageEncoder{
+		"png":  PNGEncoder{},
+		"webp": WebPEncoder{},
+	}
+)
+
+// RegisterEncoder makes enc the encoder of format, adding an output format
+// or replacing a built-in one. It is safe to call while extractions run;
+// each keeps the encoder it started with.
+func RegisterEncoder(format string, enc ImageEncoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoderRegistry[strings.ToLower(format)] = enc
+}
+
+// encoders returns a copy of the registry, which callers may keep using
+// while formats are registered
+func encoders() map[string]ImageEncoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	return maps.Clone(encoderRegistry)
+}
+
+// Formats returns the output formats: original, then those with an
+// encoder in this build (heic needs -tags heif) or registered since
+func Formats() []string {
+	formats := slices.Sorted(maps.Keys(encoders()))
+	return append([]string{"original"}, formats...)
 }
 
 // GetEncoder returns encoder for given format
 func GetEncoder(format string) (ImageEncoder, error) {
 	format = strings.ToLower(format)
-	if enc, ok := encoderRegistry[format]; ok {
+	if enc, ok := encoders()[format]; ok {
 		return enc, nil
 	}
 	return nil, fmt.Errorf("unsupported format: %s", format)
 }
 
-// toRGBA converts any image to RGBA
+// toRGBA converts any image to RGBA using a pooled pixel buffer.
+// Common decoder outputs take direct loops; others go through draw.Draw.
 func toRGBA(img image.Image) *image.RGBA {
 	if rgba, ok := img.(*image.RGBA); ok {
 		return rgba
 	}
 	b := img.Bounds()
-	rgba := image.NewRGBA(b)
+	rgba := getRGBA(b)
+	switch src := img.(type) {
+	case *image.Gray:
+		grayToRGBA(rgba, src)
+		return rgba
+	case *image.NRGBA:
+		nrgbaToRGBA(rgba, src)
+		return rgba
+	case *image.YCbCr:
+		if ycbcrToRGBA(rgba, src) {
+			return rgba
+		}
+	}
 	draw.Draw(rgba, b, img, b.Min, draw.Src)
 	return rgba
 }
@@ -79,196 +125,745 @@ func toRGBA(img image.Image) *image.RGBA {
 // LoadedImage holds image data for processing
 type LoadedImage struct {
 	OrigName string      // Original filename for "original" format
-	Img      *image.RGBA // Decoded RGBA (for conversion)
-	RawData  []byte      // Original bytes (for "original" format)
+	Page     int         // Page the image was found on
+	ObjNr    int         // PDF object number of the image
+	Width    int         // Pixel width after orientation
+	Height   int         // Pixel height after orientation
+	Img      *image.RGBA // Decoded RGBA (nil unless pixels are needed)
+	Path     string      // Extracted file in the temp directory
+	Size     int64       // File size in bytes
+	Part     string      // Half of a split spread: PartLeft, PartRight or ""
+	Tile     *TileRect   // Position of a tile cut from a larger image (nil = whole)
+	Dir      string      // Output subfolder, slash-separated ("" = top level)
+	Label    string      // Caption found next to the image ("" = none)
+	Stem     string      // File name without extension ("" = numbered name)
+	Number   string      // Numbered name, used without a Stem
 	FileHash string
+
+	profile       *iccProfile // ICC profile of the source (nil = none)
+	colorHandling string      // What became of profile once written
+}
+
+// Options controls how images are extracted and written.
+// Fields that affect output are recorded in the manifest; runtime-only
+// fields are excluded from JSON so they don't invalidate earlier results.
+// Extractions only read their Options, so parallel ones may share the
+// same values, maps included.
+type Options struct {
+	Format        string  `json:"format"`                   // Output format: original, png, webp, heic
+	StripMetadata bool    `json:"strip_metadata"`           // Remove EXIF/XMP/ICC data from the images written
+	HTMLReport    bool    `json:"html_report"`              // Write an index.html gallery into the output directory
+	Report        string  `json:"report"`                   // Report format: csv, tsv, markdown ("" = none)
+	Analyze       bool    `json:"analyze"`                  // Record dominant colors and luminance histograms
+	EmbedPreviews int     `json:"embed_previews"`           // Embed previews this many pixels across in the manifest (0 = none)
+	MinDPI        int     `json:"min_dpi"`                  // Flag and warn about images drawn at a lower resolution (0 = off)
+	ColorManaged  bool    `json:"color_managed"`            // Fail if the ICC profile of an image can't be kept
+	DedupScope    string  `json:"dedup_scope"`              // Where duplicates are removed: document, page, off ("" = document)
+	SimilarDist   int     `json:"similar_dist"`             // Also merge images within this perceptual hash distance (0 = exact only)
+	DedupKeep     string  `json:"dedup_keep"`               // Which duplicate survives: first, largest-pixels, largest-bytes ("" = first)
+	Despeckle     bool    `json:"despeckle"`                // Median-filter grayscale and bilevel scans before encoding
+	AutoCrop      bool    `json:"autocrop"`                 // Trim uniform black or white scanner borders
+	CropTolerance int     `json:"crop_tolerance,omitempty"` // Channel distance from black/white still counted as border (0 = exact)
+	SplitSpread   bool    `json:"split_spread"`             // Split landscape double-page scans at the gutter
+	KeyColor      string  `json:"key_color"`                // Color made transparent in converted images, #RRGGBB ("" = none)
+	KeyTolerance  int     `json:"key_tolerance,omitempty"`  // Largest per-channel distance from KeyColor still keyed (0 = exact)
+	Invert        bool    `json:"invert"`                   // Turn negative scans into positives
+	Tone          Tone    `json:"tone"`                     // Brightness, contrast, gamma and auto levels
+	Stamp         Stamp   `json:"stamp"`                    // Text or image watermark on every converted image
+	Stitch        string  `json:"stitch"`                   // Also join converted images into one strip: vertical, horizontal ("" = off)
+	MultiTIFF     bool    `json:"multi_tiff"`               // Also write all images as pages of one TIFF
+	Tile          string  `json:"tile"`                     // Split larger images into tiles of this size, e.g. "1024x1024" ("" = off)
+	Pyramid       string  `json:"pyramid"`                  // Also write a tile pyramid of each image: dzi, iiif ("" = off)
+	IIIFBase      string  `json:"iiif_base"`                // URL the IIIF pyramids are served under ("" = relative ids)
+	UpscaleFactor int     `json:"upscale"`                  // Enlarge converted images by this factor (0 or 1 = off)
+	UpscaleCmd    string  `json:"upscale_cmd"`              // External upscaler command ("" = built-in resampling)
+	OptimizePNG   bool    `json:"optimize_png"`             // Shrink PNG output with palettes, filter choice and best compression
+	OptimizeCmd   string  `json:"optimize_cmd"`             // External PNG optimizer run after OptimizePNG ("" = none)
+	OutlineDirs   bool    `json:"outline_dirs"`             // Group images into folders named after outline sections
+	SafeNames     bool    `json:"safe_names"`               // Transliterate generated folder names to plain ASCII
+	CaptionNames  bool    `json:"caption_names"`            // Name images after the caption next to them
+	NumberOffset  int     `json:"number_offset"`            // Added to image numbers, which start at 1
+	PageNumbers   bool    `json:"page_numbers"`             // Number images per page: image_p12_001
+	Tags          bool    `json:"tags"`                     // Record source, page and input hash in extended attributes of each image
+	Objects       string  `json:"objects"`                  // Extract only these images, e.g. "15,3.Im3" ("" = all)
+	Attachments   int     `json:"attachments"`              // Also extract PDFs attached to PDFs this many levels down (0 = none)
+	Limits        Limits  `json:"limits"`                   // Per-image resource limits (zero = defaults)
+	Workers       Workers `json:"-"`                        // Per-stage worker counts (zero = defaults)
+	TempDir       string  `json:"-"`                        // Parent for temporary files ("" = OS default)
+	IgnorePerms   bool    `json:"-"`                        // Extract even if the PDF's permissions forbid it
+	Source        string  `json:"-"`                        // Original input recorded in the manifest (default: filename)
+	ZipPassword   string  `json:"-"`                        // Password of encrypted PDFs in archives ("" = none)
+
+	// Turns and mirrors converted images: all of them by Transform, unless
+	// their page has an entry in PageTransforms
+	Transform      Transform         `json:"transform"`
+	PageTransforms map[int]Transform `json:"page_transforms,omitempty"`
+
+	// Most bytes of images written per document; the images after those
+	// that fit are left out (0 = no limit)
+	MaxTotalOutput int64 `json:"max_total_output,omitempty"`
+
+	// Library rendering the image streams, one of Engines() ("" =
+	// DefaultEngine)
+	Engine string `json:"engine,omitempty"`
+
+	// Images smaller than this many bytes are embedded in the markdown
+	// report as data URIs instead of linked (0 = none)
+	InlineUnder int64 `json:"inline_under,omitempty"`
+
+	// Modes and owner of the output; not recorded
+	Perms Permissions `json:"-"`
+	// Images held by earlier runs, which are left out (nil = none)
+	DedupIndex *DedupIndex `json:"-"`
+	// Receives an event for every step of the pipeline (nil = none)
+	Events EventSink `json:"-"`
+	// Receives progress notices, one line each, e.g. images quarantined
+	// or left out (nil = none); must be safe for concurrent use
+	Notices io.Writer `json:"-"`
+
+	updated   map[int]bool // Only objects of an incremental update (nil = all)
+	memory    *memoryInput // PDF held in memory, not at the source path (nil = file)
+	target    string       // Image directory the output replaces, for DedupIndex
+	enclosing []string     // SHA-256 of the PDFs an embedded PDF is in, outermost first
 }
 
 // ExtractImagesFromFile extracts images from a PDF
 // For "original": saves native format with deduplication
 // For "png"/"webp": decodes, converts, and encodes with concurrency
 func ExtractImagesFromFile(filename string, imgDir string, format string) error {
-	if err := os.Mkdir(imgDir, 0755); err != nil && !os.IsExist(err) {
-		return err
+	return ExtractImages(filename, imgDir, Options{Format: format})
+}
+
+// ExtractImages extracts images from a PDF using the given options.
+// Output is written to a staging directory and renamed to imgDir on success,
+// so imgDir never contains a half-finished extraction.
+func ExtractImages(filename string, imgDir string, opts Options) error {
+	return ExtractImagesContext(context.Background(), filename, imgDir, opts)
+}
+
+// ExtractImagesContext is ExtractImages with cancellation; when ctx is
+// done, all workers stop. The staging directory is removed on cancellation
+// and kept with the partial results when ctx's deadline passed.
+// Worker pools live for this call only; use an Extractor to keep them.
+func ExtractImagesContext(ctx context.Context, filename string, imgDir string, opts Options) error {
+	e := NewExtractor(opts.Workers)
+	defer e.Close()
+	_, err := e.Extract(ctx, filename, imgDir, opts)
+	return err
+}
+
+// Extract extracts images from a PDF like ExtractImagesContext but runs on
+// the extractor's pools, and returns the summary; opts.Workers is ignored
+func (e *Extractor) Extract(ctx context.Context, filename string, imgDir string, opts Options) (*Result, error) {
+	doc, err := OpenDocument(ctx, filename, Credentials{})
+	if err != nil {
+		return nil, fmt.Errorf("extract images: %w", err)
+	}
+	defer doc.Close()
+	return e.ExtractDocument(ctx, doc, imgDir, opts)
+}
+
+// ExtractDocument extracts images from a document opened in memory, like
+// Extract. opts.Source defaults to the document's file.
+// The images of the PDFs embedded in a portfolio, or attached up to
+// opts.Attachments levels down, are extracted as well, each into a
+// folder named after it, as for archives. If some of them fail, the others
+// are still written and the result is returned along with an error joining
+// an *ArchiveError for each.
+func (e *Extractor) ExtractDocument(ctx context.Context, doc *Document, imgDir string, opts Options) (*Result, error) {
+	if !opts.IgnorePerms && !doc.AllowsExtraction() {
+		return nil, ErrExtractionForbidden
 	}
+	embeds := embedsPDFs(doc, opts)
+	if embeds && (opts.Stitch != "" || opts.MultiTIFF) {
+		return nil, errors.New("stitched strips and multi-page TIFFs are not supported for portfolios and attachments")
+	}
+	imgDir = LongPath(imgDir)
+	source := doc.filename
+	if opts.Source != "" {
+		source = LongPath(opts.Source)
+	}
+	ctx = withEvents(ctx, opts.Events, filepath.Base(source))
+	if doc.protected {
+		eventsOf(ctx).emit(Event{Type: EventDecrypted})
+	}
+
+	staging, err := beginStaging(imgDir)
+	if err != nil {
+		return nil, err
+	}
+
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	opts.target = imgDir
+	var res *Result
+	var failed []*ArchiveError
+	if embeds {
+		res, failed, err = e.extractEmbedded(ctx, doc, source, staging, opts)
+	} else {
+		res, err = e.extractToDir(ctx, doc.pdf, source, staging, opts)
+	}
+	if err != nil {
+		// Keep what was written before a timeout or interruption for
+		// inspection; the next run discards it
+		if interrupted(ctx) {
+			return nil, fmt.Errorf("partial results kept in %s: %w", staging, ErrInterrupted)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("partial results kept in %s: %w", staging, err)
+		}
+		os.RemoveAll(staging)
+		return nil, err
+	}
+
+	if opts.Tags {
+		if err := tagImages(staging, opts.Notices); err != nil {
+			os.RemoveAll(staging)
+			return nil, err
+		}
+	}
+	if err := opts.Perms.Apply(staging); err != nil {
+		os.RemoveAll(staging)
+		return nil, fmt.Errorf("set permissions: %w", err)
+	}
+	if err := commitStaging(staging, imgDir); err != nil {
+		return nil, err
+	}
+	if err := opts.DedupIndex.record(imgDir); err != nil {
+		return nil, fmt.Errorf("update dedup index: %w", err)
+	}
+	if len(failed) > 0 {
+		return res, embeddedError(failed)
+	}
+	return res, nil
+}
+
+// extractToDir runs the extraction pipeline on pdf writing into imgDir;
+// source is the input file recorded in the manifest, or only its name if
+// opts.memory holds the PDF. When interrupted, it leaves a manifest of the
+// images completed so far.
+func (e *Extractor) extractToDir(ctx context.Context, pdf *model.Context, source string, imgDir string, opts Options) (res *Result, err error) {
+	var sourceHash string
+	if opts.memory != nil {
+		sourceHash = opts.memory.hash
+	} else if sourceHash, err = HashFile(source); err != nil {
+		return nil, fmt.Errorf("hash input: %w", err)
+	}
+	manifest := &Manifest{
+		Input:     filepath.Base(source),
+		InputHash: sourceHash,
+		Options:   opts.Recorded(),
+		StampHash: stampHash(opts.Stamp),
+		CreatedAt: time.Now().UTC(),
+		Images:    []ManifestImage{},
+		source:    source,
+		memory:    opts.memory,
+		timer:     newStageTimer(),
+	}
+	events := eventsOf(ctx)
+	defer func() {
+		if err != nil {
+			events.emit(Event{Type: EventError, Error: err.Error()})
+		}
+	}()
 
 	// Extract to temp directory
-	tempDir, err := os.MkdirTemp("", "pdfimg")
+	tempDir, err := os.MkdirTemp(opts.TempDir, "pdfimg")
 	if err != nil {
-		return fmt.Errorf("create temp dir: %w", err)
+		return nil, fmt.Errorf("create temp dir: %w", err)
 	}
 	defer os.RemoveAll(tempDir)
 
-	if err := api.ExtractImagesFile(filename, tempDir, nil, nil); err != nil {
-		return fmt.Errorf("extract images: %w", err)
+	sel, err := parseObjects(opts.Objects)
+	if err != nil {
+		return nil, err
+	}
+	eng, err := engineFor(opts.Engine)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := eng.(pdfcpuEngine); !ok && opts.memory != nil && opts.memory.encrypted {
+		return nil, fmt.Errorf("the %s engine would write the decrypted PDF to disk; use the %s engine for encrypted archive members", opts.Engine, DefaultEngine)
+	}
+	files, err := extractRaw(ctx, pdf, tempDir, sel.within(opts.updated), opts.Limits.withDefaults(), eng)
+	if err != nil {
+		return nil, fmt.Errorf("extract images: %w", err)
+	}
+	if sel != nil && len(files) == 0 {
+		noticef(opts.Notices, "no image matches %s", opts.Objects)
 	}
 
-	// Load all images (single read per file)
-	images, err := loadImages(tempDir)
+	// Read and hash raw streams; nothing is decoded yet
+	skips := &skipLog{imgDir: imgDir, events: events, notices: opts.Notices}
+	images, err := loadImages(tempDir, files, skips)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var written func(LoadedImage) string // Output name, once images are saved
+	defer func() {
+		if err != nil && interrupted(ctx) {
+			manifest.Images, manifest.Skipped = writtenImages(imgDir, images, written), skips.skipped
+			writeInterrupted(imgDir, manifest)
+		}
+	}()
+	for _, img := range images {
+		events.emit(Event{Type: EventExtracted, Page: img.Page, ObjNr: img.ObjNr, Bytes: img.Size})
+	}
+	manifest.timer.done("read")
+	if opts.OutlineDirs {
+		// A broken outline costs the folders, not the images
+		sections, err := pageSections(pdf, opts.SafeNames)
+		if err != nil {
+			noticef(opts.Notices, "ignoring outline: %v", err)
+		} else {
+			assignSections(images, sections)
+		}
 	}
 
-	if len(images) == 0 {
-		return nil
+	// Drop what earlier runs wrote and exact duplicates first so only
+	// unique images cost decode time
+	images, manifest.Indexed = opts.DedupIndex.filter(images, opts.target)
+	if manifest.Indexed > 0 {
+		noticef(opts.Notices, "left out %d image(s) already in the dedup index", manifest.Indexed)
+	}
+	images, dups, err := deduplicate(images, opts)
+	if err != nil {
+		return nil, err
+	}
+	emitDuplicates(events, images, dups)
+	if images, dups, err = e.decodeImages(ctx, images, dups, skips, needsPixels(opts), opts.Limits.withDefaults()); err != nil {
+		return nil, err
+	}
+	exact := len(dups)
+	if images, dups, err = mergeSimilar(images, dups, opts); err != nil {
+		return nil, err
 	}
+	emitDuplicates(events, images, dups[exact:])
+	manifest.Skipped = skips.skipped
+	manifest.timer.done("decode")
 
-	// Deduplicate
-	images = deduplicate(images)
+	// Fail now rather than with a half-written directory
+	if err := checkSpace(filepath.Dir(imgDir), estimateOutput(images, opts)); err != nil {
+		return nil, err
+	}
 
-	// Process based on format
-	format = strings.ToLower(format)
-	if format == "original" || format == "" {
-		return saveOriginal(images, imgDir)
+	attachProfiles(pdf, images)
+
+	if len(images) == 0 {
+		recordLayout(pdf, manifest, images, dups)
+		return finishOutput(ctx, imgDir, manifest, images, dups, opts)
 	}
 
-	encoder, err := GetEncoder(format)
+	// Process based on format
+	var names, pyramids []string
+	format := strings.ToLower(opts.Format)
+	original := format == "original" || format == ""
+	if original && editsPixels(opts) {
+		return nil, errors.New("editing images needs a converted format (png or webp)")
+	}
+	if original && opts.Pyramid != "" {
+		return nil, errors.New("tile pyramids need a converted format (png or webp)")
+	}
+	if err := makeImageDirs(imgDir, images); err != nil {
+		return nil, err
+	}
+	// Turned upright first, so the edits below see the page as it is read
+	if opts.transformsPixels() {
+		if err := e.transformImages(ctx, images, opts); err != nil {
+			return nil, err
+		}
+	}
+	// Clean up noise before it is enlarged
+	if opts.Despeckle {
+		if err := e.despeckleImages(ctx, images); err != nil {
+			return nil, err
+		}
+	}
+	if opts.AutoCrop {
+		if err := e.autocropImages(ctx, images, opts.CropTolerance); err != nil {
+			return nil, err
+		}
+	}
+	if opts.SplitSpread {
+		if images, dups, err = e.splitSpreads(ctx, images, dups, opts.Notices); err != nil {
+			return nil, err
+		}
+	}
+	// Named after splitting so both halves of a spread get a name
+	if opts.CaptionNames {
+		assignCaptions(images, findCaptions(pdf, images), opts.SafeNames)
+	}
+	if opts.UpscaleFactor > 1 {
+		if err := e.upscaleImages(ctx, images, newUpscaler(opts), opts.UpscaleFactor, opts.Limits.withDefaults(), opts.Notices); err != nil {
+			return nil, err
+		}
+	}
+	assignNumbers(images, opts, pdf.PageCount)
+	var tiled []TiledImage
+	if opts.Tile != "" {
+		if opts.Stitch != "" || opts.MultiTIFF || opts.Pyramid != "" {
+			return nil, errors.New("tiles can't be stitched, written to a multi-page TIFF or made into pyramids")
+		}
+		w, h, err := ParseTileSize(opts.Tile)
+		if err != nil {
+			return nil, err
+		}
+		// Tiles are named after their image, so this follows numbering
+		images, dups, tiled = tileImages(images, dups, w, h, opts.Notices)
+	}
+	var truncated *Truncation
+	if images, dups, truncated = applyBudget(images, dups, opts); truncated != nil {
+		manifest.Truncated = append(manifest.Truncated, *truncated)
+	}
+	manifest.timer.done("edit")
+	if original {
+		written = func(img LoadedImage) string { return imageName(img, originalExt(img)) }
+		names, err = saveOriginal(ctx, images, imgDir, opts.StripMetadata, events)
+	} else {
+		// Encoders write pixels only; converted output carries no metadata
+		// but the ICC profile, which saveConverted embeds unless stripping
+		encoder, encErr := GetEncoder(format)
+		if encErr != nil {
+			return nil, encErr
+		}
+		var recompress *pngRecompressor
+		if opts.OptimizePNG {
+			if format != "png" {
+				return nil, errors.New("optimizing PNGs needs the png format")
+			}
+			encoder = OptimizedPNGEncoder{}
+			if opts.OptimizeCmd != "" {
+				recompress = &pngRecompressor{command: opts.OptimizeCmd, tempDir: opts.TempDir}
+			}
+		}
+		key, keyErr := newColorKey(opts)
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		stamp, stampErr := newStamper(opts.Stamp)
+		if stampErr != nil {
+			return nil, stampErr
+		}
+		written = func(img LoadedImage) string { return imageName(img, encoder.Extension()) }
+		names, err = e.saveConverted(ctx, images, imgDir, encoder, encodeEdits{
+			key:    key,
+			invert: opts.Invert,
+			tone:   opts.Tone,
+			stamp:  stamp,
+		}, recompress, opts.StripMetadata)
+		if err == nil && opts.Stitch != "" {
+			manifest.Stitched, err = writeStitched(imgDir, images, opts.Stitch, encoder, opts.Limits.withDefaults())
+		}
+		if err == nil && len(tiled) > 0 {
+			manifest.Tiles, err = writeTileIndex(imgDir, tiled, encoder.Extension())
+		}
+		if err == nil && opts.Pyramid != "" {
+			pyramids, err = e.writePyramids(ctx, imgDir, images, encoder, opts)
+		}
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if opts.MultiTIFF {
+		if manifest.TIFF, err = writeMultiPageTIFF(imgDir, images); err != nil {
+			return nil, err
+		}
 	}
 
-	return saveConverted(images, imgDir, encoder)
+	manifest.timer.done("encode")
+
+	if manifest.Images, err = buildManifest(imgDir, images, names); err != nil {
+		return nil, err
+	}
+	for i := range pyramids {
+		manifest.Images[i].Pyramid = pyramids[i]
+	}
+	recordLayout(pdf, manifest, images, dups)
+	return finishOutput(ctx, imgDir, manifest, images, dups, opts)
 }
 
-// loadImages reads and decodes all image files
-func loadImages(dir string) ([]LoadedImage, error) {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		return nil, fmt.Errorf("read dir: %w", err)
+// finishOutput writes the manifest and optional reports for the saved
+// images and returns the summary of the extraction
+func finishOutput(ctx context.Context, imgDir string, manifest *Manifest, images []LoadedImage, dups []duplicate, opts Options) (*Result, error) {
+	defer releasePixels(images, dups)
+
+	for _, d := range dups {
+		img := &manifest.Images[d.Of]
+		img.Duplicates = append(img.Duplicates, DuplicateRef{Page: d.Image.Page, ObjNr: d.Image.ObjNr, Similar: d.Similar})
 	}
 
+	if opts.MinDPI > 0 {
+		flagLowDPI(manifest, opts.MinDPI, opts.Notices)
+	}
+	if opts.ColorManaged {
+		if err := checkColorManaged(manifest); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Analyze {
+		for i := range manifest.Images {
+			manifest.Images[i].Analysis = analyzeImage(images[i].Img)
+		}
+	}
+	if opts.EmbedPreviews > 0 {
+		for i := range manifest.Images {
+			manifest.Images[i].Preview = embedPreview(images[i].Img, opts.EmbedPreviews)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts.HTMLReport {
+		if err := writeHTMLReport(imgDir, manifest, images); err != nil {
+			return nil, err
+		}
+	}
+	switch report := strings.ToLower(opts.Report); report {
+	case "":
+	case MarkdownReport:
+		if err := writeMarkdownReport(imgDir, manifest, opts.InlineUnder); err != nil {
+			return nil, err
+		}
+	default:
+		if err := writeCSVReport(imgDir, report, manifest, dups); err != nil {
+			return nil, err
+		}
+	}
+
+	// Without a manifest a timed-out run is never mistaken for up to date
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// pixf only reads its input; prove it before recording the result
+	if err := manifest.verifyInput(); err != nil {
+		return nil, err
+	}
+	manifest.timer.done("finish")
+	manifest.Stages = manifest.timer.times
+	if err := writeManifest(imgDir, manifest); err != nil {
+		return nil, err
+	}
+	return manifest.result(len(dups)), nil
+}
+
+// releasePixels returns decoded pixel buffers to the pool
+func releasePixels(images []LoadedImage, dups []duplicate) {
+	for i := range images {
+		putRGBA(images[i].Img)
+		images[i].Img = nil
+	}
+	for i := range dups {
+		putRGBA(dups[i].Image.Img)
+		dups[i].Image.Img = nil
+	}
+}
+
+// errUnknownImageType is the reason an extracted stream that isn't of any
+// image type sniffImage knows is quarantined
+var errUnknownImageType = errors.New("not a known image type")
+
+// loadImages collects the extracted image files in dir, told apart from
+// other streams by their first bytes, so names without or with the wrong
+// extension get the right one; their hashes were taken while extracting.
+// Streams pdfcpu could not render, or rendered as no known image type, are
+// skipped into the quarantine folder.
+func loadImages(dir string, files []extractedFile, skips *skipLog) ([]LoadedImage, error) {
 	var images []LoadedImage
+	quarantined := 0
+
+	// Files shared by several references are read and hashed once
+	loaded := make(map[string]int)
+	failed := make(map[string]bool)
+
+	exts := make(map[string]string)
+
 	for _, f := range files {
-		if !isImageFile(f.Name()) {
+		path := filepath.Join(dir, f.Name)
+		ext, sniffed := exts[f.Name]
+		if f.Err == nil && !sniffed {
+			ext = imageExt(path)
+			exts[f.Name] = ext
+		}
+		if f.Err == nil && ext == "" {
+			f.Err = errUnknownImageType
+		}
+		if idx, ok := loaded[f.Name]; ok {
+			img := images[idx]
+			img.Page, img.ObjNr = f.Page, f.ObjNr
+			images = append(images, img)
 			continue
 		}
-
-		path := filepath.Join(dir, f.Name())
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return nil, fmt.Errorf("read %s: %w", f.Name(), err)
+		if failed[f.Name] {
+			continue
 		}
 
-		// Decode and convert to RGBA
-		img, _, err := image.Decode(bytes.NewReader(data))
-		if err != nil {
-			continue // Skip undecodable files
+		if f.Err != nil {
+			if err := skips.skip(f.Name, path, f.Page, f.ObjNr, "extract", f.Err); err != nil {
+				return nil, err
+			}
+			failed[f.Name] = true
+			quarantined++
+			continue
 		}
 
 		images = append(images, LoadedImage{
-			OrigName: f.Name(),
-			Img:      toRGBA(img),
-			RawData:  data,
-			FileHash: hashBytes(data),
+			OrigName: withImageExt(f.Name, ext),
+			Page:     f.Page,
+			ObjNr:    f.ObjNr,
+			Path:     path,
+			Size:     f.Size,
+			FileHash: f.Hash,
 		})
+		loaded[f.Name] = len(images) - 1
+	}
+
+	if quarantined > 0 {
+		noticef(skips.notices, "quarantined %d undecodable image(s) in %s", quarantined, QuarantineDirName)
 	}
 	return images, nil
 }
 
-// deduplicate removes duplicate images by hash
-func deduplicate(images []LoadedImage) []LoadedImage {
-	seen := make(map[string]bool)
-	var unique []LoadedImage
+// Numbers are padded to at least these many digits, so names keep the
+// same length in small runs
+const (
+	minNumberDigits     = 4
+	minPageNumberDigits = 3
+)
 
-	for _, img := range images {
-		if !seen[img.FileHash] {
-			seen[img.FileHash] = true
-			unique = append(unique, img)
+// numberName returns the numbered name of image n, padded to width digits
+func numberName(n, width int) string {
+	return fmt.Sprintf("image_%0*d", width, n)
+}
+
+// pageNumberName returns the numbered name of image n of page, both
+// padded to their widths
+func pageNumberName(page, pageWidth, n, width int) string {
+	return fmt.Sprintf("image_p%0*d_%0*d", pageWidth, page, width, n)
+}
+
+// digits is the number of decimal digits of n
+func digits(n int) int {
+	return len(strconv.Itoa(n))
+}
+
+// assignNumbers gives images their numbered names in order, from 1 plus
+// opts.NumberOffset: image_0001 onwards, or with opts.PageNumbers a count
+// per page, image_p12_001. Numbers are padded to the width of the largest,
+// so names sort in order however many images there are; page numbers are
+// padded to the width of pageCount.
+func assignNumbers(images []LoadedImage, opts Options, pageCount int) {
+	first := 1 + opts.NumberOffset
+	if !opts.PageNumbers {
+		width := max(minNumberDigits, digits(first+len(images)-1))
+		for i := range images {
+			images[i].Number = numberName(first+i, width)
 		}
+		return
 	}
 
-	if len(unique) < len(images) {
-		fmt.Printf("skipped %d duplicate(s)\n", len(images)-len(unique))
+	counts := make(map[int]int)
+	most := 0
+	for _, img := range images {
+		counts[img.Page]++
+		most = max(most, counts[img.Page])
+	}
+	width := max(minPageNumberDigits, digits(first+most-1))
+	pageWidth := digits(max(pageCount, 1))
+	next := make(map[int]int)
+	for i := range images {
+		page := images[i].Page
+		images[i].Number = pageNumberName(page, pageWidth, first+next[page], width)
+		next[page]++
 	}
-	return unique
 }
 
-// saveOriginal copies raw files preserving original format
-func saveOriginal(images []LoadedImage, imgDir string) error {
-	for i, img := range images {
-		ext := strings.ToLower(filepath.Ext(img.OrigName))
-		if ext == "" {
-			ext = ".png"
+// imageName returns the slash-separated path of img within the output
+// directory
+func imageName(img LoadedImage, ext string) string {
+	if img.Stem != "" {
+		return path.Join(img.Dir, img.Stem+ext)
+	}
+	return path.Join(img.Dir, img.Number+ext)
+}
+
+// makeImageDirs creates the output subfolders of images
+func makeImageDirs(imgDir string, images []LoadedImage) error {
+	made := make(map[string]bool)
+	for _, img := range images {
+		if img.Dir == "" || made[img.Dir] {
+			continue
 		}
-		path := filepath.Join(imgDir, fmt.Sprintf("image_%04d%s", i+1, ext))
-		if err := os.WriteFile(path, img.RawData, 0644); err != nil {
-			return fmt.Errorf("write %s: %w", path, err)
+		if err := os.MkdirAll(filepath.Join(imgDir, filepath.FromSlash(img.Dir)), 0755); err != nil {
+			return fmt.Errorf("create folder %s: %w", img.Dir, err)
 		}
+		made[img.Dir] = true
 	}
 	return nil
 }
 
-// saveConverted encodes images concurrently using all available CPUs
-func saveConverted(images []LoadedImage, imgDir string, encoder ImageEncoder) error {
-	numWorkers := runtime.NumCPU()
-
-	type task struct {
-		index int
-		img   *image.RGBA
-	}
-
-	tasks := make(chan task, len(images))
-	results := make(chan error, len(images))
-
-	var wg sync.WaitGroup
-
-	// Start workers
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for t := range tasks {
-				results <- encodeImage(t.img, encoder, imgDir, t.index)
+// saveOriginal copies raw files preserving original format. Files are
+// streamed; only metadata stripping needs a whole image in memory.
+func saveOriginal(ctx context.Context, images []LoadedImage, imgDir string, strip bool, events *eventLog) ([]string, error) {
+	names := make([]string, len(images))
+	for i, img := range images {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		names[i] = imageName(img, originalExt(img))
+		path := filepath.Join(imgDir, filepath.FromSlash(names[i]))
+
+		// A profile of the PDF's color space is added to the file
+		embed := img.profile != nil && img.profile.source == profileFromPDF && !strip
+		if !strip && !embed {
+			if err := copyFile(path, img.Path); err != nil {
+				return nil, fmt.Errorf("write %s: %w", path, err)
 			}
-		}()
-	}
-
-	// Dispatch tasks
-	go func() {
-		for i, img := range images {
-			tasks <- task{index: i, img: img.Img}
+			images[i].colorHandling = img.profile.handling(true)
+			events.emit(Event{Type: EventWritten, Page: img.Page, ObjNr: img.ObjNr, File: names[i], Bytes: img.Size})
+			continue
 		}
-		close(tasks)
-	}()
-
-	// Wait and collect first error
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	var firstErr error
-	for err := range results {
-		if err != nil && firstErr == nil {
-			firstErr = err
+		data, err := os.ReadFile(img.Path)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", img.OrigName, err)
+		}
+		embedded := false
+		if strip {
+			data = stripMetadata(data, img.OrigName)
+		} else {
+			data, embedded = embedProfile(data, originalExt(img), img.profile)
 		}
+		images[i].colorHandling = img.profile.handling(embedded)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return nil, fmt.Errorf("write %s: %w", path, err)
+		}
+		events.emit(Event{Type: EventWritten, Page: img.Page, ObjNr: img.ObjNr, File: names[i], Bytes: int64(len(data))})
 	}
-	return firstErr
+	return names, nil
 }
 
-// encodeImage encodes a single image to disk
-func encodeImage(img *image.RGBA, encoder ImageEncoder, imgDir string, index int) error {
-	buf := getBuffer()
-	defer putBuffer(buf)
-
-	if err := encoder.Encode(buf, img); err != nil {
-		return fmt.Errorf("encode: %w", err)
+// originalExt is the extension img is saved with in its original format
+func originalExt(img LoadedImage) string {
+	if ext := strings.ToLower(filepath.Ext(img.OrigName)); ext != "" {
+		return ext
 	}
-
-	outPath := filepath.Join(imgDir, fmt.Sprintf("image_%04d%s", index+1, encoder.Extension()))
-	return os.WriteFile(outPath, buf.Bytes(), 0644)
+	return ".png"
 }
 
-// isImageFile checks if filename has image extension
-func isImageFile(name string) bool {
-	ext := strings.ToLower(filepath.Ext(name))
-	return ext == ".png" || ext == ".jpg" || ext == ".jpeg" ||
-		ext == ".gif" || ext == ".bmp" || ext == ".tiff" || ext == ".webp"
-}
+// copyFile streams src into a new file dst
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
 
-// hashBytes computes SHA-256 of data
-func hashBytes(data []byte) string {
-	h := sha256.New()
-	h.Write(data)
-	return fmt.Sprintf("%x", h.Sum(nil))
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
 }
@@ -0,0 +1,355 @@
+package imageHandling
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// ErrNotAnUpdate is returned when an output directory doesn't hold the
+// images of an earlier revision of the input, so only a full extraction
+// can bring it up to date
+var ErrNotAnUpdate = errors.New("input is not an incremental update of the extracted revision")
+
+// numberedName and pageNumberedName match the numbered file names given to
+// images without a caption
+var (
+	numberedName     = regexp.MustCompile(`^image_(\d+)$`)
+	pageNumberedName = regexp.MustCompile(`^image_p(\d+)_(\d+)$`)
+)
+
+// ExtractUpdate brings imgDir, which holds the images of an earlier
+// revision of the document, up to date with the document by extracting
+// only the images that incremental updates since that revision added or
+// changed. Images the earlier revision already had are left as they are;
+// new ones are numbered on from the existing ones. The earlier revision is
+// found by the input hash in imgDir's manifest, so opts must be the
+// options it was extracted with. Output summarizing all images (reports,
+// stitched strips, multi-page TIFFs) needs a full extraction. The result
+// counts the images added; errors wrapping ErrNotAnUpdate leave imgDir
+// untouched.
+func (e *Extractor) ExtractUpdate(ctx context.Context, doc *Document, imgDir string, opts Options) (*Result, error) {
+	if !opts.IgnorePerms && !doc.AllowsExtraction() {
+		return nil, ErrExtractionForbidden
+	}
+	if opts.HTMLReport || opts.Report != "" || opts.Stitch != "" || opts.MultiTIFF {
+		return nil, errors.New("reports, stitched strips and multi-page TIFFs cover all images and need a full extraction")
+	}
+	if embedsPDFs(doc, opts) {
+		return nil, fmt.Errorf("%w: portfolios and attachments are always extracted in full", ErrNotAnUpdate)
+	}
+	imgDir = LongPath(imgDir)
+	source := doc.filename
+	if opts.Source != "" {
+		source = LongPath(opts.Source)
+	}
+	ctx = withEvents(ctx, opts.Events, filepath.Base(source))
+	if doc.protected {
+		eventsOf(ctx).emit(Event{Type: EventDecrypted})
+	}
+
+	prev, err := ReadManifest(imgDir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("%w: no earlier extraction in %s", ErrNotAnUpdate, imgDir)
+	case err != nil:
+		return nil, fmt.Errorf("%w: %v", ErrNotAnUpdate, err)
+	}
+	if !sameOptions(prev.Options, opts) {
+		return nil, fmt.Errorf("%w: the options differ from the earlier extraction", ErrNotAnUpdate)
+	}
+	base, ok, err := revisionLength(doc.filename, prev.InputHash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: no revision of the input matches %s", ErrNotAnUpdate, ManifestName)
+	}
+
+	// Extract the new images on their own, then add them to a copy of the
+	// existing output
+	tmp, err := os.MkdirTemp(opts.TempDir, "pdfupdate")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	opts.updated = updatedObjects(doc.pdf, base)
+	opts.target = imgDir
+	res, err := e.extractToDir(ctx, doc.pdf, source, tmp, opts)
+	if err != nil {
+		return nil, err
+	}
+	update, err := ReadManifest(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	staging, err := beginStaging(imgDir)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := mergeUpdate(staging, imgDir, tmp, prev, update)
+	if err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	if opts.Tags {
+		if err := tagImages(staging, opts.Notices); err != nil {
+			os.RemoveAll(staging)
+			return nil, err
+		}
+	}
+	if err := opts.Perms.Apply(staging); err != nil {
+		os.RemoveAll(staging)
+		return nil, fmt.Errorf("set permissions: %w", err)
+	}
+	if err := commitStaging(staging, imgDir); err != nil {
+		return nil, err
+	}
+	if err := opts.DedupIndex.record(imgDir); err != nil {
+		return nil, fmt.Errorf("update dedup index: %w", err)
+	}
+
+	// Images the earlier revision already had count as duplicates
+	added := merged.Images[len(prev.Images):]
+	res.Duplicates += res.Images - len(added)
+	res.Images, res.BytesOut = len(added), 0
+	for _, img := range added {
+		res.BytesOut += img.Bytes
+	}
+	return res, nil
+}
+
+// mergeUpdate writes the images of prev in imgDir and the new images of
+// update in updateDir to staging, and returns the manifest describing
+// both. Images of the update already in prev count as duplicates, as
+// they would in a full extraction.
+func mergeUpdate(staging, imgDir, updateDir string, prev, update *Manifest) (*Manifest, error) {
+	if err := copyTree(staging, imgDir); err != nil {
+		return nil, fmt.Errorf("copy %s: %w", imgDir, err)
+	}
+
+	// New images are numbered on from the last number, per page if the
+	// images are numbered per page
+	next, width := 1+prev.Options.NumberOffset, minNumberDigits
+	nextOnPage, pageWidth := make(map[int]int), minPageNumberDigits
+	known := make(map[string]bool)
+	for _, img := range prev.Images {
+		stem := strings.TrimSuffix(path.Base(img.File), path.Ext(img.File))
+		if m := numberedName.FindStringSubmatch(stem); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			next, width = max(next, n+1), max(width, len(m[1]))
+		}
+		if m := pageNumberedName.FindStringSubmatch(stem); m != nil {
+			page, _ := strconv.Atoi(m[1])
+			n, _ := strconv.Atoi(m[2])
+			nextOnPage[page], pageWidth = max(nextOnPage[page], n+1), max(pageWidth, len(m[2]))
+		}
+		known[dedupKey(img, prev.Options.DedupScope)] = true
+	}
+
+	merged := *prev
+	merged.Images = append([]ManifestImage(nil), prev.Images...)
+	merged.Input, merged.InputHash = update.Input, update.InputHash
+	merged.StampHash = update.StampHash
+	merged.InputVerified, merged.CreatedAt = update.InputVerified, update.CreatedAt
+	merged.Stages = update.Stages
+	merged.Pages = update.Pages
+	for _, img := range update.Images {
+		if prev.Options.DedupScope != "off" && known[dedupKey(img, prev.Options.DedupScope)] {
+			continue
+		}
+		name := img.File
+		stem := strings.TrimSuffix(path.Base(name), path.Ext(name))
+		if numberedName.MatchString(stem) {
+			name = path.Join(path.Dir(name), numberName(next, max(width, digits(next)))+path.Ext(name))
+			next++
+		}
+		if m := pageNumberedName.FindStringSubmatch(stem); m != nil {
+			page, _ := strconv.Atoi(m[1])
+			n := max(nextOnPage[page], 1+prev.Options.NumberOffset)
+			name = path.Join(path.Dir(name), pageNumberName(page, len(m[1]), n, max(pageWidth, digits(n)))+path.Ext(name))
+			nextOnPage[page] = n + 1
+		}
+		name = freeName(staging, name)
+		target := filepath.Join(staging, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		if err := copyFile(target, filepath.Join(updateDir, filepath.FromSlash(img.File))); err != nil {
+			return nil, fmt.Errorf("write %s: %w", name, err)
+		}
+		img.File = name
+		merged.Images = append(merged.Images, img)
+	}
+
+	// Undecodable images of the update join the quarantine
+	quarantined, err := os.ReadDir(filepath.Join(updateDir, QuarantineDirName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	renamed := make(map[string]string)
+	for _, q := range quarantined {
+		if q.IsDir() || strings.HasSuffix(q.Name(), ".reason.txt") {
+			continue
+		}
+		name := freeName(staging, path.Join(QuarantineDirName, q.Name()))
+		renamed[path.Join(QuarantineDirName, q.Name())] = name
+		src := filepath.Join(updateDir, QuarantineDirName, q.Name())
+		if err := os.MkdirAll(filepath.Join(staging, QuarantineDirName), 0755); err != nil {
+			return nil, err
+		}
+		for _, suffix := range []string{"", ".reason.txt"} {
+			if err := copyFile(filepath.Join(staging, filepath.FromSlash(name))+suffix, src+suffix); err != nil {
+				return nil, fmt.Errorf("write %s: %w", name+suffix, err)
+			}
+		}
+	}
+
+	merged.Skipped = append([]SkippedImage(nil), prev.Skipped...)
+	for _, sk := range update.Skipped {
+		if name, ok := renamed[sk.File]; ok {
+			sk.File = name
+		}
+		merged.Skipped = append(merged.Skipped, sk)
+	}
+	merged.Truncated = append(append([]Truncation(nil), prev.Truncated...), update.Truncated...)
+
+	if err := writeManifest(staging, &merged); err != nil {
+		return nil, err
+	}
+	return &merged, nil
+}
+
+// dedupKey identifies an image for duplicate detection within scope
+func dedupKey(img ManifestImage, scope string) string {
+	if scope == "page" {
+		return fmt.Sprintf("%d/%s", img.Page, img.SHA256)
+	}
+	return img.SHA256
+}
+
+// freeName returns name, or name with _2, _3, ... added to its stem if a
+// file of that name exists in dir
+func freeName(dir, name string) string {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); errors.Is(err, os.ErrNotExist) {
+			return name
+		}
+		name = fmt.Sprintf("%s_%d%s", stem, n, ext)
+	}
+}
+
+// updatedObjects are the objects of pdf defined at or after offset base,
+// that is in the incremental updates appended to the first base bytes
+func updatedObjects(pdf *model.Context, base int64) map[int]bool {
+	updated := make(map[int]bool)
+	for nr, entry := range pdf.Table {
+		if entry == nil || entry.Free {
+			continue
+		}
+		offset := entry.Offset
+		if entry.Compressed {
+			// Objects in an object stream are as new as the stream
+			offset = nil
+			if entry.ObjectStream != nil {
+				if stream, ok := pdf.Table[*entry.ObjectStream]; ok && stream != nil {
+					offset = stream.Offset
+				}
+			}
+		}
+		if offset != nil && *offset >= base {
+			updated[nr] = true
+		}
+	}
+	return updated
+}
+
+// revisionLength finds the revision of filename whose SHA-256 is hash.
+// Incremental updates append to a PDF, so every revision is a prefix of
+// the file ending after an %%EOF marker. ok is false if none matches.
+func revisionLength(filename, hash string) (n int64, ok bool, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	ends, err := revisionEnds(f)
+	if err != nil {
+		return 0, false, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+	h := sha256.New()
+	var pos int64
+	for _, end := range ends {
+		if _, err := io.CopyN(h, f, end-pos); err != nil {
+			return 0, false, fmt.Errorf("hash %s: %w", filename, err)
+		}
+		pos = end
+		if fmt.Sprintf("%x", h.Sum(nil)) == hash {
+			return end, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// revisionEnds lists the offsets at which a revision of the PDF read from
+// r could end, in ascending order: after each %%EOF marker, and after its
+// end of line if there is one, since writers differ in whether they count
+// it as part of the revision
+func revisionEnds(r io.Reader) ([]int64, error) {
+	const marker = "%%EOF"
+	br := bufio.NewReaderSize(r, 64<<10)
+	var ends []int64
+	var pos int64
+	matched := 0
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return ends, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		pos++
+		switch {
+		case b == marker[matched]:
+			matched++
+		case b != '%':
+			matched = 0
+		case matched != 2:
+			// A third % in a row still leaves "%%" matched
+			matched = 1
+		}
+		if matched < len(marker) {
+			continue
+		}
+		matched = 0
+		ends = append(ends, pos)
+		eol, _ := br.Peek(2)
+		switch {
+		case len(eol) == 2 && eol[0] == '\r' && eol[1] == '\n':
+			ends = append(ends, pos+1, pos+2)
+		case len(eol) > 0 && (eol[0] == '\r' || eol[0] == '\n'):
+			ends = append(ends, pos+1)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package imageHandling
+
+import (
+	"io"
+	"math"
+	"sort"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// PageBox is the visible area of a page, in points, and its rotation
+type PageBox struct {
+	Page   int        `json:"page"`
+	Box    [4]float64 `json:"box"` // Crop box, else media box: [llx lly urx ury]
+	Rotate int        `json:"rotate,omitempty"`
+	DPI    int        `json:"dpi,omitempty"` // Lowest effective resolution of the images drawn on it
+}
+
+// ImagePlacement is one place an image is drawn on a page
+type ImagePlacement struct {
+	Page      int        `json:"page"`
+	Z         int        `json:"z"`         // Drawing order among the images of the page, from 0
+	Transform [6]float64 `json:"transform"` // Maps the unit square onto the page: [a b c d e f]
+}
+
+// StreamFilter is a filter of the PDF stream an image was stored with
+type StreamFilter struct {
+	Name  string `json:"name"`
+	Parms string `json:"parms,omitempty"` // DecodeParms in PDF syntax
+}
+
+// recordLayout records the page boxes of pdf and, for each written image,
+// the filters of its stream and every place it or one of its duplicates
+// is drawn, so Assemble can put the images back. Images on pages whose
+// content can't be read get no placements, and no effective resolution.
+func recordLayout(pdf *model.Context, manifest *Manifest, images []LoadedImage, dups []duplicate) {
+	manifest.Pages = pageBoxes(pdf)
+
+	// Drawn objects per extracted file; halves of a split spread share it
+	type drawKey struct{ page, objNr int }
+	keys := make(map[string][]drawKey)
+	pages := make(map[int]bool)
+	for _, img := range images {
+		keys[img.OrigName] = append(keys[img.OrigName], drawKey{img.Page, img.ObjNr})
+		pages[img.Page] = true
+	}
+	for _, d := range dups {
+		src := images[d.Of].OrigName
+		keys[src] = append(keys[src], drawKey{d.Image.Page, d.Image.ObjNr})
+		pages[d.Image.Page] = true
+	}
+	draws := make(map[int][]imageDraw)
+	pageDPI := make(map[int]int)
+	for page := range pages {
+		if scan, err := scanPage(pdf, page, false); err == nil {
+			draws[page] = scan.draws
+		}
+	}
+
+	for i, img := range images {
+		var placements []ImagePlacement
+		seen := make(map[drawKey]bool)
+		for _, k := range keys[img.OrigName] {
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			for z, d := range draws[k.page] {
+				if d.objNr == k.objNr {
+					placements = append(placements, ImagePlacement{Page: k.page, Z: z, Transform: roundMatrix(d.ctm)})
+				}
+			}
+		}
+		sort.Slice(placements, func(a, b int) bool {
+			if placements[a].Page != placements[b].Page {
+				return placements[a].Page < placements[b].Page
+			}
+			return placements[a].Z < placements[b].Z
+		})
+		manifest.Images[i].Placements = placements
+		manifest.Images[i].Filters = streamFilters(pdf, img.ObjNr)
+		// Scans are judged by the pixels stored, before any edits
+		w, h := streamSize(pdf, img.ObjNr)
+		for _, p := range placements {
+			dpi := placementDPI(w, h, p.Transform)
+			if dpi == 0 {
+				continue
+			}
+			if img := &manifest.Images[i]; img.DPI == 0 || dpi < img.DPI {
+				img.DPI = dpi
+			}
+			if d, ok := pageDPI[p.Page]; !ok || dpi < d {
+				pageDPI[p.Page] = dpi
+			}
+		}
+	}
+	for i := range manifest.Pages {
+		manifest.Pages[i].DPI = pageDPI[manifest.Pages[i].Page]
+	}
+}
+
+// streamSize returns the width and height of the image stream objNr,
+// zero if unknown
+func streamSize(pdf *model.Context, objNr int) (int, int) {
+	sd, _, err := pdf.DereferenceStreamDict(*types.NewIndirectRef(objNr, 0))
+	if err != nil || sd == nil {
+		return 0, 0
+	}
+	w, h := sd.IntEntry("Width"), sd.IntEntry("Height")
+	if w == nil || h == nil {
+		return 0, 0
+	}
+	return *w, *h
+}
+
+// placementDPI returns the resolution, in pixels per inch, of a w x h
+// image drawn with transform m: the lower of both directions, 0 if unknown
+func placementDPI(w, h int, m [6]float64) int {
+	// The transform maps the image's sides onto these vectors, in points
+	width, height := math.Hypot(m[0], m[1]), math.Hypot(m[2], m[3])
+	if w <= 0 || h <= 0 || width < 1e-3 || height < 1e-3 {
+		return 0
+	}
+	return int(math.Round(min(float64(w)*72/width, float64(h)*72/height)))
+}
+
+// flagLowDPI flags the images drawn at less than minDPI and warns about
+// each, so scans too coarse for OCR are caught before they are archived.
+// Images of unknown resolution are left alone.
+func flagLowDPI(manifest *Manifest, minDPI int, notices io.Writer) {
+	for i := range manifest.Images {
+		img := &manifest.Images[i]
+		if img.DPI > 0 && img.DPI < minDPI {
+			img.LowDPI = true
+			noticef(notices, "warning: %s (page %d) is drawn at %d dpi, below %d", img.File, img.Page, img.DPI, minDPI)
+		}
+	}
+}
+
+// pageBoxes returns the box and rotation of every page of pdf
+func pageBoxes(pdf *model.Context) []PageBox {
+	boxes := make([]PageBox, 0, pdf.PageCount)
+	for page := 1; page <= pdf.PageCount; page++ {
+		_, _, inh, err := pdf.PageDict(page, false)
+		if err != nil || inh == nil {
+			continue
+		}
+		r := inh.CropBox
+		if r == nil {
+			r = inh.MediaBox
+		}
+		if r == nil {
+			continue
+		}
+		boxes = append(boxes, PageBox{
+			Page:   page,
+			Box:    [4]float64{r.LL.X, r.LL.Y, r.UR.X, r.UR.Y},
+			Rotate: inh.Rotate,
+		})
+	}
+	return boxes
+}
+
+// streamFilters lists the filters of the image stream objNr
+func streamFilters(pdf *model.Context, objNr int) []StreamFilter {
+	sd, _, err := pdf.DereferenceStreamDict(*types.NewIndirectRef(objNr, 0))
+	if err != nil || sd == nil {
+		return nil
+	}
+	var filters []StreamFilter
+	for _, f := range sd.FilterPipeline {
+		sf := StreamFilter{Name: f.Name}
+		if len(f.DecodeParms) > 0 {
+			sf.Parms = f.DecodeParms.PDFString()
+		}
+		filters = append(filters, sf)
+	}
+	return filters
+}
+
+// roundMatrix keeps transforms readable in JSON
+func roundMatrix(m matrix) [6]float64 {
+	var r [6]float64
+	for i, v := range m {
+		r[i] = math.Round(v*1e4) / 1e4
+	}
+	return r
+}
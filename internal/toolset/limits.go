@@ -0,0 +1,59 @@
+package imageHandling
+
+import (
+	"fmt"
+	"time"
+)
+
+// Default per-image limits. They keep decompression bombs in crafted PDFs
+// from exhausting memory or stalling the pipeline.
+const (
+	DefaultMaxPixels     = 200_000_000 // about 800 MB once decoded to RGBA
+	DefaultMaxImageBytes = 256 << 20   // compressed size of one image stream
+	DefaultDecodeTimeout = time.Minute
+)
+
+// Limits bounds the resources a single image may use. Images over a limit
+// are quarantined with the reason. Zero values fall back to the defaults.
+type Limits struct {
+	MaxPixels     int64         `json:"max_pixels"`      // Largest width*height that is decoded
+	MaxBytes      int64         `json:"max_image_bytes"` // Largest compressed image stream
+	DecodeTimeout time.Duration `json:"-"`               // Longest time spent decoding one image
+}
+
+// withDefaults fills in unset limits
+func (l Limits) withDefaults() Limits {
+	if l.MaxPixels <= 0 {
+		l.MaxPixels = DefaultMaxPixels
+	}
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = DefaultMaxImageBytes
+	}
+	if l.DecodeTimeout <= 0 {
+		l.DecodeTimeout = DefaultDecodeTimeout
+	}
+	return l
+}
+
+// checkPixels fails when a w x h image is over the pixel limit
+func (l Limits) checkPixels(w, h int) error {
+	if int64(w)*int64(h) > l.MaxPixels {
+		return fmt.Errorf("image is %dx%d, over the limit of %d pixels", w, h, l.MaxPixels)
+	}
+	return nil
+}
+
+// checkBytes fails when an image stream of n bytes is over the size limit
+func (l Limits) checkBytes(n int64) error {
+	if n > l.MaxBytes {
+		return fmt.Errorf("image stream is %d bytes, over the limit of %d bytes", n, l.MaxBytes)
+	}
+	return nil
+}
+
+// maxDecodedBytes is the most an image of pixels pixels may inflate to or
+// render into: eight bytes cover a 16-bit CMYK pixel, the rest PNG row
+// filters and headers
+func maxDecodedBytes(pixels int64) int64 {
+	return pixels*9 + 1<<20
+}
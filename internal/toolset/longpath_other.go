@@ -0,0 +1,6 @@
+//go:build !windows
+
+package imageHandling
+
+// LongPath returns p unchanged; only Windows limits path length to MAX_PATH
+func LongPath(p string) string { return p }
@@ -0,0 +1,25 @@
+//go:build windows
+
+package imageHandling
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// LongPath returns p in extended-length form (\\?\C:\...) so paths
+// beyond MAX_PATH (260 chars) work with the Win32 file APIs
+func LongPath(p string) string {
+	if strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	// UNC shares use the \\?\UNC\server\share form
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
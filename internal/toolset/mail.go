@@ -0,0 +1,271 @@
+package imageHandling
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// maxMailDepth bounds how deeply attached emails and nested multiparts
+// are followed
+const maxMailDepth = 16
+
+// MailAttachment is a PDF attached to an email
+type MailAttachment struct {
+	Name string // File name, sanitized and unique within the email
+	Data []byte
+}
+
+// IsMail reports whether name is an email file whose PDF attachments pixf
+// reads: .eml (RFC 822) or .msg (Outlook)
+func IsMail(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".eml" || ext == ".msg"
+}
+
+// MailPDFs returns the PDF attachments of the email in filename, including
+// those of emails attached to it, in the order they appear. Attachments
+// count as PDFs if their content starts like one, or failing that if they
+// are named or typed as one.
+func MailPDFs(filename string) ([]MailAttachment, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var c mailCollector
+	if isCFB(data) {
+		err = c.msg(data)
+	} else {
+		err = c.eml(bytes.NewReader(data), 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", filepath.Base(filename), err)
+	}
+	return c.pdfs, nil
+}
+
+// mailCollector gathers the PDF attachments of an email
+type mailCollector struct {
+	pdfs  []MailAttachment
+	names map[string]bool
+}
+
+// add keeps data if it is a PDF
+func (c *mailCollector) add(name, mediaType string, data []byte) {
+	head := data[:min(len(data), 1024)]
+	named := strings.EqualFold(path.Ext(name), ".pdf") || strings.EqualFold(mediaType, "application/pdf")
+	if !bytes.Contains(head, []byte("%PDF-")) && !named {
+		return
+	}
+
+	// Names come from the sender; keep only the last path element
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || name == "" {
+		name = fmt.Sprintf("attachment%d.pdf", len(c.pdfs)+1)
+	}
+	if !strings.EqualFold(path.Ext(name), ".pdf") {
+		name += ".pdf"
+	}
+	name = SanitizeName(name, false)
+	if c.names == nil {
+		c.names = make(map[string]bool)
+	}
+	stem := strings.TrimSuffix(name, path.Ext(name))
+	for n := 2; c.names[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s_%d.pdf", stem, n)
+	}
+	c.names[strings.ToLower(name)] = true
+	c.pdfs = append(c.pdfs, MailAttachment{Name: name, Data: data})
+}
+
+// mailWords decodes RFC 2047 encoded words in any charset x/text knows
+var mailWords = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	},
+}
+
+// eml walks an RFC 822 message
+func (c *mailCollector) eml(r io.Reader, depth int) error {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return err
+	}
+	return c.part(textproto.MIMEHeader(msg.Header), msg.Body, depth)
+}
+
+// part walks a MIME entity with header h and undecoded body
+func (c *mailCollector) part(h textproto.MIMEHeader, body io.Reader, depth int) error {
+	if depth > maxMailDepth {
+		return fmt.Errorf("MIME parts nested deeper than %d levels", maxMailDepth)
+	}
+	get := h.Get
+	mediaType, params, err := mime.ParseMediaType(get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := c.part(p.Header, p, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(get("Content-Transfer-Encoding"))) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, &base64Filter{r: body})
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	if mediaType == "message/rfc822" {
+		return c.eml(body, depth+1)
+	}
+
+	name := ""
+	if _, dparams, err := mime.ParseMediaType(get("Content-Disposition")); err == nil {
+		name = dparams["filename"]
+	}
+	if name == "" {
+		name = params["name"]
+	}
+	if decoded, err := mailWords.DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("attachment %s: %w", name, err)
+	}
+	c.add(name, mediaType, data)
+	return nil
+}
+
+// base64Filter drops the characters outside the base64 alphabet that
+// mailers wrap lines with, which the decoder would reject
+type base64Filter struct {
+	r io.Reader
+}
+
+func (f *base64Filter) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		kept := 0
+		for _, b := range p[:n] {
+			if b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '+' || b == '/' || b == '=' {
+				p[kept] = b
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// Outlook .msg files are compound files whose root storage holds the
+// message properties and one storage per attachment ([MS-OXMSG]).
+const (
+	msgAttachPrefix = "__attach_version1.0_#"
+	msgAttachData   = "__substg1.0_37010102" // PidTagAttachDataBinary
+	msgAttachMsg    = "__substg1.0_3701000D" // Attached message, as a storage
+	msgLongName     = "__substg1.0_3707"     // PidTagAttachLongFilename
+	msgShortName    = "__substg1.0_3704"     // PidTagAttachFilename
+	msgMimeTag      = "__substg1.0_370E"     // PidTagAttachMimeTag
+)
+
+// msg walks an Outlook message
+func (c *mailCollector) msg(data []byte) error {
+	f, err := readCFB(data)
+	if err != nil {
+		return err
+	}
+	return c.msgStorage(f, 0, 0)
+}
+
+// msgStorage collects the attachments of the message stored in dir
+func (c *mailCollector) msgStorage(f *cfbFile, dir uint32, depth int) error {
+	if depth > maxMailDepth {
+		return fmt.Errorf("attached messages nested deeper than %d levels", maxMailDepth)
+	}
+	var attachments []string
+	kids := f.children(dir)
+	for name, i := range kids {
+		if strings.HasPrefix(name, msgAttachPrefix) && f.entries[i].typ == cfbStorage {
+			attachments = append(attachments, name)
+		}
+	}
+	// Attachment storages are numbered in the order of the message
+	sort.Strings(attachments)
+
+	for _, a := range attachments {
+		props := f.children(kids[a])
+		if i, ok := props[msgAttachMsg]; ok && f.entries[i].typ == cfbStorage {
+			if err := c.msgStorage(f, i, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		i, ok := props[msgAttachData]
+		if !ok {
+			continue
+		}
+		data, err := f.stream(i)
+		if err != nil {
+			return fmt.Errorf("attachment %s: %w", a, err)
+		}
+		name := msgString(f, props, msgLongName)
+		if name == "" {
+			name = msgString(f, props, msgShortName)
+		}
+		c.add(name, msgString(f, props, msgMimeTag), data)
+	}
+	return nil
+}
+
+// msgString reads a string property stream, stored as UTF-16 (type
+// 001F) or in the message's 8-bit code page (type 001E)
+func msgString(f *cfbFile, props map[string]uint32, prefix string) string {
+	if i, ok := props[prefix+"001F"]; ok {
+		if b, err := f.stream(i); err == nil {
+			units := make([]uint16, len(b)/2)
+			for j := range units {
+				units[j] = binary.LittleEndian.Uint16(b[2*j:])
+			}
+			return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+		}
+	}
+	if i, ok := props[prefix+"001E"]; ok {
+		if b, err := f.stream(i); err == nil {
+			return strings.TrimRight(string(b), "\x00")
+		}
+	}
+	return ""
+}
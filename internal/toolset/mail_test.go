@@ -0,0 +1,169 @@
+package imageHandling
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// emlPart is a MIME part of a multipart/mixed test email
+func emlPart(header, body string) string {
+	return "--b\r\n" + header + "\r\n\r\n" + body + "\r\n"
+}
+
+// eml returns a multipart/mixed email of the parts
+func eml(parts ...string) string {
+	return "From: a@example.com\r\nSubject: test\r\nMIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n\r\n" + strings.Join(parts, "") + "--b--\r\n"
+}
+
+// pdfAttachment is a base64-encoded attachment named name
+func pdfAttachment(name, content string) string {
+	b64 := base64.StdEncoding.EncodeToString([]byte(content))
+	// Mailers wrap base64 lines
+	wrapped := b64[:len(b64)/2] + "\r\n" + b64[len(b64)/2:]
+	return emlPart(fmt.Sprintf("Content-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n"+
+		"Content-Disposition: attachment; filename=%q", name), wrapped)
+}
+
+func TestMailPDFsEML(t *testing.T) {
+	const pdf = "%PDF-1.7\n..."
+	inner := "Subject: inner\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=inner.pdf\r\n\r\n" + pdf
+	tests := []struct {
+		name  string
+		email string
+		want  []string // Attachment names
+		err   string   // Part of the error message
+	}{
+		{"none", eml(emlPart("Content-Type: text/plain", "hello")), nil, ""},
+		{"base64", eml(emlPart("Content-Type: text/plain", "hello"), pdfAttachment("doc.pdf", pdf)), []string{"doc.pdf"}, ""},
+		{"sniffed", eml(pdfAttachment("scan", pdf)), []string{"scan.pdf"}, ""},
+		{"typed", eml(emlPart("Content-Type: application/pdf; name=typed.pdf", "not really")), []string{"typed.pdf"}, ""},
+		{"not pdf", eml(pdfAttachment("photo.jpg", "\xff\xd8\xff")), nil, ""},
+		{"quoted-printable", eml(emlPart("Content-Type: application/pdf\r\nContent-Transfer-Encoding: quoted-printable\r\n"+
+			"Content-Disposition: attachment; filename=qp.pdf", "%PDF-1.4 =3D")), []string{"qp.pdf"}, ""},
+		{"encoded name", eml(pdfAttachment("=?utf-8?q?R=C3=A9sum=C3=A9.pdf?=", pdf)), []string{"Résumé.pdf"}, ""},
+		{"path in name", eml(pdfAttachment(`..\..\evil.pdf`, pdf)), []string{"evil.pdf"}, ""},
+		{"unnamed", eml(emlPart("Content-Type: application/pdf", pdf)), []string{"attachment1.pdf"}, ""},
+		{"duplicates", eml(pdfAttachment("a.pdf", pdf), pdfAttachment("A.pdf", pdf), pdfAttachment("a.pdf", pdf)),
+			[]string{"a.pdf", "A_2.pdf", "a_3.pdf"}, ""},
+		{"attached email", eml(pdfAttachment("outer.pdf", pdf), emlPart("Content-Type: message/rfc822", inner)), []string{"outer.pdf", "inner.pdf"}, ""},
+		{"too deep", strings.Repeat("Content-Type: message/rfc822\r\n\r\n", maxMailDepth+2) + "\r\n", nil, "nested deeper"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "mail.eml")
+			if err := os.WriteFile(path, []byte(tt.email), 0644); err != nil {
+				t.Fatal(err)
+			}
+			pdfs, err := MailPDFs(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range pdfs {
+				got = append(got, p.Name)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("attachments %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// msgUTF16 encodes a string property of an Outlook message
+func msgUTF16(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+// msgAttachment is the storage of attachment n with the properties
+func msgAttachment(n int, props ...*cfbNode) *cfbNode {
+	return &cfbNode{name: fmt.Sprintf("%s%08X", msgAttachPrefix, n), storage: true, kids: props}
+}
+
+func TestMailPDFsMSG(t *testing.T) {
+	pdf := []byte("%PDF-1.7\n...")
+	tests := []struct {
+		name string
+		kids []*cfbNode
+		want []string
+	}{
+		{"long name", []*cfbNode{msgAttachment(0,
+			&cfbNode{name: msgAttachData, data: pdf},
+			&cfbNode{name: msgLongName + "001F", data: msgUTF16("Long name.pdf")},
+			&cfbNode{name: msgShortName + "001F", data: msgUTF16("LONGNA~1.PDF")},
+		)}, []string{"Long name.pdf"}},
+		{"short 8-bit name", []*cfbNode{msgAttachment(0,
+			&cfbNode{name: msgAttachData, data: pdf},
+			&cfbNode{name: msgShortName + "001E", data: []byte("short.pdf\x00")},
+		)}, []string{"short.pdf"}},
+		{"mime tag", []*cfbNode{msgAttachment(0,
+			&cfbNode{name: msgAttachData, data: []byte("not really")},
+			&cfbNode{name: msgMimeTag + "001F", data: msgUTF16("application/pdf")},
+		)}, []string{"attachment1.pdf"}},
+		{"not pdf", []*cfbNode{msgAttachment(0,
+			&cfbNode{name: msgAttachData, data: []byte("\xff\xd8\xff")},
+			&cfbNode{name: msgLongName + "001F", data: msgUTF16("photo.jpg")},
+		)}, nil},
+		{"order", []*cfbNode{
+			msgAttachment(1, &cfbNode{name: msgAttachData, data: pdf}, &cfbNode{name: msgLongName + "001F", data: msgUTF16("second.pdf")}),
+			msgAttachment(0, &cfbNode{name: msgAttachData, data: pdf}, &cfbNode{name: msgLongName + "001F", data: msgUTF16("first.pdf")}),
+		}, []string{"first.pdf", "second.pdf"}},
+		{"attached message", []*cfbNode{
+			msgAttachment(0, &cfbNode{name: msgAttachMsg, storage: true, kids: []*cfbNode{
+				msgAttachment(0, &cfbNode{name: msgAttachData, data: pdf}, &cfbNode{name: msgLongName + "001F", data: msgUTF16("inner.pdf")}),
+			}}),
+		}, []string{"inner.pdf"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "mail.msg")
+			if err := os.WriteFile(path, writeCFB(tt.kids...), 0644); err != nil {
+				t.Fatal(err)
+			}
+			pdfs, err := MailPDFs(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range pdfs {
+				got = append(got, p.Name)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("attachments %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsMail(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"a.eml", true},
+		{"a.MSG", true},
+		{"a.pdf", false},
+		{"eml", false},
+	}
+	for _, tt := range tests {
+		if got := IsMail(tt.name); got != tt.want {
+			t.Errorf("IsMail(%q) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
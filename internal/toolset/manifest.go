@@ -0,0 +1,285 @@
+package imageHandling
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestName is the file describing an output directory
+const ManifestName = "manifest.json"
+
+// Manifest records the input and options that produced an output directory
+type Manifest struct {
+	Input         string          `json:"input"`
+	InputHash     string          `json:"input_sha256"`
+	InputVerified bool            `json:"input_verified"` // Input hash re-checked unchanged after extraction
+	Options       Options         `json:"options"`
+	StampHash     string          `json:"stamp_sha256,omitempty"` // SHA-256 of Options.Stamp.Image
+	CreatedAt     time.Time       `json:"created_at"`
+	Images        []ManifestImage `json:"images"`
+	Stitched      string          `json:"stitched,omitempty"`    // Strip of all images written with Options.Stitch
+	TIFF          string          `json:"tiff,omitempty"`        // Multi-page TIFF written with Options.MultiTIFF
+	Tiles         string          `json:"tiles,omitempty"`       // Tile index written with Options.Tile
+	Failed        []string        `json:"failed,omitempty"`      // PDFs of an archive, or embedded, that couldn't be extracted
+	Skipped       []SkippedImage  `json:"skipped,omitempty"`     // Images quarantined instead of written
+	Pages         []PageBox       `json:"pages,omitempty"`       // Page geometry, for Assemble
+	Stages        []StageTime     `json:"stages,omitempty"`      // Duration of each pipeline stage
+	Interrupted   bool            `json:"interrupted,omitempty"` // Run stopped early; Images lists what was complete
+	Indexed       int             `json:"indexed,omitempty"`     // Images left out as held by Options.DedupIndex
+	NoImages      bool            `json:"no_images,omitempty"`   // The input holds no images at all
+
+	// Documents whose images didn't all fit within Options.MaxTotalOutput
+	Truncated []Truncation `json:"truncated,omitempty"`
+
+	source string       // Input path, for verification
+	memory *memoryInput // Input read into memory, verified as it was hashed
+	timer  *stageTimer  // Times the stages while the output is written
+}
+
+// ManifestImage describes one written image
+type ManifestImage struct {
+	File     string `json:"file"`
+	Source   string `json:"source"`
+	Page     int    `json:"page"`
+	ObjNr    int    `json:"obj_nr"`
+	Part     string `json:"part,omitempty"` // left or right half of a split spread
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Bytes    int64  `json:"bytes"`
+	SHA256   string `json:"sha256"`
+	Label    string `json:"label,omitempty"`    // caption the file is named after
+	Document string `json:"document,omitempty"` // PDF within the archive, or embedded PDF, the image comes from
+	DPI      int    `json:"dpi,omitempty"`      // Lowest effective resolution it is drawn at (0 = unknown)
+	LowDPI   bool   `json:"low_dpi,omitempty"`  // DPI below Options.MinDPI
+
+	ColorProfile *ColorProfile `json:"color_profile,omitempty"` // ICC profile of the source and what became of it
+
+	Filters    []StreamFilter   `json:"filters,omitempty"`    // How the image was stored in the PDF
+	Placements []ImagePlacement `json:"placements,omitempty"` // Where it and its duplicates are drawn
+	Duplicates []DuplicateRef   `json:"duplicates,omitempty"` // Images left out as duplicates of it
+	Analysis   *ImageAnalysis   `json:"analysis,omitempty"`
+	Preview    string           `json:"preview,omitempty"` // Small data: URI thumbnail for quick previews
+	Tile       *TileRect        `json:"tile,omitempty"`    // Where a tile of a large image lies in it
+	Pyramid    string           `json:"pyramid,omitempty"` // .dzi or info.json of the image's tile pyramid
+}
+
+// DuplicateRef is an image left out as a duplicate of the image it is
+// recorded with
+type DuplicateRef struct {
+	Page    int  `json:"page"`
+	ObjNr   int  `json:"obj_nr"`
+	Similar bool `json:"similar,omitempty"` // Perceptually similar rather than identical
+}
+
+// HashFile computes SHA-256 of a file without loading it into memory
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// ReadManifest loads the manifest of an output directory
+func ReadManifest(imgDir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(imgDir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", ManifestName, err)
+	}
+	return &m, nil
+}
+
+// writeManifest saves m into imgDir, marking it NoImages if nothing was
+// found: no image written, left out or skipped, and no PDF failed
+func writeManifest(imgDir string, m *Manifest) error {
+	m.NoImages = len(m.Images) == 0 && len(m.Skipped) == 0 && m.Indexed == 0 && len(m.Failed) == 0 && !m.Interrupted && len(m.Truncated) == 0
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+	path := filepath.Join(imgDir, ManifestName)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// ErrInputModified reports that the input changed while it was processed
+var ErrInputModified = errors.New("input file was modified during processing")
+
+// VerifyUnchanged re-hashes path and fails with ErrInputModified when it
+// no longer matches hash
+func VerifyUnchanged(path, hash string) error {
+	now, err := HashFile(LongPath(path))
+	if err != nil {
+		return fmt.Errorf("verify input: %w", err)
+	}
+	if now != hash {
+		return fmt.Errorf("%s: %w", path, ErrInputModified)
+	}
+	return nil
+}
+
+// verifyInput confirms the input still has the hash recorded at the start
+func (m *Manifest) verifyInput() error {
+	if m.memory != nil {
+		m.InputVerified = true
+		return nil
+	}
+	if err := VerifyUnchanged(m.source, m.InputHash); err != nil {
+		return err
+	}
+	m.InputVerified = true
+	return nil
+}
+
+// Matches reports whether the manifest was produced from the same input,
+// options and stamp image, without PDFs that failed to be extracted
+func (m *Manifest) Matches(inputHash string, opts Options) bool {
+	return m.InputHash == inputHash && sameOptions(m.Options, opts) && m.StampHash == stampHash(opts.Stamp) &&
+		len(m.Failed) == 0 && !m.Interrupted
+}
+
+// stampHash returns the SHA-256 of the stamp image, which the options only
+// name ("" = no stamp image, or it can't be read)
+func stampHash(s Stamp) string {
+	if s.Image == "" {
+		return ""
+	}
+	hash, err := HashFile(s.Image)
+	if err != nil {
+		return ""
+	}
+	return hash
+}
+
+// filesPresent reports whether the files the manifest lists are all in
+// imgDir
+func (m *Manifest) filesPresent(imgDir string) bool {
+	files := []string{m.Stitched, m.TIFF, m.Tiles}
+	for _, img := range m.Images {
+		files = append(files, img.File)
+	}
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(imgDir, filepath.FromSlash(f))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// sameOptions reports whether a and b produce the same output
+func sameOptions(a, b Options) bool {
+	x, errX := json.Marshal(a.Recorded())
+	y, errY := json.Marshal(b.Recorded())
+	return errX == nil && errY == nil && bytes.Equal(x, y)
+}
+
+// Recorded returns o as manifests record it: the parameters of features
+// that are off are cleared, so they don't tell apart options producing
+// the same output
+func (o Options) Recorded() Options {
+	if !o.AutoCrop {
+		o.CropTolerance = 0
+	}
+	if o.KeyColor == "" {
+		o.KeyTolerance = 0
+	}
+	return o
+}
+
+// IsUpToDate reports whether imgDir already holds the result of extracting
+// input with opts, with all its files still present, so the work can be
+// skipped. The stamp image is compared by content; external commands
+// (Options.UpscaleCmd, Options.OptimizeCmd) only by the command line, so a
+// changed tool behind the same command isn't noticed.
+func IsUpToDate(input string, imgDir string, opts Options) bool {
+	input, imgDir = LongPath(input), LongPath(imgDir)
+	m, err := ReadManifest(imgDir)
+	if err != nil {
+		return false
+	}
+	hash, err := HashFile(input)
+	if err != nil {
+		return false
+	}
+	return m.Matches(hash, opts) && m.filesPresent(imgDir)
+}
+
+// buildManifest describes the images written to imgDir
+func buildManifest(imgDir string, images []LoadedImage, names []string) ([]ManifestImage, error) {
+	entries := make([]ManifestImage, 0, len(images))
+	for i, img := range images {
+		info, err := os.Stat(filepath.Join(imgDir, filepath.FromSlash(names[i])))
+		if err != nil {
+			return nil, fmt.Errorf("stat %s: %w", names[i], err)
+		}
+		entries = append(entries, manifestEntry(img, names[i], info.Size()))
+	}
+	return entries, nil
+}
+
+// manifestEntry describes img, written as name with size bytes
+func manifestEntry(img LoadedImage, name string, size int64) ManifestImage {
+	return ManifestImage{
+		File:   name,
+		Source: img.OrigName,
+		Page:   img.Page,
+		ObjNr:  img.ObjNr,
+		Part:   img.Part,
+		Tile:   img.Tile,
+		Label:  img.Label,
+		Width:  img.Width,
+		Height: img.Height,
+		Bytes:  size,
+		SHA256: img.FileHash,
+
+		ColorProfile: img.profile.record(img.colorHandling),
+	}
+}
+
+// writtenImages describes the images complete in imgDir, as name gives
+// their files (nil = none written yet)
+func writtenImages(imgDir string, images []LoadedImage, name func(LoadedImage) string) []ManifestImage {
+	entries := []ManifestImage{}
+	if name == nil {
+		return entries
+	}
+	for _, img := range images {
+		file := name(img)
+		if info, err := os.Stat(filepath.Join(imgDir, filepath.FromSlash(file))); err == nil {
+			entries = append(entries, manifestEntry(img, file, info.Size()))
+		}
+	}
+	return entries
+}
+
+// writeInterrupted saves m, holding what a stopped run completed, into
+// imgDir marked as interrupted
+func writeInterrupted(imgDir string, m *Manifest) error {
+	m.Interrupted = true
+	if m.timer != nil {
+		m.Stages = m.timer.times
+	}
+	return writeManifest(imgDir, m)
+}
@@ -0,0 +1,110 @@
+package imageHandling
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsUpToDate(t *testing.T) {
+	opts := Options{Format: "png", DedupScope: DedupPage, KeyColor: "#ffffff", KeyTolerance: DefaultKeyTolerance}
+	remove := func(name ...string) func(*testing.T, string, string, *Options) {
+		return func(t *testing.T, _, imgDir string, _ *Options) {
+			if err := os.Remove(filepath.Join(append([]string{imgDir}, name...)...)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tests := []struct {
+		name     string
+		manifest func(*Manifest)                                      // Changes the manifest written
+		after    func(t *testing.T, input, imgDir string, o *Options) // Changes the input, output or options
+		want     bool
+	}{
+		{"unchanged", nil, nil, true},
+		{"input changed", nil, func(t *testing.T, input, _ string, _ *Options) {
+			if err := os.WriteFile(input, []byte("%PDF-1.7 changed"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}, false},
+		{"options changed", nil, func(_ *testing.T, _, _ string, o *Options) { o.Format = "webp" }, false},
+		{"runtime option changed", nil, func(_ *testing.T, _, _ string, o *Options) { o.TempDir = "/elsewhere" }, true},
+		{"parameter of feature on changed", nil, func(_ *testing.T, _, _ string, o *Options) { o.KeyTolerance = 0 }, false},
+		{"parameter of feature off changed", nil, func(_ *testing.T, _, _ string, o *Options) { o.CropTolerance = 8 }, true},
+		{"image missing", nil, remove("sub", "a.png"), false},
+		{"tiff missing", func(m *Manifest) { m.TIFF = TIFFName }, nil, false},
+		{"failed documents", func(m *Manifest) { m.Failed = []string{"b.pdf"} }, nil, false},
+		{"interrupted", func(m *Manifest) { m.Interrupted = true }, nil, false},
+		{"no manifest", nil, remove(ManifestName), false},
+		{"stamp image changed", nil, func(t *testing.T, input, _ string, _ *Options) {
+			if err := os.WriteFile(filepath.Join(filepath.Dir(input), "logo.png"), []byte("new logo"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			input, imgDir := filepath.Join(root, "in.pdf"), filepath.Join(root, "imgs")
+			stamp := filepath.Join(root, "logo.png")
+			for _, f := range []string{input, stamp, filepath.Join(imgDir, "sub", "a.png")} {
+				if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(f, []byte(filepath.Base(f)), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			hash, err := HashFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			current := opts
+			current.Stamp.Image = stamp
+			m := &Manifest{InputHash: hash, Options: current, StampHash: stampHash(current.Stamp), Images: []ManifestImage{{File: "sub/a.png"}}}
+			if tt.manifest != nil {
+				tt.manifest(m)
+			}
+			if err := writeManifest(imgDir, m); err != nil {
+				t.Fatal(err)
+			}
+			if tt.after != nil {
+				tt.after(t, input, imgDir, &current)
+			}
+			if got := IsUpToDate(input, imgDir, current); got != tt.want {
+				t.Errorf("up to date %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptionsRecorded(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      Options
+		crop, key int
+	}{
+		{"off", Options{CropTolerance: DefaultCropTolerance, KeyTolerance: DefaultKeyTolerance}, 0, 0},
+		{"autocrop", Options{AutoCrop: true, CropTolerance: 5, KeyTolerance: DefaultKeyTolerance}, 5, 0},
+		{"key color", Options{CropTolerance: DefaultCropTolerance, KeyColor: "#000000", KeyTolerance: 7}, 0, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.opts.Recorded()
+			if got.CropTolerance != tt.crop || got.KeyTolerance != tt.key {
+				t.Errorf("tolerances %d, %d; want %d, %d", got.CropTolerance, got.KeyTolerance, tt.crop, tt.key)
+			}
+			data, err := json.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for field, want := range map[string]bool{`"crop_tolerance"`: tt.crop != 0, `"key_tolerance"`: tt.key != 0} {
+				if strings.Contains(string(data), field) != want {
+					t.Errorf("%s recorded %t, want %t", field, !want, want)
+				}
+			}
+		})
+	}
+}
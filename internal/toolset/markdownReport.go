@@ -0,0 +1,101 @@
+package imageHandling
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MarkdownReport is the Options.Report format of a markdown page of the
+// images, written as report.md
+const MarkdownReport = "markdown"
+
+// markdownEscaper escapes the characters that would end alt text or the
+// title early
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, "\n", " ")
+
+// inlineMIMETypes are the MIME types of the sniffed image types browsers
+// display, which are the only ones embedded as data URIs
+var inlineMIMETypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".bmp":  "image/bmp",
+}
+
+// writeMarkdownReport writes report.md into imgDir: the images by page,
+// each linked relative to the report so the folder can be pasted into a
+// wiki or repository as is. Images smaller than inlineUnder bytes are
+// embedded as data URIs instead, so the page shows them on its own (0 =
+// link all), unless browsers can't display their type, such as TIFF.
+func writeMarkdownReport(imgDir string, m *Manifest, inlineUnder int64) error {
+	path := filepath.Join(imgDir, "report.md")
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "# %s\n\n", markdownEscaper.Replace(m.Input))
+	fmt.Fprintf(w, "%d image(s) extracted by pixf, SHA-256 of the input `%s`\n", len(m.Images), m.InputHash)
+
+	page := 0
+	for _, img := range m.Images {
+		if img.Page != page {
+			page = img.Page
+			fmt.Fprintf(w, "\n## Page %d\n", page)
+		}
+		src, err := markdownImageSource(imgDir, img, inlineUnder)
+		if err != nil {
+			return err
+		}
+		alt := img.Label
+		if alt == "" {
+			alt = img.File
+		}
+		fmt.Fprintf(w, "\n![%s](%s)\n\n", markdownEscaper.Replace(alt), src)
+		fmt.Fprintf(w, "`%s` · %d×%d · %s", img.File, img.Width, img.Height, FormatSize(img.Bytes))
+		if len(img.Duplicates) > 0 {
+			pages := make([]string, len(img.Duplicates))
+			for j, d := range img.Duplicates {
+				pages[j] = fmt.Sprint(d.Page)
+			}
+			fmt.Fprintf(w, " · also on page %s", strings.Join(pages, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+	if len(m.Skipped) > 0 {
+		fmt.Fprintf(w, "\n%d image(s) couldn't be decoded and were quarantined in `%s/`\n", len(m.Skipped), QuarantineDirName)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// markdownImageSource returns what a markdown image of img points to: a
+// data URI if its file is smaller than inlineUnder and of a type browsers
+// display, else its path relative to the report
+func markdownImageSource(imgDir string, img ManifestImage, inlineUnder int64) (string, error) {
+	if img.Bytes < inlineUnder {
+		data, err := os.ReadFile(filepath.Join(imgDir, filepath.FromSlash(img.File)))
+		if err != nil {
+			return "", fmt.Errorf("inline %s: %w", img.File, err)
+		}
+		if mime, ok := inlineMIMETypes[sniffImage(data)]; ok {
+			return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+		}
+	}
+	segments := strings.Split(img.File, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/"), nil
+}
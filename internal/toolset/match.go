@@ -0,0 +1,165 @@
+package imageHandling
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// LibraryImage is an image of a reference library, such as licensed
+// assets, that the images of PDFs are matched against
+type LibraryImage struct {
+	Name   string `json:"file"`
+	SHA256 string `json:"sha256"`
+	Hash   string `json:"phash,omitempty"` // Perceptual hash ("" = exact matches only)
+
+	phash uint64
+}
+
+// Match is an image of a PDF found in a reference library
+type Match struct {
+	Image    ClusterMember `json:"image"`
+	Library  string        `json:"library"`  // Matching library image
+	Exact    bool          `json:"exact"`    // Identical encoded image
+	Distance int           `json:"distance"` // dHash distance, 0 for exact matches
+}
+
+// LoadLibrary reads a reference library: a directory tree of images,
+// which are hashed, or an index file written by SaveLibrary. A dedup
+// index (see DedupIndex) serves as well, for exact matches only.
+func LoadLibrary(path string) ([]LibraryImage, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return readLibraryIndex(path)
+	}
+
+	members, err := hashDirImages(path)
+	if err != nil {
+		return nil, err
+	}
+	lib := make([]LibraryImage, 0, len(members))
+	for _, m := range members {
+		lib = append(lib, LibraryImage{Name: m.Name, SHA256: m.fileHash, Hash: m.Hash, phash: m.phash})
+	}
+	return lib, nil
+}
+
+// readLibraryIndex reads a library index of JSON lines
+func readLibraryIndex(path string) ([]LibraryImage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lib []LibraryImage
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e struct {
+			LibraryImage
+			Dir string `json:"dir"` // Set in dedup index entries
+		}
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.SHA256 == "" {
+			return nil, fmt.Errorf("%s:%d: not a library index entry", path, n)
+		}
+		img := e.LibraryImage
+		if e.Dir != "" {
+			img.Name = filepath.Join(e.Dir, filepath.FromSlash(img.Name))
+		}
+		if img.Hash != "" {
+			if img.phash, err = strconv.ParseUint(img.Hash, 16, 64); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid phash %q", path, n, img.Hash)
+			}
+		}
+		lib = append(lib, img)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return lib, nil
+}
+
+// SaveLibrary writes lib as an index file for LoadLibrary, so a large
+// library is hashed once
+func SaveLibrary(path string, lib []LibraryImage) error {
+	return writeJSONLines(path, lib)
+}
+
+// writeJSONLines writes items to path as JSON lines, replacing the file
+// only once it is complete
+func writeJSONLines[T any](path string, items []T) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, item := range items {
+		if err = enc.Encode(item); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// MatchImages finds the images of a PDF in lib: identical ones, or else
+// the closest library image within threshold dHash distance. Images
+// without a match are left out; checked counts the images compared.
+func MatchImages(filename string, lib []LibraryImage, threshold int, tempDir string) (matches []Match, checked int, err error) {
+	members, err := hashPDFImages(filename, tempDir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	exact := make(map[string]string)
+	for _, img := range lib {
+		if _, ok := exact[img.SHA256]; !ok {
+			exact[img.SHA256] = img.Name
+		}
+	}
+
+	matches = []Match{}
+	for _, m := range members {
+		if name, ok := exact[m.fileHash]; ok {
+			matches = append(matches, Match{Image: m, Library: name, Exact: true})
+			continue
+		}
+		best := Match{Distance: threshold + 1}
+		for _, img := range lib {
+			if img.Hash == "" {
+				continue
+			}
+			if d := HammingDistance(m.phash, img.phash); d < best.Distance {
+				best = Match{Image: m, Library: img.Name, Distance: d}
+			}
+		}
+		if best.Distance <= threshold {
+			matches = append(matches, best)
+		}
+	}
+	return matches, len(members), nil
+}
@@ -0,0 +1,95 @@
+package imageHandling
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+)
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Ancillary PNG chunks that carry metadata rather than pixels
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"iCCP": true,
+	"iTXt": true, // XMP lives here
+	"tEXt": true,
+	"zTXt": true,
+	"tIME": true,
+}
+
+// stripMetadata removes EXIF/XMP/ICC and comment data from raw image bytes.
+// Unknown or malformed data is returned unchanged.
+func stripMetadata(data []byte, name string) []byte {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return stripJPEGMetadata(data)
+	case ".png":
+		return stripPNGMetadata(data)
+	}
+	return data
+}
+
+// stripJPEGMetadata drops APPn (except JFIF APP0 and Adobe APP14) and COM segments
+func stripJPEGMetadata(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return data
+		}
+		marker := data[pos+1]
+
+		// Start of scan: the rest is entropy-coded data, copy verbatim
+		if marker == 0xDA {
+			return append(out, data[pos:]...)
+		}
+
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if size < 2 || pos+2+size > len(data) {
+			return data
+		}
+		end := pos + 2 + size
+
+		// APP0 (JFIF) and APP14 (Adobe color transform) affect decoding
+		isMetadata := (marker >= 0xE1 && marker <= 0xEF && marker != 0xEE) || marker == 0xFE
+		if !isMetadata {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return data
+}
+
+// stripPNGMetadata drops text, EXIF, ICC and timestamp chunks
+func stripPNGMetadata(data []byte) []byte {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return data
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	// Chunk layout: length(4) type(4) data(length) crc(4)
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return data
+		}
+		if !pngMetadataChunks[string(data[pos+4:pos+8])] {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return out
+}
@@ -0,0 +1,97 @@
+package imageHandling
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Windows reserves these base names regardless of extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// maxNameBytes keeps a single path component within common filesystem limits
+const maxNameBytes = 200
+
+// SanitizeName makes a single path component valid on all platforms.
+// With ascii set, the name is transliterated to plain ASCII
+// (accents removed, other characters and spaces replaced by '_').
+func SanitizeName(name string, ascii bool) string {
+	if ascii {
+		name = transliterate(name)
+	}
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r < 0x20 || r == 0x7F:
+			b.WriteRune('_')
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			b.WriteRune('_')
+		case ascii && (r > unicode.MaxASCII || r == ' '):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	// Windows strips trailing dots and spaces silently
+	s := strings.TrimRight(b.String(), ". ")
+	if s == "" {
+		s = "_"
+	}
+
+	stem := s
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	if reservedNames[strings.ToUpper(stem)] {
+		s = "_" + s
+	}
+
+	return truncateName(s, maxNameBytes)
+}
+
+// transliterate removes diacritics (é -> e) using Unicode decomposition
+func transliterate(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	out, _, err := transform.String(t, s)
+	if err != nil {
+		return s
+	}
+	return out
+}
+
+// truncateName shortens s to at most n bytes by cutting its stem on a
+// rune boundary, keeping the extension. A cut leaving a trailing dot or
+// space, which Windows would strip, is trimmed further.
+func truncateName(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	ext := ""
+	if i := strings.LastIndexByte(s, '.'); i > 0 && len(s)-i < n {
+		s, ext = s[:i], s[i:]
+		n -= len(ext)
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	stem := s[:n]
+	if ext == "" {
+		stem = strings.TrimRight(stem, ". ")
+	}
+	if stem == "" {
+		stem = "_"
+	}
+	return stem + ext
+}
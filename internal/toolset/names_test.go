@@ -0,0 +1,43 @@
+package imageHandling
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateName(t *testing.T) {
+	tests := []struct {
+		name, s string
+		n       int
+		want    string
+	}{
+		{"short", "image.png", 20, "image.png"},
+		{"exact", "image.png", 9, "image.png"},
+		{"keeps extension", "a_long_caption.png", 10, "a_long.png"},
+		{"last extension", "scan.tar.gz", 9, "scan.t.gz"},
+		{"rune boundary", "ééééé.png", 9, "éé.png"},
+		{"no extension", "abcdefghij", 4, "abcd"},
+		{"extension too long", "a.bcdefghij", 4, "a.bc"},
+		{"trailing dot", "ab. cdefghij", 4, "ab"},
+		{"dot file", ".hidden_file", 6, ".hidde"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateName(tt.s, tt.n)
+			if got != tt.want {
+				t.Errorf("truncateName(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+			}
+			if len(got) > tt.n || !utf8.ValidString(got) {
+				t.Errorf("%q is over %d bytes or not valid UTF-8", got, tt.n)
+			}
+		})
+	}
+}
+
+func TestSanitizeNameLong(t *testing.T) {
+	got := SanitizeName(strings.Repeat("ü", 150)+".webp", false)
+	if len(got) > maxNameBytes || !strings.HasSuffix(got, "ü.webp") || !utf8.ValidString(got) {
+		t.Errorf("SanitizeName kept %d bytes ending in %q", len(got), got[max(0, len(got)-8):])
+	}
+}
@@ -0,0 +1,249 @@
+package imageHandling
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrNoImages is returned when there is no image to work on, such as a
+// page without images for GrabImage or a directory without any for Pack.
+// Extracting a PDF without images is not an error; its Result has none.
+var ErrNoImages = errors.New("no images")
+
+// ImageInfo describes an image XObject as listed by ListImages
+type ImageInfo struct {
+	ID       string   `json:"id"`       // Selector for Options.Objects: page.resource
+	Page     int      `json:"page"`     // Page the image is used on
+	ObjNr    int      `json:"obj_nr"`   // PDF object number
+	Resource string   `json:"resource"` // Resource path on the page (e.g. Im0 or Fm1.Im0)
+	Width    int      `json:"width"`
+	Height   int      `json:"height"`
+	Filters  []string `json:"filters"` // Stream filters, e.g. DCTDecode
+}
+
+// ListImages lists the image XObjects of a PDF in page order without
+// extracting them. An image used on several pages is listed once per page.
+func ListImages(ctx context.Context, filename string) ([]ImageInfo, error) {
+	pdf, closePDF, err := openPDF(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer closePDF()
+
+	refs, err := collectImageRefs(pdf)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]ImageInfo, 0, len(refs))
+	for _, ref := range refs {
+		info := ImageInfo{
+			ID:       fmt.Sprintf("%d.%s", ref.page, ref.name),
+			Page:     ref.page,
+			ObjNr:    ref.objNr,
+			Resource: ref.name,
+			Filters:  []string{},
+		}
+		if w := ref.sd.IntEntry("Width"); w != nil {
+			info.Width = *w
+		}
+		if h := ref.sd.IntEntry("Height"); h != nil {
+			info.Height = *h
+		}
+		for _, f := range ref.sd.FilterPipeline {
+			info.Filters = append(info.Filters, f.Name)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Thumbnails extracts and decodes every image of a PDF and scales it to
+// at most size pixels per side, keyed by object number. Images Go can't
+// decode are left out. Unless ignorePerms is set, PDFs whose permissions
+// forbid extraction fail with ErrExtractionForbidden.
+func Thumbnails(ctx context.Context, filename string, size int, tempDir string, ignorePerms bool) (map[int]image.Image, error) {
+	dir, err := os.MkdirTemp(tempDir, "pdfimg")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pdf, closePDF, err := openPDF(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer closePDF()
+	if !ignorePerms && !allowsExtraction(pdf) {
+		return nil, ErrExtractionForbidden
+	}
+	files, err := extractRaw(ctx, pdf, dir, nil, Limits{}.withDefaults(), pdfcpuEngine{})
+	if err != nil {
+		return nil, err
+	}
+
+	thumbs := make(map[int]image.Image)
+	for _, f := range files {
+		if _, ok := thumbs[f.ObjNr]; ok || f.Err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, f.Name))
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", f.Name, err)
+		}
+		if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+			thumbs[f.ObjNr] = makeThumbnail(img, size)
+		}
+	}
+	return thumbs, nil
+}
+
+// GrabImage extracts and decodes the index-th image (1-based) of page, in
+// the order ListImages reports them. Unless ignorePerms is set, PDFs whose
+// permissions forbid extraction fail with ErrExtractionForbidden.
+func GrabImage(ctx context.Context, filename string, page, index int, tempDir string, ignorePerms bool) (image.Image, error) {
+	dir, err := os.MkdirTemp(tempDir, "pdfimg")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pdf, closePDF, err := openPDF(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer closePDF()
+	if !ignorePerms && !allowsExtraction(pdf) {
+		return nil, ErrExtractionForbidden
+	}
+	if page < 1 || page > pdf.PageCount {
+		return nil, fmt.Errorf("page %d out of range (document has %d pages)", page, pdf.PageCount)
+	}
+
+	refs, err := collectImageRefs(pdf)
+	if err != nil {
+		return nil, err
+	}
+	var onPage []imageRef
+	for _, ref := range refs {
+		if ref.page == page {
+			onPage = append(onPage, ref)
+		}
+	}
+	if len(onPage) == 0 {
+		return nil, fmt.Errorf("page %d: %w", page, ErrNoImages)
+	}
+	if index < 1 || index > len(onPage) {
+		return nil, fmt.Errorf("image %d not found (page %d has %d images)", index, page, len(onPage))
+	}
+	ref := onPage[index-1]
+
+	sel := &objectSet{onPage: map[string]bool{fmt.Sprintf("%d.%s", page, ref.name): true}}
+	files, err := extractRaw(ctx, pdf, dir, sel, Limits{}.withDefaults(), pdfcpuEngine{})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("image %s not found", ref.name)
+	}
+	if files[0].Err != nil {
+		return nil, files[0].Err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, files[0].Name))
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", files[0].Name, err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", ref.name, decodeError(err))
+	}
+	return img, nil
+}
+
+// objectSet selects images by object number, by resource on any page or
+// by resource on one page. A nil set selects everything.
+type objectSet struct {
+	objNrs    map[int]bool
+	resources map[string]bool
+	onPage    map[string]bool // "page.resource"
+	all       bool            // Select everything (restricted by only)
+	only      map[int]bool    // If set, nothing outside these object numbers is selected
+}
+
+// CheckObjects validates a selection for Options.Objects
+func CheckObjects(list string) error {
+	_, err := parseObjects(list)
+	return err
+}
+
+// parseObjects reads a comma-separated selection such as "15,27,3.Im3":
+// numbers are object numbers, page.resource selects a resource on one
+// page and a bare resource name selects it on every page. Page 0 stands
+// for every page. An empty list selects all images.
+func parseObjects(list string) (*objectSet, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	set := &objectSet{
+		objNrs:    make(map[int]bool),
+		resources: make(map[string]bool),
+		onPage:    make(map[string]bool),
+	}
+	for _, sel := range strings.Split(list, ",") {
+		sel = strings.TrimSpace(sel)
+		if sel == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(sel); err == nil {
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid object number %d", n)
+			}
+			set.objNrs[n] = true
+			continue
+		}
+		page, res, found := strings.Cut(sel, ".")
+		n, err := strconv.Atoi(page)
+		switch {
+		case !found || err != nil:
+			set.resources[sel] = true
+		case n < 0 || res == "":
+			return nil, fmt.Errorf("invalid object selector '%s'", sel)
+		case n == 0:
+			set.resources[res] = true
+		default:
+			set.onPage[sel] = true
+		}
+	}
+	return set, nil
+}
+
+// matches reports whether ref is selected
+func (s *objectSet) matches(ref imageRef) bool {
+	if s == nil {
+		return true
+	}
+	if s.only != nil && !s.only[ref.objNr] {
+		return false
+	}
+	return s.all || s.objNrs[ref.objNr] || s.resources[ref.name] || s.onPage[fmt.Sprintf("%d.%s", ref.page, ref.name)]
+}
+
+// within narrows s to the object numbers in only; a nil only leaves s as
+// it is
+func (s *objectSet) within(only map[int]bool) *objectSet {
+	if only == nil {
+		return s
+	}
+	narrowed := objectSet{all: true}
+	if s != nil {
+		narrowed = *s
+	}
+	narrowed.only = only
+	return &narrowed
+}
@@ -0,0 +1,132 @@
+package imageHandling
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// EXIF orientation values (TIFF tag 0x0112)
+const (
+	orientNormal     = 1
+	orientFlipH      = 2
+	orientRotate180  = 3
+	orientFlipV      = 4
+	orientTranspose  = 5
+	orientRotate90   = 6
+	orientTransverse = 7
+	orientRotate270  = 8
+)
+
+// exifOrientation returns the EXIF orientation of JPEG data, or 1 if absent
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return orientNormal
+	}
+
+	// Walk JPEG segments until the APP1 Exif block or start of scan
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return orientNormal
+		}
+		marker := data[pos+1]
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			pos += 2
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			return orientNormal
+		}
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if size < 2 || pos+2+size > len(data) {
+			return orientNormal
+		}
+		seg := data[pos+4 : pos+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return tiffOrientation(seg[6:])
+		}
+		pos += 2 + size
+	}
+	return orientNormal
+}
+
+// tiffOrientation reads the orientation tag from IFD0 of a TIFF header
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return orientNormal
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return orientNormal
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return orientNormal
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o < orientNormal || o > orientRotate270 {
+				return orientNormal
+			}
+			return o
+		}
+	}
+	return orientNormal
+}
+
+// applyOrientation returns img with pixels transformed so orientation becomes 1
+func applyOrientation(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= orientNormal || orientation > orientRotate270 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// Orientations 5-8 swap width and height
+	dw, dh := w, h
+	if orientation >= orientTranspose {
+		dw, dh = h, w
+	}
+	dst := getRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		src := img.Pix[y*img.Stride : y*img.Stride+w*4]
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case orientFlipH:
+				dx, dy = w-1-x, y
+			case orientRotate180:
+				dx, dy = w-1-x, h-1-y
+			case orientFlipV:
+				dx, dy = x, h-1-y
+			case orientTranspose:
+				dx, dy = y, x
+			case orientRotate90:
+				dx, dy = h-1-y, x
+			case orientTransverse:
+				dx, dy = h-1-y, w-1-x
+			case orientRotate270:
+				dx, dy = y, w-1-x
+			}
+			di := dy*dst.Stride + dx*4
+			copy(dst.Pix[di:di+4], src[x*4:x*4+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,117 @@
+package imageHandling
+
+import (
+	"encoding/binary"
+	"image"
+	"strings"
+	"testing"
+)
+
+// exifJPEG returns the start of a JPEG whose APP1 segment holds a
+// little- or big-endian TIFF header with the orientation tag (0 = no tag)
+func exifJPEG(bigEndian bool, orientation uint16) []byte {
+	var bo binary.AppendByteOrder = binary.LittleEndian
+	tiff := []byte("II*\x00")
+	if bigEndian {
+		bo, tiff = binary.BigEndian, []byte("MM\x00*")
+	}
+	tiff = bo.AppendUint32(tiff, 8)
+	tiff = bo.AppendUint16(tiff, 2)
+	// An entry before the orientation, as cameras write
+	tiff = bo.AppendUint16(tiff, 0x010f) // Make
+	tiff = bo.AppendUint16(tiff, 2)
+	tiff = bo.AppendUint32(tiff, 4)
+	tiff = append(tiff, "Cam\x00"...)
+	tag := uint16(0x0112)
+	if orientation == 0 {
+		tag = 0x0110 // Model
+	}
+	tiff = bo.AppendUint16(tiff, tag)
+	tiff = bo.AppendUint16(tiff, tiffShort)
+	tiff = bo.AppendUint32(tiff, 1)
+	tiff = bo.AppendUint16(tiff, orientation)
+	tiff = bo.AppendUint16(tiff, 0)
+	tiff = bo.AppendUint32(tiff, 0)
+
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	b := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10}
+	b = append(b, "JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"...)
+	b = append(b, 0xff, 0xe1)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(app1)+2))
+	b = append(b, app1...)
+	return append(b, 0xff, 0xda, 0x00, 0x02)
+}
+
+func TestExifOrientation(t *testing.T) {
+	sos := []byte{0xff, 0xd8, 0xff, 0xda, 0x00, 0x02}
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"little-endian", exifJPEG(false, orientRotate90), orientRotate90},
+		{"big-endian", exifJPEG(true, orientRotate180), orientRotate180},
+		{"transverse", exifJPEG(true, orientTransverse), orientTransverse},
+		{"no tag", exifJPEG(false, 0), orientNormal},
+		{"out of range", exifJPEG(false, 9), orientNormal},
+		{"truncated", exifJPEG(false, orientRotate90)[:30], orientNormal},
+		{"exif after scan", append(sos, exifJPEG(false, orientRotate90)[2:]...), orientNormal},
+		{"not jpeg", []byte("\x89PNG\r\n\x1a\n"), orientNormal},
+		{"empty", nil, orientNormal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exifOrientation(tt.data); got != tt.want {
+				t.Errorf("orientation %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// letterImage returns an image whose rows are the strings of rows, one
+// letter per pixel kept in the red channel
+func letterImage(rows ...string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x := range len(row) {
+			img.Pix[y*img.Stride+4*x] = row[x]
+		}
+	}
+	return img
+}
+
+// letters reads back the rows of a letterImage, separated by slashes
+func letters(img *image.RGBA) string {
+	var rows []string
+	for y := range img.Rect.Dy() {
+		var row []byte
+		for x := range img.Rect.Dx() {
+			row = append(row, img.Pix[y*img.Stride+4*x])
+		}
+		rows = append(rows, string(row))
+	}
+	return strings.Join(rows, "/")
+}
+
+func TestApplyOrientation(t *testing.T) {
+	tests := []struct {
+		orientation int
+		want        string
+	}{
+		{0, "abc/def"},
+		{orientNormal, "abc/def"},
+		{orientFlipH, "cba/fed"},
+		{orientRotate180, "fed/cba"},
+		{orientFlipV, "def/abc"},
+		{orientTranspose, "ad/be/cf"},
+		{orientRotate90, "da/eb/fc"},
+		{orientTransverse, "fc/eb/da"},
+		{orientRotate270, "cf/be/ad"},
+		{9, "abc/def"},
+	}
+	for _, tt := range tests {
+		if got := letters(applyOrientation(letterImage("abc", "def"), tt.orientation)); got != tt.want {
+			t.Errorf("orientation %d: %s, want %s", tt.orientation, got, tt.want)
+		}
+	}
+}
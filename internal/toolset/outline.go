@@ -0,0 +1,57 @@
+package imageHandling
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// pageSections maps each page of pdf to the folder of the deepest outline
+// entry containing it, such as "02 Installation/01 Requirements". Pages
+// before the first entry, and all pages of documents without an outline,
+// map to "". Folders are numbered so they sort in document order.
+func pageSections(pdf *model.Context, ascii bool) (map[int]string, error) {
+	bms, err := pdfcpu.Bookmarks(pdf)
+	if err != nil {
+		return nil, fmt.Errorf("read outline: %w", err)
+	}
+	sections := make(map[int]string, pdf.PageCount)
+	for p := 1; p <= pdf.PageCount; p++ {
+		sections[p] = sectionPath(bms, p, ascii)
+	}
+	return sections, nil
+}
+
+// sectionPath descends the outline to the deepest entry containing page.
+// An entry covers its pages up to the next entry that starts later, so
+// the entry starting last at or before page is taken on each level.
+func sectionPath(bms []pdfcpu.Bookmark, page int, ascii bool) string {
+	var parts []string
+	for level := bms; len(level) > 0; {
+		idx := -1
+		for i, bm := range level {
+			if bm.PageFrom > 0 && bm.PageFrom <= page && (idx < 0 || bm.PageFrom >= level[idx].PageFrom) {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			break
+		}
+		width := max(len(strconv.Itoa(len(level))), 2)
+		name := fmt.Sprintf("%0*d %s", width, idx+1, strings.TrimSpace(level[idx].Title))
+		parts = append(parts, SanitizeName(name, ascii))
+		level = level[idx].Kids
+	}
+	return path.Join(parts...)
+}
+
+// assignSections sets the output folder of every image from its page
+func assignSections(images []LoadedImage, sections map[int]string) {
+	for i := range images {
+		images[i].Dir = sections[images[i].Page]
+	}
+}
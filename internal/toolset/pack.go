@@ -0,0 +1,447 @@
+package imageHandling
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// PackInfo is the metadata of a comic book or ebook made by Pack
+type PackInfo struct {
+	Title    string // Default: the input PDF or directory name
+	Author   string
+	Language string // BCP 47 tag, e.g. "en" or "ja" (default "en" for EPUB)
+	RTL      bool   // Pages are read right to left, as in manga
+}
+
+// PackPage is one page image of a pack
+type PackPage struct {
+	Path   string // File below the directory
+	Ext    string // Extension of its image type, e.g. ".png", whatever its name
+	Width  int
+	Height int
+}
+
+// epubMediaTypes are the image types EPUB reading systems must display
+var epubMediaTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// PackPages lists the page images of dir in reading order: the order of
+// the manifest if dir is an output directory of pixf, else the image files
+// below dir sorted by name, with numbers compared by value so page10
+// follows page9. Quarantined images are left out.
+func PackPages(dir string) ([]PackPage, error) {
+	var pages []PackPage
+	if m, err := ReadManifest(dir); err == nil {
+		for _, img := range m.Images {
+			pages = append(pages, PackPage{Path: filepath.FromSlash(img.File), Ext: strings.ToLower(path.Ext(img.File)), Width: img.Width, Height: img.Height})
+		}
+		return pages, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == QuarantineDirName || d.Name() == "thumbs" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := imageExt(p)
+		if ext == "" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		pages = append(pages, PackPage{Path: rel, Ext: ext})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(pages, func(i, j int) bool {
+		return naturalLess(filepath.ToSlash(pages[i].Path), filepath.ToSlash(pages[j].Path))
+	})
+	return pages, nil
+}
+
+// naturalLess compares strings case-insensitively with runs of digits
+// compared by value
+func naturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da != "" && db != "" {
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// digitPrefix is the run of ASCII digits s starts with
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// Pack builds a comic book archive (CBZ) or, if out ends in .epub, a
+// fixed-layout EPUB 3 with one page per image from the page images of dir,
+// in the order of PackPages. Returns the number of pages.
+func Pack(dir, out string, info PackInfo) (int, error) {
+	pages, err := PackPages(dir)
+	if err != nil {
+		return 0, err
+	}
+	if len(pages) == 0 {
+		return 0, fmt.Errorf("%s: %w", dir, ErrNoImages)
+	}
+	if info.Title == "" {
+		info.Title = packTitle(dir)
+	}
+	for i := range pages {
+		if pages[i].Width > 0 && pages[i].Height > 0 {
+			continue
+		}
+		if pages[i].Width, pages[i].Height, err = imageSize(filepath.Join(dir, pages[i].Path)); err != nil {
+			return 0, err
+		}
+	}
+
+	epub := strings.EqualFold(filepath.Ext(out), ".epub")
+	if epub {
+		for _, p := range pages {
+			if _, ok := epubMediaTypes[p.Ext]; !ok {
+				return 0, fmt.Errorf("%s: EPUB readers don't display %s images; extract as png or webp", p.Path, p.Ext)
+			}
+		}
+	}
+
+	// Written next to out and renamed, so out is never half a book
+	f, err := os.CreateTemp(filepath.Dir(out), ".pack*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	zw := zip.NewWriter(f)
+	if epub {
+		err = writeEPUB(zw, dir, pages, info)
+	} else {
+		err = writeCBZ(zw, dir, pages, info)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("write %s: %w", out, err)
+	}
+	if err := os.Rename(f.Name(), out); err != nil {
+		return 0, err
+	}
+	return len(pages), nil
+}
+
+// packTitle names a pack after the PDF the images came from, else dir
+func packTitle(dir string) string {
+	if m, err := ReadManifest(dir); err == nil && m.Input != "" {
+		return strings.TrimSuffix(m.Input, filepath.Ext(m.Input))
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	return strings.TrimPrefix(filepath.Base(abs), "images_")
+}
+
+// imageSize reads the dimensions of an image file from its header
+func imageSize(path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// addFile copies the file src into the archive as name. Images are
+// compressed already, so they are stored.
+func addFile(zw *zip.Writer, name, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, in)
+	return err
+}
+
+// addText adds a compressed text file to the archive
+func addText(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// pageName is the archive name of page i (0-based) with extension ext:
+// zero-padded, so readers that sort by name keep the order
+func pageName(i int, ext string) string {
+	return fmt.Sprintf("%04d%s", i+1, ext)
+}
+
+// comicInfo is the ComicInfo.xml of CBZ readers such as Komga, Kavita and
+// ComicRack
+type comicInfo struct {
+	XMLName     xml.Name        `xml:"ComicInfo"`
+	Title       string          `xml:"Title"`
+	Writer      string          `xml:"Writer,omitempty"`
+	PageCount   int             `xml:"PageCount"`
+	LanguageISO string          `xml:"LanguageISO,omitempty"`
+	Manga       string          `xml:"Manga,omitempty"`
+	Pages       []comicInfoPage `xml:"Pages>Page"`
+}
+
+// comicInfoPage describes one page in ComicInfo.xml
+type comicInfoPage struct {
+	Image       int    `xml:"Image,attr"`
+	Type        string `xml:"Type,attr,omitempty"`
+	ImageWidth  int    `xml:"ImageWidth,attr"`
+	ImageHeight int    `xml:"ImageHeight,attr"`
+}
+
+// writeCBZ writes the pages and a ComicInfo.xml
+func writeCBZ(zw *zip.Writer, dir string, pages []PackPage, info PackInfo) error {
+	ci := comicInfo{Title: info.Title, Writer: info.Author, PageCount: len(pages), LanguageISO: info.Language}
+	if info.RTL {
+		ci.Manga = "YesAndRightToLeft"
+	}
+	for i, p := range pages {
+		if err := addFile(zw, pageName(i, p.Ext), filepath.Join(dir, p.Path)); err != nil {
+			return err
+		}
+		page := comicInfoPage{Image: i, ImageWidth: p.Width, ImageHeight: p.Height}
+		if i == 0 {
+			page.Type = "FrontCover"
+		}
+		ci.Pages = append(ci.Pages, page)
+	}
+	data, err := xml.MarshalIndent(ci, "", "  ")
+	if err != nil {
+		return err
+	}
+	return addText(zw, "ComicInfo.xml", append([]byte(xml.Header), append(data, '\n')...))
+}
+
+// EPUB templates. Each page is an XHTML document whose viewport is the
+// size of its image, so reading systems scale the page as a whole.
+var (
+	epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+	epubPackage = template.Must(template.New("opf").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="id">{{.ID}}</dc:identifier>
+    <dc:title>{{.Title}}</dc:title>
+{{- if .Author}}
+    <dc:creator>{{.Author}}</dc:creator>
+{{- end}}
+    <dc:language>{{.Language}}</dc:language>
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">auto</meta>
+    <meta property="rendition:spread">landscape</meta>
+    <meta name="cover" content="img0001"/>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+{{- range .Pages}}
+    <item id="img{{.Num}}" href="images/{{.Image}}" media-type="{{.MediaType}}"{{if eq .Index 0}} properties="cover-image"{{end}}/>
+    <item id="page{{.Num}}" href="page{{.Num}}.xhtml" media-type="application/xhtml+xml"/>
+{{- end}}
+  </manifest>
+  <spine page-progression-direction="{{.Direction}}">
+{{- range .Pages}}
+    <itemref idref="page{{.Num}}"/>
+{{- end}}
+  </spine>
+</package>
+`))
+	epubNav = template.Must(template.New("nav").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="{{.Language}}">
+<head><title>{{.Title}}</title></head>
+<body>
+  <nav epub:type="toc"><ol><li><a href="page0001.xhtml">{{.Title}}</a></li></ol></nav>
+  <nav epub:type="page-list" hidden=""><ol>
+{{- range .Pages}}
+    <li><a href="page{{.Num}}.xhtml">{{.Index1}}</a></li>
+{{- end}}
+  </ol></nav>
+</body>
+</html>
+`))
+	epubPage = template.Must(template.New("page").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+  <title>{{.Index1}}</title>
+  <meta name="viewport" content="width={{.Width}}, height={{.Height}}"/>
+  <style>html, body { margin: 0; padding: 0; } img { display: block; width: {{.Width}}px; height: {{.Height}}px; }</style>
+</head>
+<body><img src="images/{{.Image}}" alt=""/></body>
+</html>
+`))
+)
+
+// epubItem is a page as the templates see it
+type epubItem struct {
+	Index, Index1 int
+	Num           string // Zero-padded page number
+	Image         string // File name in images/
+	MediaType     string
+	Width, Height int
+}
+
+// writeEPUB writes a fixed-layout EPUB 3 with one image per page
+func writeEPUB(zw *zip.Writer, dir string, pages []PackPage, info PackInfo) error {
+	// The mimetype must come first, stored, so the file is recognized by
+	// its leading bytes
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "application/epub+zip"); err != nil {
+		return err
+	}
+	if err := addText(zw, "META-INF/container.xml", []byte(epubContainer)); err != nil {
+		return err
+	}
+
+	// The identifier is derived from the pages, so packing the same images
+	// again gives the same book
+	h := sha256.New()
+	items := make([]epubItem, len(pages))
+	for i, p := range pages {
+		items[i] = epubItem{
+			Index:     i,
+			Index1:    i + 1,
+			Num:       fmt.Sprintf("%04d", i+1),
+			Image:     pageName(i, p.Ext),
+			MediaType: epubMediaTypes[p.Ext],
+			Width:     p.Width,
+			Height:    p.Height,
+		}
+		if err := addFile(zw, path.Join("OEBPS/images", items[i].Image), filepath.Join(dir, p.Path)); err != nil {
+			return err
+		}
+		var page bytes.Buffer
+		if err := epubPage.Execute(&page, items[i]); err != nil {
+			return err
+		}
+		if err := addText(zw, "OEBPS/page"+items[i].Num+".xhtml", page.Bytes()); err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\n", p.Path)
+	}
+	fmt.Fprintf(h, "%s\n%s\n", info.Title, info.Author)
+	sum := h.Sum(nil)
+	sum[6] = sum[6]&0x0f | 0x50 // Name-based UUID layout
+	sum[8] = sum[8]&0x3f | 0x80
+
+	lang := info.Language
+	if lang == "" {
+		lang = "en"
+	}
+	direction := "ltr"
+	if info.RTL {
+		direction = "rtl"
+	}
+	data := struct {
+		ID, Title, Author, Language, Modified, Direction string
+		Pages                                            []epubItem
+	}{
+		ID:        fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16]),
+		Title:     xmlText(info.Title),
+		Author:    xmlText(info.Author),
+		Language:  xmlText(lang),
+		Modified:  time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		Direction: direction,
+		Pages:     items,
+	}
+	for _, doc := range []struct {
+		name string
+		tmpl *template.Template
+	}{{"OEBPS/content.opf", epubPackage}, {"OEBPS/nav.xhtml", epubNav}} {
+		var buf bytes.Buffer
+		if err := doc.tmpl.Execute(&buf, data); err != nil {
+			return err
+		}
+		if err := addText(zw, doc.name, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// xmlText escapes s for XML content and attributes
+func xmlText(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
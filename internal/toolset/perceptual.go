@@ -0,0 +1,34 @@
+package imageHandling
+
+import (
+	"image"
+	"math/bits"
+
+	"golang.org/x/image/draw"
+)
+
+// PerceptualHash computes a 64-bit difference hash (dHash) of img.
+// Visually similar images, including rescaled copies, have hashes
+// with a small Hamming distance.
+func PerceptualHash(img image.Image) uint64 {
+	// Reduce to 9x8 grayscale and compare horizontally adjacent pixels
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		row := small.Pix[y*small.Stride:]
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if row[x] < row[x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HammingDistance counts differing bits between two perceptual hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,63 @@
+package imageHandling
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Permissions are the modes and owner given to outputs, for shared drop
+// directories with strict requirements. The zero value keeps what the OS
+// gives: files 0644 and directories 0755 less the umask, owned by the
+// running user.
+type Permissions struct {
+	FileMode fs.FileMode // Mode of written files (0 = unchanged)
+	DirMode  fs.FileMode // Mode of written directories (0 = unchanged)
+	Owner    *Owner      // nil = unchanged
+}
+
+// Owner is a user and group ID; -1 leaves either unchanged
+type Owner struct {
+	UID, GID int
+}
+
+// Apply gives path, and everything below it if it is a directory, the
+// modes and owner of p. Symbolic links are not followed. Directories get
+// their mode last, so a mode that locks out the running user still
+// reaches their contents.
+func (p Permissions) Apply(path string) error {
+	if p == (Permissions{}) {
+		return nil
+	}
+	var dirs []string
+	err := filepath.WalkDir(path, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		if p.Owner != nil {
+			if err := os.Lchown(name, p.Owner.UID, p.Owner.GID); err != nil {
+				return err
+			}
+		}
+		if d.IsDir() {
+			dirs = append(dirs, name)
+			return nil
+		}
+		if p.FileMode != 0 {
+			return os.Chmod(name, p.FileMode)
+		}
+		return nil
+	})
+	if err != nil || p.DirMode == 0 {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i], p.DirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package imageHandling
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// DefaultWriteWorkers is the number of concurrent file writers. Writing is
+// IO bound, so a few writers keep the disk busy without thrashing it.
+const DefaultWriteWorkers = 2
+
+// Workers sets the number of goroutines per pipeline stage.
+// Zero values fall back to the defaults.
+type Workers struct {
+	Decode int // Decoders, CPU bound (default: NumCPU)
+	Encode int // Encoders, CPU bound (default: NumCPU)
+	Write  int // File writers, IO bound (default: DefaultWriteWorkers)
+
+	// EncodeScale limits the encoders one output format may occupy to a
+	// share of Encode, keyed by extension without the dot. With
+	// {"webp": 0.5}, slow WebP encodes take at most half the encoders and
+	// PNG output of extractions running alongside keeps the rest. Shares
+	// of 1 and above, and formats not listed, may use every encoder.
+	EncodeScale map[string]float64
+}
+
+// withDefaults fills in unset worker counts
+func (w Workers) withDefaults() Workers {
+	if w.Decode <= 0 {
+		w.Decode = runtime.NumCPU()
+	}
+	if w.Encode <= 0 {
+		w.Encode = runtime.NumCPU()
+	}
+	if w.Write <= 0 {
+		w.Write = DefaultWriteWorkers
+	}
+	return w
+}
+
+// run tracks the jobs one extraction submits to the shared pools.
+// The first error cancels its context so queued and in-flight jobs of
+// this extraction stop early; other extractions are not affected.
+type run struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   sync.WaitGroup
+	once   sync.Once
+	err    error
+}
+
+// newRun derives a cancellable run from ctx
+func newRun(ctx context.Context) *run {
+	ctx, cancel := context.WithCancel(ctx)
+	return &run{ctx: ctx, cancel: cancel}
+}
+
+// fail records err if it is the first failure and cancels the run
+func (r *run) fail(err error) {
+	r.once.Do(func() {
+		r.err = err
+		r.cancel()
+	})
+}
+
+// submit queues job on pool; it returns false once the run is cancelled.
+// Jobs may submit further jobs to other pools. A job that panics fails
+// the run instead of taking down the worker and the process with it.
+func (r *run) submit(pool *workerPool, job func() error) bool {
+	return r.submitLimited(pool, nil, job)
+}
+
+// submitLimited is submit for jobs that hold one of slots from before
+// they are queued until they return, waiting while all are taken (nil =
+// no limit)
+func (r *run) submitLimited(pool *workerPool, slots limiter, job func() error) bool {
+	if err := slots.acquire(r.ctx); err != nil {
+		r.fail(err)
+		return false
+	}
+	r.jobs.Add(1)
+	err := pool.submit(r.ctx, func() {
+		defer r.jobs.Done()
+		defer slots.release()
+		defer func() {
+			if p := recover(); p != nil {
+				r.fail(fmt.Errorf("panic: %v", p))
+			}
+		}()
+		if r.ctx.Err() != nil {
+			return
+		}
+		if err := job(); err != nil {
+			r.fail(err)
+		}
+	})
+	if err != nil {
+		slots.release()
+		r.jobs.Done()
+		r.fail(err)
+		return false
+	}
+	return true
+}
+
+// wait blocks until every submitted job has returned
+func (r *run) wait() error {
+	r.jobs.Wait()
+	r.cancel()
+	return r.err
+}
+
+// decodeAll decodes images on the decode pool and returns one error slot
+// per image. Decode failures are per image; only cancellation stops it.
+func (e *Extractor) decodeAll(ctx context.Context, images []LoadedImage, pixels bool, limits Limits) ([]error, error) {
+	errs := make([]error, len(images))
+	events := eventsOf(ctx)
+
+	r := newRun(ctx)
+	for i := range images {
+		ok := r.submit(e.decode, func() error {
+			errs[i] = decodeImageTimeout(r.ctx, &images[i], pixels, limits)
+			if errs[i] == nil {
+				events.emit(Event{Type: EventDecoded, Page: images[i].Page, ObjNr: images[i].ObjNr})
+			}
+			return nil
+		})
+		if !ok {
+			break
+		}
+	}
+	if err := r.wait(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// decodeImageTimeout decodes a copy of img and stores it only on success,
+// so a decode abandoned after limits.DecodeTimeout can't touch img later.
+// The abandoned decoder runs to completion in the background, one of the
+// few RunContext lets run at once.
+func decodeImageTimeout(ctx context.Context, img *LoadedImage, pixels bool, limits Limits) error {
+	tctx, cancel := context.WithTimeout(ctx, limits.DecodeTimeout)
+	defer cancel()
+
+	res := *img
+	err := RunContext(tctx, func() error {
+		return decodeImageSafe(&res, pixels, limits)
+	})
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("decoding took longer than %s", limits.DecodeTimeout)
+		}
+		return err
+	}
+	*img = res
+	return nil
+}
+
+// decodeImageSafe turns decoder panics on malformed data into errors
+func decodeImageSafe(img *LoadedImage, pixels bool, limits Limits) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return decodeImage(img, pixels, limits)
+}
+
+// encodeEdits are the image changes made by the encode job just before
+// encoding, in field order. The color key sees the extracted colors.
+type encodeEdits struct {
+	key    *colorKey // nil = no color key
+	invert bool
+	tone   Tone
+	stamp  *stamper // nil = no stamp
+}
+
+// apply makes the edits to img in place
+func (ed encodeEdits) apply(img *image.RGBA) {
+	if ed.key != nil {
+		ed.key.apply(img)
+	}
+	if ed.invert {
+		invertImage(img)
+	}
+	if ed.tone.enabled() {
+		ed.tone.apply(img)
+	}
+	if ed.stamp != nil {
+		ed.stamp.apply(img)
+	}
+}
+
+// saveConverted edits, encodes and writes images in two stages. Each encode job
+// hands its buffer to the write pool and blocks while all writers are busy,
+// so a slow disk throttles encoding instead of piling up encoded images.
+// The first error cancels both stages. A recompressor, if any, runs on
+// each encoded PNG in the encode job. Unless strip is set, the ICC
+// profile of each image is embedded where the format takes it.
+func (e *Extractor) saveConverted(ctx context.Context, images []LoadedImage, imgDir string, encoder ImageEncoder, edits encodeEdits, recompress *pngRecompressor, strip bool) ([]string, error) {
+	ext := encoder.Extension()
+	slots := e.encodeSlots[strings.TrimPrefix(ext, ".")]
+	events := eventsOf(ctx)
+
+	r := newRun(ctx)
+	for i := range images {
+		ok := r.submitLimited(e.encode, slots, func() error {
+			edits.apply(images[i].Img)
+			buf, err := encodeImageSafe(images[i].Img, encoder, i)
+			if err != nil {
+				return err
+			}
+			if recompress != nil {
+				data, err := recompress.recompress(r.ctx, buf.Bytes())
+				if err != nil {
+					putBuffer(buf)
+					return fmt.Errorf("optimize image %d: %w", i+1, err)
+				}
+				if len(data) < buf.Len() {
+					buf.Reset()
+					buf.Write(data)
+				}
+			}
+			embedded := false
+			if p := images[i].profile; p != nil && !strip {
+				var data []byte
+				if data, embedded = embedProfile(buf.Bytes(), ext, p); embedded {
+					buf.Reset()
+					buf.Write(data)
+				}
+			}
+			images[i].colorHandling = images[i].profile.handling(embedded)
+			img := images[i]
+			events.emit(Event{Type: EventEncoded, Page: img.Page, ObjNr: img.ObjNr, Bytes: int64(buf.Len())})
+			queued := r.submit(e.write, func() error {
+				defer putBuffer(buf)
+				name := imageName(img, ext)
+				if err := os.WriteFile(filepath.Join(imgDir, filepath.FromSlash(name)), buf.Bytes(), 0644); err != nil {
+					return fmt.Errorf("write image %d: %w", i+1, err)
+				}
+				events.emit(Event{Type: EventWritten, Page: img.Page, ObjNr: img.ObjNr, File: name, Bytes: int64(buf.Len())})
+				return nil
+			})
+			if !queued {
+				putBuffer(buf)
+			}
+			return nil
+		})
+		if !ok {
+			break
+		}
+	}
+	if err := r.wait(); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(images))
+	for i := range images {
+		names[i] = imageName(images[i], ext)
+	}
+	return names, nil
+}
+
+// encodeImageSafe recovers encoder panics so the caller can still clean up
+func encodeImageSafe(img *image.RGBA, encoder ImageEncoder, index int) (buf *bytes.Buffer, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("encode image %d: panic: %v", index+1, r)
+		}
+	}()
+	return encodeImage(img, encoder)
+}
+
+// encodeImage encodes a single image into a pooled buffer
+func encodeImage(img *image.RGBA, encoder ImageEncoder) (*bytes.Buffer, error) {
+	buf := getBuffer()
+	if err := encoder.Encode(buf, img); err != nil {
+		putBuffer(buf)
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+	return buf, nil
+}
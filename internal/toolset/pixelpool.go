@@ -0,0 +1,51 @@
+package imageHandling
+
+import (
+	"image"
+	"math/bits"
+	"sync"
+)
+
+// Pixel slabs of large documents run to many megabytes each; allocating a
+// fresh one per image dominates GC time. Slabs are pooled in power-of-two
+// size classes so a released buffer can serve any image that fits.
+const (
+	minPixelClass = 16 // 64 KiB; smaller images are cheap to allocate
+	maxPixelClass = 31 // 2 GiB
+)
+
+var pixelPools [maxPixelClass + 1]sync.Pool
+
+// getRGBA returns an RGBA image with bounds r backed by a pooled slab.
+// Pixel contents are undefined; callers must overwrite every pixel.
+func getRGBA(r image.Rectangle) *image.RGBA {
+	n := 4 * r.Dx() * r.Dy()
+	class := bits.Len(uint(n - 1)) // smallest class with 1<<class >= n
+	if n <= 0 || class < minPixelClass || class > maxPixelClass {
+		return image.NewRGBA(r)
+	}
+
+	var pix []byte
+	if slab, ok := pixelPools[class].Get().(*[]byte); ok {
+		pix = (*slab)[:n]
+	} else {
+		pix = make([]byte, n, 1<<class)
+	}
+	return &image.RGBA{Pix: pix, Stride: 4 * r.Dx(), Rect: r}
+}
+
+// putRGBA returns the pixel slab of img to the pool. img must not be used
+// afterwards. Slabs not allocated by getRGBA are accepted as well.
+func putRGBA(img *image.RGBA) {
+	if img == nil {
+		return
+	}
+	c := cap(img.Pix)
+	class := bits.Len(uint(c)) - 1 // largest class with 1<<class <= c
+	if c == 0 || class < minPixelClass || class > maxPixelClass {
+		return
+	}
+	pix := img.Pix[:cap(img.Pix)]
+	img.Pix = nil
+	pixelPools[class].Put(&pix)
+}
@@ -0,0 +1,365 @@
+package imageHandling
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// OptimizedPNGEncoder writes the smallest PNG it finds, for publishing
+// rather than speed. Images with at most 256 colors get a palette at the
+// lowest bit depth that holds it, other gray images are stored as gray and
+// opaque ones without alpha. The pixels are compressed at the highest level
+// both unfiltered and with a filter chosen per row, and the smaller kept.
+type OptimizedPNGEncoder struct{}
+
+func (OptimizedPNGEncoder) Encode(w io.Writer, img *image.RGBA) error {
+	raw := reducePNG(img)
+	var best []byte
+	for _, adaptive := range []bool{false, true} {
+		z, err := raw.compress(adaptive)
+		if err != nil {
+			return err
+		}
+		if best == nil || len(z) < len(best) {
+			best = z
+		}
+	}
+	return raw.write(w, best)
+}
+func (OptimizedPNGEncoder) Extension() string { return ".png" }
+
+// PNG color types
+const (
+	pngGray    = 0
+	pngRGB     = 2
+	pngPalette = 3
+	pngRGBA    = 6
+)
+
+// pngRaster is an image in the smallest PNG color type that holds it,
+// packed into rows
+type pngRaster struct {
+	width, height int
+	colorType     int
+	depth         int       // Bits per sample
+	palette       [][4]byte // Non-premultiplied RGBA, for pngPalette
+	rows          [][]byte
+}
+
+// reducePNG picks the color type and bit depth for img and packs its rows
+func reducePNG(img *image.RGBA) *pngRaster {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	r := &pngRaster{width: w, height: h}
+
+	// Count colors, up to one more than a palette holds
+	index := make(map[[4]byte]int)
+	gray, opaque := true, true
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+		for x := 0; x < len(row); x += 4 {
+			c := unpremultiply(row[x : x+4])
+			gray = gray && c[3] == 0xff && c[0] == c[1] && c[1] == c[2]
+			opaque = opaque && c[3] == 0xff
+			if len(index) <= 256 {
+				if _, ok := index[c]; !ok {
+					index[c] = len(index)
+				}
+			}
+		}
+	}
+
+	switch {
+	case len(index) <= 16 || (len(index) <= 256 && !gray):
+		r.colorType = pngPalette
+		r.depth = 8
+		for _, d := range []int{1, 2, 4} {
+			if len(index) <= 1<<d {
+				r.depth = d
+				break
+			}
+		}
+		// Transparent entries first keep the tRNS chunk short
+		for c := range index {
+			r.palette = append(r.palette, c)
+		}
+		sort.Slice(r.palette, func(i, j int) bool {
+			a, b := r.palette[i], r.palette[j]
+			if a[3] != b[3] {
+				return a[3] < b[3]
+			}
+			return binary.BigEndian.Uint32(a[:]) < binary.BigEndian.Uint32(b[:])
+		})
+		for i, c := range r.palette {
+			index[c] = i
+		}
+	case gray:
+		r.colorType, r.depth = pngGray, 8
+	case opaque:
+		r.colorType, r.depth = pngRGB, 8
+	default:
+		r.colorType, r.depth = pngRGBA, 8
+	}
+
+	channels := map[int]int{pngGray: 1, pngRGB: 3, pngPalette: 1, pngRGBA: 4}[r.colorType]
+	rowBytes := (w*channels*r.depth + 7) / 8
+	r.rows = make([][]byte, h)
+	for y := 0; y < h; y++ {
+		src := img.Pix[y*img.Stride : y*img.Stride+w*4]
+		dst := make([]byte, rowBytes)
+		for x := 0; x < w; x++ {
+			c := unpremultiply(src[x*4 : x*4+4])
+			switch r.colorType {
+			case pngPalette:
+				bit := x * r.depth
+				dst[bit/8] |= byte(index[c]) << (8 - r.depth - bit%8)
+			case pngGray:
+				dst[x] = c[0]
+			case pngRGB:
+				copy(dst[x*3:], c[:3])
+			default:
+				copy(dst[x*4:], c[:])
+			}
+		}
+		r.rows[y] = dst
+	}
+	return r
+}
+
+// unpremultiply converts a premultiplied RGBA pixel to the straight alpha
+// PNG stores, rounding as the standard PNG encoder does
+func unpremultiply(p []byte) [4]byte {
+	switch p[3] {
+	case 0xff:
+		return [4]byte{p[0], p[1], p[2], p[3]}
+	case 0:
+		return [4]byte{}
+	}
+	c := color.NRGBAModel.Convert(color.RGBA{p[0], p[1], p[2], p[3]}).(color.NRGBA)
+	return [4]byte{c.R, c.G, c.B, c.A}
+}
+
+// compress filters and deflates the rows: unfiltered, or with each row's
+// filter chosen by the smallest sum of absolute differences
+func (r *pngRaster) compress(adaptive bool) ([]byte, error) {
+	bpp := 1 // Bytes per complete pixel, at least one
+	switch r.colorType {
+	case pngRGB:
+		bpp = 3
+	case pngRGBA:
+		bpp = 4
+	}
+
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	var prev []byte
+	if len(r.rows) > 0 {
+		prev = make([]byte, len(r.rows[0]))
+	}
+	var candidates [5][]byte
+	for i := range candidates {
+		candidates[i] = make([]byte, len(prev)+1)
+	}
+	for _, row := range r.rows {
+		best := candidates[0]
+		best[0] = 0
+		copy(best[1:], row)
+		if adaptive {
+			bestSum := rowCost(best[1:])
+			for ft := 1; ft < 5; ft++ {
+				filterRow(candidates[ft], row, prev, ft, bpp)
+				if sum := rowCost(candidates[ft][1:]); sum < bestSum {
+					best, bestSum = candidates[ft], sum
+				}
+			}
+		}
+		if _, err := zw.Write(best); err != nil {
+			return nil, err
+		}
+		prev = row
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// filterRow writes filter type ft and row filtered against prev into dst
+func filterRow(dst, row, prev []byte, ft, bpp int) {
+	dst[0] = byte(ft)
+	out := dst[1:]
+	for i := range row {
+		var a, c byte
+		if i >= bpp {
+			a, c = row[i-bpp], prev[i-bpp]
+		}
+		b := prev[i]
+		switch ft {
+		case 1:
+			out[i] = row[i] - a
+		case 2:
+			out[i] = row[i] - b
+		case 3:
+			out[i] = row[i] - byte((int(a)+int(b))/2)
+		case 4:
+			out[i] = row[i] - paeth(a, b, c)
+		}
+	}
+}
+
+// paeth is the predictor of PNG filter type 4
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// rowCost sums the filtered bytes as signed values, the usual estimate of
+// how well a row compresses
+func rowCost(row []byte) int {
+	sum := 0
+	for _, v := range row {
+		sum += abs(int(int8(v)))
+	}
+	return sum
+}
+
+// write writes the PNG file with the compressed pixel data z
+func (r *pngRaster) write(w io.Writer, z []byte) error {
+	if _, err := w.Write([]byte("\x89PNG\r\n\x1a\n")); err != nil {
+		return err
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(r.width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(r.height))
+	ihdr[8], ihdr[9] = byte(r.depth), byte(r.colorType)
+	chunks := [][2][]byte{{[]byte("IHDR"), ihdr}}
+
+	if r.colorType == pngPalette {
+		plte := make([]byte, 0, len(r.palette)*3)
+		var trns []byte
+		for _, c := range r.palette {
+			plte = append(plte, c[0], c[1], c[2])
+			if c[3] != 0xff {
+				trns = append(trns, c[3])
+			}
+		}
+		chunks = append(chunks, [2][]byte{[]byte("PLTE"), plte})
+		if len(trns) > 0 {
+			chunks = append(chunks, [2][]byte{[]byte("tRNS"), trns})
+		}
+	}
+	chunks = append(chunks, [2][]byte{[]byte("IDAT"), z}, [2][]byte{[]byte("IEND"), nil})
+
+	for _, c := range chunks {
+		var head [8]byte
+		binary.BigEndian.PutUint32(head[:4], uint32(len(c[1])))
+		copy(head[4:], c[0])
+		crc := crc32.NewIEEE()
+		crc.Write(head[4:])
+		crc.Write(c[1])
+		var tail [4]byte
+		binary.BigEndian.PutUint32(tail[:], crc.Sum32())
+		for _, part := range [][]byte{head[:], c[1], tail[:]} {
+			if _, err := w.Write(part); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pngRecompressor runs an external PNG optimizer, such as zopflipng or
+// oxipng, on every encoded image. Command is split on spaces; the
+// arguments {in} and {out} are replaced by the PNG to optimize and the
+// PNG the tool must write. A result that isn't smaller is discarded.
+type pngRecompressor struct {
+	command string
+	tempDir string // Directory for the exchanged PNGs ("" = OS default)
+}
+
+// recompress returns the smaller of data and the optimizer's output for it
+func (p pngRecompressor) recompress(ctx context.Context, data []byte) ([]byte, error) {
+	args := strings.Fields(p.command)
+	if len(args) == 0 {
+		return nil, errors.New("empty PNG optimizer command")
+	}
+	in, err := os.CreateTemp(p.tempDir, "optimize*.png")
+	if err != nil {
+		return nil, err
+	}
+	inPath := in.Name()
+	outPath := strings.TrimSuffix(inPath, ".png") + ".out.png"
+	defer os.Remove(inPath)
+	defer os.Remove(outPath)
+
+	_, err = in.Write(data)
+	if cerr := in.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write %s: %w", inPath, err)
+	}
+
+	r := strings.NewReplacer("{in}", inPath, "{out}", outPath)
+	for i := range args {
+		args[i] = r.Replace(args[i])
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", args[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	optimized, err := os.ReadFile(outPath)
+	if err != nil {
+		return nil, fmt.Errorf("read optimized image: %w", err)
+	}
+	// The tool must keep the image; a broken or resized file is an error
+	before, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	after, err := png.DecodeConfig(bytes.NewReader(optimized))
+	if err != nil {
+		return nil, fmt.Errorf("read optimized image: %w", err)
+	}
+	if after.Width != before.Width || after.Height != before.Height {
+		return nil, fmt.Errorf("optimized image is %dx%d, want %dx%d", after.Width, after.Height, before.Width, before.Height)
+	}
+	if len(optimized) >= len(data) {
+		return data, nil
+	}
+	return optimized, nil
+}
@@ -0,0 +1,163 @@
+package imageHandling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// officeExtensions are the document types converted to PDF before
+// processing
+var officeExtensions = map[string]bool{
+	".doc": true, ".docx": true, ".odt": true, ".rtf": true,
+	".ppt": true, ".pptx": true, ".odp": true,
+	".xls": true, ".xlsx": true, ".ods": true,
+}
+
+// NeedsConversion reports whether name is an Office document that has to
+// be converted to PDF before its images can be extracted
+func NeedsConversion(name string) bool {
+	return officeExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// PreConverter turns a document pixf can't read into a PDF
+type PreConverter interface {
+	// Convert writes input as a PDF into dir, which is created if needed,
+	// and returns the path of the PDF
+	Convert(ctx context.Context, input, dir string) (string, error)
+}
+
+// OfficeConverter converts with LibreOffice, run headless with a profile
+// of its own, so it neither waits for a LibreOffice the user has open nor
+// touches their settings
+type OfficeConverter struct {
+	Binary string // soffice executable ("" = PATH, then the usual install locations)
+}
+
+func (c OfficeConverter) Convert(ctx context.Context, input, dir string) (string, error) {
+	bin := c.Binary
+	if bin == "" {
+		var err error
+		if bin, err = findSoffice(); err != nil {
+			return "", err
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	profile, err := os.MkdirTemp(dir, "profile")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(profile)
+	profileURL, err := fileURL(profile)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.CommandContext(ctx, bin, "-env:UserInstallation="+profileURL,
+		"--headless", "--norestore", "--convert-to", "pdf", "--outdir", dir, input)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", commandError(filepath.Base(bin), err, out)
+	}
+	// soffice reports some failures only by not writing the PDF
+	pdf := filepath.Join(dir, strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))+".pdf")
+	if _, err := os.Stat(pdf); err != nil {
+		return "", commandError(filepath.Base(bin), errors.New("no PDF written"), out)
+	}
+	return pdf, nil
+}
+
+// CommandConverter runs an external converter. Command is split on
+// spaces; the arguments {in} and {out} are replaced by the document and
+// the PDF the tool must write.
+type CommandConverter struct {
+	Command string
+}
+
+func (c CommandConverter) Convert(ctx context.Context, input, dir string) (string, error) {
+	args := strings.Fields(c.Command)
+	if len(args) == 0 {
+		return "", errors.New("empty convert command")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	pdf := filepath.Join(dir, strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))+".pdf")
+
+	r := strings.NewReplacer("{in}", input, "{out}", pdf)
+	for i := range args {
+		args[i] = r.Replace(args[i])
+	}
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return "", commandError(args[0], err, out)
+	}
+	if _, err := os.Stat(pdf); err != nil {
+		return "", commandError(args[0], errors.New("no PDF written"), out)
+	}
+	return pdf, nil
+}
+
+// NewPreConverter returns the converter running command, or LibreOffice
+// if command is ""
+func NewPreConverter(command string) PreConverter {
+	if command != "" {
+		return CommandConverter{Command: command}
+	}
+	return OfficeConverter{}
+}
+
+// commandError describes a failed external command with its output
+func commandError(name string, err error, out []byte) error {
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		return fmt.Errorf("%s: %w: %s", name, err, msg)
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+// findSoffice locates the LibreOffice executable
+func findSoffice() (string, error) {
+	for _, name := range []string{"soffice", "libreoffice"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	var candidates []string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = []string{"/Applications/LibreOffice.app/Contents/MacOS/soffice"}
+	case "windows":
+		for _, env := range []string{"ProgramFiles", "ProgramFiles(x86)"} {
+			if dir := os.Getenv(env); dir != "" {
+				candidates = append(candidates, filepath.Join(dir, "LibreOffice", "program", "soffice.exe"))
+			}
+		}
+	}
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("LibreOffice (soffice) not found; install it or use --convert-cmd")
+}
+
+// fileURL turns a local path into a file: URL
+func fileURL(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	p := filepath.ToSlash(abs)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p // Windows drive letter
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String(), nil
+}
@@ -0,0 +1,53 @@
+package imageHandling
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+)
+
+// MaxPreviewSize is the largest preview that can be embedded, in pixels;
+// bigger ones would bloat the manifest
+const MaxPreviewSize = 512
+
+// previewQuality is the JPEG quality of opaque previews
+const previewQuality = 70
+
+// embedPreview returns img scaled so its longer side is at most size
+// pixels, as a data URI: JPEG when it's opaque, PNG to keep transparency.
+// Unlike makeThumbnail, every source pixel contributes, so fine detail
+// doesn't alias at tiny sizes.
+func embedPreview(img *image.RGBA, size int) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w < 1 || h < 1 {
+		return ""
+	}
+	tw, th := w, h
+	if w > size || h > size {
+		tw, th = size, max(1, h*size/w)
+		if h > w {
+			tw, th = max(1, w*size/h), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	var buf bytes.Buffer
+	mime := "image/jpeg"
+	if dst.Opaque() {
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: previewQuality}); err != nil {
+			return ""
+		}
+	} else {
+		mime = "image/png"
+		if err := png.Encode(&buf, dst); err != nil {
+			return ""
+		}
+	}
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
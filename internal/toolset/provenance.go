@@ -0,0 +1,133 @@
+package imageHandling
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// ProvenanceNS is the XMP namespace of the provenance properties
+const ProvenanceNS = "https://github.com/n01nex/pixf/ns/provenance/1.0/"
+
+// Provenance links a PDF to the images extracted from it
+type Provenance struct {
+	ManifestHash string    // SHA-256 of the manifest.json of the extraction
+	Version      string    // pixf version that extracted the images
+	ExtractedAt  time.Time // When the manifest was written
+}
+
+// ManifestProvenance describes the extraction recorded in imgDir
+func ManifestProvenance(imgDir, version string) (Provenance, error) {
+	m, err := ReadManifest(imgDir)
+	if err != nil {
+		return Provenance{}, err
+	}
+	hash, err := HashFile(LongPath(filepath.Join(imgDir, ManifestName)))
+	if err != nil {
+		return Provenance{}, err
+	}
+	return Provenance{ManifestHash: hash, Version: version, ExtractedAt: m.CreatedAt}, nil
+}
+
+// provenanceDesc matches an rdf:Description of earlier provenance, so
+// extracting from a traced copy records only the latest extraction
+var provenanceDesc = regexp.MustCompile(`(?s)<rdf:Description[^>]*xmlns:pixf="` + regexp.QuoteMeta(ProvenanceNS) + `".*?</rdf:Description>\s*`)
+
+// rdfAbout finds the resource the descriptions of a packet are about
+var rdfAbout = regexp.MustCompile(`rdf:about=(["'])(.*?)["']`)
+
+// WriteProvenance writes a copy of the document to path whose XMP
+// metadata records p. Existing XMP metadata is kept. Like the unlocked
+// copy, the copy of an encrypted document is written without encryption.
+func (d *Document) WriteProvenance(ctx context.Context, path string, p Provenance) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	root, err := d.pdf.Catalog()
+	if err != nil {
+		return err
+	}
+	xmp, err := provenanceXMP(d.pdf, root["Metadata"], p)
+	if err != nil {
+		return err
+	}
+	sd := types.StreamDict{
+		Dict: types.Dict{
+			"Type":    types.Name("Metadata"),
+			"Subtype": types.Name("XML"),
+		},
+		Content: xmp,
+	}
+	if err := sd.Encode(); err != nil {
+		return err
+	}
+	ref, err := d.pdf.IndRefForNewObject(sd)
+	if err != nil {
+		return err
+	}
+
+	// Only the copy gets the metadata; the document may still be written
+	// unlocked or extracted afterwards
+	prev, hadPrev := root["Metadata"]
+	root["Metadata"] = *ref
+	defer func() {
+		if hadPrev {
+			root["Metadata"] = prev
+		} else {
+			delete(root, "Metadata")
+		}
+	}()
+	return d.writeCopy(ctx, path)
+}
+
+// provenanceXMP returns the XMP packet of the copy: the existing metadata
+// with the provenance added, or a new packet if there is none
+func provenanceXMP(pdf *model.Context, meta types.Object, p Provenance) ([]byte, error) {
+	var xmp []byte
+	if meta != nil {
+		sd, _, err := pdf.DereferenceStreamDict(meta)
+		if err == nil && sd != nil && sd.Decode() == nil && bytes.Contains(sd.Content, []byte("</rdf:RDF>")) {
+			xmp = provenanceDesc.ReplaceAll(sd.Content, nil)
+		}
+	}
+
+	// All descriptions of a packet must be about the same resource
+	about := ""
+	if m := rdfAbout.FindSubmatch(xmp); m != nil {
+		about = string(m[2])
+	}
+	var desc bytes.Buffer
+	desc.WriteString(`<rdf:Description rdf:about="`)
+	xml.EscapeText(&desc, []byte(about))
+	desc.WriteString(`" xmlns:pixf="` + ProvenanceNS + `">` + "\n")
+	for _, prop := range []struct{ name, value string }{
+		{"ManifestSHA256", p.ManifestHash},
+		{"Version", p.Version},
+		{"ExtractedAt", p.ExtractedAt.UTC().Format(time.RFC3339)},
+	} {
+		fmt.Fprintf(&desc, "  <pixf:%s>", prop.name)
+		if err := xml.EscapeText(&desc, []byte(prop.value)); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&desc, "</pixf:%s>\n", prop.name)
+	}
+	desc.WriteString("</rdf:Description>\n")
+
+	if xmp != nil {
+		i := bytes.LastIndex(xmp, []byte("</rdf:RDF>"))
+		return append(xmp[:i:i], append(desc.Bytes(), xmp[i:]...)...), nil
+	}
+	var packet bytes.Buffer
+	packet.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	packet.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` + "\n")
+	packet.WriteString(`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n")
+	packet.Write(desc.Bytes())
+	packet.WriteString("</rdf:RDF>\n</x:xmpmeta>\n<?xpacket end=\"w\"?>\n")
+	return packet.Bytes(), nil
+}
@@ -0,0 +1,362 @@
+package imageHandling
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/hhrutter/pkcs7"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// ErrCertificateRequired is returned for a PDF encrypted to certificates
+// (public-key security) when no identity is given to open it with
+var ErrCertificateRequired = fmt.Errorf("%w to a certificate", ErrEncrypted)
+
+// Identity is a certificate and its private key, used to open PDFs
+// encrypted to certificates rather than passwords
+type Identity struct {
+	Cert *x509.Certificate
+	Key  crypto.PrivateKey
+}
+
+// LoadIdentity reads the certificate and private key of a PKCS#12 file
+// (.p12 or .pfx) protected by passphrase
+func LoadIdentity(path, passphrase string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, cert, _, err := pkcs12.DecodeChain(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return &Identity{Cert: cert, Key: key}, nil
+}
+
+// pdfcpu only implements the password-based standard security handler.
+// A PDF encrypted to certificates is opened by decrypting its file key
+// with the identity and appending an incremental update that replaces the
+// encryption dictionary with a standard one (revision 6, empty user
+// password) wrapping the same key. The update redefines the object the
+// trailer refers to, as pdfcpu takes the Encrypt entry of the oldest
+// trailer. Both handlers encrypt objects with the
+// file key alone under AES-256, so the rest of the file reads unchanged.
+// Older public-key encryption (RC4, AES-128) derives object keys
+// differently and can't be translated this way.
+
+// objHeader matches the start of an indirect object definition
+const objHeader = `(?:^|[^0-9])%d\s+%d\s+obj`
+
+// pubSecData returns the content of f with its public-key encryption
+// replaced for reading. ok is false when f isn't encrypted to certificates.
+func pubSecData(f io.ReadSeeker, id *Identity) (data []byte, ok bool, err error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+	if data, err = io.ReadAll(f); err != nil {
+		return nil, false, err
+	}
+	enc, trailer, xrefOffset, err := encryptDict(data)
+	if err != nil || enc == nil {
+		return nil, false, err
+	}
+	if filter := enc.NameEntry("Filter"); filter == nil || *filter != "Adobe.PubSec" {
+		return nil, false, nil
+	}
+	if id == nil {
+		return nil, true, ErrCertificateRequired
+	}
+
+	cfName, key, perms, encryptMetadata, err := pubSecKey(enc, id)
+	if err != nil {
+		return nil, true, err
+	}
+	std, err := standardEncryptDict(cfName, enc, key, perms, encryptMetadata)
+	if err != nil {
+		return nil, true, err
+	}
+	return append(data, encryptUpdate(len(data), std, trailer, xrefOffset)...), true, nil
+}
+
+// encryptDict finds the encryption dictionary and the trailer of the last
+// revision of a PDF; enc is nil when the PDF isn't encrypted
+func encryptDict(data []byte) (enc, trailer types.Dict, xrefOffset int, err error) {
+	i := bytes.LastIndex(data, []byte("startxref"))
+	if i < 0 {
+		return nil, nil, 0, errors.New("startxref not found")
+	}
+	fields := bytes.Fields(data[i+len("startxref"):])
+	if len(fields) == 0 {
+		return nil, nil, 0, errors.New("startxref offset missing")
+	}
+	if xrefOffset, err = strconv.Atoi(string(fields[0])); err != nil || xrefOffset < 0 || xrefOffset >= len(data) {
+		return nil, nil, 0, fmt.Errorf("invalid startxref offset %q", fields[0])
+	}
+
+	// A classic table is followed by its trailer; a cross-reference
+	// stream carries the trailer entries in its own dictionary
+	rest := data[xrefOffset:]
+	var dictStart int
+	if bytes.HasPrefix(bytes.TrimLeft(rest, " \t\r\n"), []byte("xref")) {
+		dictStart = bytes.Index(rest, []byte("trailer")) + len("trailer")
+	} else {
+		dictStart = bytes.Index(rest, []byte("obj")) + len("obj")
+	}
+	if dictStart < len("obj") {
+		return nil, nil, 0, errors.New("trailer not found")
+	}
+	if trailer, err = parseDict(rest[dictStart:]); err != nil {
+		return nil, nil, 0, fmt.Errorf("trailer: %w", err)
+	}
+
+	switch e := trailer["Encrypt"].(type) {
+	case nil:
+		return nil, trailer, xrefOffset, nil
+	case types.Dict:
+		return e, trailer, xrefOffset, nil
+	case types.IndirectRef:
+		// The encryption dictionary is never in an object stream; the last
+		// definition in the file is the current one
+		re := regexp.MustCompile(fmt.Sprintf(objHeader, e.ObjectNumber, e.GenerationNumber))
+		locs := re.FindAllIndex(data, -1)
+		if locs == nil {
+			return nil, nil, 0, fmt.Errorf("encryption dictionary %s not found", e)
+		}
+		if enc, err = parseDict(data[locs[len(locs)-1][1]:]); err != nil {
+			return nil, nil, 0, fmt.Errorf("encryption dictionary: %w", err)
+		}
+		return enc, trailer, xrefOffset, nil
+	default:
+		return nil, nil, 0, errors.New("invalid Encrypt entry")
+	}
+}
+
+// parseDict parses the dictionary at the start of b
+func parseDict(b []byte) (types.Dict, error) {
+	// Dictionaries this function reads are small; don't copy the whole file
+	s := string(b[:min(len(b), 1<<20)])
+	o, err := model.ParseObject(&s)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := o.(types.Dict)
+	if !ok {
+		return nil, errors.New("not a dictionary")
+	}
+	return d, nil
+}
+
+// pubSecKey decrypts the file key of a public-key encryption dictionary
+// with id. It returns the crypt filter used for streams, the key, the
+// permissions granted to id and whether metadata is encrypted.
+func pubSecKey(enc types.Dict, id *Identity) (cfName string, key []byte, perms int32, encryptMetadata bool, err error) {
+	v := enc.IntEntry("V")
+	stmF := enc.NameEntry("StmF")
+	cfs := enc.DictEntry("CF")
+	if v == nil || *v != 5 || stmF == nil || cfs == nil || cfs.DictEntry(*stmF) == nil {
+		return "", nil, 0, false, errors.New("certificate encryption with RC4 or AES-128 is not supported, only AES-256")
+	}
+	cf := cfs.DictEntry(*stmF)
+	if cfm := cf.NameEntry("CFM"); cfm == nil || *cfm != "AESV3" {
+		return "", nil, 0, false, errors.New("certificate encryption with RC4 or AES-128 is not supported, only AES-256")
+	}
+	if strF := enc.NameEntry("StrF"); strF != nil && *strF != *stmF && *strF != "Identity" {
+		return "", nil, 0, false, errors.New("separate crypt filters for strings and streams are not supported")
+	}
+	encryptMetadata = true
+	if b := cf.BooleanEntry("EncryptMetadata"); b != nil {
+		encryptMetadata = *b
+	}
+
+	var recipients [][]byte
+	switch r := cf["Recipients"].(type) {
+	case types.Array:
+		for _, o := range r {
+			b, err := stringBytes(o)
+			if err != nil {
+				return "", nil, 0, false, fmt.Errorf("recipients: %w", err)
+			}
+			recipients = append(recipients, b)
+		}
+	default:
+		b, err := stringBytes(r)
+		if err != nil {
+			return "", nil, 0, false, fmt.Errorf("recipients: %w", err)
+		}
+		recipients = append(recipients, b)
+	}
+
+	// Each recipient entry is a PKCS#7 envelope holding a 20-byte seed and
+	// the permissions of the certificates it is addressed to
+	var content []byte
+	for _, r := range recipients {
+		p7, err := pkcs7.Parse(r)
+		if err != nil {
+			continue
+		}
+		if c, err := p7.Decrypt(id.Cert, id.Key); err == nil && len(c) >= 24 {
+			content = c
+			break
+		}
+	}
+	if content == nil {
+		return "", nil, 0, false, fmt.Errorf("PDF is not encrypted to certificate %q", id.Cert.Subject.String())
+	}
+
+	h := sha256.New()
+	h.Write(content[:20])
+	for _, r := range recipients {
+		h.Write(r)
+	}
+	if !encryptMetadata {
+		h.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	}
+	perms = int32(binary.BigEndian.Uint32(content[20:24]))
+	return *stmF, h.Sum(nil), perms, encryptMetadata, nil
+}
+
+// stringBytes returns the bytes of a string object
+func stringBytes(o types.Object) ([]byte, error) {
+	switch s := o.(type) {
+	case types.StringLiteral:
+		return types.Unescape(s.Value())
+	case types.HexLiteral:
+		return s.Bytes()
+	}
+	return nil, errors.New("not a string")
+}
+
+// standardEncryptDict builds a standard (revision 6) encryption dictionary
+// that yields key for the empty user password. The owner password is
+// random and discarded.
+func standardEncryptDict(cfName string, enc types.Dict, key []byte, perms int32, encryptMetadata bool) (string, error) {
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		rand.Read(b)
+		return b
+	}
+	userSalts, ownerSalts := random(16), random(16)
+	owner := random(16)
+
+	u := append(hashR6(nil, userSalts[:8], nil), userSalts...)
+	ue, err := wrapKey(hashR6(nil, userSalts[8:], nil), key)
+	if err != nil {
+		return "", err
+	}
+	o := append(hashR6(owner, ownerSalts[:8], u), ownerSalts...)
+	oe, err := wrapKey(hashR6(owner, ownerSalts[8:], u), key)
+	if err != nil {
+		return "", err
+	}
+
+	// Perms repeats the permissions encrypted with the file key
+	p := make([]byte, 16)
+	binary.LittleEndian.PutUint32(p, uint32(perms))
+	copy(p[4:], []byte{0xff, 0xff, 0xff, 0xff, 'F', 'a', 'd', 'b'})
+	if encryptMetadata {
+		p[8] = 'T'
+	}
+	copy(p[12:], random(4))
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	block.Encrypt(p, p)
+
+	strF := cfName
+	if s := enc.NameEntry("StrF"); s != nil {
+		strF = *s
+	}
+	return fmt.Sprintf("<</Filter/Standard/V 5/R 6/Length 256/P %d/O<%x>/U<%x>/OE<%x>/UE<%x>/Perms<%x>"+
+		"/CF<<%s<</CFM/AESV3/AuthEvent/DocOpen/Length 32>>>>/StmF%s/StrF%s/EncryptMetadata %t>>",
+		perms, o, u, oe, ue, p, types.Name(cfName).PDFString(), types.Name(cfName).PDFString(),
+		types.Name(strF).PDFString(), encryptMetadata), nil
+}
+
+// hashR6 is the password hash of the standard security handler revision
+// 6 (ISO 32000-2, algorithm 2.B)
+func hashR6(password, salt, userKey []byte) []byte {
+	k0 := sha256.Sum256(append(append(append([]byte{}, password...), salt...), userKey...))
+	k := k0[:]
+	var e []byte
+	for round := 0; round < 64 || int(e[len(e)-1]) > round-32; round++ {
+		seq := append(append(append([]byte{}, password...), k...), userKey...)
+		k1 := bytes.Repeat(seq, 64)
+		block, _ := aes.NewCipher(k[:16])
+		e = make([]byte, len(k1))
+		cipher.NewCBCEncrypter(block, k[16:32]).CryptBlocks(e, k1)
+
+		// The first 16 bytes of e as a number, modulo 3
+		sum := 0
+		for _, b := range e[:16] {
+			sum += int(b)
+		}
+		switch sum % 3 {
+		case 0:
+			h := sha256.Sum256(e)
+			k = h[:]
+		case 1:
+			h := sha512.Sum384(e)
+			k = h[:]
+		default:
+			h := sha512.Sum512(e)
+			k = h[:]
+		}
+	}
+	return k[:32]
+}
+
+// wrapKey encrypts the file key for UE or OE: AES-256 in CBC mode with a
+// zero IV and no padding
+func wrapKey(kek, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(key))
+	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(out, key)
+	return out, nil
+}
+
+// encryptUpdate returns an incremental update for a file of size bytes
+// that makes std its encryption dictionary
+func encryptUpdate(size int, std string, trailer types.Dict, xrefOffset int) []byte {
+	objNr, genNr, newSize := 1, 0, 1
+	if n := trailer.IntEntry("Size"); n != nil {
+		objNr, newSize = *n, *n
+	}
+	if ref := trailer.IndirectRefEntry("Encrypt"); ref != nil {
+		objNr, genNr = ref.ObjectNumber.Value(), ref.GenerationNumber.Value()
+	}
+	newSize = max(newSize, objNr+1)
+
+	var b bytes.Buffer
+	b.WriteString("\n")
+	objOffset := size + b.Len()
+	fmt.Fprintf(&b, "%d %d obj\n%s\nendobj\n", objNr, genNr, std)
+	xref := size + b.Len()
+	fmt.Fprintf(&b, "xref\n%d 1\n%010d %05d n\r\ntrailer\n<</Size %d/Prev %d/Encrypt %d %d R",
+		objNr, objOffset, genNr, newSize, xrefOffset, objNr, genNr)
+	for _, k := range []string{"Root", "Info", "ID"} {
+		if o, ok := trailer[k]; ok && o != nil {
+			fmt.Fprintf(&b, "/%s %s", k, o.PDFString())
+		}
+	}
+	fmt.Fprintf(&b, ">>\nstartxref\n%d\n%%%%EOF\n", xref)
+	return b.Bytes()
+}
@@ -0,0 +1,160 @@
+package imageHandling
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hhrutter/pkcs7"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// testIdentity returns a self-signed certificate named name and its key
+func testIdentity(t *testing.T, name string, serial int64) (*Identity, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Identity{Cert: cert, Key: key}, key
+}
+
+// pubSecPDF returns a PDF encrypted to id with the filter version and
+// method, and the file key it is encrypted with. The PDF has no strings
+// or streams, so the key is only checked by how it opens.
+func pubSecPDF(t *testing.T, id *Identity, v int, cfm string, perms int32, encryptMetadata bool) ([]byte, []byte) {
+	t.Helper()
+	content := append(bytes.Repeat([]byte{7}, 20), binary.BigEndian.AppendUint32(nil, uint32(perms))...)
+	recipient, err := pkcs7.Encrypt(content, []*x509.Certificate{id.Cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.New()
+	h.Write(content[:20])
+	h.Write(recipient)
+	if !encryptMetadata {
+		h.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	}
+
+	enc := fmt.Sprintf("<< /Filter /Adobe.PubSec /SubFilter /adbe.pkcs7.s5 /V %d /Length 256 "+
+		"/CF << /DefaultCryptFilter << /CFM /%s /AuthEvent /DocOpen /Recipients [<%x>] /EncryptMetadata %t >> >> "+
+		"/StmF /DefaultCryptFilter /StrF /DefaultCryptFilter >>", v, cfm, recipient, encryptMetadata)
+	id16 := bytes.Repeat([]byte{0xab}, 16)
+	return buildPDF([]string{enc}, fmt.Sprintf("/Encrypt 4 0 R /ID [<%x> <%x>] ", id16, id16)), h.Sum(nil)
+}
+
+func TestOpenDocumentCertificate(t *testing.T) {
+	recipient, _ := testIdentity(t, "Recipient", 1)
+	other, _ := testIdentity(t, "Other", 2)
+	const all, noExtract = int32(-4), int32(-4 &^ 0x10)
+
+	tests := []struct {
+		name            string
+		id              *Identity
+		v               int
+		cfm             string
+		perms           int32
+		encryptMetadata bool
+		err             error  // Wrapped error
+		errText         string // Part of the error message
+		allows          bool
+	}{
+		{name: "recipient", id: recipient, v: 5, cfm: "AESV3", perms: all, encryptMetadata: true, allows: true},
+		{name: "metadata unencrypted", id: recipient, v: 5, cfm: "AESV3", perms: all, allows: true},
+		{name: "no extraction", id: recipient, v: 5, cfm: "AESV3", perms: noExtract, encryptMetadata: true, allows: false},
+		{name: "no identity", v: 5, cfm: "AESV3", perms: all, err: ErrCertificateRequired},
+		{name: "other identity", id: other, v: 5, cfm: "AESV3", perms: all, errText: "not encrypted to certificate"},
+		{name: "aes-128", id: recipient, v: 4, cfm: "AESV2", perms: all, errText: "only AES-256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, key := pubSecPDF(t, recipient, tt.v, tt.cfm, tt.perms, tt.encryptMetadata)
+			doc, err := openDocumentData(context.Background(), "test.pdf", data, Credentials{Identity: tt.id})
+			if tt.err != nil || tt.errText != "" {
+				if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("error %v, want %v %q", err, tt.err, tt.errText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer doc.Close()
+			if !bytes.Equal(doc.pdf.EncKey, key) {
+				t.Errorf("file key %x, want %x", doc.pdf.EncKey, key)
+			}
+			if !doc.Protected() {
+				t.Error("not protected")
+			}
+			if doc.AllowsExtraction() != tt.allows {
+				t.Errorf("allows extraction %t, want %t", doc.AllowsExtraction(), tt.allows)
+			}
+		})
+	}
+}
+
+func TestLoadIdentity(t *testing.T) {
+	id, key := testIdentity(t, "Recipient", 1)
+	pfx, err := pkcs12.Modern.Encode(key, id.Cert, nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id.p12")
+	if err := os.WriteFile(path, pfx, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, path, passphrase string
+		err                    error // nil = loads
+	}{
+		{name: "ok", path: path, passphrase: "secret"},
+		{name: "wrong passphrase", path: path, passphrase: "public", err: pkcs12.ErrIncorrectPassword},
+		{name: "missing", path: path + ".missing", passphrase: "secret", err: os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadIdentity(tt.path, tt.passphrase)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Cert.Equal(id.Cert) || !key.Equal(got.Key) {
+				t.Error("loaded a different certificate or key")
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package imageHandling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Tile pyramid layouts accepted by Options.Pyramid
+const (
+	PyramidDZI  = "dzi"  // Deep Zoom: <image>.dzi and <image>_files/<level>/<col>_<row>
+	PyramidIIIF = "iiif" // IIIF Image API 3.0 level 0: <image>/info.json and static tiles
+)
+
+// Tile sizes of the pyramids, the defaults of common viewers
+const (
+	dziTileSize  = 254 // 256 with the overlap on both sides
+	dziOverlap   = 1
+	iiifTileSize = 512
+)
+
+// pyramidJob is a tile of a pyramid to encode: rect of level, written to
+// name within the image directory
+type pyramidJob struct {
+	level *image.RGBA
+	rect  image.Rectangle
+	name  string
+}
+
+// writePyramids writes a tile pyramid of every image next to it in the
+// layout opts.Pyramid, with tiles encoded like the images, and returns the
+// file describing each pyramid. Tiles are encoded on the encode pool.
+func (e *Extractor) writePyramids(ctx context.Context, imgDir string, images []LoadedImage, encoder ImageEncoder, opts Options) ([]string, error) {
+	ext := encoder.Extension()
+	layout := strings.ToLower(opts.Pyramid)
+	if layout != PyramidDZI && layout != PyramidIIIF {
+		return nil, fmt.Errorf("unsupported pyramid layout: %s", opts.Pyramid)
+	}
+
+	described := make([]string, len(images))
+	for i := range images {
+		img := images[i].Img
+		stem := imageName(images[i], "")
+		var jobs []pyramidJob
+		var err error
+		if layout == PyramidDZI {
+			described[i], jobs, err = dziPyramid(imgDir, stem, img, ext)
+		} else {
+			described[i], jobs, err = iiifPyramid(imgDir, stem, img, ext, opts.IIIFBase)
+		}
+		if err == nil {
+			err = e.encodeTiles(ctx, imgDir, jobs, encoder)
+		}
+		for _, level := range uniqueLevels(jobs) {
+			if level != img {
+				putRGBA(level)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pyramid of %s: %w", stem, err)
+		}
+	}
+	return described, nil
+}
+
+// dziPyramid writes the Deep Zoom descriptor of img and returns the tiles
+// to write. Level 0 is a single pixel; every level doubles the size up to
+// the image at the last.
+func dziPyramid(imgDir, stem string, img *image.RGBA, ext string) (string, []pyramidJob, error) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	maxLevel := 0
+	for 1<<maxLevel < max(w, h) {
+		maxLevel++
+	}
+
+	var jobs []pyramidJob
+	level := img
+	for l := maxLevel; l >= 0; l-- {
+		if l < maxLevel {
+			level = halve(level)
+		}
+		lw, lh := level.Rect.Dx(), level.Rect.Dy()
+		dir := path.Join(stem+"_files", fmt.Sprint(l))
+		if err := os.MkdirAll(filepath.Join(imgDir, filepath.FromSlash(dir)), 0755); err != nil {
+			return "", nil, err
+		}
+		for col := 0; col*dziTileSize < lw; col++ {
+			for row := 0; row*dziTileSize < lh; row++ {
+				r := image.Rect(col*dziTileSize-dziOverlap, row*dziTileSize-dziOverlap,
+					(col+1)*dziTileSize+dziOverlap, (row+1)*dziTileSize+dziOverlap).Intersect(image.Rect(0, 0, lw, lh))
+				jobs = append(jobs, pyramidJob{level, r.Add(level.Rect.Min), path.Join(dir, fmt.Sprintf("%d_%d%s", col, row, ext))})
+			}
+		}
+	}
+
+	name := stem + ".dzi"
+	dzi := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Image xmlns="http://schemas.microsoft.com/deepzoom/2008" Format="%s" Overlap="%d" TileSize="%d">
+  <Size Width="%d" Height="%d"/>
+</Image>
+`, strings.TrimPrefix(ext, "."), dziOverlap, dziTileSize, w, h)
+	if err := os.WriteFile(filepath.Join(imgDir, filepath.FromSlash(name)), []byte(dzi), 0644); err != nil {
+		return "", nil, fmt.Errorf("write %s: %w", name, err)
+	}
+	return name, jobs, nil
+}
+
+// iiifPyramid writes the info.json of img as a level 0 IIIF image service
+// at base (or the image's path, if base is "") and returns the tiles to
+// write, at the paths viewers request: {region}/{w},{h}/0/default.<ext>.
+// Scale factors double until the image fits in a tile; that level is
+// also written as the full image, which viewers ask for as "full".
+func iiifPyramid(imgDir, stem string, img *image.RGBA, ext, base string) (string, []pyramidJob, error) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	format := strings.TrimPrefix(ext, ".")
+
+	var jobs []pyramidJob
+	var factors []int
+	var sizes []iiifSize
+	level := img
+	for sf := 1; ; sf *= 2 {
+		if sf > 1 {
+			level = halve(level)
+		}
+		factors = append(factors, sf)
+		lw, lh := level.Rect.Dx(), level.Rect.Dy()
+		for y := 0; y < h; y += iiifTileSize * sf {
+			for x := 0; x < w; x += iiifTileSize * sf {
+				rw, rh := min(iiifTileSize*sf, w-x), min(iiifTileSize*sf, h-y)
+				r := image.Rect(x/sf, y/sf, x/sf+(rw+sf-1)/sf, y/sf+(rh+sf-1)/sf)
+				name := fmt.Sprintf("%d,%d,%d,%d/%d,%d/0/default%s", x, y, rw, rh, r.Dx(), r.Dy(), ext)
+				jobs = append(jobs, pyramidJob{level, r.Add(level.Rect.Min), path.Join(stem, name)})
+			}
+		}
+		if lw <= iiifTileSize && lh <= iiifTileSize {
+			name := fmt.Sprintf("full/%d,%d/0/default%s", lw, lh, ext)
+			jobs = append(jobs, pyramidJob{level, level.Rect, path.Join(stem, name)})
+			sizes = append(sizes, iiifSize{lw, lh})
+			break
+		}
+	}
+	for _, job := range jobs {
+		if err := os.MkdirAll(filepath.Join(imgDir, filepath.FromSlash(path.Dir(job.name))), 0755); err != nil {
+			return "", nil, err
+		}
+	}
+
+	id := stem
+	if base != "" {
+		id = strings.TrimSuffix(base, "/") + "/" + stem
+	}
+	info, err := json.MarshalIndent(iiifInfo{
+		Context:          "http://iiif.io/api/image/3/context.json",
+		ID:               id,
+		Type:             "ImageService3",
+		Protocol:         "http://iiif.io/api/image",
+		Profile:          "level0",
+		Width:            w,
+		Height:           h,
+		Tiles:            []iiifTiles{{Width: iiifTileSize, ScaleFactors: factors}},
+		Sizes:            sizes,
+		PreferredFormats: []string{format},
+		ExtraFormats:     []string{format},
+	}, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	name := path.Join(stem, "info.json")
+	if err := os.WriteFile(filepath.Join(imgDir, filepath.FromSlash(name)), info, 0644); err != nil {
+		return "", nil, fmt.Errorf("write %s: %w", name, err)
+	}
+	return name, jobs, nil
+}
+
+// iiifInfo is the info.json of a IIIF image service
+type iiifInfo struct {
+	Context          string      `json:"@context"`
+	ID               string      `json:"id"`
+	Type             string      `json:"type"`
+	Protocol         string      `json:"protocol"`
+	Profile          string      `json:"profile"`
+	Width            int         `json:"width"`
+	Height           int         `json:"height"`
+	Tiles            []iiifTiles `json:"tiles"`
+	Sizes            []iiifSize  `json:"sizes"`
+	PreferredFormats []string    `json:"preferredFormats"`
+	ExtraFormats     []string    `json:"extraFormats"`
+}
+
+type iiifTiles struct {
+	Width        int   `json:"width"`
+	ScaleFactors []int `json:"scaleFactors"`
+}
+
+type iiifSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// halve scales img to half its size, rounding up
+func halve(img *image.RGBA) *image.RGBA {
+	b := img.Rect
+	dst := getRGBA(image.Rect(0, 0, (b.Dx()+1)/2, (b.Dy()+1)/2))
+	draw.BiLinear.Scale(dst, dst.Rect, img, b, draw.Src, nil)
+	return dst
+}
+
+// encodeTiles crops, encodes and writes the tiles on the encode pool
+func (e *Extractor) encodeTiles(ctx context.Context, imgDir string, jobs []pyramidJob, encoder ImageEncoder) error {
+	r := newRun(ctx)
+	for _, job := range jobs {
+		ok := r.submit(e.encode, func() error {
+			tile := cropCopy(job.level, job.rect)
+			defer putRGBA(tile)
+			buf, err := encodeImage(tile, encoder)
+			if err != nil {
+				return fmt.Errorf("encode %s: %w", job.name, err)
+			}
+			defer putBuffer(buf)
+			if err := os.WriteFile(filepath.Join(imgDir, filepath.FromSlash(job.name)), buf.Bytes(), 0644); err != nil {
+				return fmt.Errorf("write %s: %w", job.name, err)
+			}
+			return nil
+		})
+		if !ok {
+			break
+		}
+	}
+	return r.wait()
+}
+
+// uniqueLevels returns the level images the jobs cut tiles from
+func uniqueLevels(jobs []pyramidJob) []*image.RGBA {
+	seen := make(map[*image.RGBA]bool)
+	var levels []*image.RGBA
+	for _, job := range jobs {
+		if !seen[job.level] {
+			seen[job.level] = true
+			levels = append(levels, job.level)
+		}
+	}
+	return levels
+}
@@ -0,0 +1,66 @@
+package imageHandling
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// QuarantineDirName is the output subdirectory for undecodable images
+const QuarantineDirName = "quarantine"
+
+// SkippedImage is an image left out of the output, with the reason. Its
+// raw bytes are kept in the quarantine folder as File.
+type SkippedImage struct {
+	File     string `json:"file"`
+	Page     int    `json:"page"`
+	ObjNr    int    `json:"obj_nr"`
+	Stage    string `json:"stage"` // extract or decode
+	Reason   string `json:"reason"`
+	Document string `json:"document,omitempty"` // PDF within the archive the image comes from
+}
+
+// skipLog quarantines the images of one extraction that can't be used and
+// records why, so one bad stream costs that image and not the run
+type skipLog struct {
+	imgDir  string
+	skipped []SkippedImage
+	events  *eventLog
+	notices io.Writer
+}
+
+// skip quarantines src as name and reports it
+func (l *skipLog) skip(name, src string, page, objNr int, stage string, reason error) error {
+	if err := quarantine(l.imgDir, name, src, fmt.Errorf("%s: %w", stage, reason)); err != nil {
+		return err
+	}
+	noticef(l.notices, "image skipped: page %d, object %d: %s: %v", page, objNr, stage, reason)
+	l.events.emit(Event{Type: EventError, Page: page, ObjNr: objNr, Stage: stage, Error: reason.Error()})
+	l.skipped = append(l.skipped, SkippedImage{
+		File:   QuarantineDirName + "/" + name,
+		Page:   page,
+		ObjNr:  objNr,
+		Stage:  stage,
+		Reason: reason.Error(),
+	})
+	return nil
+}
+
+// quarantine copies the raw file of an undecodable image plus a reason file
+func quarantine(imgDir, name, src string, reason error) error {
+	dir := filepath.Join(imgDir, QuarantineDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create quarantine dir: %w", err)
+	}
+
+	if err := copyFile(filepath.Join(dir, name), src); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+
+	reasonPath := filepath.Join(dir, name+".reason.txt")
+	if err := os.WriteFile(reasonPath, []byte(reason.Error()+"\n"), 0644); err != nil {
+		return fmt.Errorf("write %s: %w", reasonPath, err)
+	}
+	return nil
+}
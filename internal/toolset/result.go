@@ -0,0 +1,72 @@
+package imageHandling
+
+import (
+	"os"
+	"time"
+)
+
+// Result summarizes an extraction
+type Result struct {
+	Documents  int     `json:"documents,omitempty"` // PDFs extracted, for archives and batches
+	Failed     int     `json:"failed,omitempty"`    // PDFs that couldn't be extracted
+	Images     int     `json:"images"`              // Unique images written
+	Duplicates int     `json:"duplicates"`          // Duplicate images left out
+	Errors     int     `json:"errors"`              // Images skipped into quarantine
+	LowDPI     int     `json:"low_dpi,omitempty"`   // Images drawn below Options.MinDPI
+	Truncated  int     `json:"truncated,omitempty"` // Images left out over Options.MaxTotalOutput
+	BytesIn    int64   `json:"bytes_in"`            // Size of the input
+	BytesOut   int64   `json:"bytes_out"`           // Size of the images written
+	Seconds    float64 `json:"seconds"`             // Time the extraction took
+}
+
+// Throughput is the input processed per second, in bytes
+func (r *Result) Throughput() float64 {
+	if r.Seconds <= 0 {
+		return 0
+	}
+	return float64(r.BytesIn) / r.Seconds
+}
+
+// Add adds the counts and sizes of o to r; the times add up too, so
+// callers running extractions side by side set Seconds themselves
+func (r *Result) Add(o *Result) {
+	if o == nil {
+		return
+	}
+	r.Documents += o.Documents
+	r.Failed += o.Failed
+	r.Images += o.Images
+	r.Duplicates += o.Duplicates
+	r.Errors += o.Errors
+	r.LowDPI += o.LowDPI
+	r.Truncated += o.Truncated
+	r.BytesIn += o.BytesIn
+	r.BytesOut += o.BytesOut
+	r.Seconds = roundMillis(r.Seconds + o.Seconds)
+}
+
+// result summarizes the extraction m records, which left out dups
+// duplicates besides the images already in the dedup index
+func (m *Manifest) result(dups int) *Result {
+	r := &Result{
+		Images:     len(m.Images),
+		Duplicates: dups + m.Indexed,
+		Errors:     len(m.Skipped),
+		Seconds:    roundMillis(time.Since(m.CreatedAt).Seconds()),
+	}
+	if m.memory != nil {
+		r.BytesIn = m.memory.size
+	} else if info, err := os.Stat(m.source); err == nil {
+		r.BytesIn = info.Size()
+	}
+	for _, t := range m.Truncated {
+		r.Truncated += t.Omitted
+	}
+	for _, img := range m.Images {
+		r.BytesOut += img.Bytes
+		if img.LowDPI {
+			r.LowDPI++
+		}
+	}
+	return r
+}
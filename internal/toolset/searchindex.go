@@ -0,0 +1,233 @@
+package imageHandling
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SearchIndexName is the default file of a search index
+const SearchIndexName = "pixf-index.jsonl"
+
+// SearchEntry is an image of an output directory in a search index
+type SearchEntry struct {
+	File      string `json:"file"`               // Absolute path of the image
+	Input     string `json:"input"`              // PDF or archive it was extracted from
+	InputHash string `json:"input_sha256"`       // SHA-256 of the input
+	Document  string `json:"document,omitempty"` // PDF within the archive, or embedded PDF
+	Page      int    `json:"page"`
+	Label     string `json:"label,omitempty"` // Caption the file is named after
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	SHA256    string `json:"sha256"`          // Of the image file
+	Hash      string `json:"phash,omitempty"` // Perceptual hash ("" = undecodable image)
+
+	phash uint64
+}
+
+// SearchHit is an image found by SearchImage
+type SearchHit struct {
+	Entry    SearchEntry `json:"image"`
+	Exact    bool        `json:"exact"`    // Identical encoded image
+	Distance int         `json:"distance"` // dHash distance, 0 for exact matches
+}
+
+// BuildSearchIndex indexes the images of every output directory below
+// dirs, found by their manifest, with the input and page they come from
+// and their perceptual hash. Directories still being written are left out,
+// as are images no longer on disk.
+func BuildSearchIndex(dirs []string) (entries []SearchEntry, extractions int, err error) {
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && strings.HasSuffix(d.Name(), StagingSuffix) {
+				return filepath.SkipDir
+			}
+			if d.IsDir() || d.Name() != ManifestName {
+				return nil
+			}
+			found, err := indexManifest(filepath.Dir(p))
+			if err != nil {
+				return err
+			}
+			entries = append(entries, found...)
+			extractions++
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return entries, extractions, nil
+}
+
+// indexManifest returns the search entries of the images of imgDir
+func indexManifest(imgDir string) ([]SearchEntry, error) {
+	m, err := ReadManifest(imgDir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", imgDir, err)
+	}
+	imgDir, err = filepath.Abs(imgDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []SearchEntry
+	for _, img := range m.Images {
+		file := filepath.Join(imgDir, filepath.FromSlash(img.File))
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", file, err)
+		}
+		entry := SearchEntry{
+			File:      file,
+			Input:     m.Input,
+			InputHash: m.InputHash,
+			Document:  img.Document,
+			Page:      img.Page,
+			Label:     img.Label,
+			Width:     img.Width,
+			Height:    img.Height,
+			SHA256:    fmt.Sprintf("%x", sha256.Sum256(data)),
+		}
+		if member, ok := hashMember(file, img.Page, data); ok {
+			entry.Hash, entry.phash = member.Hash, member.phash
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// SaveSearchIndex writes entries as an index file for LoadSearchIndex
+func SaveSearchIndex(path string, entries []SearchEntry) error {
+	return writeJSONLines(path, entries)
+}
+
+// LoadSearchIndex reads an index file written by SaveSearchIndex
+func LoadSearchIndex(path string) ([]SearchEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []SearchEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e SearchEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.File == "" {
+			return nil, fmt.Errorf("%s:%d: not a search index entry", path, n)
+		}
+		if e.Hash != "" {
+			if e.phash, err = strconv.ParseUint(e.Hash, 16, 64); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid phash %q", path, n, e.Hash)
+			}
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// SearchText returns the entries matching every word of query: each must
+// begin a word of the input, document, caption or file name, ignoring
+// case. "invoice 2023" finds the images of invoice_2023-03.pdf.
+func SearchText(entries []SearchEntry, query string) []SearchEntry {
+	terms := searchWords(query)
+	found := []SearchEntry{}
+	if len(terms) == 0 {
+		return found
+	}
+	for _, e := range entries {
+		words := searchWords(strings.Join([]string{e.Input, e.Document, e.Label, path.Base(filepath.ToSlash(e.File))}, " "))
+		if matchesAll(terms, words) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// searchWords splits s into lower-case words of letters and digits
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchesAll reports whether every term begins one of words
+func matchesAll(terms, words []string) bool {
+	for _, t := range terms {
+		found := false
+		for _, w := range words {
+			if strings.HasPrefix(w, t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchImage finds the indexed images identical to the image file, or
+// within threshold dHash distance of it, closest first
+func SearchImage(entries []SearchEntry, file string, threshold int) ([]SearchHit, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	query, ok := hashMember(file, 0, data)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a decodable image", file)
+	}
+
+	hits := []SearchHit{}
+	for _, e := range entries {
+		switch {
+		case e.SHA256 == query.fileHash:
+			hits = append(hits, SearchHit{Entry: e, Exact: true})
+		case e.Hash != "":
+			if d := HammingDistance(query.phash, e.phash); d <= threshold {
+				hits = append(hits, SearchHit{Entry: e, Distance: d})
+			}
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Exact != hits[j].Exact {
+			return hits[i].Exact
+		}
+		return hits[i].Distance < hits[j].Distance
+	})
+	return hits, nil
+}
+
+// String formats an entry for text reports: its input, page and file
+func (e SearchEntry) String() string {
+	source := e.Input
+	if e.Document != "" {
+		source += "/" + e.Document
+	}
+	return fmt.Sprintf("%s page %d: %s", source, e.Page, e.File)
+}
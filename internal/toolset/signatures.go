@@ -0,0 +1,168 @@
+package imageHandling
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// SignatureInfo describes a digital signature of a PDF
+type SignatureInfo struct {
+	Type        string    `json:"type"`                   // form, page, usage rights or timestamp
+	Field       string    `json:"field,omitempty"`        // Name of the signature field
+	Signer      string    `json:"signer"`                 // Name given by the signer, else the certificate subject
+	SigningTime time.Time `json:"signing_time,omitzero"`  // As claimed by the signer or timestamp
+	Page        int       `json:"page,omitempty"`         // Page showing the signature (0 = invisible)
+	Certified   bool      `json:"certified"`              // Certification signature restricting later changes
+	Status      string    `json:"status"`                 // valid, invalid or unknown
+	Reason      string    `json:"reason"`                 // Why the status was given
+	Problems    []string  `json:"problems,omitempty"`     // Details of what failed to validate
+	DocModified string    `json:"doc_modified,omitempty"` // Whether the document changed after signing (yes, no or "" = unknown)
+}
+
+// sigMu guards model.UserCertPool
+var sigMu sync.Mutex
+
+// Signatures lists and validates the digital signatures of filename.
+// Certificates are checked against roots, or the system roots if roots is
+// nil; revocation isn't checked, since that would go online. Documents
+// without signatures give an empty list.
+func Signatures(ctx context.Context, filename string, roots *x509.CertPool) ([]SignatureInfo, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	conf := model.NewDefaultConfiguration()
+	conf.Cmd = model.VALIDATESIGNATURE
+	conf.Offline = true
+	var pdf *model.Context
+	err = RunContext(ctx, func() (err error) {
+		pdf, err = api.ReadValidateAndOptimize(f, conf)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pdf.Signatures) == 0 && !pdf.SignatureExist && !pdf.AppendOnly && pdf.URSignature == nil {
+		return nil, nil
+	}
+
+	// pdfcpu takes the roots from a package variable, so signatures are
+	// validated one document at a time
+	sigMu.Lock()
+	defer sigMu.Unlock()
+	model.UserCertPool = roots
+	var results []*model.SignatureValidationResult
+	err = RunContext(ctx, func() (err error) {
+		results, err = pdfcpu.ValidateSignatures(f, pdf, true)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("validate signatures: %w", err)
+	}
+
+	sigs := make([]SignatureInfo, 0, len(results))
+	for _, r := range results {
+		sig := SignatureInfo{
+			Type:        signatureType(r.Signature.Type),
+			Field:       r.Details.FieldName,
+			Signer:      r.Details.SignerName,
+			SigningTime: r.Details.SigningTime,
+			Certified:   r.Signature.Certified,
+			Status:      signatureStatus(r.Status),
+			Reason:      r.Reason.String(),
+			Problems:    r.Problems,
+		}
+		if sig.Signer == "" {
+			sig.Signer = signerIdentity(r.Details)
+		}
+		if r.Signature.Visible {
+			sig.Page = r.Signature.PageNr
+		}
+		if r.Signature.Type != model.SigTypeDTS {
+			sig.DocModified = map[int]string{model.True: "yes", model.False: "no"}[r.DocModified]
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// signerIdentity is the common name of the signing certificate. pdfcpu
+// only fills in SignerIdentity when the chain verifies, so the leaf of an
+// untrusted chain is used otherwise.
+func signerIdentity(d model.SignatureDetails) string {
+	if d.SignerIdentity != "Unknown" && d.SignerIdentity != "" {
+		return d.SignerIdentity
+	}
+	for _, s := range d.Signers {
+		if s != nil && s.Certificate != nil && s.Certificate.Subject != "" {
+			return s.Certificate.Subject
+		}
+	}
+	return "unknown"
+}
+
+// signatureType names a pdfcpu signature type
+func signatureType(t int) string {
+	switch t {
+	case model.SigTypeForm:
+		return "form"
+	case model.SigTypePage:
+		return "page"
+	case model.SigTypeUR:
+		return "usage rights"
+	default:
+		return "timestamp"
+	}
+}
+
+// signatureStatus names a pdfcpu signature status
+func signatureStatus(s model.SignatureStatus) string {
+	switch s {
+	case model.SignatureStatusValid:
+		return "valid"
+	case model.SignatureStatusInvalid:
+		return "invalid"
+	default:
+		return "unknown"
+	}
+}
+
+// ValidateCommand runs an external signature validator on filename, for
+// checks pdfcpu can't do such as revocation or a qualified trust list.
+// Command is split on spaces; the argument {in} is replaced by the PDF.
+// The validator accepts the signatures by exiting with status 0. Its
+// output is returned either way; err is only set when it couldn't run.
+func ValidateCommand(ctx context.Context, command, filename string) (valid bool, output string, err error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return false, "", errors.New("empty validator command")
+	}
+	r := strings.NewReplacer("{in}", filename)
+	for i := range args {
+		args[i] = r.Replace(args[i])
+	}
+
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	output = strings.TrimSpace(string(out))
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return true, output, nil
+	case errors.As(err, &exitErr) && ctx.Err() == nil:
+		return false, output, nil
+	}
+	return false, output, fmt.Errorf("%s: %w", args[0], err)
+}
@@ -0,0 +1,94 @@
+package imageHandling
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is how much of a file content type detection looks at
+const sniffLen = 512
+
+// Extensions of the image types detected by content
+var sniffedExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/bmp":  ".bmp",
+	"image/webp": ".webp",
+}
+
+// Signatures of the image types http.DetectContentType doesn't know:
+// TIFF, little and big endian, and JPEG 2000, which pdfcpu extracts from
+// JPXDecode streams, as a JP2 file or a raw codestream
+var headerExts = []struct {
+	header []byte
+	ext    string
+}{
+	{[]byte("II*\x00"), ".tif"},
+	{[]byte("MM\x00*"), ".tif"},
+	{[]byte("\x00\x00\x00\x0cjP  \r\n\x87\n"), ".jp2"},
+	{[]byte("\xff\x4f\xff\x51"), ".j2k"},
+}
+
+// imageExt returns the extension of the image type of the file at path,
+// told by its first bytes rather than its name, e.g. ".png" for a PNG
+// named .jpg or without extension ("" = not an image)
+func imageExt(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ""
+	}
+	return sniffImage(head[:n])
+}
+
+// sniffImage returns the extension of the image type head starts with
+// ("" = not an image)
+func sniffImage(head []byte) string {
+	for _, h := range headerExts {
+		if bytes.HasPrefix(head, h.header) {
+			return h.ext
+		}
+	}
+	return sniffedExts[http.DetectContentType(head)]
+}
+
+// isImageFile reports whether the file at path is an image, by content
+func isImageFile(path string) bool {
+	return imageExt(path) != ""
+}
+
+// sameImageExt reports whether ext, as found in a file name, stands for
+// the image type of sniffed
+func sameImageExt(ext, sniffed string) bool {
+	switch ext = strings.ToLower(ext); ext {
+	case ".jpeg":
+		ext = ".jpg"
+	case ".tiff":
+		ext = ".tif"
+	case ".jpx", ".jpf":
+		ext = ".jp2"
+	case ".j2c":
+		ext = ".j2k"
+	}
+	return ext == sniffed
+}
+
+// withImageExt returns name with the extension of its sniffed image type,
+// unless its own already stands for it
+func withImageExt(name, sniffed string) string {
+	ext := filepath.Ext(name)
+	if sameImageExt(ext, sniffed) {
+		return name
+	}
+	return strings.TrimSuffix(name, ext) + sniffed
+}
@@ -0,0 +1,98 @@
+package imageHandling
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// encoded returns a small image encoded with encode
+func encoded(t *testing.T, encode func(*bytes.Buffer, image.Image) error) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSniffImage(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"jpeg", encoded(t, func(b *bytes.Buffer, img image.Image) error { return jpeg.Encode(b, img, nil) }), ".jpg"},
+		{"png", encoded(t, func(b *bytes.Buffer, img image.Image) error { return png.Encode(b, img) }), ".png"},
+		{"gif", encoded(t, func(b *bytes.Buffer, img image.Image) error { return gif.Encode(b, img, nil) }), ".gif"},
+		{"bmp", []byte("BM\x36\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00"), ".bmp"},
+		{"webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), ".webp"},
+		{"tiff/le", []byte("II*\x00\x08\x00\x00\x00"), ".tif"},
+		{"tiff/be", []byte("MM\x00*\x00\x00\x00\x08"), ".tif"},
+		{"jp2", []byte("\x00\x00\x00\x0cjP  \r\n\x87\n\x00\x00\x00\x14ftypjp2 "), ".jp2"},
+		{"j2k", []byte("\xff\x4f\xff\x51\x00\x2f\x00\x00"), ".j2k"},
+		{"pdf", []byte("%PDF-1.7\n"), ""},
+		{"text", []byte("hello"), ""},
+		{"empty", nil, ""},
+		{"truncated jp2", []byte("\x00\x00\x00\x0cjP"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffImage(tt.head); got != tt.want {
+				t.Errorf("sniffImage = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithImageExt(t *testing.T) {
+	tests := []struct {
+		name, sniffed, want string
+	}{
+		{"img.png", ".png", "img.png"},
+		{"img.jpeg", ".jpg", "img.jpeg"},
+		{"img.TIFF", ".tif", "img.TIFF"},
+		{"img.jpx", ".jp2", "img.jpx"},
+		{"img.jpx", ".j2k", "img.j2k"},
+		{"img.jpg", ".png", "img.png"},
+		{"img", ".webp", "img.webp"},
+	}
+	for _, tt := range tests {
+		if got := withImageExt(tt.name, tt.sniffed); got != tt.want {
+			t.Errorf("withImageExt(%q, %q) = %q, want %q", tt.name, tt.sniffed, got, tt.want)
+		}
+	}
+}
+
+func TestLoadImagesQuarantinesUnknown(t *testing.T) {
+	dir, imgDir := t.TempDir(), t.TempDir()
+	files := []extractedFile{
+		{Name: "a.jpx", Page: 1, ObjNr: 5},
+		{Name: "b.bin", Page: 2, ObjNr: 6},
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.jpx"), []byte("\x00\x00\x00\x0cjP  \r\n\x87\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.bin"), []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	skips := &skipLog{imgDir: imgDir}
+	images, err := loadImages(dir, files, skips)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || images[0].OrigName != "a.jpx" {
+		t.Errorf("loaded %+v, want a.jpx only", images)
+	}
+	if len(skips.skipped) != 1 || skips.skipped[0].File != QuarantineDirName+"/b.bin" {
+		t.Fatalf("skipped %+v, want b.bin", skips.skipped)
+	}
+	if _, err := os.Stat(filepath.Join(imgDir, QuarantineDirName, "b.bin")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,113 @@
+package imageHandling
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+)
+
+// Image halves of a split spread, as recorded in the manifest
+const (
+	PartLeft  = "left"
+	PartRight = "right"
+)
+
+// minGutterContrast is how much the gutter's mean brightness must differ
+// from the middle of the spread around it; weaker gutters are guessed to
+// be in the center
+const minGutterContrast = 16
+
+// splitSpreads cuts each landscape image into a left and a right page at
+// its gutter. Halves take the place of the spread, so output numbering
+// follows reading order. Portrait images are single pages and kept whole.
+func (e *Extractor) splitSpreads(ctx context.Context, images []LoadedImage, dups []duplicate, notices io.Writer) ([]LoadedImage, []duplicate, error) {
+	halves := make([][2]*image.RGBA, len(images))
+
+	r := newRun(ctx)
+	for i := range images {
+		img := images[i].Img
+		if img.Rect.Dx() <= img.Rect.Dy() {
+			continue
+		}
+		ok := r.submit(e.encode, func() error {
+			left, right := img.Rect, img.Rect
+			left.Max.X = img.Rect.Min.X + gutterColumn(img)
+			right.Min.X = left.Max.X
+			halves[i][0], halves[i][1] = cropCopy(img, left), cropCopy(img, right)
+			return nil
+		})
+		if !ok {
+			break
+		}
+	}
+	if err := r.wait(); err != nil {
+		return nil, nil, fmt.Errorf("split spreads: %w", err)
+	}
+
+	out := make([]LoadedImage, 0, len(images))
+	remap := make([]int, len(images))
+	split := 0
+	for i, img := range images {
+		remap[i] = len(out)
+		if halves[i][0] == nil {
+			out = append(out, img)
+			continue
+		}
+		putRGBA(img.Img)
+		for k, part := range []string{PartLeft, PartRight} {
+			half := img
+			half.Img = halves[i][k]
+			half.Width, half.Height = half.Img.Rect.Dx(), half.Img.Rect.Dy()
+			half.Part = part
+			out = append(out, half)
+		}
+		split++
+	}
+
+	if split > 0 {
+		noticef(notices, "split %d double-page spread(s)", split)
+	}
+	// Duplicates of a spread point at its left page
+	return out, remapDuplicates(dups, remap), nil
+}
+
+// gutterColumn returns the x coordinate where a spread is split: the column
+// in the middle fifth whose mean brightness stands out most, which is the
+// shadow or gap between the pages
+func gutterColumn(img *image.RGBA) int {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	x0, x1 := w*2/5, w*3/5
+	if x1-x0 < 3 {
+		return w / 2
+	}
+
+	sums := make([]int, x1-x0)
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := x0; x < x1; x++ {
+			p := row[x*4 : x*4+3]
+			sums[x-x0] += (int(p[0]) + 2*int(p[1]) + int(p[2])) / 4
+		}
+	}
+	total := 0
+	for _, s := range sums {
+		total += s
+	}
+	mean := total / len(sums)
+
+	best, bestDiff := w/2, 0
+	for i, s := range sums {
+		diff := s - mean
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > bestDiff {
+			best, bestDiff = x0+i, diff
+		}
+	}
+	if bestDiff/h < minGutterContrast {
+		return w / 2
+	}
+	return best
+}
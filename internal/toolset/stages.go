@@ -0,0 +1,55 @@
+package imageHandling
+
+import (
+	"math"
+	"time"
+)
+
+// StageTime is how long a stage of the extraction pipeline took
+type StageTime struct {
+	Stage   string  `json:"stage"`
+	Seconds float64 `json:"seconds"`
+}
+
+// stageTimer times consecutive pipeline stages
+type stageTimer struct {
+	last  time.Time
+	times []StageTime
+}
+
+func newStageTimer() *stageTimer {
+	return &stageTimer{last: time.Now()}
+}
+
+// done ends stage, which started when the previous one ended. A stage
+// ending more than once adds up.
+func (t *stageTimer) done(stage string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.times = AddStages(t.times, []StageTime{{stage, now.Sub(t.last).Seconds()}})
+	t.last = now
+}
+
+// AddStages adds the durations of b to those of the same stages in a,
+// appending stages a lacks
+func AddStages(a, b []StageTime) []StageTime {
+	sum := append([]StageTime(nil), a...)
+next:
+	for _, s := range b {
+		for i := range sum {
+			if sum[i].Stage == s.Stage {
+				sum[i].Seconds = roundMillis(sum[i].Seconds + s.Seconds)
+				continue next
+			}
+		}
+		sum = append(sum, StageTime{s.Stage, roundMillis(s.Seconds)})
+	}
+	return sum
+}
+
+// roundMillis keeps durations readable in JSON
+func roundMillis(seconds float64) float64 {
+	return math.Round(seconds*1000) / 1000
+}
@@ -0,0 +1,103 @@
+package imageHandling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// StagingSuffix marks an output directory that is still being written
+const StagingSuffix = ".partial"
+
+// ErrInterrupted is the cause to cancel an extraction's context with when
+// the user stops it. The staging directory is then kept with the images
+// written so far and a manifest marked as interrupted; the next run
+// discards it and starts over.
+var ErrInterrupted = errors.New("interrupted")
+
+// interrupted reports whether ctx was cancelled with ErrInterrupted
+func interrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrInterrupted)
+}
+
+// oldSuffix marks an earlier result moved aside while a new one replaces it
+const oldSuffix = ".old"
+
+// beginStaging creates an empty staging directory next to imgDir,
+// discarding leftovers of an earlier interrupted run
+func beginStaging(imgDir string) (string, error) {
+	if err := recoverOld(imgDir); err != nil {
+		return "", err
+	}
+	staging := imgDir + StagingSuffix
+	if err := os.RemoveAll(staging); err != nil {
+		return "", fmt.Errorf("remove stale %s: %w", staging, err)
+	}
+	if err := os.Mkdir(staging, 0755); err != nil {
+		return "", fmt.Errorf("create %s: %w", staging, err)
+	}
+	return staging, nil
+}
+
+// recoverOld puts back an earlier result left moved aside by a run that
+// stopped between the two renames of commitStaging, or removes it if
+// imgDir was replaced after all
+func recoverOld(imgDir string) error {
+	old := imgDir + oldSuffix
+	if _, err := os.Stat(old); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(imgDir); err == nil {
+		if err := os.RemoveAll(old); err != nil {
+			return fmt.Errorf("remove %s: %w", old, err)
+		}
+		return nil
+	}
+	if err := os.Rename(old, imgDir); err != nil {
+		return fmt.Errorf("restore %s: %w", old, err)
+	}
+	return nil
+}
+
+// commitStaging moves the staging directory to imgDir. Where the system
+// can swap two directories in one step (Linux), an existing imgDir is
+// exchanged with staging, so imgDir always holds either the old or the
+// new complete result. Elsewhere it is moved aside first and removed
+// afterwards; a crash between the two renames leaves only the old result
+// moved aside, which the next run puts back.
+func commitStaging(staging, imgDir string) error {
+	old := imgDir + oldSuffix
+	hadOld := false
+
+	if _, err := os.Stat(imgDir); err == nil {
+		err := exchangeDirs(staging, imgDir)
+		if err == nil {
+			// staging now holds the old result
+			os.RemoveAll(staging)
+			return nil
+		}
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return fmt.Errorf("swap %s into %s: %w", staging, imgDir, err)
+		}
+		if err := os.RemoveAll(old); err != nil {
+			return fmt.Errorf("remove %s: %w", old, err)
+		}
+		if err := os.Rename(imgDir, old); err != nil {
+			return fmt.Errorf("move aside %s: %w", imgDir, err)
+		}
+		hadOld = true
+	}
+
+	if err := os.Rename(staging, imgDir); err != nil {
+		if hadOld {
+			os.Rename(old, imgDir)
+		}
+		return fmt.Errorf("rename %s: %w", staging, err)
+	}
+
+	if hadOld {
+		os.RemoveAll(old)
+	}
+	return nil
+}
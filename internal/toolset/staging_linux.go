@@ -0,0 +1,58 @@
+//go:build linux
+
+package imageHandling
+
+import (
+	"errors"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// renameat2 system call numbers, which package syscall only has for some
+// architectures
+var sysRenameat2 = map[string]uintptr{
+	"386":     353,
+	"amd64":   316,
+	"arm":     382,
+	"arm64":   276,
+	"loong64": 276,
+	"ppc64":   357,
+	"ppc64le": 357,
+	"riscv64": 276,
+	"s390x":   347,
+}
+
+// renameat2's RENAME_EXCHANGE flag, and AT_FDCWD for paths relative to
+// the working directory
+const (
+	renameExchange = 1 << 1
+	atFDCWD        = -100
+)
+
+// exchangeDirs atomically swaps the directories a and b, returning
+// errors.ErrUnsupported if the kernel or file system can't
+func exchangeDirs(a, b string) error {
+	nr, ok := sysRenameat2[runtime.GOARCH]
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	pa, err := syscall.BytePtrFromString(a)
+	if err != nil {
+		return err
+	}
+	pb, err := syscall.BytePtrFromString(b)
+	if err != nil {
+		return err
+	}
+	fd := atFDCWD
+	fdcwd := uintptr(fd)
+	_, _, errno := syscall.Syscall6(nr, fdcwd, uintptr(unsafe.Pointer(pa)), fdcwd, uintptr(unsafe.Pointer(pb)), renameExchange, 0)
+	switch errno {
+	case 0:
+		return nil
+	case syscall.ENOSYS, syscall.EINVAL:
+		return errors.ErrUnsupported
+	}
+	return errno
+}
@@ -0,0 +1,39 @@
+package imageHandling
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExchangeDirs(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	for _, d := range []string{a, b} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(d, filepath.Base(d)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := exchangeDirs(a, b)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("file system can't exchange directories")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{filepath.Join(a, "b"), filepath.Join(b, "a")} {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("not swapped: %v", err)
+		}
+	}
+
+	if err := exchangeDirs(a, filepath.Join(dir, "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("swap with a missing directory: error %v, want %v", err, fs.ErrNotExist)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package imageHandling
+
+import "errors"
+
+// exchangeDirs can't swap directories atomically on this platform
+func exchangeDirs(a, b string) error {
+	return errors.ErrUnsupported
+}
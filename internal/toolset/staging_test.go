@@ -0,0 +1,113 @@
+package imageHandling
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeDirs creates the directories named by the keys under root, each
+// holding a file named by its value ("" = no directory)
+func writeDirs(t *testing.T, root string, dirs map[string]string) {
+	t.Helper()
+	for d, f := range dirs {
+		if f == "" {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, d, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// dirFiles names the files in each directory under root ("" = missing)
+func dirFiles(t *testing.T, root string, dirs ...string) map[string]string {
+	t.Helper()
+	got := make(map[string]string)
+	for _, d := range dirs {
+		entries, err := os.ReadDir(filepath.Join(root, d))
+		if os.IsNotExist(err) {
+			got[d] = ""
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			got[d] += e.Name()
+		}
+	}
+	return got
+}
+
+func TestRecoverOld(t *testing.T) {
+	tests := []struct {
+		name         string
+		before, want map[string]string // File in imgs and imgs.old
+	}{
+		{"nothing", map[string]string{"imgs": "", "imgs.old": ""}, map[string]string{"imgs": "", "imgs.old": ""}},
+		{"current only", map[string]string{"imgs": "new", "imgs.old": ""}, map[string]string{"imgs": "new", "imgs.old": ""}},
+		{"moved aside", map[string]string{"imgs": "", "imgs.old": "old"}, map[string]string{"imgs": "old", "imgs.old": ""}},
+		{"replaced", map[string]string{"imgs": "new", "imgs.old": "old"}, map[string]string{"imgs": "new", "imgs.old": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeDirs(t, root, tt.before)
+			if err := recoverOld(filepath.Join(root, "imgs")); err != nil {
+				t.Fatal(err)
+			}
+			got := dirFiles(t, root, "imgs", "imgs.old")
+			for d, want := range tt.want {
+				if got[d] != want {
+					t.Errorf("%s holds %q, want %q", d, got[d], want)
+				}
+			}
+		})
+	}
+}
+
+func TestStaging(t *testing.T) {
+	tests := []struct {
+		name   string
+		before map[string]string // File in imgs, imgs.old and imgs.partial
+	}{
+		{"first run", map[string]string{}},
+		{"replace", map[string]string{"imgs": "old"}},
+		{"after interrupted run", map[string]string{"imgs": "old", "imgs" + StagingSuffix: "stale"}},
+		{"after crash while committing", map[string]string{"imgs" + oldSuffix: "old", "imgs" + StagingSuffix: "stale"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeDirs(t, root, tt.before)
+			imgDir := filepath.Join(root, "imgs")
+
+			staging, err := beginStaging(imgDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := dirFiles(t, root, "imgs"+StagingSuffix)
+			if got["imgs"+StagingSuffix] != "" {
+				t.Fatalf("staging holds %q, want nothing", got["imgs"+StagingSuffix])
+			}
+			if err := os.WriteFile(filepath.Join(staging, "new"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := commitStaging(staging, imgDir); err != nil {
+				t.Fatal(err)
+			}
+
+			got = dirFiles(t, root, "imgs", "imgs"+oldSuffix, "imgs"+StagingSuffix)
+			want := map[string]string{"imgs": "new", "imgs" + oldSuffix: "", "imgs" + StagingSuffix: ""}
+			for d := range want {
+				if got[d] != want[d] {
+					t.Errorf("%s holds %q, want %q", d, got[d], want[d])
+				}
+			}
+		})
+	}
+}
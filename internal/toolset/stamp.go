@@ -0,0 +1,185 @@
+package imageHandling
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Stamp positions
+const (
+	StampCenter      = "center"
+	StampTopLeft     = "top-left"
+	StampTopRight    = "top-right"
+	StampBottomLeft  = "bottom-left"
+	StampBottomRight = "bottom-right"
+)
+
+// DefaultStampOpacity is used when Stamp.Opacity is unset
+const DefaultStampOpacity = 0.5
+
+// stampColor is the color of text stamps
+var stampColor = color.RGBA{0xc0, 0, 0, 0xff}
+
+// Stamp marks every converted image with a text or image watermark.
+// The mark is sized to the image: a third of its width in a corner, two
+// thirds in the center, and never more than a tenth (text) or a quarter
+// (image) of its height.
+type Stamp struct {
+	Text     string  `json:"text"`     // Text to stamp ("" = none)
+	Image    string  `json:"image"`    // Image file stamped instead of the text ("" = none)
+	Position string  `json:"position"` // StampCenter or a corner ("" = StampBottomRight)
+	Opacity  float64 `json:"opacity"`  // 0..1 (0 = DefaultStampOpacity)
+}
+
+// enabled reports whether s marks images
+func (s Stamp) enabled() bool {
+	return s.Text != "" || s.Image != ""
+}
+
+// stamper draws a Stamp; it is safe for concurrent use
+type stamper struct {
+	Stamp
+	font *opentype.Font // Font of text stamps
+	mark image.Image    // Decoded Stamp.Image
+}
+
+// newStamper prepares s for drawing; nil when s is not enabled
+func newStamper(s Stamp) (*stamper, error) {
+	if !s.enabled() {
+		return nil, nil
+	}
+	if s.Opacity <= 0 {
+		s.Opacity = DefaultStampOpacity
+	}
+	if s.Position == "" {
+		s.Position = StampBottomRight
+	}
+	st := &stamper{Stamp: s}
+
+	if s.Image != "" {
+		f, err := os.Open(LongPath(s.Image))
+		if err != nil {
+			return nil, fmt.Errorf("stamp image: %w", err)
+		}
+		defer f.Close()
+		if st.mark, _, err = image.Decode(f); err != nil {
+			return nil, fmt.Errorf("stamp image %s: %w", s.Image, err)
+		}
+		return st, nil
+	}
+
+	fnt, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("stamp font: %w", err)
+	}
+	st.font = fnt
+	return st, nil
+}
+
+// apply draws the stamp onto img
+func (st *stamper) apply(img *image.RGBA) {
+	b := img.Rect
+	maxW := b.Dx() / 3
+	if st.Position == StampCenter {
+		maxW = b.Dx() * 2 / 3
+	}
+
+	// Image stamps are faded by a uniform mask, text by its glyph coverage
+	var src, mask image.Image
+	var size image.Point
+	if st.mark != nil {
+		m := st.scaleMark(maxW, b.Dy()/4)
+		src, mask, size = m, image.NewUniform(color.Alpha{uint8(st.Opacity * 0xff)}), m.Rect.Size()
+	} else {
+		glyphs := st.renderText(maxW, b.Dy()/10)
+		if glyphs == nil {
+			return
+		}
+		src, mask, size = image.NewUniform(stampColor), glyphs, glyphs.Rect.Size()
+	}
+
+	at := stampOrigin(b, size, st.Position)
+	draw.DrawMask(img, image.Rectangle{at, at.Add(size)}, src, image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+// scaleMark fits the stamp image into maxW x maxH keeping its aspect ratio
+func (st *stamper) scaleMark(maxW, maxH int) *image.RGBA {
+	mb := st.mark.Bounds()
+	w, h := maxW, mb.Dy()*maxW/max(mb.Dx(), 1)
+	if h > maxH {
+		w, h = mb.Dx()*maxH/max(mb.Dy(), 1), maxH
+	}
+	w, h = max(w, 1), max(h, 1)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), st.mark, mb, draw.Src, nil)
+	return dst
+}
+
+// renderText draws the stamp text as glyph coverage faded by the opacity,
+// as large as fits into maxW x maxH. Every image must carry the mark, so
+// on small images the text keeps a legible size and may be clipped.
+func (st *stamper) renderText(maxW, maxH int) *image.Alpha {
+	const minSize = 8
+	size := float64(max(maxH, minSize))
+	face, width := st.textFace(size)
+	if width > maxW && size > minSize {
+		face.Close()
+		size = max(size*float64(maxW)/float64(width), minSize)
+		face, width = st.textFace(size)
+	}
+	defer face.Close()
+	if width < 1 {
+		return nil
+	}
+
+	m := face.Metrics()
+	ascent, height := m.Ascent.Ceil(), (m.Ascent + m.Descent).Ceil()
+	mask := image.NewAlpha(image.Rect(0, 0, width, height))
+	d := font.Drawer{
+		Dst:  mask,
+		Src:  image.Opaque,
+		Face: face,
+		Dot:  fixed.P(0, ascent),
+	}
+	d.DrawString(st.Text)
+	for i, a := range mask.Pix {
+		mask.Pix[i] = uint8(float64(a) * st.Opacity)
+	}
+	return mask
+}
+
+// textFace returns a face of the stamp font at size pixels and the width
+// of the stamp text in it
+func (st *stamper) textFace(size float64) (font.Face, int) {
+	// Sizes are positive, so this can't fail for the embedded font
+	face, _ := opentype.NewFace(st.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+	return face, font.MeasureString(face, st.Text).Ceil()
+}
+
+// stampOrigin returns the top-left corner of a mark of the given size at
+// pos inside b, keeping a small margin from the edges
+func stampOrigin(b image.Rectangle, size image.Point, pos string) image.Point {
+	margin := min(b.Dx(), b.Dy()) / 50
+	left, top := b.Min.X+margin, b.Min.Y+margin
+	right, bottom := b.Max.X-margin-size.X, b.Max.Y-margin-size.Y
+	switch pos {
+	case StampCenter:
+		return image.Pt(b.Min.X+(b.Dx()-size.X)/2, b.Min.Y+(b.Dy()-size.Y)/2)
+	case StampTopLeft:
+		return image.Pt(left, top)
+	case StampTopRight:
+		return image.Pt(right, top)
+	case StampBottomLeft:
+		return image.Pt(left, bottom)
+	default:
+		return image.Pt(right, bottom)
+	}
+}
@@ -0,0 +1,73 @@
+package imageHandling
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+)
+
+// Stitch directions accepted by Options.Stitch
+const (
+	StitchVertical   = "vertical"
+	StitchHorizontal = "horizontal"
+)
+
+// StitchName is the file name, without extension, of the stitched image
+const StitchName = "stitched"
+
+// maxWebPSide is the largest width or height a WebP image can have
+const maxWebPSide = 16383
+
+// writeStitched joins images into one strip in reading order and writes it
+// to imgDir next to the single images. Images narrower (or, stitched
+// horizontally, lower) than the strip are centered on white.
+func writeStitched(imgDir string, images []LoadedImage, direction string, encoder ImageEncoder, limits Limits) (string, error) {
+	w, h := 0, 0
+	for _, img := range images {
+		size := img.Img.Rect.Size()
+		if direction == StitchHorizontal {
+			w, h = w+size.X, max(h, size.Y)
+		} else {
+			w, h = max(w, size.X), h+size.Y
+		}
+	}
+	if err := limits.checkPixels(w, h); err != nil {
+		return "", fmt.Errorf("stitch: %w", err)
+	}
+	if encoder.Extension() == ".webp" && max(w, h) > maxWebPSide {
+		return "", fmt.Errorf("stitched image is %dx%d, over the WebP limit of %d pixels per side; use png", w, h, maxWebPSide)
+	}
+
+	strip := getRGBA(image.Rect(0, 0, w, h))
+	defer putRGBA(strip)
+	for i := range strip.Pix {
+		strip.Pix[i] = 0xff
+	}
+	at := image.Point{}
+	for _, img := range images {
+		b := img.Img.Bounds()
+		off := at
+		if direction == StitchHorizontal {
+			off.Y = (h - b.Dy()) / 2
+			at.X += b.Dx()
+		} else {
+			off.X = (w - b.Dx()) / 2
+			at.Y += b.Dy()
+		}
+		draw.Draw(strip, image.Rectangle{off, off.Add(b.Size())}, img.Img, b.Min, draw.Over)
+	}
+
+	buf, err := encodeImage(strip, encoder)
+	if err != nil {
+		return "", fmt.Errorf("stitch: %w", err)
+	}
+	defer putBuffer(buf)
+	name := StitchName + encoder.Extension()
+	path := filepath.Join(imgDir, name)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return "", fmt.Errorf("write %s: %w", path, err)
+	}
+	return name, nil
+}
@@ -0,0 +1,54 @@
+package imageHandling
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+)
+
+// Extended attributes recording where an image came from (Options.Tags)
+const (
+	TagSource = "user.pixf.source" // Input file, and the PDF within an archive
+	TagPage   = "user.pixf.page"   // Page number
+	TagHash   = "user.pixf.hash"   // SHA-256 of the input file
+)
+
+// errTagsUnsupported reports a file system or platform without extended
+// attributes
+var errTagsUnsupported = errors.New("extended attributes not supported")
+
+// tagImages records the provenance of every image in the manifest of dir
+// in extended attributes (alternate data streams on Windows), so it stays
+// with files copied elsewhere. Where they aren't supported, the images are
+// left untagged with a notice.
+func tagImages(dir string, notices io.Writer) error {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	for _, img := range m.Images {
+		source := m.Input
+		if img.Document != "" {
+			source += "/" + img.Document
+		}
+		tags := [][2]string{
+			{TagSource, source},
+			{TagPage, strconv.Itoa(img.Page)},
+			{TagHash, m.InputHash},
+		}
+		file := filepath.Join(dir, filepath.FromSlash(img.File))
+		for _, t := range tags {
+			err := setTag(file, t[0], t[1])
+			if errors.Is(err, errTagsUnsupported) {
+				noticef(notices, "images not tagged: %v", err)
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("tag %s: %w", img.File, err)
+			}
+		}
+	}
+	return nil
+}
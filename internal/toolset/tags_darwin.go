@@ -0,0 +1,22 @@
+//go:build darwin
+
+package imageHandling
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// setTag sets the extended attribute name of path to value with the
+// xattr tool, as the standard library has no call for it on macOS
+func setTag(path, name, value string) error {
+	out, err := exec.Command("xattr", "-w", name, value, path).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("xattr: %w: %s", err, msg)
+		}
+		return fmt.Errorf("xattr: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build linux
+
+package imageHandling
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// setTag sets the extended attribute name of path to value
+func setTag(path, name, value string) error {
+	err := syscall.Setxattr(path, name, []byte(value), 0)
+	if errors.Is(err, syscall.ENOTSUP) {
+		return fmt.Errorf("%w by the file system of %s", errTagsUnsupported, path)
+	}
+	return err
+}
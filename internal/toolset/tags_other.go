@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package imageHandling
+
+// setTag can't set extended attributes on this platform
+func setTag(path, name, value string) error {
+	return errTagsUnsupported
+}
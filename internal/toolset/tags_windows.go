@@ -0,0 +1,15 @@
+//go:build windows
+
+package imageHandling
+
+import (
+	"os"
+	"strings"
+)
+
+// setTag writes value to the alternate data stream name of path, without
+// the "user." namespace of Unix attributes: report.png:pixf.page. File
+// systems other than NTFS have no streams and fail.
+func setTag(path, name, value string) error {
+	return os.WriteFile(path+":"+strings.TrimPrefix(name, "user."), []byte(value), 0644)
+}
@@ -0,0 +1,200 @@
+package imageHandling
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// TIFFName is the file the multi-page TIFF is written to
+const TIFFName = "pages.tif"
+
+// TIFF tags, types and values written by writeMultiPageTIFF
+const (
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagPhotometric     = 262
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagXResolution     = 282
+	tagYResolution     = 283
+	tagPlanarConfig    = 284
+	tagResolutionUnit  = 296
+	tagPageNumber      = 297
+	tagExtraSamples    = 338
+
+	tiffShort    = 3
+	tiffLong     = 4
+	tiffRational = 5
+
+	compressionDeflate = 8
+	photometricGray    = 1 // BlackIsZero
+	photometricRGB     = 2
+	extraAssocAlpha    = 1 // Premultiplied, like image.RGBA
+)
+
+// errTIFFTooLarge reports a file beyond the 32-bit offsets of classic TIFF
+var errTIFFTooLarge = errors.New("multi-page TIFF would exceed 4 GiB")
+
+// writeMultiPageTIFF writes the images as pages of one Deflate-compressed
+// TIFF in imgDir. Gray pages are stored with one sample per pixel and
+// opaque pages without alpha, which keeps scans small.
+func writeMultiPageTIFF(imgDir string, images []LoadedImage) (string, error) {
+	path := filepath.Join(imgDir, TIFFName)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if err := writeTIFF(f, images); err != nil {
+		return "", fmt.Errorf("write %s: %w", path, err)
+	}
+	return TIFFName, f.Close()
+}
+
+// writeTIFF writes a little-endian TIFF with one IFD per image. Each page's
+// IFD follows its pixel data; the previous IFD's next pointer is patched.
+func writeTIFF(w io.WriteSeeker, images []LoadedImage) error {
+	le := binary.LittleEndian
+	// Header; the offset of the first IFD is patched in
+	if _, err := w.Write([]byte{'I', 'I', 42, 0, 0, 0, 0, 0}); err != nil {
+		return err
+	}
+	next := int64(4) // Where the offset of the coming IFD goes
+
+	var data bytes.Buffer
+	for i, img := range images {
+		samples, photometric := tiffLayout(img.Img)
+		data.Reset()
+		if err := compressPixels(&data, img.Img, samples); err != nil {
+			return err
+		}
+
+		start, err := w.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data.Bytes()); err != nil {
+			return err
+		}
+
+		// Values that don't fit into an entry follow the pixel data
+		extra := start + int64(data.Len())
+		extra += extra & 1 // TIFF offsets are word aligned
+		bpsAt, xresAt := extra, extra+8
+		yresAt := xresAt + 8
+		ifdAt := yresAt + 8
+
+		var tail bytes.Buffer
+		tail.Write(make([]byte, extra-start-int64(data.Len())))
+		binary.Write(&tail, le, [4]uint16{8, 8, 8, 8})
+		binary.Write(&tail, le, [4]uint32{72, 1, 72, 1}) // Resolution unknown
+
+		entries := [][3]uint32{
+			{tagImageWidth, tiffLong, uint32(img.Img.Rect.Dx())},
+			{tagImageLength, tiffLong, uint32(img.Img.Rect.Dy())},
+			{tagBitsPerSample, tiffShort, uint32(bpsAt)},
+			{tagCompression, tiffShort, compressionDeflate},
+			{tagPhotometric, tiffShort, photometric},
+			{tagStripOffsets, tiffLong, uint32(start)},
+			{tagSamplesPerPixel, tiffShort, uint32(samples)},
+			{tagRowsPerStrip, tiffLong, uint32(img.Img.Rect.Dy())},
+			{tagStripByteCounts, tiffLong, uint32(data.Len())},
+			{tagXResolution, tiffRational, uint32(xresAt)},
+			{tagYResolution, tiffRational, uint32(yresAt)},
+			{tagPlanarConfig, tiffShort, 1},
+			{tagResolutionUnit, tiffShort, 2}, // Inch
+			{tagPageNumber, tiffShort, uint32(i) | uint32(len(images))<<16},
+		}
+		if samples == 4 {
+			entries = append(entries, [3]uint32{tagExtraSamples, tiffShort, extraAssocAlpha})
+		}
+
+		binary.Write(&tail, le, uint16(len(entries)))
+		for _, e := range entries {
+			count := uint32(1)
+			switch e[0] {
+			case tagBitsPerSample:
+				count = uint32(samples)
+				if samples == 1 {
+					e[2] = 8 // Fits into the entry itself
+				}
+			case tagPageNumber:
+				count = 2
+			}
+			binary.Write(&tail, le, uint16(e[0]))
+			binary.Write(&tail, le, uint16(e[1]))
+			binary.Write(&tail, le, count)
+			binary.Write(&tail, le, e[2])
+		}
+		binary.Write(&tail, le, uint32(0)) // Next IFD, patched by the next page
+
+		if start+int64(tail.Len())+int64(data.Len()) > math.MaxUint32 {
+			return errTIFFTooLarge
+		}
+		if _, err := w.Write(tail.Bytes()); err != nil {
+			return err
+		}
+		if err := patchOffset(w, next, uint32(ifdAt)); err != nil {
+			return err
+		}
+		next = ifdAt + 2 + 12*int64(len(entries))
+	}
+	return nil
+}
+
+// patchOffset writes v as a 32-bit offset at pos
+func patchOffset(w io.WriteSeeker, pos int64, v uint32) error {
+	if _, err := w.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	_, err := w.Write(b[:])
+	return err
+}
+
+// tiffLayout picks the samples per pixel and photometric interpretation
+// for img: gray, RGB, or RGB with premultiplied alpha
+func tiffLayout(img *image.RGBA) (samples int, photometric uint32) {
+	if !img.Opaque() {
+		return 4, photometricRGB
+	}
+	if isGray(img) {
+		return 1, photometricGray
+	}
+	return 3, photometricRGB
+}
+
+// compressPixels deflates the first samples channels of every pixel
+func compressPixels(w io.Writer, img *image.RGBA, samples int) error {
+	zw := zlib.NewWriter(w)
+	width := img.Rect.Dx()
+	row := make([]byte, width*samples)
+	for y := 0; y < img.Rect.Dy(); y++ {
+		src := img.Pix[y*img.Stride : y*img.Stride+width*4]
+		if samples == 4 {
+			copy(row, src)
+		} else {
+			for x, si := 0, 0; x < width; x, si = x+1, si+4 {
+				copy(row[x*samples:x*samples+samples], src[si:si+samples])
+			}
+		}
+		if _, err := zw.Write(row); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
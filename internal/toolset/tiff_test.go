@@ -0,0 +1,158 @@
+package imageHandling
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+// tiffPage is a page read back from a TIFF
+type tiffPage struct {
+	width, height, samples, photometric int
+	page, pages                         int
+	pix                                 []byte
+}
+
+// readTIFFPages walks the IFDs of a little-endian TIFF with one Deflate
+// strip per page
+func readTIFFPages(t *testing.T, data []byte) []tiffPage {
+	t.Helper()
+	le := binary.LittleEndian
+	if !bytes.HasPrefix(data, []byte{'I', 'I', 42, 0}) {
+		t.Fatalf("header % x", data[:4])
+	}
+	var pages []tiffPage
+	for ifd := le.Uint32(data[4:]); ifd != 0; {
+		if ifd%2 != 0 {
+			t.Fatalf("IFD at odd offset %d", ifd)
+		}
+		n := int(le.Uint16(data[ifd:]))
+		// Value fields; up to two shorts are read as one little-endian long
+		tags := make(map[uint16]uint32)
+		for i := range n {
+			e := data[int(ifd)+2+12*i:]
+			tags[le.Uint16(e)] = le.Uint32(e[8:])
+		}
+		if tags[tagCompression] != compressionDeflate {
+			t.Fatalf("compression %d", tags[tagCompression])
+		}
+		off, size := tags[tagStripOffsets], tags[tagStripByteCounts]
+		zr, err := zlib.NewReader(bytes.NewReader(data[off : off+size]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pix, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, tiffPage{
+			width:       int(tags[tagImageWidth]),
+			height:      int(tags[tagImageLength]),
+			samples:     int(tags[tagSamplesPerPixel]),
+			photometric: int(tags[tagPhotometric]),
+			page:        int(tags[tagPageNumber] & 0xffff),
+			pages:       int(tags[tagPageNumber] >> 16),
+			pix:         pix,
+		})
+		ifd = le.Uint32(data[int(ifd)+2+12*n:])
+	}
+	return pages
+}
+
+// tiffTestImage fills a w×h image with random gray, color or translucent
+// pixels
+func tiffTestImage(rng *rand.Rand, w, h int, kind string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			v := uint8(rng.IntN(256))
+			c := color.RGBA{v, v, v, 255}
+			switch kind {
+			case "rgb":
+				c.G, c.B = uint8(rng.IntN(256)), uint8(rng.IntN(256))
+			case "alpha":
+				c.A = uint8(rng.IntN(256))
+				c.R, c.G, c.B = min(c.R, c.A), 0, c.A/2
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestWriteMultiPageTIFF(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	tests := []struct {
+		kind        string
+		w, h        int
+		samples     int
+		photometric int
+	}{
+		{"gray", 7, 5, 1, photometricGray},
+		{"rgb", 3, 9, 3, photometricRGB},
+		{"alpha", 5, 5, 4, photometricRGB},
+		{"gray", 1, 1, 1, photometricGray},
+	}
+	var images []LoadedImage
+	for _, tt := range tests {
+		images = append(images, LoadedImage{Img: tiffTestImage(rng, tt.w, tt.h, tt.kind)})
+	}
+
+	dir := t.TempDir()
+	name, err := writeMultiPageTIFF(dir, images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := readTIFFPages(t, data)
+	if len(pages) != len(tests) {
+		t.Fatalf("%d pages, want %d", len(pages), len(tests))
+	}
+	for i, tt := range tests {
+		p, img := pages[i], images[i].Img
+		if p.width != tt.w || p.height != tt.h || p.samples != tt.samples || p.photometric != tt.photometric {
+			t.Errorf("page %d: %dx%d, %d samples, photometric %d; want %dx%d, %d, %d",
+				i, p.width, p.height, p.samples, p.photometric, tt.w, tt.h, tt.samples, tt.photometric)
+		}
+		if p.page != i || p.pages != len(tests) {
+			t.Errorf("page %d: numbered %d of %d", i, p.page, p.pages)
+		}
+		var want []byte
+		for px := 0; px < len(img.Pix); px += 4 {
+			want = append(want, img.Pix[px:px+tt.samples]...)
+		}
+		if !bytes.Equal(p.pix, want) {
+			t.Errorf("page %d: pixels differ", i)
+		}
+	}
+
+	// A standard decoder reads the first page
+	first, err := tiff.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Bounds() != images[0].Img.Bounds() {
+		t.Errorf("decoded %v, want %v", first.Bounds(), images[0].Img.Bounds())
+	}
+	for y := range tests[0].h {
+		for x := range tests[0].w {
+			got, _, _, _ := first.At(x, y).RGBA()
+			want, _, _, _ := images[0].Img.At(x, y).RGBA()
+			if got != want {
+				t.Fatalf("pixel %d,%d decoded %d, want %d", x, y, got>>8, want>>8)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package imageHandling
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// TilesName is the index of the tiles written with Options.Tile
+const TilesName = "tiles.json"
+
+// Tile sizes accepted by ParseTileSize; the largest is the WebP limit
+const (
+	MinTileSize = 16
+	MaxTileSize = maxWebPSide
+)
+
+// TileRect places a tile within the image it was cut from
+type TileRect struct {
+	Row         int `json:"row"` // From 1, top to bottom
+	Col         int `json:"col"` // From 1, left to right
+	X           int `json:"x"`   // Left edge in the whole image
+	Y           int `json:"y"`   // Top edge in the whole image
+	ImageWidth  int `json:"image_width"`
+	ImageHeight int `json:"image_height"`
+}
+
+// TiledImage is the entry of an image split into tiles in the tile index
+type TiledImage struct {
+	Image      string   `json:"image"` // Name the whole image would have had, without extension
+	Page       int      `json:"page"`
+	Width      int      `json:"width"`
+	Height     int      `json:"height"`
+	TileWidth  int      `json:"tile_width"`
+	TileHeight int      `json:"tile_height"`
+	Rows       int      `json:"rows"`
+	Cols       int      `json:"cols"`
+	Tiles      []string `json:"tiles"` // Files row by row; edge tiles may be smaller
+}
+
+// ParseTileSize reads a tile size such as "1024x1024", or "1024" for
+// square tiles
+func ParseTileSize(s string) (width, height int, err error) {
+	w, h, ok := strings.Cut(strings.ToLower(s), "x")
+	if !ok {
+		h = w
+	}
+	width, errW := strconv.Atoi(w)
+	height, errH := strconv.Atoi(h)
+	if errW != nil || errH != nil || min(width, height) < MinTileSize || max(width, height) > MaxTileSize {
+		return 0, 0, fmt.Errorf("invalid tile size '%s' (use WxH from %d to %d pixels, e.g. 1024x1024)", s, MinTileSize, MaxTileSize)
+	}
+	return width, height, nil
+}
+
+// tileImages replaces every image larger than width x height by a grid of
+// tiles, named after the image with _r<row>_c<col> appended, in row
+// order. Images must be named already. Duplicates of a tiled image point
+// at its first tile.
+func tileImages(images []LoadedImage, dups []duplicate, width, height int, notices io.Writer) ([]LoadedImage, []duplicate, []TiledImage) {
+	out := make([]LoadedImage, 0, len(images))
+	remap := make([]int, len(images))
+	var tiled []TiledImage
+	count := 0
+	for i, img := range images {
+		remap[i] = len(out)
+		b := img.Img.Rect
+		if b.Dx() <= width && b.Dy() <= height {
+			out = append(out, img)
+			continue
+		}
+
+		stem := img.Stem
+		if stem == "" {
+			stem = img.Number
+		}
+		rows, cols := (b.Dy()+height-1)/height, (b.Dx()+width-1)/width
+		entry := TiledImage{
+			Image: path.Join(img.Dir, stem), Page: img.Page,
+			Width: b.Dx(), Height: b.Dy(),
+			TileWidth: width, TileHeight: height,
+			Rows: rows, Cols: cols,
+		}
+		for r := 0; r < rows; r++ {
+			for c := 0; c < cols; c++ {
+				rect := image.Rect(c*width, r*height, min((c+1)*width, b.Dx()), min((r+1)*height, b.Dy())).Add(b.Min)
+				tile := img
+				tile.Img = cropCopy(img.Img, rect)
+				tile.Width, tile.Height = rect.Dx(), rect.Dy()
+				tile.Stem = fmt.Sprintf("%s_r%0*d_c%0*d", stem, digits(rows), r+1, digits(cols), c+1)
+				tile.Tile = &TileRect{
+					Row: r + 1, Col: c + 1,
+					X: c * width, Y: r * height,
+					ImageWidth: b.Dx(), ImageHeight: b.Dy(),
+				}
+				out = append(out, tile)
+				entry.Tiles = append(entry.Tiles, tile.Stem)
+			}
+		}
+		putRGBA(img.Img)
+		tiled = append(tiled, entry)
+		count += rows * cols
+	}
+
+	if len(tiled) > 0 {
+		noticef(notices, "split %d large image(s) into %d tiles", len(tiled), count)
+	}
+	return out, remapDuplicates(dups, remap), tiled
+}
+
+// writeTileIndex writes the tile index to imgDir, naming tiles with ext,
+// and returns its name
+func writeTileIndex(imgDir string, tiled []TiledImage, ext string) (string, error) {
+	for i := range tiled {
+		dir := path.Dir(tiled[i].Image)
+		for k, stem := range tiled[i].Tiles {
+			tiled[i].Tiles[k] = path.Join(dir, stem+ext)
+		}
+	}
+	data, err := json.MarshalIndent(tiled, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(imgDir, TilesName), data, 0644); err != nil {
+		return "", fmt.Errorf("write %s: %w", TilesName, err)
+	}
+	return TilesName, nil
+}
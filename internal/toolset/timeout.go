@@ -0,0 +1,77 @@
+package imageHandling
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// abandonedSlots bounds the calls RunContext gave up on that are still
+// running, so repeated timeouts can't pile up CPU and memory
+var abandonedSlots = make(chan struct{}, max(2, runtime.GOMAXPROCS(0)))
+
+// RunContext runs fn but returns ctx's error as soon as ctx is done.
+// pdfcpu calls can't be cancelled, so an abandoned fn keeps running in
+// the background until it returns; its result is discarded. While as
+// many calls as abandonedSlots holds are abandoned, further calls wait for
+// one of them to end before starting fn. A panic in fn is returned as an
+// error, since nothing could recover it on fn's goroutine.
+func RunContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case abandonedSlots <- struct{}{}:
+		<-abandonedSlots
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var (
+		mu       sync.Mutex
+		finished bool // fn returned
+		held     bool // The caller gave up and took a slot of abandonedSlots
+	)
+	done := make(chan error, 1)
+	go func() {
+		err := callSafe(fn)
+		mu.Lock()
+		finished = true
+		release := held
+		mu.Unlock()
+		done <- err
+		if release {
+			<-abandonedSlots
+		}
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if finished {
+		return <-done
+	}
+	select {
+	case abandonedSlots <- struct{}{}:
+		held = true
+	default:
+		// Only if calls that passed the wait together all gave up; the
+		// excess is bounded by the callers and goes uncounted
+	}
+	return ctx.Err()
+}
+
+// callSafe calls fn, returning a panic as an error
+func callSafe(fn func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return fn()
+}
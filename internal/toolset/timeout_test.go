@@ -0,0 +1,107 @@
+package imageHandling
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// abandon starts a call that blocks until release is closed and gives up
+// on it, returning a channel closed once the call ended
+func abandon(t *testing.T, release <-chan struct{}) <-chan struct{} {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	started, ended := make(chan struct{}), make(chan struct{})
+	go func() {
+		<-started
+		cancel()
+	}()
+	err := RunContext(ctx, func() error {
+		defer close(ended)
+		close(started)
+		<-release
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error %v, want %v", err, context.Canceled)
+	}
+	return ended
+}
+
+// waitSlotsFree waits for the abandoned calls to give their slots back
+func waitSlotsFree(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(abandonedSlots) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d slot(s) still held", len(abandonedSlots))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func() error
+		want string // error message ("" = none)
+	}{
+		{"ok", func() error { return nil }, ""},
+		{"error", func() error { return errors.New("failed") }, "failed"},
+		{"panic", func() error { panic("boom") }, "panic: boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RunContext(context.Background(), tt.fn)
+			if got := ""; err != nil {
+				got = err.Error()
+				if got != tt.want {
+					t.Errorf("error %q, want %q", got, tt.want)
+				}
+			} else if tt.want != "" {
+				t.Errorf("no error, want %q", tt.want)
+			}
+		})
+	}
+}
+
+func TestRunContextAbandoned(t *testing.T) {
+	release := make(chan struct{})
+	ended := abandon(t, release)
+	if len(abandonedSlots) != 1 {
+		t.Fatalf("%d slot(s) held, want 1", len(abandonedSlots))
+	}
+	close(release)
+	<-ended
+	waitSlotsFree(t)
+}
+
+func TestRunContextBoundsAbandoned(t *testing.T) {
+	release := make(chan struct{})
+	var ended []<-chan struct{}
+	for range cap(abandonedSlots) {
+		ended = append(ended, abandon(t, release))
+	}
+
+	// While the slots are full, a call waits instead of starting
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	ran := false
+	err := RunContext(ctx, func() error {
+		ran = true
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || ran {
+		t.Fatalf("error %v, ran %t; want %v without running", err, ran, context.DeadlineExceeded)
+	}
+
+	close(release)
+	for _, c := range ended {
+		<-c
+	}
+	waitSlotsFree(t)
+	if err := RunContext(context.Background(), func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
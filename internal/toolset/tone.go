@@ -0,0 +1,99 @@
+package imageHandling
+
+import (
+	"image"
+	"math"
+)
+
+// autoLevelsClip is the share of darkest and brightest pixels ignored when
+// stretching levels, so a few specks don't pin the range
+const autoLevelsClip = 0.005
+
+// Tone adjusts the tonal range of converted images. The zero value leaves
+// images unchanged. Adjustments apply in order: auto levels, brightness,
+// contrast, gamma.
+type Tone struct {
+	Brightness float64 `json:"brightness"`  // -100..100, percent of full scale added
+	Contrast   float64 `json:"contrast"`    // -100..100, percent change of the spread around mid-gray
+	Gamma      float64 `json:"gamma"`       // Gamma correction, >1 brightens midtones (0 or 1 = none)
+	AutoLevels bool    `json:"auto_levels"` // Stretch each image to use the full range
+}
+
+// enabled reports whether t changes any pixels
+func (t Tone) enabled() bool {
+	return t.Brightness != 0 || t.Contrast != 0 || (t.Gamma != 0 && t.Gamma != 1) || t.AutoLevels
+}
+
+// apply adjusts the color channels of img in place; alpha is kept
+func (t Tone) apply(img *image.RGBA) {
+	lut := t.table(img)
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	forRows(w, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+			for i := 0; i < len(row); i += 4 {
+				switch a := row[i+3]; a {
+				case 0:
+				case 0xff:
+					row[i], row[i+1], row[i+2] = lut[row[i]], lut[row[i+1]], lut[row[i+2]]
+				default:
+					// RGBA is premultiplied; adjust the straight color
+					for c := i; c < i+3; c++ {
+						v := lut[min(uint32(row[c])*0xff/uint32(a), 0xff)]
+						row[c] = uint8(uint32(v) * uint32(a) / 0xff)
+					}
+				}
+			}
+		}
+	})
+}
+
+// table builds the lookup table mapping input to output channel values
+func (t Tone) table(img *image.RGBA) *[256]uint8 {
+	lo, hi := 0.0, 255.0
+	if t.AutoLevels {
+		lo, hi = levelRange(img)
+	}
+	gamma := t.Gamma
+	if gamma == 0 {
+		gamma = 1
+	}
+
+	var lut [256]uint8
+	for i := range lut {
+		v := (float64(i) - lo) / (hi - lo) // 0..1
+		v += t.Brightness / 100
+		v = (v-0.5)*(1+t.Contrast/100) + 0.5
+		v = math.Max(0, math.Min(1, v))
+		v = math.Pow(v, 1/gamma)
+		lut[i] = uint8(math.Round(v * 255))
+	}
+	return &lut
+}
+
+// levelRange returns the darkest and brightest luminance of img after
+// clipping autoLevelsClip of the pixels at each end
+func levelRange(img *image.RGBA) (lo, hi float64) {
+	var hist [256]int
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+		for i := 0; i < len(row); i += 4 {
+			hist[(int(row[i])+2*int(row[i+1])+int(row[i+2]))/4]++
+		}
+	}
+
+	clip := int(float64(w*h) * autoLevelsClip)
+	l, hh := 0, 255
+	for n := 0; l < 255 && n+hist[l] <= clip; l++ {
+		n += hist[l]
+	}
+	for n := 0; hh > 0 && n+hist[hh] <= clip; hh-- {
+		n += hist[hh]
+	}
+	if hh <= l {
+		// Flat image: nothing to stretch
+		return 0, 255
+	}
+	return float64(l), float64(hh)
+}
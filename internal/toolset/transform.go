@@ -0,0 +1,154 @@
+package imageHandling
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Directions of Transform.Flip
+const (
+	FlipHorizontal = "h" // Mirror left to right
+	FlipVertical   = "v" // Mirror top to bottom
+)
+
+// Transform turns and mirrors images, e.g. to correct scans fed in
+// upside down
+type Transform struct {
+	Rotate int    `json:"rotate,omitempty"` // Clockwise degrees: 90, 180 or 270 (0 = none)
+	Flip   string `json:"flip,omitempty"`   // Mirror after turning: h or v ("" = none)
+}
+
+// Check reports an invalid rotation or flip direction
+func (t Transform) Check() error {
+	switch t.Rotate {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("invalid rotation %d (use 90, 180 or 270)", t.Rotate)
+	}
+	switch t.Flip {
+	case "", FlipHorizontal, FlipVertical:
+	default:
+		return fmt.Errorf("invalid flip %q (use h or v)", t.Flip)
+	}
+	return nil
+}
+
+// enabled reports whether t changes any pixels
+func (t Transform) enabled() bool {
+	return t.Rotate != 0 || t.Flip != ""
+}
+
+// orientation returns the EXIF orientation that undoes the same change as
+// t, for applyOrientation
+func (t Transform) orientation() int {
+	flips := map[string][4]int{
+		"":             {orientNormal, orientRotate90, orientRotate180, orientRotate270},
+		FlipHorizontal: {orientFlipH, orientTranspose, orientFlipV, orientTransverse},
+		FlipVertical:   {orientFlipV, orientTransverse, orientFlipH, orientTranspose},
+	}
+	return flips[t.Flip][t.Rotate/90%4]
+}
+
+// transformFor returns the transform of the images of page: its entry in
+// PageTransforms, or else Transform
+func (o Options) transformFor(page int) Transform {
+	if t, ok := o.PageTransforms[page]; ok {
+		return t
+	}
+	return o.Transform
+}
+
+// transformsPixels reports whether any image is turned or mirrored
+func (o Options) transformsPixels() bool {
+	return o.Transform.enabled() || len(o.PageTransforms) > 0
+}
+
+// transformImages turns and mirrors the images as opts asks for their page
+func (e *Extractor) transformImages(ctx context.Context, images []LoadedImage, opts Options) error {
+	r := newRun(ctx)
+	for i := range images {
+		img := &images[i]
+		t := opts.transformFor(img.Page)
+		if !t.enabled() {
+			continue
+		}
+		ok := r.submit(e.encode, func() error {
+			turned := applyOrientation(img.Img, t.orientation())
+			putRGBA(img.Img)
+			img.Img = turned
+			img.Width, img.Height = turned.Rect.Dx(), turned.Rect.Dy()
+			return nil
+		})
+		if !ok {
+			break
+		}
+	}
+	if err := r.wait(); err != nil {
+		return fmt.Errorf("rotate: %w", err)
+	}
+	return nil
+}
+
+// LoadTransformMap reads per-page transforms, one line each: a page or
+// range of pages, the clockwise rotation and optionally h or v to flip,
+// e.g. "3 180" or "10-12 90 h". Blank lines and lines starting with #
+// are skipped; later lines win for pages listed twice.
+func LoadTransformMap(path string) (map[int]Transform, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	transforms := make(map[int]Transform)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected pages, rotation and optionally h or v", path, n)
+		}
+		first, last, err := parsePageRange(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		var t Transform
+		if t.Rotate, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid rotation %q", path, n, fields[1])
+		}
+		if len(fields) == 3 {
+			t.Flip = fields[2]
+		}
+		if err := t.Check(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		for page := first; page <= last; page++ {
+			transforms[page] = t
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return transforms, nil
+}
+
+// parsePageRange parses "7" or "3-9" into its first and last page
+func parsePageRange(s string) (int, int, error) {
+	from, to, isRange := strings.Cut(s, "-")
+	first, err := strconv.Atoi(from)
+	last := first
+	if err == nil && isRange {
+		last, err = strconv.Atoi(to)
+	}
+	// Ranges are spelled out per page, so absurd ones are refused
+	if err != nil || first < 1 || last < first || last-first >= 1<<20 {
+		return 0, 0, fmt.Errorf("invalid pages %q", s)
+	}
+	return first, last, nil
+}
@@ -0,0 +1,157 @@
+package imageHandling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// MaxUpscaleFactor is the largest supported scale factor
+const MaxUpscaleFactor = 8
+
+// Upscaler enlarges an image by an integer factor. Implementations must
+// return an image of exactly factor times the width and height of img.
+type Upscaler interface {
+	Upscale(ctx context.Context, img *image.RGBA, factor int) (*image.RGBA, error)
+}
+
+// ResampleUpscaler interpolates with Catmull-Rom. It is fast and needs no
+// external tools, but adds no detail the way a super-resolution model does.
+type ResampleUpscaler struct{}
+
+func (ResampleUpscaler) Upscale(ctx context.Context, img *image.RGBA, factor int) (*image.RGBA, error) {
+	b := img.Bounds()
+	dst := getRGBA(image.Rect(0, 0, b.Dx()*factor, b.Dy()*factor))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst, nil
+}
+
+// CommandUpscaler runs an external super-resolution tool per image, such as
+// an ONNX or ncnn model runner. Command is split on spaces; the arguments
+// {in}, {out} and {scale} are replaced by the input PNG, the PNG the tool
+// must write and the scale factor.
+type CommandUpscaler struct {
+	Command string
+	TempDir string // Directory for the exchanged PNGs ("" = OS default)
+}
+
+func (u CommandUpscaler) Upscale(ctx context.Context, img *image.RGBA, factor int) (*image.RGBA, error) {
+	args := strings.Fields(u.Command)
+	if len(args) == 0 {
+		return nil, errors.New("empty upscale command")
+	}
+
+	in, err := os.CreateTemp(u.TempDir, "upscale*.png")
+	if err != nil {
+		return nil, err
+	}
+	inPath := in.Name()
+	outPath := strings.TrimSuffix(inPath, ".png") + ".out.png"
+	defer os.Remove(inPath)
+	defer os.Remove(outPath)
+
+	err = png.Encode(in, img)
+	if cerr := in.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write %s: %w", inPath, err)
+	}
+
+	r := strings.NewReplacer("{in}", inPath, "{out}", outPath, "{scale}", strconv.Itoa(factor))
+	for i := range args {
+		args[i] = r.Replace(args[i])
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", args[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	b := img.Bounds()
+	return readUpscaled(outPath, b.Dx()*factor, b.Dy()*factor)
+}
+
+// readUpscaled loads the result of an external upscaler, checking its size
+// before decoding so a misbehaving tool can't bloat memory
+func readUpscaled(path string, w, h int) (*image.RGBA, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read upscaled image: %w", err)
+	}
+	defer f.Close()
+
+	cfg, err := png.DecodeConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("read upscaled image: %w", err)
+	}
+	if cfg.Width != w || cfg.Height != h {
+		return nil, fmt.Errorf("upscaled image is %dx%d, want %dx%d", cfg.Width, cfg.Height, w, h)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	decoded, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("read upscaled image: %w", err)
+	}
+	return toRGBA(decoded), nil
+}
+
+// newUpscaler returns the upscaler selected by opts
+func newUpscaler(opts Options) Upscaler {
+	if opts.UpscaleCmd != "" {
+		return CommandUpscaler{Command: opts.UpscaleCmd, TempDir: opts.TempDir}
+	}
+	return ResampleUpscaler{}
+}
+
+// upscaleImages enlarges every image by factor on the encode pool.
+// Images that would exceed the pixel limit are left at their size.
+func (e *Extractor) upscaleImages(ctx context.Context, images []LoadedImage, up Upscaler, factor int, limits Limits, notices io.Writer) error {
+	skipped := 0
+	r := newRun(ctx)
+	for i := range images {
+		img := &images[i]
+		if limits.checkPixels(img.Width*factor, img.Height*factor) != nil {
+			skipped++
+			continue
+		}
+		ok := r.submit(e.encode, func() error {
+			out, err := up.Upscale(r.ctx, img.Img, factor)
+			if err != nil {
+				return fmt.Errorf("upscale image %d: %w", i+1, err)
+			}
+			putRGBA(img.Img)
+			img.Img = out
+			img.Width, img.Height = out.Bounds().Dx(), out.Bounds().Dy()
+			return nil
+		})
+		if !ok {
+			break
+		}
+	}
+	err := r.wait()
+	// A killed upscaler reports its own error; name the real cause
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		noticef(notices, "left %d image(s) at original size: upscaling would exceed the pixel limit", skipped)
+	}
+	return nil
+}
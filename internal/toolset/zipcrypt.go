@@ -0,0 +1,257 @@
+package imageHandling
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// ErrZipPassword is returned for an encrypted PDF of an archive if no
+// password was given, or not the one it was encrypted with
+var ErrZipPassword = errors.New("missing or wrong ZIP password")
+
+// Encryption of ZIP entries, as written by zip -e, 7-Zip and WinZip
+const (
+	zipEncrypted   = 0x1    // General purpose flag of encrypted entries
+	zipDescriptor  = 0x8    // Flag of entries whose CRC follows the data
+	zipMethodAES   = 99     // Method of WinZip AES entries
+	zipExtraAES    = 0x9901 // Extra field with the AES strength and method
+	zipCryptoHdr   = 12     // Header of traditional (ZipCrypto) entries
+	zipAESVerifier = 2      // Password verification value of AES entries
+	zipAESMAC      = 10     // Authentication code after AES entries
+)
+
+// openZipFile opens f for reading, decrypting it with password if it is
+// encrypted with ZipCrypto or WinZip AES. Like zip.File.Open, the reader
+// fails at the end if the content doesn't match its checksum.
+func openZipFile(f *zip.File, password string) (io.ReadCloser, error) {
+	if f.Flags&zipEncrypted == 0 {
+		return f.Open()
+	}
+	if password == "" {
+		return nil, ErrZipPassword
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	var data io.Reader
+	method, checkCRC := f.Method, true
+	if f.Method == zipMethodAES {
+		var strength int
+		strength, method, checkCRC, err = zipAESParams(f.Extra)
+		if err == nil {
+			data, err = newZipAESReader(raw, int64(f.CompressedSize64), strength, password)
+		}
+	} else {
+		check := byte(f.CRC32 >> 24)
+		if f.Flags&zipDescriptor != 0 {
+			check = byte(f.ModifiedTime >> 8)
+		}
+		data, err = newZipCryptoReader(raw, check, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &checkedReader{data: data, want: f.CRC32}
+	switch method {
+	case zip.Store:
+		c.rc = io.NopCloser(data)
+	case zip.Deflate:
+		c.rc = flate.NewReader(bufio.NewReader(data))
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+	if checkCRC {
+		c.crc = crc32.NewIEEE()
+	}
+	return c, nil
+}
+
+// zipAESParams reads the key strength (1-3 for 128-256 bit), the method
+// the data was compressed with and whether the entry keeps its CRC (AE-1)
+// from the extra fields of a WinZip AES entry
+func zipAESParams(extra []byte) (strength int, method uint16, crc bool, err error) {
+	for len(extra) >= 4 {
+		id, size := binary.LittleEndian.Uint16(extra), int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != zipExtraAES || size < 7 {
+			continue
+		}
+		strength = int(field[4])
+		if strength < 1 || strength > 3 {
+			return 0, 0, false, zip.ErrAlgorithm
+		}
+		return strength, binary.LittleEndian.Uint16(field[5:]), binary.LittleEndian.Uint16(field) == 1, nil
+	}
+	return 0, 0, false, zip.ErrFormat
+}
+
+// zipCryptoReader decrypts traditional PKWARE encryption
+type zipCryptoReader struct {
+	r    io.Reader
+	keys [3]uint32
+}
+
+// newZipCryptoReader reads the encryption header from r and returns a
+// reader of the data after it. check is the last byte of the decrypted
+// header, which tells most wrong passwords apart.
+func newZipCryptoReader(r io.Reader, check byte, password string) (*zipCryptoReader, error) {
+	z := &zipCryptoReader{r: r, keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for i := 0; i < len(password); i++ {
+		z.update(password[i])
+	}
+	var hdr [zipCryptoHdr]byte
+	if _, err := io.ReadFull(z, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[zipCryptoHdr-1] != check {
+		return nil, ErrZipPassword
+	}
+	return z, nil
+}
+
+func (z *zipCryptoReader) update(b byte) {
+	z.keys[0] = crc32.IEEETable[byte(z.keys[0])^b] ^ z.keys[0]>>8
+	z.keys[1] = (z.keys[1]+z.keys[0]&0xff)*134775813 + 1
+	z.keys[2] = crc32.IEEETable[byte(z.keys[2])^byte(z.keys[1]>>24)] ^ z.keys[2]>>8
+}
+
+func (z *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	for i := range p[:n] {
+		t := z.keys[2] | 2
+		p[i] ^= byte(t * (t ^ 1) >> 8)
+		z.update(p[i])
+	}
+	return n, err
+}
+
+// zipAESReader decrypts WinZip AES (AE-1 and AE-2): AES in counter mode
+// with a little-endian counter from 1, authenticated by HMAC-SHA1 of the
+// encrypted data
+type zipAESReader struct {
+	data    io.Reader // Encrypted data, without the code after it
+	rest    io.Reader // Authentication code
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int // Bytes of stream used
+	mac     hash.Hash
+	end     error // Result of the check at the end, once read
+}
+
+// newZipAESReader reads the salt and password verifier from r, an entry
+// of size bytes, and returns a reader of the data after them
+func newZipAESReader(r io.Reader, size int64, strength int, password string) (*zipAESReader, error) {
+	keyLen := 8 + 8*strength
+	saltLen := keyLen / 2
+	if size < int64(saltLen+zipAESVerifier+zipAESMAC) {
+		return nil, zip.ErrFormat
+	}
+	head := make([]byte, saltLen+zipAESVerifier)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	keys, err := pbkdf2.Key(sha1.New, password, head[:saltLen], 1000, 2*keyLen+zipAESVerifier)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(keys[2*keyLen:], head[saltLen:]) != 1 {
+		return nil, ErrZipPassword
+	}
+	block, err := aes.NewCipher(keys[:keyLen])
+	if err != nil {
+		return nil, err
+	}
+	return &zipAESReader{
+		data:  io.LimitReader(r, size-int64(len(head))-zipAESMAC),
+		rest:  r,
+		block: block,
+		used:  aes.BlockSize,
+		mac:   hmac.New(sha1.New, keys[keyLen:2*keyLen]),
+	}, nil
+}
+
+func (z *zipAESReader) Read(p []byte) (int, error) {
+	if z.end != nil {
+		return 0, z.end
+	}
+	n, err := z.data.Read(p)
+	z.mac.Write(p[:n])
+	for i := range p[:n] {
+		if z.used == aes.BlockSize {
+			for j := range z.counter {
+				z.counter[j]++
+				if z.counter[j] != 0 {
+					break
+				}
+			}
+			z.block.Encrypt(z.stream[:], z.counter[:])
+			z.used = 0
+		}
+		p[i] ^= z.stream[z.used]
+		z.used++
+	}
+	if err == io.EOF {
+		var code [zipAESMAC]byte
+		if _, err := io.ReadFull(z.rest, code[:]); err != nil {
+			z.end = io.ErrUnexpectedEOF
+		} else if !hmac.Equal(z.mac.Sum(nil)[:zipAESMAC], code[:]) {
+			z.end = zip.ErrChecksum
+		} else {
+			z.end = io.EOF
+		}
+		return n, z.end
+	}
+	return n, err
+}
+
+// checkedReader reads the decompressed content of an encrypted entry and
+// fails at its end if the content doesn't match the CRC, or the decrypted
+// data its authentication code. The decompressor may stop short of the
+// end of the data, so the rest is read to get to the code.
+type checkedReader struct {
+	rc   io.ReadCloser
+	data io.Reader // Decrypted data
+	crc  hash.Hash32
+	want uint32
+}
+
+func (c *checkedReader) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	if c.crc != nil {
+		c.crc.Write(p[:n])
+	}
+	if err != io.EOF {
+		return n, err
+	}
+	if _, err := io.Copy(io.Discard, c.data); err != nil {
+		return n, err
+	}
+	if c.crc != nil && c.crc.Sum32() != c.want {
+		return n, zip.ErrChecksum
+	}
+	return n, io.EOF
+}
+
+func (c *checkedReader) Close() error {
+	return c.rc.Close()
+}
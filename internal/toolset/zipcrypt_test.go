@@ -0,0 +1,257 @@
+package imageHandling
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/rand/v2"
+	"testing"
+)
+
+// zipCryptoEncrypt encrypts data with traditional PKWARE encryption,
+// after a header ending in check
+func zipCryptoEncrypt(password string, check byte, data []byte) []byte {
+	z := &zipCryptoReader{keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for i := 0; i < len(password); i++ {
+		z.update(password[i])
+	}
+	plain := append([]byte("0123456789a"), check)
+	plain = append(plain, data...)
+	out := make([]byte, len(plain))
+	for i, b := range plain {
+		t := z.keys[2] | 2
+		out[i] = b ^ byte(t*(t^1)>>8)
+		z.update(b)
+	}
+	return out
+}
+
+// zipAESEncrypt encrypts data as WinZip AES with the key strength: salt,
+// password verifier, AES-CTR data with a little-endian counter from 1 and
+// the first 10 bytes of its HMAC-SHA1
+func zipAESEncrypt(t *testing.T, password string, strength int, data []byte) []byte {
+	t.Helper()
+	keyLen := 8 + 8*strength
+	salt := bytes.Repeat([]byte{0x5a}, keyLen/2)
+	keys, err := pbkdf2.Key(sha1.New, password, salt, 1000, 2*keyLen+2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(keys[:keyLen])
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := make([]byte, len(data))
+	var counter, stream [aes.BlockSize]byte
+	for i := range data {
+		if i%aes.BlockSize == 0 {
+			binary.LittleEndian.PutUint64(counter[:], uint64(i/aes.BlockSize+1))
+			block.Encrypt(stream[:], counter[:])
+		}
+		enc[i] = data[i] ^ stream[i%aes.BlockSize]
+	}
+	mac := hmac.New(sha1.New, keys[keyLen:2*keyLen])
+	mac.Write(enc)
+	out := append(salt, keys[2*keyLen:]...)
+	out = append(out, enc...)
+	return append(out, mac.Sum(nil)[:zipAESMAC]...)
+}
+
+// zipAESExtra returns the extra field of a WinZip AES entry
+func zipAESExtra(version, strength int, method uint16) []byte {
+	extra := binary.LittleEndian.AppendUint16(nil, zipExtraAES)
+	extra = binary.LittleEndian.AppendUint16(extra, 7)
+	extra = binary.LittleEndian.AppendUint16(extra, uint16(version))
+	extra = append(extra, 'A', 'E', byte(strength))
+	return binary.LittleEndian.AppendUint16(extra, method)
+}
+
+// rawZipFile writes an archive of one entry with the raw data and
+// returns the entry as read back
+func rawZipFile(t *testing.T, fh *zip.FileHeader, raw []byte) *zip.File {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fh.CompressedSize64 = uint64(len(raw))
+	out, err := w.CreateRaw(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := out.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.File[0]
+}
+
+func deflated(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOpenZipFile(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	content := make([]byte, 3000)
+	for i := range content {
+		content[i] = byte(rng.IntN(8)) // Compressible, and not block-aligned
+	}
+	crc := crc32.ChecksumIEEE(content)
+
+	// flip corrupts the byte at i of the raw data (negative: from the end)
+	flip := func(i int) func([]byte) {
+		return func(raw []byte) {
+			if i < 0 {
+				i += len(raw)
+			}
+			raw[i] ^= 0x40
+		}
+	}
+
+	tests := []struct {
+		name     string
+		aes      int // Key strength, 0 = ZipCrypto
+		version  int // AE-1 or AE-2
+		method   uint16
+		desc     bool // ZipCrypto entry with a data descriptor
+		password string
+		corrupt  func([]byte)
+		openErr  error
+		readErr  error
+	}{
+		{name: "zipcrypto/store", method: zip.Store, password: "pw"},
+		{name: "zipcrypto/deflate", method: zip.Deflate, password: "pw"},
+		{name: "zipcrypto/descriptor", method: zip.Deflate, desc: true, password: "pw"},
+		{name: "zipcrypto/no password", method: zip.Store, openErr: ErrZipPassword},
+		{name: "zipcrypto/wrong password", method: zip.Store, password: "px", openErr: ErrZipPassword},
+		{name: "zipcrypto/corrupt", method: zip.Store, password: "pw", corrupt: flip(-1), readErr: zip.ErrChecksum},
+		{name: "ae1/128/store", aes: 1, version: 1, method: zip.Store, password: "pw"},
+		{name: "ae1/192/deflate", aes: 2, version: 1, method: zip.Deflate, password: "pw"},
+		{name: "ae2/256/deflate", aes: 3, version: 2, method: zip.Deflate, password: "pw"},
+		{name: "ae2/no password", aes: 3, version: 2, method: zip.Store, openErr: ErrZipPassword},
+		{name: "ae2/wrong password", aes: 3, version: 2, method: zip.Store, password: "px", openErr: ErrZipPassword},
+		{name: "ae2/corrupt data", aes: 3, version: 2, method: zip.Store, password: "pw", corrupt: flip(100), readErr: zip.ErrChecksum},
+		{name: "ae2/corrupt mac", aes: 3, version: 2, method: zip.Store, password: "pw", corrupt: flip(-1), readErr: zip.ErrChecksum},
+		{name: "ae1/bad method", aes: 1, version: 1, method: zip.Store + 1, password: "pw", openErr: zip.ErrAlgorithm},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := content
+			if tt.method == zip.Deflate {
+				data = deflated(t, content)
+			}
+			fh := &zip.FileHeader{
+				Name:               "doc.pdf",
+				Flags:              zipEncrypted,
+				Method:             tt.method,
+				CRC32:              crc,
+				UncompressedSize64: uint64(len(content)),
+				ModifiedTime:       0x6b2c,
+			}
+			var raw []byte
+			if tt.aes != 0 {
+				if tt.version == 2 {
+					fh.CRC32 = 0
+				}
+				fh.Method, fh.Extra = zipMethodAES, zipAESExtra(tt.version, tt.aes, tt.method)
+				raw = zipAESEncrypt(t, "pw", tt.aes, data)
+			} else {
+				check := byte(crc >> 24)
+				if tt.desc {
+					fh.Flags |= zipDescriptor
+					check = byte(fh.ModifiedTime >> 8)
+				}
+				raw = zipCryptoEncrypt("pw", check, data)
+			}
+			if tt.corrupt != nil {
+				tt.corrupt(raw)
+			}
+
+			rc, err := openZipFile(rawZipFile(t, fh, raw), tt.password)
+			if tt.openErr != nil || err != nil {
+				if !errors.Is(err, tt.openErr) {
+					t.Fatalf("open: error %v, want %v", err, tt.openErr)
+				}
+				return
+			}
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			if tt.readErr != nil || err != nil {
+				if !errors.Is(err, tt.readErr) {
+					t.Fatalf("read: error %v, want %v", err, tt.readErr)
+				}
+				return
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("read %d bytes differing from the %d written", len(got), len(content))
+			}
+		})
+	}
+}
+
+func TestOpenZipFileUnencrypted(t *testing.T) {
+	content := []byte("%PDF-1.7\n")
+	fh := &zip.FileHeader{Name: "doc.pdf", Method: zip.Store, CRC32: crc32.ChecksumIEEE(content), UncompressedSize64: uint64(len(content))}
+	rc, err := openZipFile(rawZipFile(t, fh, content), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, err := io.ReadAll(rc); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("read %q, %v; want %q", got, err, content)
+	}
+}
+
+func TestZipAESParams(t *testing.T) {
+	other := []byte{0x55, 0x54, 0x05, 0x00, 1, 2, 3, 4, 5} // Extended timestamp
+	tests := []struct {
+		name     string
+		extra    []byte
+		strength int
+		method   uint16
+		crc      bool
+		err      error
+	}{
+		{"ae1", zipAESExtra(1, 3, zip.Deflate), 3, zip.Deflate, true, nil},
+		{"ae2", zipAESExtra(2, 1, zip.Store), 1, zip.Store, false, nil},
+		{"after other field", append(append([]byte{}, other...), zipAESExtra(2, 2, zip.Deflate)...), 2, zip.Deflate, false, nil},
+		{"strength 0", zipAESExtra(2, 0, zip.Store), 0, 0, false, zip.ErrAlgorithm},
+		{"strength 4", zipAESExtra(2, 4, zip.Store), 0, 0, false, zip.ErrAlgorithm},
+		{"missing", other, 0, 0, false, zip.ErrFormat},
+		{"truncated", zipAESExtra(2, 3, zip.Store)[:8], 0, 0, false, zip.ErrFormat},
+		{"empty", nil, 0, 0, false, zip.ErrFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strength, method, crc, err := zipAESParams(tt.extra)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if strength != tt.strength || method != tt.method || crc != tt.crc {
+				t.Errorf("strength %d, method %d, crc %t; want %d, %d, %t", strength, method, crc, tt.strength, tt.method, tt.crc)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+//go:build darwin
+
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// keychainEntries reads pixf's entries matching name from the login
+// keychain with the security tool. Entries are generic passwords with
+// service pixf and the file name pattern as account:
+//
+//	security add-generic-password -s pixf -a 'invoices-*.pdf' -w
+//
+// Only matching entries are read, so macOS asks for access to those alone.
+func keychainEntries(name string) ([]keychainEntry, error) {
+	cmd := exec.Command("security", "dump-keychain")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, keychainToolError(cmd, err)
+	}
+
+	// Items start with a "keychain:" line; their attributes follow as
+	// `"acct"<blob>="value"`
+	var patterns []string
+	var account, service string
+	flush := func() {
+		if service == keychainService && account != "" && patternMatches(account, name) {
+			patterns = append(patterns, account)
+		}
+		account, service = "", ""
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "keychain:"):
+			flush()
+		case strings.HasPrefix(line, `"acct"<blob>=`):
+			account = blobValue(strings.TrimPrefix(line, `"acct"<blob>=`))
+		case strings.HasPrefix(line, `"svce"<blob>=`):
+			service = blobValue(strings.TrimPrefix(line, `"svce"<blob>=`))
+		}
+	}
+	flush()
+
+	var entries []keychainEntry
+	for _, pattern := range patterns {
+		cmd := exec.Command("security", "find-generic-password", "-s", keychainService, "-a", pattern, "-w")
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, keychainToolError(cmd, err)
+		}
+		entries = append(entries, keychainEntry{pattern: pattern, password: strings.TrimSuffix(string(out), "\n")})
+	}
+	return entries, nil
+}
+
+// blobValue reads a quoted attribute value of dump-keychain; values shown
+// as hex (<NULL> or non-ASCII) are returned empty
+func blobValue(s string) string {
+	if v, err := strconv.Unquote(s); err == nil {
+		return v
+	}
+	return ""
+}
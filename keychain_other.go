@@ -0,0 +1,54 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// keychainEntries reads pixf's entries matching name from the Secret
+// Service keyring (GNOME Keyring, KWallet) with secret-tool. Entries are
+// stored with the attributes service=pixf and pattern=<file name pattern>:
+//
+//	secret-tool store --label "pixf invoices" service pixf pattern 'invoices-*.pdf'
+func keychainEntries(name string) ([]keychainEntry, error) {
+	cmd := exec.Command("secret-tool", "search", "--all", "--unlock", "service", keychainService)
+	out, err := cmd.Output()
+	if err != nil {
+		// secret-tool fails silently when nothing is stored
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(out) == 0 && len(exitErr.Stderr) == 0 {
+			return nil, nil
+		}
+		return nil, keychainToolError(cmd, err)
+	}
+
+	// Items are printed as "[path]" followed by "key = value" lines
+	var items []keychainEntry
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "[") {
+			items = append(items, keychainEntry{})
+			continue
+		}
+		key, value, ok := strings.Cut(line, " = ")
+		if !ok || len(items) == 0 {
+			continue
+		}
+		switch item := &items[len(items)-1]; key {
+		case "secret":
+			item.password = value
+		case "attribute.pattern":
+			item.pattern = value
+		}
+	}
+
+	var entries []keychainEntry
+	for _, item := range items {
+		if item.pattern != "" && item.password != "" && patternMatches(item.pattern, name) {
+			entries = append(entries, item)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,78 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+var (
+	advapi32             = syscall.NewLazyDLL("advapi32.dll")
+	procCredEnumerateW   = advapi32.NewProc("CredEnumerateW")
+	procCredFree         = advapi32.NewProc("CredFree")
+	errNotFound          = syscall.Errno(1168) // ERROR_NOT_FOUND
+	keychainTargetPrefix = keychainService + ":"
+)
+
+// credential mirrors CREDENTIALW
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// keychainEntries reads pixf's entries matching name from the Windows
+// Credential Manager. Entries are generic credentials whose target is
+// "pixf:" followed by the file name pattern:
+//
+//	cmdkey /generic:"pixf:invoices-*.pdf" /user:pixf /pass
+func keychainEntries(name string) ([]keychainEntry, error) {
+	filter, err := syscall.UTF16PtrFromString(keychainTargetPrefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	var count uint32
+	var creds **credential
+	r, _, callErr := procCredEnumerateW.Call(uintptr(unsafe.Pointer(filter)), 0,
+		uintptr(unsafe.Pointer(&count)), uintptr(unsafe.Pointer(&creds)))
+	if r == 0 {
+		if callErr == errNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("CredEnumerateW: %w", callErr)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(creds)))
+
+	var entries []keychainEntry
+	for _, c := range unsafe.Slice(creds, count) {
+		pattern := strings.TrimPrefix(syscall.UTF16ToString(unsafe.Slice(c.TargetName, wcslen(c.TargetName))), keychainTargetPrefix)
+		if c.CredentialBlob == nil || !patternMatches(pattern, name) {
+			continue
+		}
+		// cmdkey and the Credential Manager store passwords as UTF-16
+		blob := unsafe.Slice((*uint16)(unsafe.Pointer(c.CredentialBlob)), c.CredentialBlobSize/2)
+		entries = append(entries, keychainEntry{pattern: pattern, password: string(utf16.Decode(blob))})
+	}
+	return entries, nil
+}
+
+// wcslen counts the UTF-16 units of a NUL-terminated string
+func wcslen(p *uint16) int {
+	n := 0
+	for ; *(*uint16)(unsafe.Add(unsafe.Pointer(p), n*2)) != 0; n++ {
+	}
+	return n
+}
@@ -1,50 +1,549 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
 	imageHandling "pixf/internal/toolset"
+	"slices"
+	"strconv"
 	"strings"
-
-	"github.com/pdfcpu/pdfcpu/pkg/api"
-	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"time"
 )
 
 func printHelp() {
 	fmt.Println(`Usage: pixf [OPTIONS] <pdf-file> [format]
+       pixf <command> [OPTIONS] <args>
 
 A tool for working with PDF files - unlock PDFs and extract images.
 
 Arguments:
-  pdf-file     Path to the PDF file to process (required)
+  pdf-file     Path to the PDF file to process (required), a .zip
+               archive whose PDFs are all extracted, or an Office
+               document (.docx, .pptx, .xlsx, .odt, ...) converted to
+               PDF first
   format       Image output format (optional, defaults to 'original')
-               Supported formats: original, png, webp
+               Supported formats: original, png, webp, heic
+               (heic only in builds with -tags heif)
+
+Commands:
+  cluster <dir-or-pdf> Group perceptually similar images and print a report
+                       (--threshold N, --json)
+  match <pdf-file>     Report images of a PDF that exist, exactly or
+                       perceptually, in a reference library (--library
+                       dir-or-index, --threshold N, --save-index file,
+                       --json)
+  index <dir>...       Index the images of the output directories below
+                       the given directories for search (--out file)
+  search <words>...    Find which PDF and page indexed images come from,
+                       by input, document, caption or file name, or by
+                       likeness to an image (--index file, --image file,
+                       --threshold N, --json)
+  list <pdf-file>      List the images of a PDF with the IDs --objects
+                       accepts (--json)
+  pick <pdf-file>      Choose images to export from a list, optionally
+                       with inline previews (--preview kitty|sixel,
+                       --output-dir dir)
+  grab <pdf-file>      Copy one image to the clipboard or save it as PNG
+                       (--page N, --index N, --clipboard, --out file)
+  batch <dir-or-pdf>...
+                       Unlock and extract many PDFs, Office documents and
+                       the PDFs attached to .eml and .msg emails,
+                       decrypting the next while the current one is
+                       extracted (--format, --output-dir,
+                       --dir-policy, --dedup-index,
+                       --batch-report json|csv|html, --fail-on-empty,
+                       --min-dpi, --require-color-managed, --rotate,
+                       --flip, --max-total-output, --engine,
+                       --encode-share, --preset,
+                       --events, --events-file, --file-mode, --dir-mode,
+                       --chown,
+                       --force, --safe-names, --timeout, --tmpdir,
+                       --ignore-permissions, --password-file, --keychain,
+                       --p12, --p12-pass-file, --convert-cmd, --quiet,
+                       --json, --list-duplicates, --usage, --cpuprofile,
+                       --memprofile, --trace, --pprof)
+  sigs <pdf-file>      List digital signatures with signers and validity
+                       (--json, --trust roots.pem, --validator cmd)
+  forms <pdf-file>     Export form field names and values
+                       (--format json|fdf, --out file, --ignore-permissions)
+  annots <pdf-file>    List comments, highlights and other annotations
+                       (--json, --ignore-permissions)
+  pack <dir> <out>     Pack extracted page images into a comic book (.cbz)
+                       or fixed-layout ebook (.epub) (--title, --author,
+                       --lang, --rtl)
+  assemble --from-manifest <dir> <out.pdf>
+                       Rebuild a PDF from extracted images, placing them
+                       where and in the order they were on each page
 
 Options:
   -h, --help           Show this help message
   --unlock-only        Only unlock the PDF, do not extract images
   --extract-only       Only extract images, do not unlock the PDF first
+  --preset <name>      Fill in the options not given: archive (originals,
+                       min dpi 300), web (webp, no metadata, similar
+                       images merged), ocr (png, despeckled, auto levels,
+                       per-page dedup, min dpi 300) or a preset of the
+                       config file ($PIXF_CONFIG, default:
+                       <config dir>/pixf/config.json); also for batch
+  --strip-metadata     Remove EXIF/XMP/ICC data from extracted images
+  --tmpdir <dir>       Directory for temporary files (default: OS temp dir)
+  --output-dir <dir>   Directory for unlocked PDFs and images (default: .)
+  --dir-policy <p>     If images_<name> already exists: reuse it (default),
+                       extract into images_<name>_<timestamp> instead
+                       (timestamp) or fail (error)
+  --file-mode <mode>   Give written files this octal mode, e.g. 0640
+  --dir-mode <mode>    Give written directories this octal mode, e.g. 0750
+  --chown <user:group> Give outputs this owner and group (Unix only)
+  --force              Re-extract even if the output is already up to date
+  --incremental        If the output holds the images of an earlier revision
+                       of the PDF, extract only those that incremental
+                       updates since added or changed
+  --cache              Reuse results of earlier runs on the same PDF with the
+                       same options from the cache (default: user cache dir)
+  --cache-dir <dir>    Cache location (implies --cache)
+  --cache-size <size>  Evict least recently used results beyond this size,
+                       e.g. 500M or 10G (default: 1G)
+  --safe-names         Transliterate output names to plain ASCII
+  --outline-dirs       Group images into folders named after the outline
+                       (bookmark) section containing their page
+  --caption-names      Name images after the caption next to them, such
+                       as "Figure 3: Overview"
+  --start-index <n>    Number images from n instead of 1
+  --number-by-page     Start numbering again on every page and include the
+                       page in the name, e.g. image_p12_001
+  --objects <list>     Extract only these images: object numbers or
+                       page.resource IDs from "pixf list", e.g. 15,3.Im3
+  --recurse-attachments
+                       Also extract the images of PDFs attached to the
+                       PDF, and of PDFs attached to those, each into a
+                       folder named after it (not with --stitch or
+                       --multipage-tiff)
+  --attachment-depth <n>
+                       How many levels of attachments to follow with
+                       --recurse-attachments (default: 3)
+  --html-report        Write an index.html gallery into the image directory
+  --report <csv|tsv|markdown>
+                       Write per-image statistics as report.csv or
+                       report.tsv, or report.md listing the images by page
+                       with links, for wikis and pull requests
+  --inline-under <size>
+                       Embed images smaller than size, e.g. 8K, in
+                       report.md as data URIs instead of links (TIFF
+                       and other types browsers don't show stay linked)
+  --analyze            Record dominant colors and luminance histograms
+  --min-dpi <n>        Warn about images drawn at less than n pixels per
+                       inch, e.g. scans too coarse for OCR, and flag them
+                       in the manifest; also for batch
+  --require-color-managed
+                       Fail if the ICC profile of an image can't be kept
+                       in the output, so colors stay accurate for print;
+                       also for batch
+  --embed-previews <s> Embed a base64 preview of each image, at most s
+                       pixels across (e.g. 64px), in the manifest
+  --dedup-scope <s>    Where duplicates are removed: document (default),
+                       page or off
+  --similar <n>        Also merge perceptually similar images within
+                       hash distance n (0-64, default 0 = exact only)
+  --dedup-keep <p>     Which duplicate to keep: first (default),
+                       largest-pixels or largest-bytes
+  --dedup-index <file> Leave out images written by earlier runs recorded
+                       in file, and record the new ones (not with
+                       --cache); also for batch
+  --decode-workers <n> Concurrent image decoders (default: CPU count)
+  --encode-workers <n> Concurrent image encoders (default: CPU count)
+  --write-workers <n>  Concurrent file writers (default: 2)
+  --encode-share <f=s> Let output format f take at most share s of the
+                       encoders, e.g. webp=0.5; comma-separated for
+                       several formats; also for batch
+  --timeout <d>        Give up on a PDF after this long, e.g. 10m or 90s
+                       (default: no limit)
+  --max-pixels <n>     Quarantine images larger than n pixels
+                       (default: 200000000)
+  --max-image-bytes <n>
+                       Quarantine image streams larger than n bytes
+                       (default: 268435456)
+  --decode-timeout <d> Quarantine images that take longer to decode
+                       (default: 1m)
+  --sandbox            Process the PDF in a restricted child process
+                       (Linux only: no network, no privileges, rlimits)
+  --audit-log <file>   Append a JSON record of this run to file
+  --events jsonl       Stream one line of JSON per pipeline step
+                       (decrypted, extracted, decoded, deduped, encoded,
+                       written, error) to stderr; also for batch
+  --events-file <file> Append the --events stream to file instead
+  --open               Open the output directory (or the HTML report) in
+                       the file manager or browser when done
+  --fail-on-empty      Exit with status 3 if the PDF holds no images at
+                       all, so pipelines can route it; also for batch
+  --max-total-output <size>
+                       Stop writing images of a document once they would
+                       take more than size, e.g. 2G, record the cut in the
+                       manifest and exit with status 4; also for batch
+  --engine <name>      Library rendering the image streams: pdfcpu
+                       (default) or mupdf (needs mutool), for PDFs only
+                       one of them reads; pdfcpu still parses the PDF,
+                       so one it can't open fails either way; also for
+                       batch
+  --ignore-permissions Extract from PDFs whose permissions forbid it
+                       (default: refuse); also for pick, grab and batch
+  --password-file <file>
+                       Try these passwords, one per line, on PDFs that
+                       need one; also for batch
+  --keychain           Look up passwords in the OS keychain by file name
+                       pattern (not with --sandbox); also for batch
+  --p12 <file>         Certificate and private key (PKCS#12) for PDFs
+                       encrypted to a certificate; also for batch
+  --p12-pass-file <file>
+                       File whose first line is the --p12 passphrase
+                       (default: $PIXF_P12_PASS, else empty)
+  --zip-password <pw>  Decrypt the PDFs of a ZIP archive encrypted with
+                       ZipCrypto or AES as they are read (default:
+                       $PIXF_ZIP_PASSWORD)
+  --despeckle         Remove specks from grayscale and bilevel scans in
+                       converted images (3x3 median filter)
+  --rotate <deg>       Turn converted images clockwise by 90, 180 or 270
+                       degrees, e.g. 180 for scans fed in upside down;
+                       also for batch
+  --flip <h|v>         Mirror converted images left to right (h) or top to
+                       bottom (v), after --rotate; also for batch
+  --rotate-map <file>  Turn and mirror the images of the pages listed in
+                       file instead, one line each: pages, degrees and
+                       optionally h or v, e.g. "3 180" or "10-12 90 h"
+  --autocrop           Trim black or white scanner borders off converted
+                       images
+  --autocrop-tolerance <n>
+                       How far border pixels may stray from pure black or
+                       white, 0-255 (default: 24)
+  --split-spread       Split double-page scans into left and right pages
+  --transparent-color <#RRGGBB>
+                       Make this color transparent in converted images,
+                       e.g. #FFFFFF for logos on white
+  --transparent-tolerance <n>
+                       How far a channel may differ from that color and
+                       still become transparent, 0-255 (default: 16)
+  --invert             Turn negative scans (e.g. microfilm) into positives
+  --brightness <n>     Brighten (or darken, if negative) converted images
+                       by n percent, -100 to 100
+  --contrast <n>       Raise (or lower, if negative) contrast by n percent,
+                       -100 to 100
+  --gamma <g>          Gamma correction, above 1 brightens midtones
+                       (default: 1 = none)
+  --auto-levels        Stretch each converted image to the full tonal range
+  --stamp <text>       Mark every converted image with text, e.g. CONFIDENTIAL
+  --stamp-image <file> Mark every converted image with this image instead
+  --stamp-position <p> Where to put the stamp: bottom-right (default),
+                       bottom-left, top-right, top-left or center
+  --stamp-opacity <o>  Stamp opacity from 0 to 1 (default: 0.5)
+  --stitch <d>         Also join all converted images into one long image,
+                       vertical or horizontal
+  --multipage-tiff     Also write all images as pages of one pages.tif
+  --tile <w>x<h>       Split converted images larger than this into tiles,
+                       e.g. 1024x1024, listed in tiles.json
+  --pyramid <layout>   Also write a zoomable tile pyramid of every
+                       converted image: dzi (Deep Zoom) or iiif (IIIF
+                       Image API level 0)
+  --iiif-base <url>    URL the image directory is served under, for the
+                       ids in IIIF info.json files
+  --upscale <n>x       Enlarge converted images n times (2x-8x), e.g. for
+                       low-resolution scans before OCR
+  --upscale-cmd <cmd>  Upscale with an external tool instead of resampling;
+                       {in}, {out} and {scale} are replaced in cmd
+  --optimize-png       Shrink png output for publishing: palettes for images
+                       with up to 256 colors, per-row filter choice and
+                       maximum compression (slower)
+  --optimize-png-cmd <cmd>
+                       Also run an external optimizer such as zopflipng on
+                       every PNG; {in} and {out} are replaced in cmd
+  --provenance         Also write traced_<name>.pdf, a copy recording the
+                       manifest hash and pixf version in its XMP metadata
+  --xattr              Tag every image with its source, page and the input
+                       hash in extended attributes (user.pixf.*), or
+                       alternate data streams on Windows
+  --convert-cmd <cmd>  Convert Office documents to PDF with this command
+                       instead of LibreOffice; {in} and {out} are replaced
+                       in cmd; also for batch
+  --quiet              Print only errors: no progress messages and no
+                       summary of images, duplicates, errors, sizes and
+                       throughput when done; also for batch
+  --json               Print the summary as one line of JSON, the only
+                       output on stdout; progress and errors go to
+                       stderr; also for batch
+  --list-duplicates    List the duplicates left out with the page and
+                       object of each and the image kept; also for batch
+  --usage              Print wall and CPU time, peak memory, goroutines,
+                       bytes read and written and the time of each
+                       pipeline stage when done; also for batch
+  --cpuprofile <file>  Write a CPU profile (go tool pprof); also for batch
+  --memprofile <file>  Write a heap profile when done; also for batch
+  --trace <file>       Write an execution trace (go tool trace); also for
+                       batch
+  --pprof <addr>       Serve net/http/pprof endpoints on addr while running,
+                       e.g. localhost:6060 (not with --sandbox); also for
+                       batch
+  --version            Show the pixf version
 
 Format Options:
   original    Extract images using PDF's native format (default)
   png         Extract as PNG with transparency support
   webp        Extract as WebP with transparency support
+  heic        Extract as HEIC for Apple Photos (lossy; needs a build
+              with -tags heif and libheif)
 
 Examples:
   pixf document.pdf                    # Unlock and extract images (original format)
   pixf document.pdf png                # Unlock and extract as PNG
   pixf --unlock-only document.pdf      # Only unlock the PDF
   pixf --extract-only document.pdf     # Only extract images from PDF
+  pixf --strip-metadata document.pdf   # Extract images without metadata
+  pixf bundle.zip png                  # Extract the images of every PDF in a ZIP
+  pixf --zip-password s3cret vendor.zip  # Extract from an encrypted ZIP
+  pixf slides.pptx png                 # Convert with LibreOffice, then extract
+  pixf cluster scans/                  # Find similar images in a directory
+  pixf match --library assets/ doc.pdf # Find our images in a PDF
+  pixf search invoice 2023             # Find images indexed with pixf index
+  pixf list document.pdf               # List images with their IDs
+  pixf pick --preview kitty doc.pdf    # Pick images to export interactively
+  pixf grab doc.pdf --page 3 --clipboard  # Copy the first image of page 3
+  pixf batch --format png scans/       # Unlock and extract every PDF in scans/
+  pixf batch inbox/invoice.eml         # Extract the PDFs attached to an email
+  pixf --password-file pw.txt doc.pdf  # Try known passwords on doc.pdf
+  pixf --provenance document.pdf       # Link the PDF to its extracted images
+  pixf sigs contract.pdf               # Check whether a PDF is signed
+  pixf forms --format fdf form.pdf     # Export filled-in form data as FDF
+  pixf annots --json review.pdf        # Export review comments as JSON
+  pixf assemble --from-manifest images_doc doc2.pdf  # Rebuild doc.pdf from its images
   pixf -h                              # Show this help message`)
 }
 
+// unlock decrypts input in memory and writes the unlocked copy to output,
+// giving up when ctx is done. The document stays open so images can be
+// extracted without reading the copy back; unlocked is false when input
+// isn't encrypted and no copy was written. creds are used if input can't
+// be opened without. Unless ignorePerms is set, a PDF whose permissions
+// forbid extraction is neither copied nor kept open.
+func unlock(ctx context.Context, input, output string, ignorePerms bool, creds imageHandling.Credentials) (doc *imageHandling.Document, unlocked bool, err error) {
+	doc, err = imageHandling.OpenDocument(ctx, input, creds)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ignorePerms && !doc.AllowsExtraction() {
+		doc.Close()
+		return nil, false, imageHandling.ErrExtractionForbidden
+	}
+	err = doc.WriteUnlocked(ctx, output)
+	switch {
+	case errors.Is(err, imageHandling.ErrNotEncrypted):
+		return doc, false, nil
+	case err == nil:
+		err = setPermissions(output)
+	}
+	if err != nil {
+		doc.Close()
+		return nil, false, err
+	}
+	return doc, true, nil
+}
+
+// verifyInput fails the run when the input PDF changed while pixf worked;
+// pixf only ever reads it, even when decrypting
+func verifyInput(filename, hash string) {
+	if err := imageHandling.VerifyUnchanged(filename, hash); err != nil {
+		fail("Error verifying input:", err.Error())
+	}
+	auditVerified()
+}
+
+// parseUpscale reads an upscale factor such as "2x"; "" means no upscaling
+func parseUpscale(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(s), "x"))
+	if err != nil || n < 2 || n > imageHandling.MaxUpscaleFactor {
+		return 0, fmt.Errorf("invalid upscale factor '%s' (use 2x to %dx)", s, imageHandling.MaxUpscaleFactor)
+	}
+	return n, nil
+}
+
+// parsePreviewSize reads a preview size such as "64px"; "" means no
+// previews
+func parsePreviewSize(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(s), "px"))
+	if err != nil || n < 1 || n > imageHandling.MaxPreviewSize {
+		return 0, fmt.Errorf("invalid preview size '%s' (use 1px to %dpx)", s, imageHandling.MaxPreviewSize)
+	}
+	return n, nil
+}
+
+// describeError spells out timeouts, which otherwise read as a bare
+// "context deadline exceeded"
+func describeError(err error, timeout time.Duration) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Sprintf("timed out after %s: %v", timeout, err)
+	}
+	if errors.Is(err, imageHandling.ErrCertificateRequired) {
+		return err.Error() + " (use --p12 to give its certificate and key)"
+	}
+	if errors.Is(err, imageHandling.ErrEncrypted) {
+		return err.Error() + " (use --password-file or --keychain)"
+	}
+	if errors.Is(err, imageHandling.ErrZipPassword) {
+		return err.Error() + " (use --zip-password)"
+	}
+	if errors.Is(err, imageHandling.ErrNotColorManaged) {
+		return err.Error() + " (use png or original output, without --strip-metadata)"
+	}
+	if errors.Is(err, imageHandling.ErrExtractionForbidden) {
+		return err.Error() + " (use --ignore-permissions if your policy allows bypassing them)"
+	}
+	return err.Error()
+}
+
 func main() {
+	// Dispatch subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "cluster":
+			runCluster(os.Args[2:])
+			return
+		case "match":
+			runMatch(os.Args[2:])
+			return
+		case "index":
+			runIndex(os.Args[2:])
+			return
+		case "search":
+			runSearch(os.Args[2:])
+			return
+		case "list":
+			runList(os.Args[2:])
+			return
+		case "pick":
+			runPick(os.Args[2:])
+			return
+		case "grab":
+			runGrab(os.Args[2:])
+			return
+		case "batch":
+			runBatch(os.Args[2:])
+			return
+		case "sigs":
+			runSigs(os.Args[2:])
+			return
+		case "forms":
+			runForms(os.Args[2:])
+			return
+		case "annots":
+			runAnnots(os.Args[2:])
+			return
+		case "pack":
+			runPack(os.Args[2:])
+			return
+		case "assemble":
+			runAssemble(os.Args[2:])
+			return
+		}
+	}
+
 	// Define flags
 	helpFlag := flag.Bool("h", false, "Show help")
 	helpFlagLong := flag.Bool("help", false, "Show help")
 	unlockOnly := flag.Bool("unlock-only", false, "Only unlock the PDF")
 	extractOnly := flag.Bool("extract-only", false, "Only extract images")
+	presetName := flag.String("preset", "", "Options bundle: archive, web, ocr or a preset of the config file")
+	stripMetadata := flag.Bool("strip-metadata", false, "Remove image metadata")
+	tmpDir := flag.String("tmpdir", "", "Directory for temporary files")
+	outputDir := flag.String("output-dir", ".", "Directory for unlocked PDFs and images")
+	dirPolicy := flag.String("dir-policy", dirReuse, "If the image directory exists: reuse, timestamp or error")
+	force := flag.Bool("force", false, "Re-extract even if output is up to date")
+	safeNames := flag.Bool("safe-names", false, "Transliterate output names to ASCII")
+	outlineDirs := flag.Bool("outline-dirs", false, "Group images into folders by outline section")
+	captionNames := flag.Bool("caption-names", false, "Name images after nearby captions")
+	startIndex := flag.Int("start-index", 1, "Number of the first image")
+	numberByPage := flag.Bool("number-by-page", false, "Number images per page (image_p12_001)")
+	objects := flag.String("objects", "", "Extract only these images (object numbers, page.resource)")
+	recurseAttachments := flag.Bool("recurse-attachments", false, "Also extract the images of PDFs attached to the PDF")
+	attachmentDepth := flag.Int("attachment-depth", 3, "Levels of attachments followed with --recurse-attachments")
+	htmlReport := flag.Bool("html-report", false, "Write an index.html gallery")
+	report := flag.String("report", "", "Write per-image statistics (csv, tsv) or a markdown page (markdown)")
+	inlineUnder := flag.String("inline-under", "", "Embed images smaller than this in the markdown report, e.g. 8K")
+	analyze := flag.Bool("analyze", false, "Record color statistics in the manifest")
+	minDPI := flag.Int("min-dpi", 0, "Warn about images drawn below this resolution (0 = off)")
+	colorManaged := flag.Bool("require-color-managed", false, "Fail if an image's ICC profile can't be kept")
+	embedPreviews := flag.String("embed-previews", "", "Embed base64 previews of this size in the manifest, e.g. 64px")
+	dedupScope := flag.String("dedup-scope", "document", "Deduplication scope (document, page, off)")
+	similar := flag.Int("similar", 0, "Merge perceptually similar images within this distance")
+	dedupKeep := flag.String("dedup-keep", "first", "Duplicate to keep (first, largest-pixels, largest-bytes)")
+	dedupIndexFile := flag.String("dedup-index", "", "Index of images written by earlier runs, which are left out")
+	decodeWorkers := flag.Int("decode-workers", 0, "Concurrent image decoders (0 = CPU count)")
+	encodeWorkers := flag.Int("encode-workers", 0, "Concurrent image encoders (0 = CPU count)")
+	writeWorkers := flag.Int("write-workers", 0, "Concurrent file writers (0 = default)")
+	encodeShare := flag.String("encode-share", "", "Share of the encoders a format may take, e.g. webp=0.5")
+	timeout := flag.Duration("timeout", 0, "Maximum processing time per PDF (0 = no limit)")
+	maxPixels := flag.Int64("max-pixels", imageHandling.DefaultMaxPixels, "Largest image to decode, in pixels")
+	maxImageBytes := flag.Int64("max-image-bytes", imageHandling.DefaultMaxImageBytes, "Largest image stream, in bytes")
+	decodeTimeout := flag.Duration("decode-timeout", imageHandling.DefaultDecodeTimeout, "Maximum decode time per image")
+	sandbox := flag.Bool("sandbox", false, "Process the PDF in a restricted child process")
+	auditLog := flag.String("audit-log", "", "Append a JSON record of this run to this file")
+	eventsFormat := flag.String("events", "", "Stream an event per pipeline step to stderr (jsonl)")
+	eventsFile := flag.String("events-file", "", "Append the --events stream to this file instead")
+	openOutput := flag.Bool("open", false, "Open the output directory or HTML report when done")
+	failOnEmpty := flag.Bool("fail-on-empty", false, "Exit with status 3 if the input holds no images")
+	maxTotalOutput := flag.String("max-total-output", "", "Most bytes of images written per document, e.g. 2G (default: no limit)")
+	engine := flag.String("engine", imageHandling.DefaultEngine, "Library rendering the image streams (pdfcpu, mupdf)")
+	ignorePerms := flag.Bool("ignore-permissions", false, "Extract even if the PDF's permissions forbid it")
+	passwordFile := flag.String("password-file", "", "File of candidate passwords, one per line")
+	keychain := flag.Bool("keychain", false, "Look up passwords in the OS keychain by file name pattern")
+	p12 := flag.String("p12", "", "PKCS#12 certificate and key for PDFs encrypted to a certificate")
+	p12PassFile := flag.String("p12-pass-file", "", "File holding the passphrase of the --p12 file")
+	zipPassword := flag.String("zip-password", "", "Password of PDFs encrypted within a ZIP archive")
+	rotate := flag.Int("rotate", 0, "Turn converted images clockwise by 90, 180 or 270 degrees")
+	flip := flag.String("flip", "", "Mirror converted images: h (left to right) or v (top to bottom)")
+	rotateMap := flag.String("rotate-map", "", "File of per-page rotations and flips")
+	despeckle := flag.Bool("despeckle", false, "Median-filter grayscale scans before encoding")
+	autocrop := flag.Bool("autocrop", false, "Trim black or white scanner borders")
+	cropTolerance := flag.Int("autocrop-tolerance", imageHandling.DefaultCropTolerance, "Border color tolerance (0-255)")
+	splitSpread := flag.Bool("split-spread", false, "Split double-page scans at the gutter")
+	transparentColor := flag.String("transparent-color", "", "Color made transparent, #RRGGBB")
+	transparentTolerance := flag.Int("transparent-tolerance", imageHandling.DefaultKeyTolerance, "Color distance still made transparent (0-255)")
+	invert := flag.Bool("invert", false, "Invert negative scans")
+	brightness := flag.Float64("brightness", 0, "Brightness change in percent (-100 to 100)")
+	contrast := flag.Float64("contrast", 0, "Contrast change in percent (-100 to 100)")
+	gamma := flag.Float64("gamma", 1, "Gamma correction (1 = none)")
+	autoLevels := flag.Bool("auto-levels", false, "Stretch each image to the full tonal range")
+	stampText := flag.String("stamp", "", "Text watermark for converted images")
+	stampImage := flag.String("stamp-image", "", "Image watermark for converted images")
+	stampPosition := flag.String("stamp-position", imageHandling.StampBottomRight, "Stamp position")
+	stampOpacity := flag.Float64("stamp-opacity", imageHandling.DefaultStampOpacity, "Stamp opacity (0-1)")
+	stitch := flag.String("stitch", "", "Join converted images into one strip (vertical, horizontal)")
+	multiTIFF := flag.Bool("multipage-tiff", false, "Also write all images into one multi-page TIFF")
+	tile := flag.String("tile", "", "Split converted images larger than this into tiles, e.g. 1024x1024")
+	pyramid := flag.String("pyramid", "", "Also write a tile pyramid of each converted image (dzi, iiif)")
+	iiifBase := flag.String("iiif-base", "", "URL the image directory is served under, for IIIF ids")
+	upscale := flag.String("upscale", "", "Enlarge converted images by this factor, e.g. 2x")
+	upscaleCmd := flag.String("upscale-cmd", "", "External upscaler command ({in}, {out}, {scale})")
+	optimizePNG := flag.Bool("optimize-png", false, "Shrink png output with palettes, filter choice and maximum compression")
+	optimizePNGCmd := flag.String("optimize-png-cmd", "", "External PNG optimizer command run after --optimize-png ({in}, {out})")
+	incremental := flag.Bool("incremental", false, "Extract only images added by incremental updates since the last run")
+	useCache := flag.Bool("cache", false, "Reuse results of earlier runs from the cache")
+	cacheDir := flag.String("cache-dir", "", "Cache location (implies --cache)")
+	cacheSize := flag.String("cache-size", "1G", "Cache size cap, e.g. 500M or 10G")
+	provenance := flag.Bool("provenance", false, "Write a copy of the PDF recording the extraction in its XMP metadata")
+	xattr := flag.Bool("xattr", false, "Record source, page and input hash in extended attributes of each image")
+	convertCmd := flag.String("convert-cmd", "", "External converter of Office documents to PDF ({in}, {out})")
+	showUsage := flag.Bool("usage", false, "Print resource usage and stage durations when done")
+	profiling := addProfileFlags(flag.CommandLine)
+	perms := addPermFlags(flag.CommandLine)
+	summary := addSummaryFlags(flag.CommandLine)
+	versionFlag := flag.Bool("version", false, "Show the pixf version")
 
 	flag.Parse()
 
@@ -53,6 +552,21 @@ func main() {
 		printHelp()
 		return
 	}
+	if *versionFlag {
+		fmt.Println("pixf", pixfVersion())
+		return
+	}
+
+	// A preset fills in the options not given on the command line
+	presetFormat := ""
+	if *presetName != "" {
+		var err error
+		if presetFormat, err = applyPreset(flag.CommandLine, *presetName, false); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+	routeOutput(summary)
 
 	// Get remaining arguments
 	args := flag.Args()
@@ -66,6 +580,13 @@ func main() {
 
 	filename := args[0]
 	format := "original"
+	if presetFormat != "" {
+		format = presetFormat
+	}
+	if imageHandling.IsMail(filename) {
+		fmt.Println("Error:", filename, "is an email; extract its PDF attachments with 'pixf batch", filename+"'")
+		os.Exit(1)
+	}
 
 	// Get format from second argument if present and not an unlock-only operation
 	if len(args) > 1 && !*unlockOnly {
@@ -73,7 +594,7 @@ func main() {
 	}
 
 	// Validate format
-	supportedFormats := []string{"original", "png", "webp"}
+	supportedFormats := imageHandling.Formats()
 	isValidFormat := false
 	for _, f := range supportedFormats {
 		if format == f {
@@ -83,63 +604,956 @@ func main() {
 	}
 	if !isValidFormat && !*unlockOnly {
 		fmt.Printf("Error: Unsupported format '%s'\n", format)
-		fmt.Println("Supported formats: original, png, webp")
+		fmt.Println("Supported formats:", strings.Join(supportedFormats, ", "))
 		fmt.Println("Use 'pixf -h' for usage information")
 		os.Exit(1)
 	}
+	if *report != "" && *report != "csv" && *report != "tsv" && *report != imageHandling.MarkdownReport {
+		fmt.Printf("Error: Unsupported report format '%s'\n", *report)
+		fmt.Println("Supported report formats: csv, tsv, markdown")
+		os.Exit(1)
+	}
+	var inlineBytes int64
+	if *inlineUnder != "" {
+		if *report != imageHandling.MarkdownReport {
+			fmt.Println("Error: --inline-under requires --report markdown")
+			os.Exit(1)
+		}
+		n, err := parseSize(*inlineUnder)
+		if err != nil {
+			fmt.Println("Error: invalid --inline-under:", err)
+			os.Exit(1)
+		}
+		inlineBytes = n
+	}
+	switch *dedupScope {
+	case imageHandling.DedupDocument, imageHandling.DedupPage, imageHandling.DedupOff:
+	default:
+		fmt.Printf("Error: Unsupported dedup scope '%s'\n", *dedupScope)
+		fmt.Println("Supported dedup scopes: document, page, off")
+		os.Exit(1)
+	}
+	switch *dedupKeep {
+	case imageHandling.KeepFirst, imageHandling.KeepLargestPixels, imageHandling.KeepLargestBytes:
+	default:
+		fmt.Printf("Error: Unsupported dedup keep policy '%s'\n", *dedupKeep)
+		fmt.Println("Supported policies: first, largest-pixels, largest-bytes")
+		os.Exit(1)
+	}
+	if *decodeWorkers < 0 || *encodeWorkers < 0 || *writeWorkers < 0 {
+		fmt.Println("Error: Worker counts must not be negative")
+		os.Exit(1)
+	}
+	encodeScale, err := parseEncodeShares(*encodeShare)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if *timeout < 0 {
+		fmt.Println("Error: Timeout must not be negative")
+		os.Exit(1)
+	}
+	if *maxPixels <= 0 || *maxImageBytes <= 0 || *decodeTimeout <= 0 {
+		fmt.Println("Error: Image limits must be positive")
+		os.Exit(1)
+	}
+
+	upscaleFactor, err := parseUpscale(*upscale)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	previewSize, err := parsePreviewSize(*embedPreviews)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if *upscaleCmd != "" && upscaleFactor == 0 {
+		fmt.Println("Error: --upscale-cmd requires --upscale")
+		os.Exit(1)
+	}
+	if err := checkDirPolicy(*dirPolicy); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if outputPerms, err = perms.parse(); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if err := imageHandling.CheckObjects(*objects); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	tone := imageHandling.Tone{
+		Brightness: *brightness,
+		Contrast:   *contrast,
+		Gamma:      *gamma,
+		AutoLevels: *autoLevels,
+	}
+	if *brightness < -100 || *brightness > 100 || *contrast < -100 || *contrast > 100 || *gamma <= 0 {
+		fmt.Println("Error: --brightness and --contrast must be between -100 and 100, --gamma above 0")
+		os.Exit(1)
+	}
+	transform := imageHandling.Transform{Rotate: *rotate, Flip: *flip}
+	if err := transform.Check(); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	var pageTransforms map[int]imageHandling.Transform
+	if *rotateMap != "" {
+		if pageTransforms, err = imageHandling.LoadTransformMap(*rotateMap); err != nil {
+			fmt.Println("Error reading rotate map:", err)
+			os.Exit(1)
+		}
+	}
+	stamp := imageHandling.Stamp{
+		Text:     *stampText,
+		Image:    *stampImage,
+		Position: *stampPosition,
+		Opacity:  *stampOpacity,
+	}
+	if *stampText != "" && *stampImage != "" {
+		fmt.Println("Error: Use either --stamp or --stamp-image, not both")
+		os.Exit(1)
+	}
+	switch *stampPosition {
+	case imageHandling.StampBottomRight, imageHandling.StampBottomLeft, imageHandling.StampTopRight,
+		imageHandling.StampTopLeft, imageHandling.StampCenter:
+	default:
+		fmt.Printf("Error: Unsupported stamp position '%s'\n", *stampPosition)
+		fmt.Println("Supported positions: bottom-right, bottom-left, top-right, top-left, center")
+		os.Exit(1)
+	}
+	if *stampOpacity <= 0 || *stampOpacity > 1 {
+		fmt.Println("Error: --stamp-opacity must be above 0 and at most 1")
+		os.Exit(1)
+	}
+	if *stampImage != "" {
+		if _, err := os.Stat(*stampImage); err != nil {
+			fmt.Println("Error reading stamp image:", err)
+			os.Exit(1)
+		}
+	}
+	if *transparentColor != "" {
+		if _, err := imageHandling.ParseHexColor(*transparentColor); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+	if *transparentTolerance < 0 || *transparentTolerance > 255 {
+		fmt.Println("Error: --transparent-tolerance must be between 0 and 255")
+		os.Exit(1)
+	}
+	switch *stitch {
+	case "", imageHandling.StitchVertical, imageHandling.StitchHorizontal:
+	default:
+		fmt.Printf("Error: Unsupported stitch direction '%s'\n", *stitch)
+		fmt.Println("Supported directions: vertical, horizontal")
+		os.Exit(1)
+	}
+	if *tile != "" {
+		if _, _, err := imageHandling.ParseTileSize(*tile); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		if *stitch != "" || *multiTIFF || *pyramid != "" {
+			fmt.Println("Error: --tile can't be combined with --stitch, --multipage-tiff or --pyramid")
+			os.Exit(1)
+		}
+	}
+	switch *pyramid {
+	case "", imageHandling.PyramidDZI, imageHandling.PyramidIIIF:
+	default:
+		fmt.Printf("Error: Unsupported pyramid layout '%s'\n", *pyramid)
+		fmt.Println("Supported layouts: dzi, iiif")
+		os.Exit(1)
+	}
+	if *iiifBase != "" && *pyramid != imageHandling.PyramidIIIF {
+		fmt.Println("Error: --iiif-base requires --pyramid iiif")
+		os.Exit(1)
+	}
+	if *pyramid != "" && format == "original" && !*unlockOnly {
+		fmt.Println("Error: --pyramid requires png or webp output")
+		os.Exit(1)
+	}
+	edits := transform != (imageHandling.Transform{}) || *rotateMap != "" || *despeckle || *autocrop || *splitSpread || upscaleFactor > 0 || *transparentColor != "" || *invert ||
+		tone != (imageHandling.Tone{Gamma: 1}) || *stampText != "" || *stampImage != "" || *stitch != "" || *tile != ""
+	if edits && format == "original" && !*unlockOnly {
+		fmt.Println("Error: Image edits (--rotate, --flip, --rotate-map, --despeckle, --autocrop, --split-spread,")
+		fmt.Println("--upscale, --transparent-color, --invert, tone options, --stamp, --stitch, --tile) require png or webp output")
+		os.Exit(1)
+	}
+	if *optimizePNG && format != "png" && !*unlockOnly {
+		fmt.Println("Error: --optimize-png requires png output")
+		os.Exit(1)
+	}
+	if *optimizePNGCmd != "" && !*optimizePNG {
+		fmt.Println("Error: --optimize-png-cmd requires --optimize-png")
+		os.Exit(1)
+	}
+	if *cropTolerance < 0 || *cropTolerance > 255 {
+		fmt.Println("Error: --autocrop-tolerance must be between 0 and 255")
+		os.Exit(1)
+	}
+	if *startIndex < 0 {
+		fmt.Println("Error: --start-index must not be negative")
+		os.Exit(1)
+	}
+	if *minDPI < 0 {
+		fmt.Println("Error: --min-dpi must not be negative")
+		os.Exit(1)
+	}
+	maxTotal, err := parseOutputBudget(*maxTotalOutput)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if !slices.Contains(imageHandling.Engines(), *engine) {
+		fmt.Printf("Error: Unknown engine '%s'\n", *engine)
+		fmt.Println("Available engines:", strings.Join(imageHandling.Engines(), ", "))
+		os.Exit(1)
+	}
+	attachments := 0
+	if *recurseAttachments {
+		if *attachmentDepth < 1 {
+			fmt.Println("Error: --attachment-depth must be at least 1")
+			os.Exit(1)
+		}
+		if *stitch != "" || *multiTIFF {
+			fmt.Println("Error: --recurse-attachments can't be combined with --stitch or --multipage-tiff")
+			os.Exit(1)
+		}
+		attachments = *attachmentDepth
+	}
+	var listedPasswords []string
+	if *passwordFile != "" {
+		var err error
+		if listedPasswords, err = readPasswordFile(*passwordFile); err != nil {
+			fmt.Println("Error reading password file:", err)
+			os.Exit(1)
+		}
+	}
+	var identity *imageHandling.Identity
+	if *p12 != "" {
+		var err error
+		if identity, err = loadIdentity(*p12, *p12PassFile); err != nil {
+			fmt.Println("Error reading certificate:", err)
+			os.Exit(1)
+		}
+	} else if *p12PassFile != "" {
+		fmt.Println("Error: --p12-pass-file requires --p12")
+		os.Exit(1)
+	}
+	zipPass := *zipPassword
+	if zipPass == "" {
+		zipPass = os.Getenv(zipPassEnv)
+	}
+	if *keychain && *sandbox {
+		fmt.Println("Error: --keychain can't be combined with --sandbox")
+		os.Exit(1)
+	}
+	if *profiling.pprof != "" && *sandbox {
+		fmt.Println("Error: --pprof can't be combined with --sandbox, whose child has no network")
+		os.Exit(1)
+	}
+	cache, err := openCache(*useCache, *cacheDir, *cacheSize)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	dedupIndex, err := openDedupIndex(*dedupIndexFile)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	events, err := openEvents(*eventsFormat, *eventsFile)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if dedupIndex != nil && cache != nil {
+		fmt.Println("Error: --dedup-index can't be combined with --cache; a cached result ignores the index")
+		os.Exit(1)
+	}
+	if *incremental {
+		switch {
+		case *unlockOnly:
+			fmt.Println("Error: --incremental can't be used with --unlock-only")
+			os.Exit(1)
+		case *htmlReport || *report != "" || *stitch != "" || *multiTIFF || *tile != "":
+			fmt.Println("Error: --incremental can't be combined with --html-report, --report, --stitch, --multipage-tiff or --tile, which cover all images")
+			os.Exit(1)
+		case *dirPolicy != dirReuse:
+			fmt.Println("Error: --incremental updates the existing images and needs --dir-policy reuse")
+			os.Exit(1)
+		case *pyramid != "":
+			fmt.Println("Error: --incremental can't be combined with --pyramid; the pyramids of new images are not added")
+			os.Exit(1)
+		}
+	}
+	if *provenance && *unlockOnly {
+		fmt.Println("Error: --provenance records an extraction and can't be used with --unlock-only")
+		os.Exit(1)
+	}
+	if imageHandling.NeedsConversion(filename) {
+		switch {
+		case *unlockOnly:
+			fmt.Println("Error: Office documents converted to PDF have nothing to unlock; --unlock-only can't be used")
+			os.Exit(1)
+		case *provenance:
+			fmt.Println("Error: --provenance records an extraction in the PDF and can't be used with Office documents")
+			os.Exit(1)
+		}
+	}
+	archive := imageHandling.IsArchive(filename)
+	if archive {
+		switch {
+		case *unlockOnly:
+			fmt.Println("Error: The PDFs of a ZIP archive are only extracted; --unlock-only can't be used")
+			os.Exit(1)
+		case *incremental || *provenance || cache != nil || *stitch != "" || *multiTIFF:
+			fmt.Println("Error: --incremental, --provenance, --cache, --stitch and --multipage-tiff can't be used with a ZIP archive")
+			os.Exit(1)
+		}
+	} else if *zipPassword != "" {
+		fmt.Println("Error: --zip-password only applies to ZIP archives")
+		os.Exit(1)
+	}
+
+	// With --open, the result is shown once it is complete; a sandboxed
+	// child leaves that to its parent, which may use the desktop
+	filenameUnlocked, imgDir := outputPaths(filename, *outputDir, *safeNames)
+	filenameTraced := tracedPath(filename, *outputDir, *safeNames)
+	if dir := os.Getenv(sandboxDirEnv); dir != "" && inSandbox() {
+		imgDir = dir
+	} else if !*unlockOnly {
+		if imgDir, err = imageDirFor(imgDir, *dirPolicy, time.Now()); err != nil {
+			fmt.Fprintln(console, "Error:", err)
+			os.Exit(1)
+		}
+	}
+	done := func() {
+		// A sandboxed child checks for itself and passes its status on
+		if !*unlockOnly && (inSandbox() || !*sandbox) {
+			checkEmpty(imgDir, *failOnEmpty)
+			checkTruncated(imgDir)
+		}
+		if !*openOutput || inSandbox() {
+			return
+		}
+		switch {
+		case *unlockOnly:
+			openPath(*outputDir)
+		case *htmlReport:
+			openPath(filepath.Join(imgDir, imageHandling.HTMLReportName))
+		default:
+			openPath(imgDir)
+		}
+	}
+
+	// Measured from here on, so a sandboxed child counts for its parent
+	if *showUsage || *auditLog != "" {
+		startUsage(*showUsage)
+		if !*unlockOnly {
+			usageOutputs(imgDir)
+		}
+	}
+
+	opts := imageHandling.Options{
+		Format:        format,
+		StripMetadata: *stripMetadata,
+		HTMLReport:    *htmlReport,
+		Report:        *report,
+		Analyze:       *analyze,
+		MinDPI:        *minDPI,
+		ColorManaged:  *colorManaged,
+		EmbedPreviews: previewSize,
+		DedupScope:    *dedupScope,
+		SimilarDist:   *similar,
+		DedupKeep:     *dedupKeep,
+		OutlineDirs:   *outlineDirs,
+		SafeNames:     *safeNames,
+		CaptionNames:  *captionNames,
+		NumberOffset:  *startIndex - 1,
+		PageNumbers:   *numberByPage,
+		Objects:       *objects,
+		Attachments:   attachments,
+		Despeckle:     *despeckle,
+		AutoCrop:      *autocrop,
+		CropTolerance: *cropTolerance,
+		SplitSpread:   *splitSpread,
+		KeyColor:      *transparentColor,
+		KeyTolerance:  *transparentTolerance,
+		Invert:        *invert,
+		Transform:     transform,
+		Tone:          tone,
+		Stamp:         stamp,
+		Stitch:        *stitch,
+		MultiTIFF:     *multiTIFF,
+		Tile:          *tile,
+		Pyramid:       *pyramid,
+		IIIFBase:      *iiifBase,
+		UpscaleFactor: upscaleFactor,
+		UpscaleCmd:    *upscaleCmd,
+		OptimizePNG:   *optimizePNG,
+		OptimizeCmd:   *optimizePNGCmd,
+		Tags:          *xattr,
+		Perms:         outputPerms,
+		DedupIndex:    dedupIndex,
+		Events:        events,
+		Notices:       progress,
+		Limits: imageHandling.Limits{
+			MaxPixels:     *maxPixels,
+			MaxBytes:      *maxImageBytes,
+			DecodeTimeout: *decodeTimeout,
+		},
+		Workers: imageHandling.Workers{
+			Decode: *decodeWorkers,
+			Encode: *encodeWorkers,
+			Write:  *writeWorkers,
+
+			EncodeScale: encodeScale,
+		},
+		Source:      filename,
+		IgnorePerms: *ignorePerms,
+		ZipPassword: zipPass,
+
+		PageTransforms: pageTransforms,
+		MaxTotalOutput: maxTotal,
+		Engine:         engineOption(*engine),
+		InlineUnder:    inlineBytes,
+	}
+
+	// Hash the input up front to prove afterwards that it wasn't modified
+	inputHash, err := imageHandling.HashFile(imageHandling.LongPath(filename))
+	if err != nil {
+		fmt.Fprintln(console, "Error reading PDF:", err)
+		exit(1)
+	}
+
+	// Every document we touch is recorded, including failed runs
+	if *auditLog != "" {
+		mode, unlocked := "unlock+extract", filenameUnlocked
+		switch {
+		case *unlockOnly:
+			mode = "unlock"
+		case *extractOnly || archive:
+			mode, unlocked = "extract", ""
+		}
+		if err := startAudit(*auditLog, filename, inputHash, mode, opts); err != nil {
+			fmt.Fprintln(console, "Error opening audit log:", err)
+			exit(1)
+		}
+		auditOutputs(unlocked, imgDir)
+	}
+
+	// Untrusted PDFs are handled by a restricted copy of this process; the
+	// parent keeps the audit record, so a child that dies is logged too
+	if *sandbox && !inSandbox() {
+		code := runSandboxed(imgDir)
+		if code == 0 {
+			done()
+		}
+		finishUsage()
+		finishAudit(code)
+		os.Exit(code)
+	}
+	if inSandbox() {
+		if err := applySandboxLimits(); err != nil {
+			fmt.Fprintln(console, "Error restricting sandbox:", err)
+			exit(1)
+		}
+	}
+
+	// Temporary files are removed on normal exit, errors, panics and signals
+	if err := createWorkDir(*tmpDir); err != nil {
+		fmt.Fprintln(console, "Error creating temp directory:", err)
+		exit(1)
+	}
+	defer reportSandboxResult(0)
+	defer finishAudit(0)
+	defer finishUsage()
+	defer removeWorkDir()
+	handleSignals()
+	opts.TempDir = workDir
+
+	// Profiles cover the process doing the work, the child under --sandbox
+	if err := startProfiling(profiling); err != nil {
+		fmt.Fprintln(console, "Error:", err)
+		exit(1)
+	}
+	defer stopProfiling()
+
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fail("Error creating output directory:", err.Error())
+	}
+
+	// Results of PDFs that took no password or certificate are cached.
+	// The key covers the permission override, so a cached result never
+	// bypasses a refusal.
+	var cacheKey string
+	if cache != nil && !*unlockOnly {
+		mode := "unlock+extract"
+		if *extractOnly {
+			mode = "extract"
+		}
+		if *ignorePerms {
+			mode += "+ignore-permissions"
+		}
+		if cacheKey, err = imageHandling.CacheKey(inputHash, mode, opts); err != nil {
+			fail("Error reading cache:", err.Error())
+		}
+	}
+
+	// The timeout covers unlocking and extraction of this PDF
+	ctx := runCtx
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	// Office documents are converted when the PDF is first needed, so an
+	// up-to-date result skips the conversion. The document stays the input
+	// that is hashed, recorded and verified.
+	converted := ""
+	pdfInput := func() string {
+		if !imageHandling.NeedsConversion(filename) {
+			return filename
+		}
+		if converted == "" {
+			fmt.Fprintln(progress, "Converting to PDF:", filename)
+			var err error
+			converter := imageHandling.NewPreConverter(*convertCmd)
+			if converted, err = converter.Convert(ctx, filename, filepath.Join(workDir, "convert")); err != nil {
+				fail("Error converting to PDF:", describeError(err, *timeout))
+			}
+		}
+		return converted
+	}
+
+	// Keychain lookups may ask the user, so they wait until a PDF is opened
+	candidates := func() imageHandling.Credentials {
+		passwords, err := candidatePasswords(filename, listedPasswords, *keychain)
+		if err != nil {
+			fail("Error reading passwords:", err.Error())
+		}
+		return imageHandling.Credentials{Passwords: passwords, Identity: identity}
+	}
+
+	// The PDFs of a ZIP archive are extracted one by one into one output
+	if archive {
+		extractArchive(ctx, filename, imgDir, opts, inputHash, listedPasswords, *keychain, identity, *force, *timeout, summary)
+		done()
+		return
+	}
 
 	// Handle unlock-only mode
 	if *unlockOnly {
-		fmt.Println("Unlocking PDF...")
-		conf := model.NewDefaultConfiguration()
-		filenameUnlocked := "unlocked_" + filename
-		err := api.DecryptFile(filename, filenameUnlocked, conf)
+		fmt.Fprintln(progress, "Unlocking PDF...")
+		// Only extraction is subject to the permissions; this extracts nothing
+		doc, unlocked, err := unlock(ctx, filename, filenameUnlocked, true, candidates())
 		if err != nil {
-			fmt.Println("Error decrypting PDF:", err)
-			os.Exit(1)
+			fail("Error decrypting PDF:", describeError(err, *timeout))
+		}
+		doc.Close()
+		if !unlocked {
+			fail("Error decrypting PDF:", imageHandling.ErrNotEncrypted.Error())
 		}
-		fmt.Println("PDF successfully unlocked and saved as", filenameUnlocked)
+		verifyInput(filename, inputHash)
+		fmt.Fprintln(progress, "PDF successfully unlocked and saved as", filenameUnlocked)
+		done()
 		return
 	}
 
 	// Handle extract-only mode (use original PDF without unlocking)
 	if *extractOnly {
-		fmt.Println("Extracting images from:", filename)
-		nameOnly := strings.TrimSuffix(filename, ".pdf")
-		imgDir := "images_" + nameOnly
+		if !*force && imageHandling.IsUpToDate(filename, imgDir, opts) {
+			fmt.Fprintln(progress, "Images already up to date in", imgDir, "(use --force to re-extract)")
+			if *provenance {
+				traceUpToDate(ctx, filename, filenameTraced, imgDir, inputHash, candidates)
+			}
+			auditStatus(auditUpToDate, "")
+			done()
+			return
+		}
+
+		if restoreCached(cache, cacheKey, imgDir, "", filename) {
+			if *provenance {
+				traceUpToDate(ctx, filename, filenameTraced, imgDir, inputHash, candidates)
+			}
+			done()
+			return
+		}
 
-		err := imageHandling.ExtractImagesFromFile(filename, imgDir, format)
+		fmt.Fprintln(progress, "Extracting images from:", filename)
+
+		doc, err := imageHandling.OpenDocument(ctx, pdfInput(), candidates())
 		if err != nil {
-			fmt.Println("Error extracting images:", err)
-			os.Exit(1)
+			fail("Error extracting images:", describeError(err, *timeout))
 		}
-		fmt.Println("Images extracted to:", imgDir)
+		defer doc.Close()
+		e := imageHandling.NewExtractor(opts.Workers)
+		defer e.Close()
+		res, err := extract(ctx, e, doc, imgDir, opts, *incremental && !*force)
+		if err != nil {
+			fail("Error extracting images:", describeError(err, *timeout))
+		}
+		verifyInput(filename, inputHash)
+		fmt.Fprintln(progress, "Images extracted to:", imgDir)
+		printSummary(summary, res)
+		printDuplicates(summary, imgDir)
+		if cache != nil && !doc.Protected() {
+			storeCached(cache, cacheKey, imgDir, "")
+		}
+		if *provenance {
+			trace(ctx, doc, filenameTraced, imgDir)
+		}
+		done()
 		return
 	}
 
 	// Default mode: unlock then extract images
-	fmt.Println("Loading PDF:", filename)
+	// Skip work when a previous run already produced the same result
+	if !*force && imageHandling.IsUpToDate(filename, imgDir, opts) {
+		fmt.Fprintln(progress, "Images already up to date in", imgDir, "(use --force to re-extract)")
+		if *provenance {
+			traceUpToDate(ctx, filename, filenameTraced, imgDir, inputHash, candidates)
+		}
+		auditStatus(auditUpToDate, "")
+		done()
+		return
+	}
+
+	if restoreCached(cache, cacheKey, imgDir, filenameUnlocked, filename) {
+		if *provenance {
+			traceUpToDate(ctx, filename, filenameTraced, imgDir, inputHash, candidates)
+		}
+		done()
+		return
+	}
+
+	fmt.Fprintln(progress, "Loading PDF:", filename)
 
-	// PDFCPU Unlocking
-	conf := model.NewDefaultConfiguration()
-	filenameUnlocked := "unlocked_" + filename
-	err := api.DecryptFile(filename, filenameUnlocked, conf)
+	// PDFCPU Unlocking; the document is decrypted once, in memory
+	doc, unlocked, err := unlock(ctx, pdfInput(), filenameUnlocked, *ignorePerms, candidates())
 	if err != nil {
-		fmt.Println("Error decrypting PDF:", err)
-		os.Exit(1)
+		fail("Error decrypting PDF:", describeError(err, *timeout))
+	}
+	defer doc.Close()
+	if unlocked {
+		fmt.Fprintln(progress, "PDF successfully unlocked and saved as", filenameUnlocked)
+	} else {
+		fmt.Fprintln(progress, "PDF is not encrypted; no unlocked copy written")
+		auditOutputs("", imgDir)
 	}
-	fmt.Println("PDF successfully unlocked and saved as", filenameUnlocked)
 
-	// PDFCPU Image Extraction
-	nameOnly := strings.TrimSuffix(filename, ".pdf")
-	imgDir := "images_" + nameOnly
+	// PDFCPU Image Extraction from the decrypted document, not the copy
+	fmt.Fprintln(progress, "Extracting images in", format, "format...")
+	e := imageHandling.NewExtractor(opts.Workers)
+	defer e.Close()
+	res, err := extract(ctx, e, doc, imgDir, opts, *incremental && !*force)
+	if err != nil {
+		fail("Error extracting images:", describeError(err, *timeout))
+	}
+	verifyInput(filename, inputHash)
 
-	fmt.Println("Extracting images in", format, "format...")
-	err = imageHandling.ExtractImagesFromFile(filenameUnlocked, imgDir, format)
+	fmt.Fprintln(progress, "Images extracted to:", imgDir)
+	printSummary(summary, res)
+	printDuplicates(summary, imgDir)
+	if cache != nil && !doc.Protected() {
+		cached := ""
+		if unlocked {
+			cached = filenameUnlocked
+		}
+		storeCached(cache, cacheKey, imgDir, cached)
+	}
+	if *provenance {
+		trace(ctx, doc, filenameTraced, imgDir)
+	}
+	done()
+}
+
+// exitNoImages is the exit status with --fail-on-empty for inputs without
+// images, told apart from errors (1)
+const exitNoImages = 3
+
+// hasNoImages reports whether the manifest of imgDir records an input
+// without images
+func hasNoImages(imgDir string) bool {
+	m, err := imageHandling.ReadManifest(imgDir)
+	return err == nil && m.NoImages
+}
+
+// checkEmpty says so if the input extracted to imgDir holds no images
+// and, with failOnEmpty, exits with exitNoImages
+func checkEmpty(imgDir string, failOnEmpty bool) {
+	if !hasNoImages(imgDir) {
+		return
+	}
+	fmt.Fprintln(console, "No images found in the input")
+	if failOnEmpty {
+		auditStatus(auditNoImages, "")
+		exit(exitNoImages)
+	}
+}
+
+// exitTruncated is the exit status for inputs whose images didn't all fit
+// within --max-total-output, told apart from errors (1)
+const exitTruncated = 4
+
+// truncatedImages returns the images the manifest of imgDir records as
+// left out over --max-total-output
+func truncatedImages(imgDir string) int {
+	m, err := imageHandling.ReadManifest(imgDir)
 	if err != nil {
-		fmt.Println("Error extracting images:", err)
-		os.Exit(1)
+		return 0
+	}
+	n := 0
+	for _, t := range m.Truncated {
+		n += t.Omitted
+	}
+	return n
+}
+
+// checkTruncated says so if images of the input extracted to imgDir were
+// left out over --max-total-output, and exits with exitTruncated
+func checkTruncated(imgDir string) {
+	n := truncatedImages(imgDir)
+	if n == 0 {
+		return
+	}
+	fmt.Fprintf(console, "Output truncated: %d image(s) over --max-total-output left out\n", n)
+	auditStatus(auditTruncated, "")
+	exit(exitTruncated)
+}
+
+// extract extracts the images of doc into imgDir. With incremental, if
+// imgDir holds the images of an earlier revision, only those added by the
+// updates since are extracted.
+func extract(ctx context.Context, e *imageHandling.Extractor, doc *imageHandling.Document, imgDir string, opts imageHandling.Options, incremental bool) (*imageHandling.Result, error) {
+	if incremental {
+		res, err := e.ExtractUpdate(ctx, doc, imgDir, opts)
+		if err == nil {
+			fmt.Fprintf(progress, "added %d image(s) from incremental updates\n", res.Images)
+			return res, nil
+		}
+		if !errors.Is(err, imageHandling.ErrNotAnUpdate) {
+			return nil, err
+		}
+		fmt.Fprintln(progress, "extracting all images:", err)
+	}
+	return e.ExtractDocument(ctx, doc, imgDir, opts)
+}
+
+// extractArchive extracts the images of the PDFs in the ZIP archive
+// filename into imgDir. PDFs that fail are reported after the others are
+// done, and fail the run.
+func extractArchive(ctx context.Context, filename, imgDir string, opts imageHandling.Options, inputHash string, listed []string, keychain bool, identity *imageHandling.Identity, force bool, timeout time.Duration, summary *summaryFlags) {
+	if !force && imageHandling.IsUpToDate(filename, imgDir, opts) {
+		fmt.Fprintln(progress, "Images already up to date in", imgDir, "(use --force to re-extract)")
+		auditStatus(auditUpToDate, "")
+		return
+	}
+
+	fmt.Fprintln(progress, "Extracting images from the PDFs in:", filename)
+	creds := func(name string) imageHandling.Credentials {
+		passwords, err := candidatePasswords(name, listed, keychain)
+		if err != nil {
+			fail("Error reading passwords:", err.Error())
+		}
+		return imageHandling.Credentials{Passwords: passwords, Identity: identity}
+	}
+	e := imageHandling.NewExtractor(opts.Workers)
+	defer e.Close()
+	res, failed, err := e.ExtractArchive(ctx, filename, imgDir, opts, creds)
+	if err != nil {
+		fail("Error extracting images:", describeError(err, timeout))
+	}
+	verifyInput(filename, inputHash)
+
+	for _, f := range failed {
+		fmt.Fprintln(console, f.Name+":", "Error extracting images:", describeError(f.Err, timeout))
+	}
+	fmt.Fprintf(progress, "Images of %d PDF(s) extracted to: %s\n", res.Documents, imgDir)
+	printSummary(summary, res)
+	printDuplicates(summary, imgDir)
+	if len(failed) > 0 {
+		fail("Error extracting images:", fmt.Sprintf("%d of %d PDF(s) in %s failed", len(failed), res.Documents+len(failed), filepath.Base(filename)))
+	}
+}
+
+// openDedupIndex reads the index named by --dedup-index; nil if none
+func openDedupIndex(path string) (*imageHandling.DedupIndex, error) {
+	if path == "" {
+		return nil, nil
+	}
+	x, err := imageHandling.OpenDedupIndex(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --dedup-index: %w", err)
+	}
+	return x, nil
+}
+
+// openEvents returns the sink selected by --events and --events-file; nil
+// if events are off
+func openEvents(format, path string) (imageHandling.EventSink, error) {
+	switch format {
+	case "":
+		if path != "" {
+			return nil, errors.New("--events-file requires --events")
+		}
+		return nil, nil
+	case "jsonl":
+	default:
+		return nil, fmt.Errorf("unsupported event format '%s' (supported: jsonl)", format)
+	}
+	if path == "" {
+		return imageHandling.NewJSONLinesSink(os.Stderr), nil
+	}
+	f, err := os.OpenFile(imageHandling.LongPath(path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --events-file: %w", err)
+	}
+	return imageHandling.NewJSONLinesSink(f), nil
+}
+
+// openCache returns the cache selected by --cache, --cache-dir and
+// --cache-size; nil if caching is off
+func openCache(enabled bool, dir, size string) (*imageHandling.Cache, error) {
+	if !enabled && dir == "" {
+		return nil, nil
+	}
+	maxBytes, err := parseSize(size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --cache-size: %w", err)
+	}
+	if dir == "" {
+		if dir, err = imageHandling.DefaultCacheDir(); err != nil {
+			return nil, fmt.Errorf("no cache directory: %w (use --cache-dir)", err)
+		}
+	}
+	return &imageHandling.Cache{Dir: dir, MaxBytes: maxBytes}, nil
+}
+
+// restoreCached copies the result of an earlier run from the cache; false
+// if there is none
+func restoreCached(cache *imageHandling.Cache, key, imgDir, unlocked, filename string) bool {
+	if cache == nil {
+		return false
+	}
+	hit, restoredUnlocked, err := cache.Restore(key, imgDir, unlocked, filename)
+	if err != nil {
+		fail("Error restoring from cache:", err.Error())
+	}
+	if !hit {
+		return false
+	}
+	if err := setPermissions(imgDir); err != nil {
+		fail("Error restoring from cache:", err.Error())
+	}
+	if restoredUnlocked {
+		if err := setPermissions(unlocked); err != nil {
+			fail("Error restoring from cache:", err.Error())
+		}
+		fmt.Fprintln(progress, "Unlocked PDF restored from cache as", unlocked)
+	}
+	fmt.Fprintln(progress, "Images restored from cache to:", imgDir)
+	auditStatus(auditCached, "")
+	return true
+}
+
+// storeCached adds this run's result to the cache. The result is already
+// complete, so a failure is only reported.
+func storeCached(cache *imageHandling.Cache, key, imgDir, unlocked string) {
+	if err := cache.Store(key, imgDir, unlocked); err != nil {
+		fmt.Fprintln(console, "Result not cached:", err)
 	}
+}
 
-	fmt.Println("Images extracted to:", imgDir)
+// engineOption is the Options.Engine of --engine: empty for the default,
+// so manifests of earlier runs still match
+func engineOption(name string) string {
+	if name == imageHandling.DefaultEngine {
+		return ""
+	}
+	return name
+}
+
+// parseOutputBudget reads --max-total-output; 0 if unset
+func parseOutputBudget(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := parseSize(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --max-total-output: %w", err)
+	}
+	return n, nil
+}
+
+// parseEncodeShares reads --encode-share, such as "webp=0.5,heic=0.25",
+// into Workers.EncodeScale; nil if unset
+func parseEncodeShares(s string) (map[string]float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	shares := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		format, share, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		format = strings.ToLower(strings.TrimSpace(format))
+		if !ok || format == "original" || !slices.Contains(imageHandling.Formats(), format) {
+			return nil, fmt.Errorf("invalid --encode-share %q: expected format=share with format one of %s", pair, strings.Join(imageHandling.Formats()[1:], ", "))
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(share), 64)
+		if err != nil || n <= 0 || n > 1 {
+			return nil, fmt.Errorf("invalid --encode-share %q: the share must be above 0 and at most 1", pair)
+		}
+		shares[format] = n
+	}
+	return shares, nil
+}
+
+// parseSize reads a byte count with an optional K, M, G or T suffix
+// (powers of 1024), such as "500M"
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	shift := 0
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("KMGT", num[n-1]); i >= 0 {
+			shift, num = 10*(i+1), num[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("%q is not a positive size such as 500M or 2G", s)
+	}
+	return n << shift, nil
+}
+
+// trace writes the copy of doc recording the extraction into imgDir
+func trace(ctx context.Context, doc *imageHandling.Document, path, imgDir string) {
+	p, err := imageHandling.ManifestProvenance(imgDir, pixfVersion())
+	if err != nil {
+		fail("Error reading manifest:", err.Error())
+	}
+	if err := doc.WriteProvenance(ctx, path, p); err != nil {
+		fail("Error writing provenance:", err.Error())
+	}
+	if err := setPermissions(path); err != nil {
+		fail("Error writing provenance:", err.Error())
+	}
+	fmt.Fprintln(progress, "Provenance recorded in", path)
+}
+
+// traceUpToDate records the provenance of images extracted by an earlier
+// run, opening the PDF only for that
+func traceUpToDate(ctx context.Context, filename, path, imgDir, inputHash string, creds func() imageHandling.Credentials) {
+	doc, err := imageHandling.OpenDocument(ctx, filename, creds())
+	if err != nil {
+		fail("Error reading PDF:", describeError(err, 0))
+	}
+	defer doc.Close()
+	trace(ctx, doc, path, imgDir)
+	verifyInput(filename, inputHash)
 }
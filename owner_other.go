@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import (
+	"errors"
+
+	imageHandling "pixf/internal/toolset"
+)
+
+// lookupOwner fails: file ownership can only be set on Unix
+func lookupOwner(spec string) (*imageHandling.Owner, error) {
+	return nil, errors.New("--chown is only supported on Unix")
+}
@@ -0,0 +1,45 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+
+	imageHandling "pixf/internal/toolset"
+)
+
+// lookupOwner reads an owner given as user:group, user or :group, by name
+// or numeric ID; a part left out stays unchanged
+func lookupOwner(spec string) (*imageHandling.Owner, error) {
+	name, group, _ := strings.Cut(spec, ":")
+	if name == "" && group == "" {
+		return nil, fmt.Errorf("invalid --chown '%s' (use user:group)", spec)
+	}
+	owner := &imageHandling.Owner{UID: -1, GID: -1}
+	if name != "" {
+		id := name
+		if _, err := strconv.Atoi(name); err != nil {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return nil, err
+			}
+			id = u.Uid
+		}
+		owner.UID, _ = strconv.Atoi(id)
+	}
+	if group != "" {
+		id := group
+		if _, err := strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return nil, err
+			}
+			id = g.Gid
+		}
+		owner.GID, _ = strconv.Atoi(id)
+	}
+	return owner, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	imageHandling "pixf/internal/toolset"
+)
+
+// p12PassEnv holds the passphrase of the --p12 file when no passphrase
+// file is given
+const p12PassEnv = "PIXF_P12_PASS"
+
+// zipPassEnv holds the password of encrypted ZIP archives when
+// --zip-password isn't given
+const zipPassEnv = "PIXF_ZIP_PASSWORD"
+
+// keychainService is the service pixf's keychain entries are stored
+// under. Each entry's account is a file name pattern, such as
+// "invoices-*.pdf", and its secret the password of the matching PDFs.
+const keychainService = "pixf"
+
+// keychainEntry is a password from the keychain and the pattern of the
+// file names it is for
+type keychainEntry struct {
+	pattern  string
+	password string
+}
+
+// readPasswordFile reads candidate passwords, one per line and taken
+// verbatim; empty lines are skipped
+func readPasswordFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var passwords []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if pw := strings.TrimSuffix(sc.Text(), "\r"); pw != "" {
+			passwords = append(passwords, pw)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(passwords) == 0 {
+		return nil, fmt.Errorf("%s lists no passwords", path)
+	}
+	return passwords, nil
+}
+
+// loadIdentity reads the PKCS#12 file p12; its passphrase is the first
+// line of passFile, or else taken from $PIXF_P12_PASS (default: empty)
+func loadIdentity(p12, passFile string) (*imageHandling.Identity, error) {
+	pass := os.Getenv(p12PassEnv)
+	if passFile != "" {
+		data, err := os.ReadFile(passFile)
+		if err != nil {
+			return nil, err
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		pass = strings.TrimSuffix(line, "\r")
+	}
+	return imageHandling.LoadIdentity(p12, pass)
+}
+
+// candidatePasswords returns the passwords to try on input: those of
+// matching keychain entries (when useKeychain is set), then the listed
+// ones, without repeats
+func candidatePasswords(input string, listed []string, useKeychain bool) ([]string, error) {
+	var passwords []string
+	if useKeychain {
+		entries, err := keychainEntries(filepath.Base(input))
+		if err != nil {
+			return nil, fmt.Errorf("keychain: %w", err)
+		}
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].pattern < entries[j].pattern })
+		for _, e := range entries {
+			passwords = append(passwords, e.password)
+		}
+	}
+	passwords = append(passwords, listed...)
+
+	seen := make(map[string]bool)
+	unique := passwords[:0]
+	for _, pw := range passwords {
+		if !seen[pw] {
+			seen[pw] = true
+			unique = append(unique, pw)
+		}
+	}
+	return unique, nil
+}
+
+// patternMatches reports whether a keychain pattern covers the file name
+// name; case is ignored, so one entry serves "Invoice.PDF" and "invoice.pdf"
+func patternMatches(pattern, name string) bool {
+	ok, err := filepath.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return ok && err == nil
+}
+
+// keychainToolError describes a failed keychain tool with what it wrote
+// to stderr
+func keychainToolError(cmd *exec.Cmd, err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return commandError(cmd, err, exitErr.Stderr)
+	}
+	return commandError(cmd, err, nil)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	imageHandling "pixf/internal/toolset"
+)
+
+// outputPaths derives the unlocked PDF path and image directory for input.
+// Both are placed in outDir with sanitized names, never next to the input,
+// so PDFs on read-only mounts can be processed and directory components,
+// spaces and Unicode in the input path are handled correctly.
+func outputPaths(input string, outDir string, safeNames bool) (unlocked string, imgDir string) {
+	base := filepath.Base(input)
+
+	stem := base
+	if ext := filepath.Ext(base); strings.EqualFold(ext, ".pdf") || imageHandling.IsArchive(base) || imageHandling.NeedsConversion(base) {
+		stem = strings.TrimSuffix(base, ext)
+	}
+	if imageHandling.NeedsConversion(base) {
+		// The PDF converted from an Office document
+		base = stem + ".pdf"
+	}
+
+	unlocked = filepath.Join(outDir, imageHandling.SanitizeName("unlocked_"+base, safeNames))
+	imgDir = filepath.Join(outDir, imageHandling.SanitizeName("images_"+stem, safeNames))
+	return unlocked, imgDir
+}
+
+// tracedPath is the path of the copy of input recording its extraction
+// (--provenance), next to the unlocked copy
+func tracedPath(input string, outDir string, safeNames bool) string {
+	return filepath.Join(outDir, imageHandling.SanitizeName("traced_"+filepath.Base(input), safeNames))
+}
+
+// Policies for an image directory left by an earlier run (--dir-policy)
+const (
+	dirReuse     = "reuse"     // Extract into it, replacing or updating its images
+	dirTimestamp = "timestamp" // Extract into images_<name>_<timestamp> instead
+	dirError     = "error"     // Fail
+)
+
+// imageDirFor applies policy to the default image directory imgDir.
+// Timestamped names use now, so all documents of a run share one; a
+// second run within the same second gets a numbered suffix.
+func imageDirFor(imgDir, policy string, now time.Time) (string, error) {
+	if _, err := os.Stat(imgDir); err != nil || policy == dirReuse {
+		return imgDir, nil
+	}
+	if policy == dirError {
+		return "", fmt.Errorf("%s already exists (use --dir-policy reuse or timestamp)", imgDir)
+	}
+	stamped := imgDir + "_" + now.Format("20060102-150405")
+	dir := stamped
+	for n := 2; ; n++ {
+		if _, err := os.Stat(dir); err != nil {
+			return dir, nil
+		}
+		dir = fmt.Sprintf("%s_%d", stamped, n)
+	}
+}
+
+// checkDirPolicy reports an unknown --dir-policy
+func checkDirPolicy(policy string) error {
+	switch policy {
+	case dirReuse, dirTimestamp, dirError:
+		return nil
+	}
+	return fmt.Errorf("unknown directory policy '%s' (use reuse, timestamp or error)", policy)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"strconv"
+
+	imageHandling "pixf/internal/toolset"
+)
+
+// permFlags are the options for the modes and owner of outputs
+type permFlags struct {
+	fileMode, dirMode, chown *string
+}
+
+// addPermFlags registers --file-mode, --dir-mode and --chown on fs
+func addPermFlags(fs *flag.FlagSet) *permFlags {
+	return &permFlags{
+		fileMode: fs.String("file-mode", "", "Mode of written files, in octal, e.g. 0640"),
+		dirMode:  fs.String("dir-mode", "", "Mode of written directories, in octal, e.g. 0750"),
+		chown:    fs.String("chown", "", "Owner of outputs as user:group (Unix only)"),
+	}
+}
+
+// outputPerms are the modes and owner given to outputs written outside
+// the library, such as unlocked PDFs
+var outputPerms imageHandling.Permissions
+
+// parse reads the flags into the permissions to give outputs
+func (f *permFlags) parse() (imageHandling.Permissions, error) {
+	var p imageHandling.Permissions
+	var err error
+	if p.FileMode, err = parseMode(*f.fileMode, "--file-mode"); err != nil {
+		return p, err
+	}
+	if p.DirMode, err = parseMode(*f.dirMode, "--dir-mode"); err != nil {
+		return p, err
+	}
+	if *f.chown != "" {
+		if p.Owner, err = lookupOwner(*f.chown); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// parseMode reads an octal permission mode such as "0640"; "" means
+// unchanged
+func parseMode(s, flagName string) (fs.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n == 0 || n > 0o777 {
+		return 0, fmt.Errorf("invalid %s '%s' (use octal permissions such as 0640)", flagName, s)
+	}
+	return fs.FileMode(n), nil
+}
+
+// setPermissions gives path the modes and owner of --file-mode,
+// --dir-mode and --chown
+func setPermissions(path string) error {
+	if err := outputPerms.Apply(path); err != nil {
+		return fmt.Errorf("set permissions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	imageHandling "pixf/internal/toolset"
+)
+
+// previewSize is the longer side of inline previews, in pixels
+const previewSize = 160
+
+// pickRow is one image object offered by the picker
+type pickRow struct {
+	info  imageHandling.ImageInfo // First use of the object
+	pages []int
+}
+
+// runPick implements "pixf pick <pdf-file>": it lists the images of a PDF,
+// optionally with inline previews, asks which to export and in what format
+// and extracts those
+func runPick(args []string) {
+	fs := flag.NewFlagSet("pick", flag.ExitOnError)
+	preview := fs.String("preview", "none", "Inline previews: none, kitty, sixel")
+	outputDir := fs.String("output-dir", ".", "Directory for extracted images")
+	ignorePerms := fs.Bool("ignore-permissions", false, "Extract even if the PDF's permissions forbid it")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fmt.Println("Error: No PDF file specified")
+		fmt.Println("Usage: pixf pick [--preview none|kitty|sixel] [--output-dir dir] <pdf-file>")
+		os.Exit(1)
+	}
+	switch *preview {
+	case "none", "kitty", "sixel":
+	default:
+		fmt.Printf("Error: Unsupported preview '%s'\n", *preview)
+		fmt.Println("Supported previews: none, kitty, sixel")
+		os.Exit(1)
+	}
+	filename := fs.Arg(0)
+
+	ctx := context.Background()
+	images, err := imageHandling.ListImages(ctx, imageHandling.LongPath(filename))
+	if err != nil {
+		fmt.Println("Error listing images:", err)
+		os.Exit(1)
+	}
+	rows := pickRows(images)
+	if len(rows) == 0 {
+		fmt.Println("No images in", filename)
+		return
+	}
+
+	var thumbs map[int]image.Image
+	if *preview != "none" {
+		if thumbs, err = imageHandling.Thumbnails(ctx, imageHandling.LongPath(filename), previewSize, "", *ignorePerms); err != nil {
+			fmt.Println("Error rendering previews:", describeError(err, 0))
+			os.Exit(1)
+		}
+	}
+	for i, r := range rows {
+		fmt.Printf("%3d  %-16s %9s  %-12s %s\n", i+1, r.info.ID,
+			fmt.Sprintf("%dx%d", r.info.Width, r.info.Height), strings.Join(r.info.Filters, ","), pageList(r.pages))
+		if thumb, ok := thumbs[r.info.ObjNr]; ok {
+			writePreview(os.Stdout, thumb, *preview)
+		}
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	var picked []int
+	for picked == nil {
+		answer, ok := ask(in, "Images to export (e.g. 1,3-5 or all; empty to quit): ")
+		if !ok || answer == "" {
+			return
+		}
+		if picked, err = parsePicks(answer, len(rows)); err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
+	format := ""
+	for format == "" {
+		answer, ok := ask(in, "Format ("+strings.Join(imageHandling.Formats(), ", ")+") [original]: ")
+		if !ok {
+			return
+		}
+		switch answer = strings.ToLower(answer); {
+		case answer == "":
+			format = "original"
+		case slices.Contains(imageHandling.Formats(), answer):
+			format = answer
+		default:
+			fmt.Printf("Error: Unsupported format '%s'\n", answer)
+		}
+	}
+
+	objects := make([]string, len(picked))
+	for i, n := range picked {
+		objects[i] = strconv.Itoa(rows[n].info.ObjNr)
+	}
+	opts := imageHandling.Options{Format: format, Objects: strings.Join(objects, ","), IgnorePerms: *ignorePerms, Notices: progress}
+	_, imgDir := outputPaths(filename, *outputDir, false)
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fmt.Println("Error creating output directory:", err)
+		os.Exit(1)
+	}
+	if err := imageHandling.ExtractImagesContext(ctx, filename, imgDir, opts); err != nil {
+		fmt.Println("Error extracting images:", describeError(err, 0))
+		os.Exit(1)
+	}
+	fmt.Println("Images extracted to:", imgDir)
+}
+
+// pickRows merges the uses of each image object into one row
+func pickRows(images []imageHandling.ImageInfo) []pickRow {
+	var rows []pickRow
+	index := make(map[int]int)
+	for _, img := range images {
+		if i, ok := index[img.ObjNr]; ok {
+			if p := rows[i].pages; p[len(p)-1] != img.Page {
+				rows[i].pages = append(p, img.Page)
+			}
+			continue
+		}
+		index[img.ObjNr] = len(rows)
+		rows = append(rows, pickRow{info: img, pages: []int{img.Page}})
+	}
+	return rows
+}
+
+// pageList formats pages as "page 3" or "pages 1, 4, 7"
+func pageList(pages []int) string {
+	s := make([]string, len(pages))
+	for i, p := range pages {
+		s[i] = strconv.Itoa(p)
+	}
+	if len(pages) == 1 {
+		return "page " + s[0]
+	}
+	return "pages " + strings.Join(s, ", ")
+}
+
+// ask prints prompt and reads a trimmed answer; ok is false at end of input
+func ask(in *bufio.Reader, prompt string) (answer string, ok bool) {
+	fmt.Print(prompt)
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Println()
+		return "", false
+	}
+	return strings.TrimSpace(line), true
+}
+
+// parsePicks reads a selection such as "1,3-5" or "all" of n rows into
+// zero-based row indexes, in order and without repeats
+func parsePicks(s string, n int) ([]int, error) {
+	if strings.EqualFold(s, "all") {
+		picks := make([]int, n)
+		for i := range picks {
+			picks[i] = i
+		}
+		return picks, nil
+	}
+	seen := make(map[int]bool)
+	picks := []int{}
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		from, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		to, err2 := from, error(nil)
+		if isRange {
+			to, err2 = strconv.Atoi(strings.TrimSpace(hi))
+		}
+		if err1 != nil || err2 != nil || from < 1 || to > n || from > to {
+			return nil, fmt.Errorf("invalid selection '%s' (images are numbered 1 to %d)", strings.TrimSpace(part), n)
+		}
+		for i := from - 1; i < to; i++ {
+			if !seen[i] {
+				seen[i] = true
+				picks = append(picks, i)
+			}
+		}
+	}
+	return picks, nil
+}
+
+// writePreview shows img inline using the kitty graphics protocol or sixel
+func writePreview(w io.Writer, img image.Image, protocol string) {
+	if protocol == "kitty" {
+		writeKitty(w, img)
+	} else {
+		writeSixel(w, img)
+	}
+	fmt.Fprintln(w)
+}
+
+// writeKitty sends img as PNG in base64 chunks of at most 4096 bytes
+func writeKitty(w io.Writer, img image.Image) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+	for first := true; len(data) > 0; first = false {
+		chunk := data[:min(len(data), 4096)]
+		data = data[len(chunk):]
+		more := 0
+		if len(data) > 0 {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(w, "\x1b_Ga=T,f=100,m=%d;%s\x1b\\", more, chunk)
+		} else {
+			fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+}
+
+// writeSixel sends img as sixel graphics with a 6x6x6 color cube.
+// Transparent pixels are shown on white.
+func writeSixel(w io.Writer, img image.Image) {
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	bw.WriteString("\x1bPq")
+	for i := 0; i < 216; i++ {
+		fmt.Fprintf(bw, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+	}
+
+	// Palette index of every pixel
+	idx := make([]uint8, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			level := func(v uint8) int {
+				v = uint8((int(v)*int(c.A) + 255*(255-int(c.A))) / 255)
+				return (int(v) + 25) / 51
+			}
+			idx[(y-b.Min.Y)*b.Dx()+x-b.Min.X] = uint8(level(c.R)*36 + level(c.G)*6 + level(c.B))
+		}
+	}
+
+	row := make([]byte, b.Dx())
+	for band := 0; band < b.Dy(); band += 6 {
+		used := make(map[uint8]bool)
+		for y := band; y < min(band+6, b.Dy()); y++ {
+			for _, c := range idx[y*b.Dx() : (y+1)*b.Dx()] {
+				used[c] = true
+			}
+		}
+		first := true
+		for c := 0; c < 216; c++ {
+			if !used[uint8(c)] {
+				continue
+			}
+			for x := range row {
+				bits := 0
+				for dy := 0; dy < 6 && band+dy < b.Dy(); dy++ {
+					if idx[(band+dy)*b.Dx()+x] == uint8(c) {
+						bits |= 1 << dy
+					}
+				}
+				row[x] = byte(63 + bits)
+			}
+			if !first {
+				bw.WriteByte('$') // Back to the start of the band
+			}
+			first = false
+			fmt.Fprintf(bw, "#%d", c)
+			writeSixelRun(bw, row)
+		}
+		bw.WriteByte('-')
+	}
+	bw.WriteString("\x1b\\")
+}
+
+// writeSixelRun writes a row of sixels, run-length encoding repeats
+func writeSixelRun(w *bufio.Writer, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(w, "!%d%c", n, row[i])
+		} else {
+			w.Write(row[i:j])
+		}
+		i = j
+	}
+}
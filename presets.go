@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// configEnv overrides the location of the config file
+const configEnv = "PIXF_CONFIG"
+
+// preset maps flag names, and "format", to the values a preset gives them
+type preset map[string]string
+
+// builtinPresets bundle the options of common jobs (--preset); presets of
+// the same name in the config file replace them
+var builtinPresets = map[string]preset{
+	// Bit-exact originals with their metadata and profiles, flagging
+	// scans too coarse to keep
+	"archive": {"format": "original", "dedup-scope": "document", "min-dpi": "300"},
+	// Small files without metadata, near-duplicates merged
+	"web": {"format": "webp", "strip-metadata": "true", "similar": "4"},
+	// Clean, lossless page images; repeats on other pages are kept, so
+	// every page can be recognized
+	"ocr": {"format": "png", "despeckle": "true", "auto-levels": "true", "dedup-scope": "page", "min-dpi": "300"},
+}
+
+// config is the config file: JSON with user-defined presets, e.g.
+// {"presets": {"scans": {"format": "png", "autocrop": true}}}
+type config struct {
+	Presets map[string]map[string]any `json:"presets"`
+}
+
+// configPath returns the config file: $PIXF_CONFIG, or else config.json
+// in the pixf folder of the user's config directory
+func configPath() (string, error) {
+	if path := os.Getenv(configEnv); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pixf", "config.json"), nil
+}
+
+// loadPresets returns the built-in presets and those of the config file,
+// if there is one
+func loadPresets() (map[string]preset, error) {
+	presets := make(map[string]preset, len(builtinPresets))
+	for name, p := range builtinPresets {
+		presets[name] = p
+	}
+	path, err := configPath()
+	if err != nil {
+		return presets, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && os.Getenv(configEnv) == "" {
+		return presets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, settings := range cfg.Presets {
+		p := make(preset, len(settings))
+		for key, value := range settings {
+			p[key] = fmt.Sprint(value)
+		}
+		presets[name] = p
+	}
+	return presets, nil
+}
+
+// applyPreset gives the flags of set the values of preset name, except
+// flags given on the command line, and returns its format if set has no
+// format flag ("" = none). Unless lenient, an option set lacks is an
+// error; batch skips them instead.
+func applyPreset(set *flag.FlagSet, name string, lenient bool) (string, error) {
+	presets, err := loadPresets()
+	if err != nil {
+		return "", fmt.Errorf("read config: %w", err)
+	}
+	p, ok := presets[name]
+	if !ok {
+		names := make([]string, 0, len(presets))
+		for n := range presets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("unknown preset '%s' (available: %s)", name, strings.Join(names, ", "))
+	}
+
+	given := make(map[string]bool)
+	set.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	format := ""
+	for _, key := range keys {
+		if key == "format" && set.Lookup("format") == nil {
+			format = p[key]
+			continue
+		}
+		if given[key] || key == "preset" {
+			continue
+		}
+		if set.Lookup(key) == nil {
+			if lenient {
+				continue
+			}
+			return "", fmt.Errorf("preset %s: unknown option --%s", name, key)
+		}
+		if err := set.Set(key, p[key]); err != nil {
+			return "", fmt.Errorf("preset %s: --%s: %w", name, key, err)
+		}
+	}
+	return format, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+	rtrace "runtime/trace"
+)
+
+// profileFlags are the options for diagnosing performance
+type profileFlags struct {
+	cpu, mem, trace, pprof *string
+}
+
+// addProfileFlags registers --cpuprofile, --memprofile, --trace and
+// --pprof on fs
+func addProfileFlags(fs *flag.FlagSet) *profileFlags {
+	return &profileFlags{
+		cpu:   fs.String("cpuprofile", "", "Write a CPU profile to this file"),
+		mem:   fs.String("memprofile", "", "Write a heap profile to this file when done"),
+		trace: fs.String("trace", "", "Write an execution trace to this file"),
+		pprof: fs.String("pprof", "", "Serve pprof endpoints on this address, e.g. localhost:6060"),
+	}
+}
+
+// The profiles being recorded, finished by stopProfiling
+var (
+	cpuProfile *os.File
+	traceFile  *os.File
+	memProfile string
+)
+
+// startProfiling starts the profiles and the pprof server asked for
+func startProfiling(p *profileFlags) error {
+	if *p.pprof != "" {
+		// A mux of its own keeps the endpoints off http.DefaultServeMux
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		ln, err := net.Listen("tcp", *p.pprof)
+		if err != nil {
+			return fmt.Errorf("pprof: %w", err)
+		}
+		fmt.Fprintf(console, "pprof endpoints at http://%s/debug/pprof/\n", ln.Addr())
+		go http.Serve(ln, mux)
+	}
+	if *p.cpu != "" {
+		f, err := os.Create(*p.cpu)
+		if err != nil {
+			return fmt.Errorf("CPU profile: %w", err)
+		}
+		if err := rpprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("CPU profile: %w", err)
+		}
+		cpuProfile = f
+	}
+	if *p.trace != "" {
+		f, err := os.Create(*p.trace)
+		if err != nil {
+			stopProfiling()
+			return fmt.Errorf("trace: %w", err)
+		}
+		if err := rtrace.Start(f); err != nil {
+			f.Close()
+			stopProfiling()
+			return fmt.Errorf("trace: %w", err)
+		}
+		traceFile = f
+	}
+	memProfile = *p.mem
+	return nil
+}
+
+// stopProfiling finishes the profiles; errors are only reported, since
+// the run itself is done
+func stopProfiling() {
+	var errs []error
+	if cpuProfile != nil {
+		rpprof.StopCPUProfile()
+		errs = append(errs, cpuProfile.Close())
+		cpuProfile = nil
+	}
+	if traceFile != nil {
+		rtrace.Stop()
+		errs = append(errs, traceFile.Close())
+		traceFile = nil
+	}
+	if memProfile != "" {
+		path := memProfile
+		memProfile = ""
+		// Up-to-date statistics of what is still live
+		runtime.GC()
+		f, err := os.Create(path)
+		if err == nil {
+			err = rpprof.WriteHeapProfile(f)
+			errs = append(errs, f.Close())
+		}
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing profile:", err)
+	}
+}
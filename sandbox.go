@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// sandboxEnv marks the restricted child process started by --sandbox
+const sandboxEnv = "PIXF_SANDBOX_CHILD"
+
+// sandboxResultFD is the pipe the child reports its result on
+const sandboxResultFD = 3
+
+// sandboxResult is sent from the sandboxed child to its parent when the
+// child exits in a controlled way. A missing result means it crashed or
+// was killed by a resource limit.
+type sandboxResult struct {
+	ExitCode int           `json:"exit_code"`
+	Audit    *auditOutcome `json:"audit,omitempty"` // For the parent's audit log (nil = no --audit-log)
+}
+
+// inSandbox reports whether this process is the sandboxed child
+func inSandbox() bool {
+	return os.Getenv(sandboxEnv) != ""
+}
+
+// sandboxDirEnv passes the image directory to the sandboxed child, so a
+// timestamped name chosen by --dir-policy is the same in both processes
+const sandboxDirEnv = "PIXF_SANDBOX_IMAGE_DIR"
+
+// runSandboxed runs pixf with the same arguments in a restricted child
+// process, extracting into imgDir, and returns the exit code to use.
+//
+// Only the exit code comes back over the pipe: the child writes the
+// images, manifest and unlocked copy itself, as the caller's user. That
+// keeps large results from being copied through the parent, but it means
+// the sandbox confines network access, privileges and resources, not
+// files: a child taken over by a malicious PDF can write to imgDir and to
+// anything else the caller can write to.
+func runSandboxed(imgDir string) int {
+	attr, err := sandboxAttr()
+	if err != nil {
+		fmt.Fprintln(console, "Error starting sandbox:", err)
+		return 1
+	}
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(console, "Error starting sandbox:", err)
+		return 1
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintln(console, "Error starting sandbox:", err)
+		return 1
+	}
+	defer r.Close()
+
+	cmd := exec.Command(self, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), sandboxEnv+"=1", sandboxDirEnv+"="+imgDir)
+	cmd.ExtraFiles = []*os.File{w} // becomes sandboxResultFD
+	cmd.SysProcAttr = attr
+
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		fmt.Fprintln(console, "Error starting sandbox:", err)
+		return 1
+	}
+	w.Close()
+
+	// Let the child clean up on Ctrl+C instead of dying with the parent
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	var res sandboxResult
+	decodeErr := json.NewDecoder(r).Decode(&res)
+	waitErr := cmd.Wait()
+	if decodeErr != nil {
+		fmt.Fprintln(console, "Error: sandboxed process ended unexpectedly:", waitErr)
+		fmt.Fprintln(console, "It may have exceeded a sandbox resource limit")
+		auditStatus(auditError, fmt.Sprintf("sandboxed process ended unexpectedly: %v", waitErr))
+		return 1
+	}
+	auditChild(res.Audit)
+	return res.ExitCode
+}
+
+var reportOnce sync.Once
+
+// reportSandboxResult tells the parent how the child exited; a no-op
+// outside the sandbox
+func reportSandboxResult(code int) {
+	if !inSandbox() {
+		return
+	}
+	reportOnce.Do(func() {
+		f := os.NewFile(sandboxResultFD, "sandbox-result")
+		if f == nil {
+			return
+		}
+		json.NewEncoder(f).Encode(sandboxResult{ExitCode: code, Audit: childAudit})
+		f.Close()
+	})
+}
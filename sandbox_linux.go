@@ -0,0 +1,60 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Resource limits of the sandboxed child
+const (
+	sandboxMemory = 8 << 30 // address space in bytes
+	sandboxFiles  = 256     // open file descriptors
+)
+
+// sandboxID is the unprivileged user and group the child runs as inside
+// its user namespace; it maps to the caller's own IDs outside
+const sandboxID = 65534
+
+// sandboxAttr starts the child in new user and network namespaces. The
+// network namespace has no interfaces besides a down loopback, so the
+// child can't reach the network. In the user namespace it runs as an
+// unprivileged user mapped to the caller, keeping access to the caller's
+// files but holding no capabilities, even when pixf runs as root.
+func sandboxAttr() (*syscall.SysProcAttr, error) {
+	return &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: sandboxID, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: sandboxID, HostID: os.Getgid(), Size: 1}},
+		Credential:  &syscall.Credential{Uid: sandboxID, Gid: sandboxID, NoSetGroups: true},
+		Pdeathsig:   syscall.SIGKILL,
+	}, nil
+}
+
+// applySandboxLimits restricts the sandboxed child before it touches the
+// PDF: memory, open files and core dumps are capped, and no_new_privs
+// keeps it from gaining privileges through setuid binaries
+func applySandboxLimits() error {
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{syscall.RLIMIT_AS, sandboxMemory},
+		{syscall.RLIMIT_NOFILE, sandboxFiles},
+		{syscall.RLIMIT_CORE, 0},
+	}
+	for _, l := range limits {
+		rl := &syscall.Rlimit{Cur: l.value, Max: l.value}
+		if err := syscall.Setrlimit(l.resource, rl); err != nil {
+			return fmt.Errorf("set rlimit %d: %w", l.resource, err)
+		}
+	}
+
+	const prSetNoNewPrivs = 38
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("set no_new_privs: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// sandboxAttr fails; namespaces and rlimits used by --sandbox are Linux-only
+func sandboxAttr() (*syscall.SysProcAttr, error) {
+	return nil, errors.New("--sandbox is only supported on Linux")
+}
+
+// applySandboxLimits is never reached outside Linux
+func applySandboxLimits() error {
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	imageHandling "pixf/internal/toolset"
+)
+
+// summaryFlags are the options for the summary printed after extraction
+type summaryFlags struct {
+	quiet, json, duplicates *bool
+}
+
+// addSummaryFlags registers --quiet, --json and --list-duplicates on fs
+func addSummaryFlags(fs *flag.FlagSet) *summaryFlags {
+	return &summaryFlags{
+		quiet:      fs.Bool("quiet", false, "Print only errors: no progress, no summary"),
+		json:       fs.Bool("json", false, "Print the summary as JSON, alone on stdout"),
+		duplicates: fs.Bool("list-duplicates", false, "List the duplicates left out and the pages they were on"),
+	}
+}
+
+// Where a run reports as it goes. Progress goes to stdout, or to stderr
+// with --json so that stdout carries only the JSON summary, and nowhere
+// with --quiet. The console takes errors and the reports asked for, which
+// --quiet keeps.
+var (
+	console  io.Writer = os.Stdout
+	progress io.Writer = os.Stdout
+)
+
+// routeOutput points console and progress where f wants them; the library
+// gets progress as Options.Notices
+func routeOutput(f *summaryFlags) {
+	if *f.json {
+		console, progress = os.Stderr, os.Stderr
+	}
+	if *f.quiet {
+		progress = io.Discard
+	}
+}
+
+// printSummary prints the summary of an extraction: as a text block, as
+// one JSON line with --json, or not at all with --quiet
+func printSummary(f *summaryFlags, r *imageHandling.Result) {
+	if r == nil || *f.quiet {
+		return
+	}
+	if *f.json {
+		json.NewEncoder(os.Stdout).Encode(struct {
+			Summary    *imageHandling.Result `json:"summary"`
+			Throughput float64               `json:"throughput_bytes_per_second"`
+		}{r, r.Throughput()})
+		return
+	}
+	fmt.Println("Summary:")
+	if r.Documents > 0 || r.Failed > 0 {
+		fmt.Printf("  documents       %d (%d failed)\n", r.Documents, r.Failed)
+	}
+	fmt.Printf("  unique images   %d\n", r.Images)
+	fmt.Printf("  duplicates      %d\n", r.Duplicates)
+	fmt.Printf("  errors          %d\n", r.Errors)
+	if r.LowDPI > 0 {
+		fmt.Printf("  below min dpi   %d\n", r.LowDPI)
+	}
+	if r.Truncated > 0 {
+		fmt.Printf("  over budget     %d\n", r.Truncated)
+	}
+	fmt.Printf("  read / written  %s / %s\n", imageHandling.FormatSize(r.BytesIn), imageHandling.FormatSize(r.BytesOut))
+	fmt.Printf("  elapsed         %.3fs\n", r.Seconds)
+	fmt.Printf("  throughput      %s/s\n", imageHandling.FormatSize(int64(r.Throughput())))
+}
+
+// printDuplicates lists the duplicates left out in imgDir with
+// --list-duplicates: the page and object of each and the image kept
+// instead. The manifest records them either way, so --json leaves the
+// list out.
+func printDuplicates(f *summaryFlags, imgDir string) {
+	if !*f.duplicates || *f.json {
+		return
+	}
+	m, err := imageHandling.ReadManifest(imgDir)
+	if err != nil {
+		fmt.Println("Error listing duplicates:", err)
+		return
+	}
+	count := 0
+	for _, img := range m.Images {
+		count += len(img.Duplicates)
+	}
+	if count == 0 {
+		fmt.Println("No duplicates left out")
+		return
+	}
+	fmt.Printf("%d duplicate(s) left out:\n", count)
+	fmt.Printf("  %4s  %6s  %-9s  %s\n", "Page", "Object", "Match", "Kept image")
+	for _, img := range m.Images {
+		for _, d := range img.Duplicates {
+			match := "identical"
+			if d.Similar {
+				match = "similar"
+			}
+			fmt.Printf("  %4d  %6d  %-9s  %s\n", d.Page, d.ObjNr, match, img.File)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	imageHandling "pixf/internal/toolset"
+)
+
+// usageSampleInterval is how often the goroutine count is sampled
+const usageSampleInterval = 5 * time.Millisecond
+
+// resourceUsage is what a run consumed (--usage, and the audit log)
+type resourceUsage struct {
+	WallSeconds  float64                   `json:"wall_seconds"`
+	CPUSeconds   float64                   `json:"cpu_seconds"`              // User and system time, including child processes
+	PeakRSS      int64                     `json:"peak_rss_bytes,omitempty"` // Largest resident set of pixf or one of its children
+	Goroutines   int64                     `json:"max_goroutines"`
+	BytesRead    int64                     `json:"bytes_read,omitempty"`
+	BytesWritten int64                     `json:"bytes_written,omitempty"`
+	Stages       []imageHandling.StageTime `json:"stages,omitempty"` // From the manifests written
+}
+
+// usageMeter measures this run from startUsage on; nil when nothing asks
+// for the usage
+type usageMeter struct {
+	start      time.Time
+	print      bool
+	goroutines atomic.Int64
+	imgDirs    []string
+	stop       chan struct{}
+}
+
+var usage *usageMeter
+
+// startUsage starts measuring; with print, the usage is printed when the
+// run ends
+func startUsage(print bool) {
+	u := &usageMeter{start: time.Now(), print: print, stop: make(chan struct{})}
+	u.goroutines.Store(int64(runtime.NumGoroutine()))
+	go func() {
+		t := time.NewTicker(usageSampleInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-u.stop:
+				return
+			case <-t.C:
+				n := int64(runtime.NumGoroutine())
+				if n > u.goroutines.Load() {
+					u.goroutines.Store(n)
+				}
+			}
+		}
+	}()
+	usage = u
+}
+
+// usageOutputs adds an image directory whose stage times count for this run
+func usageOutputs(imgDir string) {
+	if usage != nil {
+		usage.imgDirs = append(usage.imgDirs, imgDir)
+	}
+}
+
+// finishUsage stops measuring, records the usage in the audit record and
+// prints it if asked to. A sandboxed child leaves printing to its parent,
+// whose figures include the child.
+func finishUsage() {
+	u := usage
+	if u == nil {
+		return
+	}
+	usage = nil
+	close(u.stop)
+
+	r := resourceUsage{
+		WallSeconds: time.Since(u.start).Seconds(),
+		Goroutines:  u.goroutines.Load(),
+	}
+	var cpu time.Duration
+	cpu, r.PeakRSS, r.BytesRead, r.BytesWritten = processUsage()
+	r.CPUSeconds = cpu.Seconds()
+	for _, dir := range u.imgDirs {
+		// A manifest from an earlier run (up to date, cached) took no time now
+		if m, err := imageHandling.ReadManifest(dir); err == nil && !m.CreatedAt.Before(u.start) {
+			r.Stages = imageHandling.AddStages(r.Stages, m.Stages)
+		}
+	}
+
+	if audit != nil {
+		audit.Usage = &r
+	}
+	if !u.print || inSandbox() {
+		return
+	}
+	fmt.Fprintln(console, "Resource usage:")
+	fmt.Fprintf(console, "  wall time       %.3fs\n", r.WallSeconds)
+	fmt.Fprintf(console, "  CPU time        %.3fs\n", r.CPUSeconds)
+	if r.PeakRSS > 0 {
+		fmt.Fprintf(console, "  peak RSS        %s\n", imageHandling.FormatSize(r.PeakRSS))
+	}
+	fmt.Fprintf(console, "  goroutines      %d at most\n", r.Goroutines)
+	if r.BytesRead > 0 || r.BytesWritten > 0 {
+		fmt.Fprintf(console, "  read / written  %s / %s\n", imageHandling.FormatSize(r.BytesRead), imageHandling.FormatSize(r.BytesWritten))
+	}
+	for _, s := range r.Stages {
+		fmt.Fprintf(console, "  %-15s %.3fs\n", s.Stage, s.Seconds)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "time"
+
+// processUsage can't measure the process on this platform
+func processUsage() (cpu time.Duration, peakRSS, read, written int64) {
+	return 0, 0, 0, 0
+}
@@ -0,0 +1,50 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// processUsage reports the CPU time and peak resident set of this process
+// and its finished children, and on Linux the bytes it read and wrote
+func processUsage() (cpu time.Duration, peakRSS, read, written int64) {
+	for _, who := range []int{syscall.RUSAGE_SELF, syscall.RUSAGE_CHILDREN} {
+		var ru syscall.Rusage
+		if err := syscall.Getrusage(who, &ru); err != nil {
+			continue
+		}
+		cpu += time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+		rss := int64(ru.Maxrss)
+		if runtime.GOOS != "darwin" {
+			rss *= 1024 // kilobytes
+		}
+		peakRSS = max(peakRSS, rss)
+	}
+
+	// Counts every read and write, whether served from the page cache or
+	// the disk
+	f, err := os.Open("/proc/self/io")
+	if err != nil {
+		return cpu, peakRSS, 0, 0
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		key, value, _ := strings.Cut(s.Text(), ":")
+		n, _ := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		switch key {
+		case "rchar":
+			read = n
+		case "wchar":
+			written = n
+		}
+	}
+	return cpu, peakRSS, read, written
+}
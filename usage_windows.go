@@ -0,0 +1,65 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	procGetProcessMemoryInfo = syscall.NewLazyDLL("psapi.dll").NewProc("GetProcessMemoryInfo")
+	procGetProcessIoCounters = syscall.NewLazyDLL("kernel32.dll").NewProc("GetProcessIoCounters")
+)
+
+// processMemoryCounters is PROCESS_MEMORY_COUNTERS
+type processMemoryCounters struct {
+	cb                         uint32
+	pageFaultCount             uint32
+	peakWorkingSetSize         uintptr
+	workingSetSize             uintptr
+	quotaPeakPagedPoolUsage    uintptr
+	quotaPagedPoolUsage        uintptr
+	quotaPeakNonPagedPoolUsage uintptr
+	quotaNonPagedPoolUsage     uintptr
+	pagefileUsage              uintptr
+	peakPagefileUsage          uintptr
+}
+
+// ioCounters is IO_COUNTERS
+type ioCounters struct {
+	readOperationCount  uint64
+	writeOperationCount uint64
+	otherOperationCount uint64
+	readTransferCount   uint64
+	writeTransferCount  uint64
+	otherTransferCount  uint64
+}
+
+// processUsage reports the CPU time, peak working set and I/O of this
+// process; child processes are not included
+func processUsage() (cpu time.Duration, peakRSS, read, written int64) {
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0, 0, 0, 0
+	}
+	var creation, exit, kernel, user syscall.Filetime
+	if syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user) == nil {
+		cpu = filetimeDuration(kernel) + filetimeDuration(user)
+	}
+	mem := processMemoryCounters{cb: uint32(unsafe.Sizeof(processMemoryCounters{}))}
+	if ok, _, _ := procGetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&mem)), uintptr(mem.cb)); ok != 0 {
+		peakRSS = int64(mem.peakWorkingSetSize)
+	}
+	var io ioCounters
+	if ok, _, _ := procGetProcessIoCounters.Call(uintptr(h), uintptr(unsafe.Pointer(&io))); ok != 0 {
+		read, written = int64(io.readTransferCount), int64(io.writeTransferCount)
+	}
+	return cpu, peakRSS, read, written
+}
+
+// filetimeDuration reads a Filetime holding a duration in 100 ns ticks
+func filetimeDuration(ft syscall.Filetime) time.Duration {
+	return time.Duration((int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)) * 100)
+}
@@ -0,0 +1,19 @@
+package main
+
+import "runtime/debug"
+
+// version is set when building releases:
+// go build -ldflags "-X main.version=v1.2.0"
+var version = ""
+
+// pixfVersion is the version of this build: the one set at build time,
+// else the module version "go install" recorded, else "dev"
+func pixfVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}